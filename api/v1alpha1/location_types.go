@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -48,6 +49,12 @@ type LocationEntry struct {
 	// resource of type "FullURL". This is typically used in combination with UpstreamTypeFullURL.
 	ProxyPassIsFullURL bool `json:"proxyPassIsFullURL,omitempty"`
 
+	// UpstreamRef points the location at an Upstream resource in the same namespace.
+	// The rendering (proxy_pass to an upstream block or a FullURL Lua module) follows the Upstream's type.
+	// It cannot be combined with proxyPass or proxyPassIsFullURL.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="UpstreamRef"
+	UpstreamRef *UpstreamReference `json:"upstreamRef,omitempty"`
+
 	// Headers defines a list of headers to set via proxy_set_header or add_header
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Headers"
 	Headers []NginxKV `json:"headers,omitempty"`
@@ -62,9 +69,21 @@ type LocationEntry struct {
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="AccessLog"
 	AccessLog *bool `json:"accessLog,omitempty"`
 
-	// LimitReq applies request rate limiting (e.g., "zone=api burst=10 nodelay")
-	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="LimitReq"
-	LimitReq *string `json:"limitReq,omitempty"`
+	// RateLimitPolicyRef applies the limit_req zone of a RateLimitPolicy in the same namespace.
+	// Burst and nodelay are taken from the policy. The policy must also be listed in the
+	// OpenResty's http.rateLimitPolicyRefs so that its zone is defined.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="RateLimitPolicyRef"
+	RateLimitPolicyRef *RateLimitPolicyReference `json:"rateLimitPolicyRef,omitempty"`
+
+	// TrafficShapingPolicyRef applies the connection and bandwidth limits of a TrafficShapingPolicy in the same namespace.
+	// The policy must also be listed in the OpenResty's http.trafficShapingPolicyRefs so that its zone is defined.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TrafficShapingPolicyRef"
+	TrafficShapingPolicyRef *TrafficShapingPolicyReference `json:"trafficShapingPolicyRef,omitempty"`
+
+	// QuotaRef counts requests against the per-consumer quota of a Quota in the same namespace.
+	// The quota must also be listed in the OpenResty's http.quotaRefs so that its counters are defined.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="QuotaRef"
+	QuotaRef *QuotaReference `json:"quotaRef,omitempty"`
 
 	// Gzip enables gzip compression for specific content types
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Gzip"
@@ -74,7 +93,7 @@ type LocationEntry struct {
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Cache"
 	Cache *CacheConf `json:"cache,omitempty"`
 
-	// Lua allows embedding custom Lua logic via access/content phases
+	// Lua allows embedding custom Lua logic in the rewrite, access, content, header_filter, body_filter and log phases
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Lua"
 	Lua *LuaBlock `json:"lua,omitempty"`
 
@@ -82,11 +101,54 @@ type LocationEntry struct {
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="EnableUpstreamMetrics"
 	EnableUpstreamMetrics bool `json:"enableUpstreamMetrics,omitempty"`
 
+	// Static serves files from a mounted ConfigMap, PVC or image volume instead of proxying.
+	// It cannot be combined with proxyPass.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Static"
+	Static *StaticConf `json:"static,omitempty"`
+
 	// Extra allows defining custom raw Nginx directives
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Extra"
 	Extra []string `json:"extra,omitempty"`
 }
 
+// UpstreamReference is a typed reference from a location to an Upstream
+type UpstreamReference struct {
+	// Name of the Upstream resource
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name"
+	Name string `json:"name"`
+
+	// PathPrefix is prepended to the forwarded request path (e.g., "/v1")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="PathPrefix"
+	PathPrefix string `json:"pathPrefix,omitempty"`
+
+	// +kubebuilder:validation:Enum=http;https
+	// +kubebuilder:default=http
+	// Scheme used to reach Address-type upstreams. Ignored for FullURL upstreams, whose URLs carry their own scheme.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Scheme"
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// RateLimitPolicyReference is a typed reference from a location to a RateLimitPolicy
+type RateLimitPolicyReference struct {
+	// Name of the RateLimitPolicy resource
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name"
+	Name string `json:"name"`
+}
+
+// TrafficShapingPolicyReference is a typed reference from a location to a TrafficShapingPolicy
+type TrafficShapingPolicyReference struct {
+	// Name of the TrafficShapingPolicy resource
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name"
+	Name string `json:"name"`
+}
+
+// QuotaReference is a typed reference from a location to a Quota
+type QuotaReference struct {
+	// Name of the Quota resource
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name"
+	Name string `json:"name"`
+}
+
 type NginxKV struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
@@ -135,15 +197,97 @@ type CacheConf struct {
 	Valid string `json:"valid,omitempty"`
 }
 
-// LuaBlock defines embedded Lua logic for access/content phases
+// LuaBlock defines embedded Lua logic for the request phases.
+// Operator-generated code (secret headers, FullURL routing, response normalization, metrics) runs first in
+// each phase, except rewrite where user code runs before FullURL routing so it can influence the target.
 type LuaBlock struct {
+	// Rewrite contains Lua code to execute during rewrite phase
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Rewrite"
+	Rewrite string `json:"rewrite,omitempty"`
+
 	// Access contains Lua code to execute during access phase
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Access"
 	Access string `json:"access,omitempty"`
 
-	// Content contains Lua code to execute during content phase
+	// Content contains Lua code to execute during content phase. It replaces proxying and cannot be
+	// combined with proxyPass, upstreamRef or static.
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Content"
 	Content string `json:"content,omitempty"`
+
+	// HeaderFilter contains Lua code to execute during header filter phase
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="HeaderFilter"
+	HeaderFilter string `json:"headerFilter,omitempty"`
+
+	// BodyFilter contains Lua code to execute during body filter phase
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="BodyFilter"
+	BodyFilter string `json:"bodyFilter,omitempty"`
+
+	// Log contains Lua code to execute during log phase
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Log"
+	Log string `json:"log,omitempty"`
+}
+
+// StaticMode controls whether files are resolved with `alias` or `root`
+type StaticMode string
+
+const (
+	// StaticModeAlias maps the location path onto the mounted directory (e.g., /app/a.js -> <dir>/a.js)
+	StaticModeAlias StaticMode = "alias"
+
+	// StaticModeRoot appends the full request URI to the mounted directory (e.g., /app/a.js -> <dir>/app/a.js)
+	StaticModeRoot StaticMode = "root"
+)
+
+// StaticConf configures static file serving for a location.
+// Exactly one of ConfigMap, PersistentVolumeClaim or Image must be set.
+type StaticConf struct {
+	// ConfigMap mounts the keys of a ConfigMap as the static content
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ConfigMap"
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+
+	// PersistentVolumeClaim mounts an existing PVC as the static content
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="PersistentVolumeClaim"
+	PersistentVolumeClaim string `json:"persistentVolumeClaim,omitempty"`
+
+	// Image mounts an OCI image or artifact as a read-only volume (requires the ImageVolume feature gate)
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Image"
+	Image *corev1.ImageVolumeSource `json:"image,omitempty"`
+
+	// SubPath selects a sub-directory of a persistentVolumeClaim or image volume to serve.
+	// ConfigMap content is mounted as a directory and updated in the pods without a restart.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="SubPath"
+	SubPath string `json:"subPath,omitempty"`
+
+	// +kubebuilder:validation:Enum=alias;root
+	// +kubebuilder:default=alias
+	// Mode selects between the `alias` and `root` directives
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Mode"
+	Mode StaticMode `json:"mode,omitempty"`
+
+	// Index is the index file name (default: "index.html")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Index"
+	Index string `json:"index,omitempty"`
+
+	// SPA falls back to the index file for unknown paths, as required by client-side routers
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="SPA"
+	SPA bool `json:"spa,omitempty"`
+
+	// CacheControl sets the Cache-Control header by file extension. The last matching rule wins.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="CacheControl"
+	CacheControl []StaticCacheControl `json:"cacheControl,omitempty"`
+
+	// GzipStatic serves precompressed "<file>.gz" variants when present
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="GzipStatic"
+	GzipStatic bool `json:"gzipStatic,omitempty"`
+}
+
+// StaticCacheControl maps a set of file extensions to a Cache-Control value
+type StaticCacheControl struct {
+	// Extensions lists file extensions without the leading dot (e.g., ["js", "css"])
+	Extensions []string `json:"extensions"`
+
+	// Value is the Cache-Control header value (e.g., "public, max-age=31536000, immutable")
+	Value string `json:"value"`
 }
 
 // LocationStatus defines the observed state of Location
@@ -153,6 +297,21 @@ type LocationStatus struct {
 	Ready   bool   `json:"ready"`
 	Version string `json:"version,omitempty"` // 对应 generation
 	Reason  string `json:"reason,omitempty"`
+
+	// UpstreamRefs lists the Upstreams resolved from the entries' upstreamRef fields
+	UpstreamRefs []string `json:"upstreamRefs,omitempty"`
+
+	// RateLimitPolicyRefs lists the RateLimitPolicies resolved from the entries' rateLimitPolicyRef fields
+	RateLimitPolicyRefs []string `json:"rateLimitPolicyRefs,omitempty"`
+
+	// TrafficShapingPolicyRefs lists the TrafficShapingPolicies resolved from the entries' trafficShapingPolicyRef fields
+	TrafficShapingPolicyRefs []string `json:"trafficShapingPolicyRefs,omitempty"`
+
+	// QuotaRefs lists the Quotas resolved from the entries' quotaRef fields
+	QuotaRefs []string `json:"quotaRefs,omitempty"`
+
+	// LintFindings lists problems found in the generated nginx config
+	LintFindings []string `json:"lintFindings,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,93 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// LuaModuleSpec defines a reusable Lua library published to every OpenResty that references it.
+// Each file is require()-able as "luamodules.<namespace>.<module>.<file>"; a file named "init"
+// makes the module itself require()-able as "luamodules.<namespace>.<module>".
+type LuaModuleSpec struct {
+	// Files is the list of Lua source files that make up the module
+	// +kubebuilder:validation:MinItems=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Files",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
+	Files []LuaModuleFile `json:"files"`
+
+	// Dependencies lists other LuaModule names (same namespace) required by this module.
+	// They are mounted alongside this module in every consuming OpenResty.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Dependencies",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
+	Dependencies []string `json:"dependencies,omitempty"`
+}
+
+// LuaModuleFile is a single Lua source file of a LuaModule
+type LuaModuleFile struct {
+	// Name is the file name without the ".lua" suffix, used as the last segment of the require() path
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Name string `json:"name"`
+
+	// Content is the Lua source code of the file
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Content",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Content string `json:"content"`
+}
+
+// LuaModuleStatus defines the observed state of LuaModule
+type LuaModuleStatus struct {
+	Ready   bool   `json:"ready,omitempty"`
+	Version string `json:"version,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+
+	// ModulePath is the require() prefix of the module, e.g. "luamodules.default.jsonutil"
+	ModulePath string `json:"modulePath,omitempty"`
+
+	// Dependencies is the resolved, transitive list of LuaModules this module depends on
+	Dependencies []string `json:"dependencies,omitempty"`
+
+	// Hash is the content hash of all files, used to detect changes that require a reload
+	Hash string `json:"hash,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// LuaModule is the Schema for the luamodules API
+// +operator-sdk:csv:customresourcedefinitions:displayName="LuaModule",resources={{ConfigMap,v1,luamodule-cm}}
+type LuaModule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LuaModuleSpec   `json:"spec,omitempty"`
+	Status LuaModuleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LuaModuleList contains a list of LuaModule
+type LuaModuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LuaModule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LuaModule{}, &LuaModuleList{})
+}
@@ -68,6 +68,37 @@ type OpenRestySpec struct {
 
 	// +kubebuilder:default:={type:EmptyDir}
 	LogVolume LogVolumeSpec `json:"logVolume,omitempty"`
+
+	// +kubebuilder:default:={enable:true}
+	// ConfigValidation runs the assembled config through `nginx -t` in a Job before it is rolled out
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Config Validation",xDescriptors="urn:alm:descriptor:com.tectonic.ui:object"
+	ConfigValidation *ConfigValidation `json:"configValidation,omitempty"`
+
+	// InPodHealthCheck runs the health checks of the referenced Upstreams from the reload-agent inside every pod,
+	// seeing the upstreams through the network and NetworkPolicies of the pod rather than of the operator
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="InPodHealthCheck",xDescriptors="urn:alm:descriptor:com.tectonic.ui:object"
+	InPodHealthCheck *InPodHealthCheck `json:"inPodHealthCheck,omitempty"`
+}
+
+type InPodHealthCheck struct {
+	// Enable starts the health-check worker of the reload-agent. Servers it reports down are skipped by the
+	// balancer of the pod, and counted in the unhealthyPods of the Upstream status.
+	Enable bool `json:"enable"`
+
+	// Workers is the number of checks run concurrently in each pod (default: 4)
+	// +kubebuilder:validation:Minimum=1
+	Workers int `json:"workers,omitempty"`
+}
+
+type ConfigValidation struct {
+	// +kubebuilder:default=true
+	// Enable controls whether a new config set must pass `nginx -t` before the Deployment is updated
+	Enable bool `json:"enable"`
+
+	// +kubebuilder:default=60
+	// +kubebuilder:validation:Minimum=5
+	// TimeoutSeconds limits how long the validation Job may run
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
 }
 
 type ServiceMonitor struct {
@@ -123,6 +154,10 @@ type HttpBlock struct {
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Gzip",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
 	Extra []string `json:"extra,omitempty"`
 
+	// Resolver configures the DNS resolver of nginx, also used by the runtime DNS resolution of Upstreams
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Resolver",xDescriptors="urn:alm:descriptor:com.tectonic.ui:object"
+	Resolver *Resolver `json:"resolver,omitempty"`
+
 	// ServerRefs lists referenced ServerBlock CR names
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ServerRefs",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
 	ServerRefs []string `json:"serverRefs"`
@@ -130,6 +165,41 @@ type HttpBlock struct {
 	// UpstreamRefs lists referenced Upstream CR names
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="UpstreamRefs",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
 	UpstreamRefs []string `json:"upstreamRefs,omitempty"`
+
+	// LuaModuleRefs lists referenced LuaModule CR names; their dependencies are mounted automatically
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="LuaModuleRefs",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
+	LuaModuleRefs []string `json:"luaModuleRefs,omitempty"`
+
+	// RateLimitPolicyRefs lists referenced RateLimitPolicy CR names; their limit_req_zone definitions are included in the http block
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="RateLimitPolicyRefs",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
+	RateLimitPolicyRefs []string `json:"rateLimitPolicyRefs,omitempty"`
+
+	// TrafficShapingPolicyRefs lists referenced TrafficShapingPolicy CR names; their limit_conn_zone definitions are included in the http block
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TrafficShapingPolicyRefs",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
+	TrafficShapingPolicyRefs []string `json:"trafficShapingPolicyRefs,omitempty"`
+
+	// QuotaRefs lists referenced Quota CR names; their counter dictionaries are defined in the http block
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="QuotaRefs",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
+	QuotaRefs []string `json:"quotaRefs,omitempty"`
+}
+
+// Resolver is rendered as the resolver directive of the http block
+type Resolver struct {
+	// Addresses are the name servers, an IP or hostname with an optional port (default: kube-dns.kube-system.svc.cluster.local).
+	// The Lua resolver only accepts IPs, hostnames are replaced with the name servers of /etc/resolv.conf.
+	// +kubebuilder:validation:MinItems=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Addresses",xDescriptors="urn:alm:descriptor:com.tectonic.ui:array"
+	Addresses []string `json:"addresses,omitempty"`
+
+	// ValidSeconds overrides the TTL of the answers cached by nginx (default: 30)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ValidSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	ValidSeconds int `json:"validSeconds,omitempty"`
+
+	// TimeoutSeconds is the timeout of a DNS query (default: 30 in nginx, 2 in the Lua resolver)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TimeoutSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
 }
 
 // MetricsServer defines an optional server to expose Prometheus metrics
@@ -154,6 +224,15 @@ type OpenRestyStatus struct {
 	AvailableReplicas int32  `json:"availableReplicas,omitempty"`
 	Ready             bool   `json:"ready"`
 	Reason            string `json:"reason,omitempty"`
+
+	// LintFindings lists problems found in the generated nginx config
+	LintFindings []string `json:"lintFindings,omitempty"`
+
+	// ValidatedConfigHash is the hash of the last config set that passed `nginx -t`
+	ValidatedConfigHash string `json:"validatedConfigHash,omitempty"`
+
+	// ConfigError holds the `nginx -t` output of the last rejected config set
+	ConfigError string `json:"configError,omitempty"`
 }
 
 // +kubebuilder:object:root=true
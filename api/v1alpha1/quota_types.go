@@ -0,0 +1,159 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QuotaSpec defines the desired state of Quota
+type QuotaSpec struct {
+	// Consumer builds the consumer identity from request attributes joined by ":", e.g. an API key header
+	// or the "sub" claim of a JWT. Requests without any identity are not counted. At least one source must
+	// identify the caller: a header, cookie or query argument, or a jwtClaim declared authenticated.
+	// +kubebuilder:validation:MinItems=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Consumer"
+	Consumer []RateLimitKeySource `json:"consumer"`
+
+	// Period is the calendar period the limit applies to, in UTC: day or month
+	// +kubebuilder:validation:Enum=day;month
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Period",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:day,urn:alm:descriptor:com.tectonic.ui:select:month"
+	Period QuotaPeriod `json:"period"`
+
+	// Limit is the number of requests each consumer may send per period
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Limit",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	Limit int64 `json:"limit"`
+
+	// Overrides sets a different limit for specific consumers, e.g. partners on a larger plan
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Overrides"
+	Overrides []QuotaOverride `json:"overrides,omitempty"`
+
+	// Overage decides what happens once a consumer used up its quota: reject (default) returns rejectStatus,
+	// allow lets the request through with an "X-Quota-Exceeded: true" header so the overage can be billed
+	// +kubebuilder:validation:Enum=reject;allow
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Overage",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:reject,urn:alm:descriptor:com.tectonic.ui:select:allow"
+	Overage QuotaOverage `json:"overage,omitempty"`
+
+	// RejectStatus is the status code returned once the quota is used up (default: 429)
+	// +kubebuilder:validation:Minimum=400
+	// +kubebuilder:validation:Maximum=599
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="RejectStatus",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	RejectStatus int `json:"rejectStatus,omitempty"`
+
+	// Redis persists the counters shared by all replicas. Each pod counts requests in a lua_shared_dict
+	// and flushes them to Redis every syncIntervalSeconds, so restarts and reloads keep the usage.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Redis"
+	Redis RedisConnection `json:"redis"`
+
+	// SyncIntervalSeconds is how often counters are flushed to and refreshed from Redis (default: 5).
+	// A consumer may exceed its quota by the requests served by all replicas within one interval.
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="SyncIntervalSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	SyncIntervalSeconds int `json:"syncIntervalSeconds,omitempty"`
+
+	// DictSize is the size of the lua_shared_dict caching the counters (default: "10m")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="DictSize",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	DictSize string `json:"dictSize,omitempty"`
+}
+
+type QuotaPeriod string
+
+const (
+	QuotaPeriodDay   QuotaPeriod = "day"
+	QuotaPeriodMonth QuotaPeriod = "month"
+)
+
+type QuotaOverage string
+
+const (
+	QuotaOverageReject QuotaOverage = "reject"
+	QuotaOverageAllow  QuotaOverage = "allow"
+)
+
+// QuotaOverride sets the limit of a single consumer
+type QuotaOverride struct {
+	// Consumer is the identity as built from spec.consumer
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Consumer",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Consumer string `json:"consumer"`
+
+	// Limit is the number of requests the consumer may send per period
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Limit",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	Limit int64 `json:"limit"`
+}
+
+// QuotaConsumerUsage is the usage of one consumer in the current period
+type QuotaConsumerUsage struct {
+	Consumer string `json:"consumer"`
+	Used     int64  `json:"used"`
+	Limit    int64  `json:"limit"`
+}
+
+// QuotaStatus defines the observed state of Quota
+type QuotaStatus struct {
+	Ready   bool   `json:"ready,omitempty"`
+	Version string `json:"version,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+
+	// LintFindings lists problems found in the generated nginx config
+	LintFindings []string `json:"lintFindings,omitempty"`
+
+	// Period identifies the current period, e.g. "20251019" for a day or "202510" for a month
+	Period string `json:"period,omitempty"`
+
+	// Consumers is the number of consumers that sent requests in the current period
+	Consumers int `json:"consumers,omitempty"`
+
+	// ExceededConsumers is the number of consumers that used up their quota in the current period
+	ExceededConsumers int `json:"exceededConsumers,omitempty"`
+
+	// TopConsumers lists the consumers with the highest usage in the current period
+	TopConsumers []QuotaConsumerUsage `json:"topConsumers,omitempty"`
+
+	// UsageError is set when the usage could not be read from Redis
+	UsageError string `json:"usageError,omitempty"`
+
+	// LastSyncTime is when the usage was last read from Redis
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Quota is the Schema for the quotas API
+// +operator-sdk:csv:customresourcedefinitions:displayName="Quota",resources={{ConfigMap,v1,quota-cm}}
+type Quota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   QuotaSpec   `json:"spec,omitempty"`
+	Status QuotaStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// QuotaList contains a list of Quota
+type QuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Quota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Quota{}, &QuotaList{})
+}
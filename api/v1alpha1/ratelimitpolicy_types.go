@@ -37,6 +37,11 @@ type RateLimitPolicySpec struct {
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Key",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
 	Key string `json:"key,omitempty"`
 
+	// KeySources builds the key from request attributes joined by ":", e.g. a tenant header plus the client IP.
+	// Cannot be combined with key.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="KeySources"
+	KeySources []RateLimitKeySource `json:"keySources,omitempty"`
+
 	// ZoneSize is the size of the shared memory zone (default: "10m")
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ZoneSize",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
 	ZoneSize string `json:"zoneSize,omitempty"`
@@ -48,12 +53,160 @@ type RateLimitPolicySpec struct {
 	// NoDelay controls whether to allow burst requests to be served immediately without delay
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ZoneName",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
 	NoDelay bool `json:"nodelay,omitempty"`
+
+	// Delay enables two-stage limiting: the first Delay excessive requests are served without delay,
+	// the rest of the burst is delayed. Cannot be combined with nodelay.
+	// +kubebuilder:validation:Minimum=0
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Delay",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	Delay int `json:"delay,omitempty"`
+
+	// Rejection customizes the response returned to rate limited requests
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Rejection"
+	Rejection *RateLimitRejection `json:"rejection,omitempty"`
+
+	// LogLevel is the level used to log rejected requests (limit_req_log_level), delayed requests are logged one level lower
+	// +kubebuilder:validation:Enum=info;notice;warn;error
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="LogLevel",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:info,urn:alm:descriptor:com.tectonic.ui:select:notice,urn:alm:descriptor:com.tectonic.ui:select:warn,urn:alm:descriptor:com.tectonic.ui:select:error"
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// DryRun enables shadow mode (limit_req_dry_run): requests are accounted and logged but never delayed or rejected
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="DryRun",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Mode selects where requests are counted. local (default) uses a limit_req zone per pod, so the effective
+	// limit is multiplied by the number of replicas; global shares the counters of all replicas through Redis.
+	// +kubebuilder:validation:Enum=local;global
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Mode",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:local,urn:alm:descriptor:com.tectonic.ui:select:global"
+	Mode RateLimitMode `json:"mode,omitempty"`
+
+	// Global configures the Redis backed limiter, required when mode is global
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Global"
+	Global *GlobalRateLimit `json:"global,omitempty"`
+}
+
+type RateLimitKeySourceType string
+
+const (
+	RateLimitKeySourceClientIP RateLimitKeySourceType = "clientIP"
+	RateLimitKeySourceHeader   RateLimitKeySourceType = "header"
+	RateLimitKeySourceCookie   RateLimitKeySourceType = "cookie"
+	RateLimitKeySourceQuery    RateLimitKeySourceType = "query"
+	RateLimitKeySourceRoute    RateLimitKeySourceType = "route"
+	RateLimitKeySourceJWTClaim RateLimitKeySourceType = "jwtClaim"
+)
+
+// RateLimitKeySource is one part of a rate limiting key
+type RateLimitKeySource struct {
+	// Type of the source: clientIP, header, cookie, query (argument), route (the Location path) or jwtClaim
+	// +kubebuilder:validation:Enum=clientIP;header;cookie;query;route;jwtClaim
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Type",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Type RateLimitKeySourceType `json:"type"`
+
+	// Name of the header, cookie, query argument or JWT claim. Nested claims are separated by dots, e.g. "tenant.id"
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Name string `json:"name,omitempty"`
+
+	// TrustedHops is the number of trusted proxies in front of OpenResty appending to X-Forwarded-For (clientIP only).
+	// The client is the address this many hops from the right of X-Forwarded-For, 0 uses the connection address.
+	// +kubebuilder:validation:Minimum=0
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TrustedHops",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	TrustedHops int `json:"trustedHops,omitempty"`
+
+	// TokenHeader is the header carrying the JWT (jwtClaim only, default: "Authorization"), a "Bearer " prefix is stripped.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TokenHeader",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	TokenHeader string `json:"tokenHeader,omitempty"`
+
+	// Authenticated declares that the token is verified before OpenResty reads the claim, e.g. by an authenticating
+	// proxy in front of it (jwtClaim only). The claim is read without checking the token signature, so jwtClaim
+	// sources are refused without it: clients could otherwise send a different claim on every request.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Authenticated",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	Authenticated bool `json:"authenticated,omitempty"`
+}
+
+type RateLimitMode string
+
+const (
+	RateLimitModeLocal  RateLimitMode = "local"
+	RateLimitModeGlobal RateLimitMode = "global"
+)
+
+// GlobalRateLimit configures rate limiting shared by all replicas through Redis
+type GlobalRateLimit struct {
+	// Algorithm used by the Redis script: gcra (default) smooths requests like limit_req,
+	// sliding-window counts requests over the rate period
+	// +kubebuilder:validation:Enum=gcra;sliding-window
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Algorithm",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:gcra,urn:alm:descriptor:com.tectonic.ui:select:sliding-window"
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Redis holds the connection settings
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Redis"
+	Redis RedisConnection `json:"redis"`
+
+	// FailureMode decides how requests are handled while Redis is unreachable and no fallbackRate is set:
+	// open (default) lets them through, closed rejects them
+	// +kubebuilder:validation:Enum=open;closed
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="FailureMode",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:open,urn:alm:descriptor:com.tectonic.ui:select:closed"
+	FailureMode string `json:"failureMode,omitempty"`
+
+	// FallbackRate is a per-pod limit, such as "5r/s", enforced with a local counter while Redis is unreachable.
+	// Takes precedence over failureMode.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="FallbackRate",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	FallbackRate string `json:"fallbackRate,omitempty"`
+}
+
+// RedisConnection describes how OpenResty connects to Redis
+type RedisConnection struct {
+	// SecretName is the Secret holding the connection: "host" (required), "port" (default 6379),
+	// "username", "password" and "database". The Secret is mounted into the OpenResty pods.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="SecretName",xDescriptors="urn:alm:descriptor:io.kubernetes:Secret"
+	SecretName string `json:"secretName"`
+
+	// TimeoutMs is the connect, send and read timeout in milliseconds (default: 100)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TimeoutMs",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+
+	// PoolSize is the number of idle keepalive connections kept per worker (default: 32)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="PoolSize",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	PoolSize int `json:"poolSize,omitempty"`
+
+	// KeyPrefix prefixes the Redis keys of the policy (default: "ratelimit:<namespace>:<name>:")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="KeyPrefix",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+}
+
+// RateLimitRejection describes the response returned to rate limited requests
+type RateLimitRejection struct {
+	// Status is the status code returned to rejected requests (limit_req_status)
+	// +kubebuilder:validation:Minimum=400
+	// +kubebuilder:validation:Maximum=599
+	// +kubebuilder:default=429
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Status",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	Status int `json:"status,omitempty"`
+
+	// Body is returned as application/json instead of nginx's HTML error page.
+	// nginx variables such as $request_id are expanded, e.g. {"error":"rate_limited","requestId":"$request_id"}
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Body",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Body string `json:"body,omitempty"`
+
+	// RetryAfterSeconds sets the Retry-After header on rejected responses
+	// +kubebuilder:validation:Minimum=0
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="RetryAfterSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	RetryAfterSeconds *int `json:"retryAfterSeconds,omitempty"`
+
+	// RateLimitHeaders adds X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset to rejected responses
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="RateLimitHeaders",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	RateLimitHeaders bool `json:"rateLimitHeaders,omitempty"`
 }
 
 type RateLimitPolicyStatus struct {
 	Ready   bool   `json:"ready,omitempty"`
 	Version string `json:"version,omitempty"`
 	Reason  string `json:"reason,omitempty"`
+
+	// LintFindings lists problems found in the generated nginx config
+	LintFindings []string `json:"lintFindings,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -61,6 +61,21 @@ type ServerBlockStatus struct {
 	Version     string   `json:"version,omitempty"` // 对应 generation
 	Reason      string   `json:"reason,omitempty"`
 	LocationRef []string `json:"locationRef,omitempty"`
+
+	// UpstreamRefs aggregates the Upstreams referenced by the included Locations
+	UpstreamRefs []string `json:"upstreamRefs,omitempty"`
+
+	// RateLimitPolicyRefs aggregates the RateLimitPolicies referenced by the included Locations
+	RateLimitPolicyRefs []string `json:"rateLimitPolicyRefs,omitempty"`
+
+	// TrafficShapingPolicyRefs aggregates the TrafficShapingPolicies referenced by the included Locations
+	TrafficShapingPolicyRefs []string `json:"trafficShapingPolicyRefs,omitempty"`
+
+	// QuotaRefs aggregates the Quotas referenced by the included Locations
+	QuotaRefs []string `json:"quotaRefs,omitempty"`
+
+	// LintFindings lists problems found in the generated nginx config
+	LintFindings []string `json:"lintFindings,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,96 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TrafficShapingPolicySpec defines the desired state of TrafficShapingPolicy
+type TrafficShapingPolicySpec struct {
+	// ZoneName is the name of the connection limiting zone defined via `limit_conn_zone`.
+	// Required when connections is set.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ZoneName",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	ZoneName string `json:"zoneName,omitempty"`
+
+	// Key specifies the key connections are counted by (default: "$binary_remote_addr")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Key",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Key string `json:"key,omitempty"`
+
+	// ZoneSize is the size of the shared memory zone (default: "10m")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ZoneSize",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	ZoneSize string `json:"zoneSize,omitempty"`
+
+	// Connections is the maximum number of concurrent connections per key (limit_conn)
+	// +kubebuilder:validation:Minimum=0
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Connections",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	Connections int `json:"connections,omitempty"`
+
+	// RejectStatus is the status code returned when the connection limit is exceeded (limit_conn_status, nginx default: 503)
+	// +kubebuilder:validation:Minimum=400
+	// +kubebuilder:validation:Maximum=599
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="RejectStatus",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	RejectStatus int `json:"rejectStatus,omitempty"`
+
+	// DryRun enables shadow mode for the connection limit (limit_conn_dry_run)
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="DryRun",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// LimitRate limits the rate of response transmission to a client, per request (e.g., "500k")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="LimitRate",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	LimitRate string `json:"limitRate,omitempty"`
+
+	// LimitRateAfter sets the amount of data sent at full speed before limitRate applies (e.g., "10m")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="LimitRateAfter",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	LimitRateAfter string `json:"limitRateAfter,omitempty"`
+}
+
+// TrafficShapingPolicyStatus defines the observed state of TrafficShapingPolicy
+type TrafficShapingPolicyStatus struct {
+	Ready   bool   `json:"ready,omitempty"`
+	Version string `json:"version,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+
+	// LintFindings lists problems found in the generated nginx config
+	LintFindings []string `json:"lintFindings,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// TrafficShapingPolicy is the Schema for the trafficshapingpolicies API
+// +operator-sdk:csv:customresourcedefinitions:displayName="TrafficShapingPolicy",resources={{ConfigMap,v1,trafficshaping-cm}}
+type TrafficShapingPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TrafficShapingPolicySpec   `json:"spec,omitempty"`
+	Status TrafficShapingPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TrafficShapingPolicyList contains a list of TrafficShapingPolicy
+type TrafficShapingPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TrafficShapingPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TrafficShapingPolicy{}, &TrafficShapingPolicyList{})
+}
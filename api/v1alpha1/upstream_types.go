@@ -19,6 +19,7 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -39,10 +40,52 @@ const (
 type UpstreamServer struct {
 	Address string `json:"address"`
 
+	// Weight is the relative share of requests sent to the server (default: 1)
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1000
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Weight",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	Weight int `json:"weight,omitempty"`
+
+	// Backup servers only receive requests when all primary servers are down or draining
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Backup",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	Backup bool `json:"backup,omitempty"`
+
+	// MaxFails is the number of failed attempts within failTimeoutSeconds after which the server is skipped
+	// for failTimeoutSeconds (default: 1). 0 disables the accounting, as with nginx max_fails=0.
+	// Failures are counted per worker process.
+	// +kubebuilder:validation:Minimum=0
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="MaxFails",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	MaxFails *int `json:"maxFails,omitempty"`
+
+	// FailTimeoutSeconds is both the window failures are counted in and how long the server is skipped (default: 10)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="FailTimeoutSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	FailTimeoutSeconds int `json:"failTimeoutSeconds,omitempty"`
+
+	// Drain stops sending new requests to the server while keeping it in the Upstream,
+	// requests already in flight are not interrupted
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Drain",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	Drain bool `json:"drain,omitempty"`
+
 	// NormalizeRequestRef refers to a reusable NormalizeRequest CRD
 	NormalizeRequestRef *corev1.LocalObjectReference `json:"normalizeRequestRef,omitempty"`
 }
 
+// UpstreamServiceRef selects a port of a Kubernetes Service whose endpoints become servers
+type UpstreamServiceRef struct {
+	// Name of the Service
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Name string `json:"name"`
+
+	// Namespace of the Service, defaults to the namespace of the Upstream
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Namespace",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Namespace string `json:"namespace,omitempty"`
+
+	// Port is the name or number of the Service port, the endpoints are rendered with its target port
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Port"
+	Port intstr.IntOrString `json:"port"`
+}
+
 // UpstreamSpec defines the desired state of Upstream
 type UpstreamSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -50,10 +93,367 @@ type UpstreamSpec struct {
 
 	// Servers is a list of backend servers
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Servers"
-	Servers []UpstreamServer `json:"servers"`
+	Servers []UpstreamServer `json:"servers,omitempty"`
+
+	// ServiceRef adds the ready endpoints of a Kubernetes Service as servers, addressed by their pod IPs.
+	// The operator watches the EndpointSlices of the Service, so scaling and rollouts update the servers
+	// without going through kube-proxy. Only supported by Address upstreams.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ServiceRef"
+	ServiceRef *UpstreamServiceRef `json:"serviceRef,omitempty"`
 
 	// +kubebuilder:default=Address
 	Type UpstreamType `json:"type"`
+
+	// LoadBalancer selects the algorithm picking a server for each request
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="LoadBalancer"
+	LoadBalancer *UpstreamLoadBalancer `json:"loadBalancer,omitempty"`
+
+	// Stickiness keeps the requests of a client on the same server while it is available,
+	// requests without affinity are balanced by the loadBalancer algorithm
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Stickiness"
+	Stickiness *UpstreamStickiness `json:"stickiness,omitempty"`
+
+	// HealthCheck configures the active check the operator runs against every server.
+	// Without it, servers are checked with a DNS lookup and a TCP dial every 60 seconds.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="HealthCheck"
+	HealthCheck *UpstreamHealthCheck `json:"healthCheck,omitempty"`
+
+	// PassiveHealthCheck ejects a server from the balancer of an OpenResty pod after consecutive failed requests,
+	// catching the failures active checks cannot see
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="PassiveHealthCheck"
+	PassiveHealthCheck *UpstreamPassiveHealthCheck `json:"passiveHealthCheck,omitempty"`
+
+	// Keepalive keeps idle connections to the servers open for reuse, Locations proxying to the upstream then use
+	// HTTP/1.1 and clear the Connection header. Only supported by Address upstreams.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Keepalive"
+	Keepalive *UpstreamKeepalive `json:"keepalive,omitempty"`
+
+	// UpdateStrategy is "Reload" or "Dynamic" (default: Reload). Dynamic upstreams keep their servers out of the
+	// nginx config: the reload-agent pushes server changes to the Lua balancer of the pod, so only structural
+	// changes reload nginx. Only supported by Address upstreams.
+	// +kubebuilder:validation:Enum=Reload;Dynamic
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="UpdateStrategy",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:Reload,urn:alm:descriptor:com.tectonic.ui:select:Dynamic"
+	UpdateStrategy UpstreamUpdateStrategy `json:"updateStrategy,omitempty"`
+
+	// DNS controls when the hostnames of the servers are resolved. By default the IPs resolved by the operator
+	// are rendered into the balancer, so a changed IP only takes effect after the next reconcile.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="DNS"
+	DNS *UpstreamDNS `json:"dns,omitempty"`
+
+	// Lua allows customizing peer selection with embedded Lua logic
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Lua"
+	Lua *UpstreamLuaBlock `json:"lua,omitempty"`
+}
+
+// LoadBalancerAlgorithm is the algorithm of the Lua balancer library picking a server for each request
+type LoadBalancerAlgorithm string
+
+const (
+	// LoadBalancerRandomWeighted picks a random server in proportion to the weights
+	LoadBalancerRandomWeighted LoadBalancerAlgorithm = "random-weighted"
+
+	// LoadBalancerRoundRobin cycles through the servers with nginx's smooth weighted round-robin
+	LoadBalancerRoundRobin LoadBalancerAlgorithm = "round-robin"
+
+	// LoadBalancerLeastConnections picks the server with the fewest in-flight requests relative to its weight,
+	// counted in a lua_shared_dict across all workers of the pod
+	LoadBalancerLeastConnections LoadBalancerAlgorithm = "least-connections"
+
+	// LoadBalancerConsistentHash maps a request key onto a hash ring, so the same key keeps reaching the same server
+	LoadBalancerConsistentHash LoadBalancerAlgorithm = "consistent-hash"
+
+	// LoadBalancerPeakEWMA picks the better of two random servers by their peak-sensitive
+	// moving average latency multiplied by their in-flight requests
+	LoadBalancerPeakEWMA LoadBalancerAlgorithm = "peak-ewma"
+)
+
+// UpstreamLoadBalancer configures how a server is picked for each request
+type UpstreamLoadBalancer struct {
+	// Algorithm is one of random-weighted (default), round-robin, least-connections, consistent-hash or peak-ewma
+	// +kubebuilder:validation:Enum=random-weighted;round-robin;least-connections;consistent-hash;peak-ewma
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Algorithm",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:random-weighted,urn:alm:descriptor:com.tectonic.ui:select:round-robin,urn:alm:descriptor:com.tectonic.ui:select:least-connections,urn:alm:descriptor:com.tectonic.ui:select:consistent-hash,urn:alm:descriptor:com.tectonic.ui:select:peak-ewma"
+	Algorithm LoadBalancerAlgorithm `json:"algorithm,omitempty"`
+
+	// Hash configures the key of the consistent-hash algorithm
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Hash"
+	Hash *ConsistentHash `json:"hash,omitempty"`
+}
+
+// StickinessSource is where the affinity of a request comes from
+type StickinessSource string
+
+const (
+	// StickinessCookie issues a signed cookie naming the server picked for the client
+	StickinessCookie StickinessSource = "cookie"
+	// StickinessExistingCookie maps a cookie set by the application onto a server
+	StickinessExistingCookie StickinessSource = "existing-cookie"
+	// StickinessHeader maps a request header onto a server
+	StickinessHeader StickinessSource = "header"
+)
+
+// UpstreamStickiness pins the requests of a client to a server. When the server becomes unavailable
+// the request is rebalanced, an issued cookie then names the new server.
+type UpstreamStickiness struct {
+	// By is cookie (default), existing-cookie or header. Existing cookies and headers are mapped onto
+	// the servers with a consistent hash, so they must be sent from the first request.
+	// +kubebuilder:validation:Enum=cookie;existing-cookie;header
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="By",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:cookie,urn:alm:descriptor:com.tectonic.ui:select:existing-cookie,urn:alm:descriptor:com.tectonic.ui:select:header"
+	By StickinessSource `json:"by,omitempty"`
+
+	// Name is the cookie or header name (default for an issued cookie: "affinity")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Name string `json:"name,omitempty"`
+
+	// TTLSeconds is the lifetime of an issued cookie, 0 (default) issues a session cookie
+	// +kubebuilder:validation:Minimum=0
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TTLSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+
+	// Path of an issued cookie (default: "/")
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Path",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Path string `json:"path,omitempty"`
+
+	// SameSite of an issued cookie: Lax (default), Strict or None. None requires secure.
+	// +kubebuilder:validation:Enum=Lax;Strict;None
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="SameSite",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:Lax,urn:alm:descriptor:com.tectonic.ui:select:Strict,urn:alm:descriptor:com.tectonic.ui:select:None"
+	SameSite string `json:"sameSite,omitempty"`
+
+	// Secure restricts an issued cookie to HTTPS
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Secure",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	Secure bool `json:"secure,omitempty"`
+
+	// SecretName is a Secret whose "key" signs the issued cookies. Without it the operator generates a key.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="SecretName",xDescriptors="urn:alm:descriptor:io.kubernetes:Secret"
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// ConsistentHashKey is the part of the request a consistent hash is computed from
+type ConsistentHashKey string
+
+const (
+	ConsistentHashKeyIP     ConsistentHashKey = "ip"
+	ConsistentHashKeyHeader ConsistentHashKey = "header"
+	ConsistentHashKeyCookie ConsistentHashKey = "cookie"
+	ConsistentHashKeyURI    ConsistentHashKey = "uri"
+)
+
+// ConsistentHash defines the request key and the ring of the consistent-hash algorithm
+type ConsistentHash struct {
+	// By is the request key: the client ip, a header, a cookie or the uri.
+	// Requests without the header or cookie are balanced by weighted random.
+	// +kubebuilder:validation:Enum=ip;header;cookie;uri
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="By",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:ip,urn:alm:descriptor:com.tectonic.ui:select:header,urn:alm:descriptor:com.tectonic.ui:select:cookie,urn:alm:descriptor:com.tectonic.ui:select:uri"
+	By ConsistentHashKey `json:"by"`
+
+	// Name is the header or cookie name, required when by is header or cookie
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Name",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Name string `json:"name,omitempty"`
+
+	// VirtualNodes is the number of points the heaviest server gets on the ring (default: 160),
+	// lighter servers get proportionally fewer. More points spread keys more evenly.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1000
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="VirtualNodes",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	VirtualNodes int `json:"virtualNodes,omitempty"`
+}
+
+// UpstreamPassiveHealthCheck counts the failed requests of every server in the OpenResty pods.
+// Each pod ejects servers on its own, the operator reports how many pods eject each server.
+type UpstreamPassiveHealthCheck struct {
+	// ConsecutiveFailures is the number of consecutive failed requests ejecting a server (default: 5)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ConsecutiveFailures",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+
+	// FailureStatuses lists the response status codes or ranges counted as failures, e.g. ["502-504"] (default: 500-599).
+	// Connection failures and timeouts always count.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="FailureStatuses"
+	FailureStatuses []string `json:"failureStatuses,omitempty"`
+
+	// EjectionSeconds is how long an ejected server gets no requests (default: 30)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="EjectionSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	EjectionSeconds int `json:"ejectionSeconds,omitempty"`
+
+	// MaxEjectionPercent caps the share of servers ejected at the same time, further failures do not eject more (default: 50)
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="MaxEjectionPercent",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	MaxEjectionPercent int `json:"maxEjectionPercent,omitempty"`
+}
+
+// UpstreamKeepalive is rendered as the keepalive directives of the upstream block
+type UpstreamKeepalive struct {
+	// Connections is the number of idle connections each worker keeps open to the servers
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Connections",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	Connections int `json:"connections"`
+
+	// TimeoutSeconds closes a connection idle for longer (default: 60)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TimeoutSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+	// MaxRequests closes a connection after this many requests (default: 1000)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="MaxRequests",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	MaxRequests int `json:"maxRequests,omitempty"`
+}
+
+// UpstreamUpdateStrategy is how server changes reach the OpenResty pods
+type UpstreamUpdateStrategy string
+
+const (
+	// UpstreamUpdateReload renders the servers into the nginx config, changes reload nginx
+	UpstreamUpdateReload UpstreamUpdateStrategy = "Reload"
+
+	// UpstreamUpdateDynamic renders the servers into a separate peers file pushed to the Lua balancer without reloads
+	UpstreamUpdateDynamic UpstreamUpdateStrategy = "Dynamic"
+)
+
+// DNSResolution selects who resolves the hostnames of the servers
+type DNSResolution string
+
+const (
+	// DNSResolutionOperator renders the IPs resolved by the operator during the health check
+	DNSResolutionOperator DNSResolution = "operator"
+
+	// DNSResolutionRuntime resolves the hostnames in the Lua balancer of every pod, with the resolver of the OpenResty
+	DNSResolutionRuntime DNSResolution = "runtime"
+)
+
+// DNSFallback is what the Lua balancer does when resolving a hostname fails
+type DNSFallback string
+
+const (
+	// DNSFallbackStale keeps using the last resolved IPs, or the IPs resolved by the operator before any succeeded
+	DNSFallbackStale DNSFallback = "stale"
+
+	// DNSFallbackOperator uses the IPs resolved by the operator
+	DNSFallbackOperator DNSFallback = "operator"
+
+	// DNSFallbackFail skips the server until its hostname resolves again
+	DNSFallbackFail DNSFallback = "fail"
+)
+
+type UpstreamDNS struct {
+	// Resolution is "operator" or "runtime" (default: operator). Runtime resolution is only supported by
+	// Address upstreams, FullURL upstreams are already resolved by nginx at request time.
+	// +kubebuilder:validation:Enum=operator;runtime
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Resolution",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:operator,urn:alm:descriptor:com.tectonic.ui:select:runtime"
+	Resolution DNSResolution `json:"resolution,omitempty"`
+
+	// MinTTLSeconds is the shortest time a runtime answer is cached, even when its TTL is lower (default: 5)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="MinTTLSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	MinTTLSeconds int `json:"minTTLSeconds,omitempty"`
+
+	// MaxTTLSeconds is the longest time a runtime answer is cached, even when its TTL is higher (default: 300)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="MaxTTLSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	MaxTTLSeconds int `json:"maxTTLSeconds,omitempty"`
+
+	// Fallback is "stale", "operator" or "fail" when runtime resolution fails (default: stale)
+	// +kubebuilder:validation:Enum=stale;operator;fail
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Fallback",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:stale,urn:alm:descriptor:com.tectonic.ui:select:operator,urn:alm:descriptor:com.tectonic.ui:select:fail"
+	Fallback DNSFallback `json:"fallback,omitempty"`
+}
+
+// HealthCheckType is the protocol of an active health check
+type HealthCheckType string
+
+const (
+	HealthCheckTypeTCP   HealthCheckType = "tcp"
+	HealthCheckTypeHTTP  HealthCheckType = "http"
+	HealthCheckTypeHTTPS HealthCheckType = "https"
+	HealthCheckTypeGRPC  HealthCheckType = "grpc"
+	HealthCheckTypeGRPCS HealthCheckType = "grpcs"
+	HealthCheckTypeTLS   HealthCheckType = "tls"
+)
+
+// UpstreamHealthCheck defines an active health check and the thresholds moving a server between up and down
+type UpstreamHealthCheck struct {
+	// Type is tcp (default), http, https, grpc, grpcs or tls. HTTP checks send a request to every server and match the response,
+	// gRPC checks call grpc.health.v1.Health/Check (grpcs over TLS), tls checks only complete a TLS handshake.
+	// +kubebuilder:validation:Enum=tcp;http;https;grpc;grpcs;tls
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Type",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:tcp,urn:alm:descriptor:com.tectonic.ui:select:http,urn:alm:descriptor:com.tectonic.ui:select:https,urn:alm:descriptor:com.tectonic.ui:select:grpc,urn:alm:descriptor:com.tectonic.ui:select:grpcs,urn:alm:descriptor:com.tectonic.ui:select:tls"
+	Type HealthCheckType `json:"type,omitempty"`
+
+	// Path of the HTTP request (default: /)
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Path",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Path string `json:"path,omitempty"`
+
+	// Method of the HTTP request (default: GET)
+	// +kubebuilder:validation:Enum=GET;HEAD;POST;OPTIONS
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Method",xDescriptors="urn:alm:descriptor:com.tectonic.ui:select:GET,urn:alm:descriptor:com.tectonic.ui:select:HEAD,urn:alm:descriptor:com.tectonic.ui:select:POST,urn:alm:descriptor:com.tectonic.ui:select:OPTIONS"
+	Method string `json:"method,omitempty"`
+
+	// Host overrides the Host header, the gRPC authority and the TLS server name (SNI),
+	// which default to the host of the server address
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Host",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	Host string `json:"host,omitempty"`
+
+	// Headers are added to the HTTP request
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Headers"
+	Headers []NginxKV `json:"headers,omitempty"`
+
+	// ExpectedStatuses lists the healthy status codes or ranges, e.g. ["200-299", "404"] (default: 200-399)
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ExpectedStatuses"
+	ExpectedStatuses []string `json:"expectedStatuses,omitempty"`
+
+	// BodyMatch is a regular expression the first 64KiB of the response body must match
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="BodyMatch",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	BodyMatch string `json:"bodyMatch,omitempty"`
+
+	// GRPCService is the service name sent in the gRPC health check request, empty checks the whole server
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="GRPCService",xDescriptors="urn:alm:descriptor:com.tectonic.ui:text"
+	GRPCService string `json:"grpcService,omitempty"`
+
+	// InsecureSkipVerify skips the verification of the certificate chain and server name of https, grpcs and tls checks,
+	// expired certificates still fail the check
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="InsecureSkipVerify",xDescriptors="urn:alm:descriptor:com.tectonic.ui:booleanSwitch"
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// MinCertValidDays fails https, grpcs and tls checks when the server certificate expires within this many days
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=36500
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="MinCertValidDays",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	MinCertValidDays int `json:"minCertValidDays,omitempty"`
+
+	// IntervalSeconds between two checks of a server (default: 10)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="IntervalSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+
+	// TimeoutSeconds of a check, including the DNS lookup (default: 2)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="TimeoutSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+	// HealthyThreshold is the number of consecutive successes marking a down server up (default: 2)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="HealthyThreshold",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	HealthyThreshold int `json:"healthyThreshold,omitempty"`
+
+	// UnhealthyThreshold is the number of consecutive failures marking an up server down (default: 3)
+	// +kubebuilder:validation:Minimum=1
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="UnhealthyThreshold",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	UnhealthyThreshold int `json:"unhealthyThreshold,omitempty"`
+
+	// JitterSeconds adds a random delay of up to this many seconds to every interval,
+	// spreading the checks of many servers
+	// +kubebuilder:validation:Minimum=0
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="JitterSeconds",xDescriptors="urn:alm:descriptor:com.tectonic.ui:number"
+	JitterSeconds int `json:"jitterSeconds,omitempty"`
+}
+
+// UpstreamLuaBlock defines embedded Lua logic for upstream phases
+type UpstreamLuaBlock struct {
+	// Balancer is the body of a Lua function receiving the rendered `servers` table and returning the
+	// chosen server (a server table for Address upstreams, an address string for FullURL upstreams).
+	// Returning nil falls back to the loadBalancer algorithm.
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Balancer"
+	Balancer string `json:"balancer,omitempty"`
 }
 
 type UpstreamServerStatus struct {
@@ -61,9 +461,44 @@ type UpstreamServerStatus struct {
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Address"
 	Address string `json:"address"`
 
-	// Alive indicates whether the server is reachable and responsive
+	// Alive indicates whether the server passes its health check, servers that are down stay listed
 	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Alive"
 	Alive bool `json:"alive"`
+
+	// Weight is the effective weight used by the balancer, 0 while the server is draining
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Weight"
+	Weight int `json:"weight"`
+
+	// Backup indicates the server only receives requests when all primary servers are down
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Backup"
+	Backup bool `json:"backup,omitempty"`
+
+	// Draining indicates the server no longer receives new requests, set by drain or for a terminating endpoint
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Draining"
+	Draining bool `json:"draining,omitempty"`
+
+	// Reason explains why the server is down, e.g. HTTP_STATUS
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Reason"
+	Reason string `json:"reason,omitempty"`
+
+	// LastTransitionTime is when the server last went up or down
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="LastTransitionTime"
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// CertificateExpiryDays is the number of days left before the server certificate expires,
+	// reported by https, grpcs and tls health checks
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="CertificateExpiryDays"
+	CertificateExpiryDays *int `json:"certificateExpiryDays,omitempty"`
+
+	// EjectedPods is the number of OpenResty pods currently ejecting the server after failed requests,
+	// see passiveHealthCheck
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="EjectedPods"
+	EjectedPods int `json:"ejectedPods,omitempty"`
+
+	// UnhealthyPods is the number of OpenResty pods whose in-pod health check reports the server down,
+	// see the inPodHealthCheck of OpenResty
+	//+operator-sdk:csv:customresourcedefinitions:type=spec,displayName="UnhealthyPods"
+	UnhealthyPods int `json:"unhealthyPods,omitempty"`
 }
 
 // UpstreamStatus defines the observed state of Upstream
@@ -76,6 +511,9 @@ type UpstreamStatus struct {
 	Ready   bool   `json:"ready"`             // 是否有效
 	Version string `json:"version,omitempty"` // 对应 generation
 	Reason  string `json:"reason,omitempty"`  // 可选：失败原因
+
+	// LintFindings lists problems found in the generated nginx config
+	LintFindings []string `json:"lintFindings,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -21,8 +21,8 @@ limitations under the License.
 package v1alpha1
 
 import (
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -41,6 +41,52 @@ func (in *CacheConf) DeepCopy() *CacheConf {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigValidation) DeepCopyInto(out *ConfigValidation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigValidation.
+func (in *ConfigValidation) DeepCopy() *ConfigValidation {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigValidation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsistentHash) DeepCopyInto(out *ConsistentHash) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsistentHash.
+func (in *ConsistentHash) DeepCopy() *ConsistentHash {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsistentHash)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GlobalRateLimit) DeepCopyInto(out *GlobalRateLimit) {
+	*out = *in
+	out.Redis = in.Redis
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GlobalRateLimit.
+func (in *GlobalRateLimit) DeepCopy() *GlobalRateLimit {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalRateLimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GzipConf) DeepCopyInto(out *GzipConf) {
 	*out = *in
@@ -74,6 +120,11 @@ func (in *HttpBlock) DeepCopyInto(out *HttpBlock) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Resolver != nil {
+		in, out := &in.Resolver, &out.Resolver
+		*out = new(Resolver)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ServerRefs != nil {
 		in, out := &in.ServerRefs, &out.ServerRefs
 		*out = make([]string, len(*in))
@@ -84,6 +135,26 @@ func (in *HttpBlock) DeepCopyInto(out *HttpBlock) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.LuaModuleRefs != nil {
+		in, out := &in.LuaModuleRefs, &out.LuaModuleRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RateLimitPolicyRefs != nil {
+		in, out := &in.RateLimitPolicyRefs, &out.RateLimitPolicyRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TrafficShapingPolicyRefs != nil {
+		in, out := &in.TrafficShapingPolicyRefs, &out.TrafficShapingPolicyRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.QuotaRefs != nil {
+		in, out := &in.QuotaRefs, &out.QuotaRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HttpBlock.
@@ -96,13 +167,28 @@ func (in *HttpBlock) DeepCopy() *HttpBlock {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InPodHealthCheck) DeepCopyInto(out *InPodHealthCheck) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InPodHealthCheck.
+func (in *InPodHealthCheck) DeepCopy() *InPodHealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(InPodHealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Location) DeepCopyInto(out *Location) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Location.
@@ -126,6 +212,11 @@ func (in *Location) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LocationEntry) DeepCopyInto(out *LocationEntry) {
 	*out = *in
+	if in.UpstreamRef != nil {
+		in, out := &in.UpstreamRef, &out.UpstreamRef
+		*out = new(UpstreamReference)
+		**out = **in
+	}
 	if in.Headers != nil {
 		in, out := &in.Headers, &out.Headers
 		*out = make([]NginxKV, len(*in))
@@ -146,9 +237,19 @@ func (in *LocationEntry) DeepCopyInto(out *LocationEntry) {
 		*out = new(bool)
 		**out = **in
 	}
-	if in.LimitReq != nil {
-		in, out := &in.LimitReq, &out.LimitReq
-		*out = new(string)
+	if in.RateLimitPolicyRef != nil {
+		in, out := &in.RateLimitPolicyRef, &out.RateLimitPolicyRef
+		*out = new(RateLimitPolicyReference)
+		**out = **in
+	}
+	if in.TrafficShapingPolicyRef != nil {
+		in, out := &in.TrafficShapingPolicyRef, &out.TrafficShapingPolicyRef
+		*out = new(TrafficShapingPolicyReference)
+		**out = **in
+	}
+	if in.QuotaRef != nil {
+		in, out := &in.QuotaRef, &out.QuotaRef
+		*out = new(QuotaReference)
 		**out = **in
 	}
 	if in.Gzip != nil {
@@ -166,6 +267,11 @@ func (in *LocationEntry) DeepCopyInto(out *LocationEntry) {
 		*out = new(LuaBlock)
 		**out = **in
 	}
+	if in.Static != nil {
+		in, out := &in.Static, &out.Static
+		*out = new(StaticConf)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Extra != nil {
 		in, out := &in.Extra, &out.Extra
 		*out = make([]string, len(*in))
@@ -240,6 +346,31 @@ func (in *LocationSpec) DeepCopy() *LocationSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LocationStatus) DeepCopyInto(out *LocationStatus) {
 	*out = *in
+	if in.UpstreamRefs != nil {
+		in, out := &in.UpstreamRefs, &out.UpstreamRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RateLimitPolicyRefs != nil {
+		in, out := &in.RateLimitPolicyRefs, &out.RateLimitPolicyRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TrafficShapingPolicyRefs != nil {
+		in, out := &in.TrafficShapingPolicyRefs, &out.TrafficShapingPolicyRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.QuotaRefs != nil {
+		in, out := &in.QuotaRefs, &out.QuotaRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LintFindings != nil {
+		in, out := &in.LintFindings, &out.LintFindings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocationStatus.
@@ -282,6 +413,125 @@ func (in *LuaBlock) DeepCopy() *LuaBlock {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LuaModule) DeepCopyInto(out *LuaModule) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LuaModule.
+func (in *LuaModule) DeepCopy() *LuaModule {
+	if in == nil {
+		return nil
+	}
+	out := new(LuaModule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LuaModule) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LuaModuleFile) DeepCopyInto(out *LuaModuleFile) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LuaModuleFile.
+func (in *LuaModuleFile) DeepCopy() *LuaModuleFile {
+	if in == nil {
+		return nil
+	}
+	out := new(LuaModuleFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LuaModuleList) DeepCopyInto(out *LuaModuleList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]LuaModule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LuaModuleList.
+func (in *LuaModuleList) DeepCopy() *LuaModuleList {
+	if in == nil {
+		return nil
+	}
+	out := new(LuaModuleList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LuaModuleList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LuaModuleSpec) DeepCopyInto(out *LuaModuleSpec) {
+	*out = *in
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make([]LuaModuleFile, len(*in))
+		copy(*out, *in)
+	}
+	if in.Dependencies != nil {
+		in, out := &in.Dependencies, &out.Dependencies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LuaModuleSpec.
+func (in *LuaModuleSpec) DeepCopy() *LuaModuleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LuaModuleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LuaModuleStatus) DeepCopyInto(out *LuaModuleStatus) {
+	*out = *in
+	if in.Dependencies != nil {
+		in, out := &in.Dependencies, &out.Dependencies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LuaModuleStatus.
+func (in *LuaModuleStatus) DeepCopy() *LuaModuleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LuaModuleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsServer) DeepCopyInto(out *MetricsServer) {
 	*out = *in
@@ -381,7 +631,7 @@ func (in *NormalizeRuleSpec) DeepCopyInto(out *NormalizeRuleSpec) {
 	}
 	if in.Response != nil {
 		in, out := &in.Response, &out.Response
-		*out = make(map[string]v1.JSON, len(*in))
+		*out = make(map[string]apiextensionsv1.JSON, len(*in))
 		for key, val := range *in {
 			(*out)[key] = *val.DeepCopy()
 		}
@@ -419,7 +669,7 @@ func (in *OpenResty) DeepCopyInto(out *OpenResty) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenResty.
@@ -498,7 +748,7 @@ func (in *OpenRestySpec) DeepCopyInto(out *OpenRestySpec) {
 	}
 	if in.ReloadAgentEnv != nil {
 		in, out := &in.ReloadAgentEnv, &out.ReloadAgentEnv
-		*out = make([]corev1.EnvVar, len(*in))
+		*out = make([]v1.EnvVar, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -512,14 +762,14 @@ func (in *OpenRestySpec) DeepCopyInto(out *OpenRestySpec) {
 	}
 	if in.Tolerations != nil {
 		in, out := &in.Tolerations, &out.Tolerations
-		*out = make([]corev1.Toleration, len(*in))
+		*out = make([]v1.Toleration, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 	if in.Affinity != nil {
 		in, out := &in.Affinity, &out.Affinity
-		*out = new(corev1.Affinity)
+		*out = new(v1.Affinity)
 		(*in).DeepCopyInto(*out)
 	}
 	if in.TerminationGracePeriodSeconds != nil {
@@ -528,6 +778,16 @@ func (in *OpenRestySpec) DeepCopyInto(out *OpenRestySpec) {
 		**out = **in
 	}
 	out.LogVolume = in.LogVolume
+	if in.ConfigValidation != nil {
+		in, out := &in.ConfigValidation, &out.ConfigValidation
+		*out = new(ConfigValidation)
+		**out = **in
+	}
+	if in.InPodHealthCheck != nil {
+		in, out := &in.InPodHealthCheck, &out.InPodHealthCheck
+		*out = new(InPodHealthCheck)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenRestySpec.
@@ -543,6 +803,11 @@ func (in *OpenRestySpec) DeepCopy() *OpenRestySpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OpenRestyStatus) DeepCopyInto(out *OpenRestyStatus) {
 	*out = *in
+	if in.LintFindings != nil {
+		in, out := &in.LintFindings, &out.LintFindings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenRestyStatus.
@@ -556,26 +821,26 @@ func (in *OpenRestyStatus) DeepCopy() *OpenRestyStatus {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RateLimitPolicy) DeepCopyInto(out *RateLimitPolicy) {
+func (in *Quota) DeepCopyInto(out *Quota) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicy.
-func (in *RateLimitPolicy) DeepCopy() *RateLimitPolicy {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Quota.
+func (in *Quota) DeepCopy() *Quota {
 	if in == nil {
 		return nil
 	}
-	out := new(RateLimitPolicy)
+	out := new(Quota)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *RateLimitPolicy) DeepCopyObject() runtime.Object {
+func (in *Quota) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -583,31 +848,46 @@ func (in *RateLimitPolicy) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RateLimitPolicyList) DeepCopyInto(out *RateLimitPolicyList) {
+func (in *QuotaConsumerUsage) DeepCopyInto(out *QuotaConsumerUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaConsumerUsage.
+func (in *QuotaConsumerUsage) DeepCopy() *QuotaConsumerUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaConsumerUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaList) DeepCopyInto(out *QuotaList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]RateLimitPolicy, len(*in))
+		*out = make([]Quota, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicyList.
-func (in *RateLimitPolicyList) DeepCopy() *RateLimitPolicyList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaList.
+func (in *QuotaList) DeepCopy() *QuotaList {
 	if in == nil {
 		return nil
 	}
-	out := new(RateLimitPolicyList)
+	out := new(QuotaList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *RateLimitPolicyList) DeepCopyObject() runtime.Object {
+func (in *QuotaList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -615,81 +895,330 @@ func (in *RateLimitPolicyList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RateLimitPolicySpec) DeepCopyInto(out *RateLimitPolicySpec) {
+func (in *QuotaOverride) DeepCopyInto(out *QuotaOverride) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicySpec.
-func (in *RateLimitPolicySpec) DeepCopy() *RateLimitPolicySpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaOverride.
+func (in *QuotaOverride) DeepCopy() *QuotaOverride {
 	if in == nil {
 		return nil
 	}
-	out := new(RateLimitPolicySpec)
+	out := new(QuotaOverride)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RateLimitPolicyStatus) DeepCopyInto(out *RateLimitPolicyStatus) {
+func (in *QuotaReference) DeepCopyInto(out *QuotaReference) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicyStatus.
-func (in *RateLimitPolicyStatus) DeepCopy() *RateLimitPolicyStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaReference.
+func (in *QuotaReference) DeepCopy() *QuotaReference {
 	if in == nil {
 		return nil
 	}
-	out := new(RateLimitPolicyStatus)
+	out := new(QuotaReference)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RequestSpec) DeepCopyInto(out *RequestSpec) {
+func (in *QuotaSpec) DeepCopyInto(out *QuotaSpec) {
 	*out = *in
-	if in.Body != nil {
-		in, out := &in.Body, &out.Body
-		*out = make(map[string]v1.JSON, len(*in))
-		for key, val := range *in {
-			(*out)[key] = *val.DeepCopy()
-		}
-	}
-	if in.Query != nil {
-		in, out := &in.Query, &out.Query
-		*out = make(map[string]v1.JSON, len(*in))
-		for key, val := range *in {
-			(*out)[key] = *val.DeepCopy()
-		}
+	if in.Consumer != nil {
+		in, out := &in.Consumer, &out.Consumer
+		*out = make([]RateLimitKeySource, len(*in))
+		copy(*out, *in)
 	}
-	if in.QueryFromSecret != nil {
-		in, out := &in.QueryFromSecret, &out.QueryFromSecret
-		*out = make([]ValueFromSecret, len(*in))
+	if in.Overrides != nil {
+		in, out := &in.Overrides, &out.Overrides
+		*out = make([]QuotaOverride, len(*in))
 		copy(*out, *in)
 	}
-	if in.Headers != nil {
-		in, out := &in.Headers, &out.Headers
-		*out = make([]NginxKV, len(*in))
+	out.Redis = in.Redis
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaSpec.
+func (in *QuotaSpec) DeepCopy() *QuotaSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaStatus) DeepCopyInto(out *QuotaStatus) {
+	*out = *in
+	if in.LintFindings != nil {
+		in, out := &in.LintFindings, &out.LintFindings
+		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
-	if in.HeadersFromSecret != nil {
-		in, out := &in.HeadersFromSecret, &out.HeadersFromSecret
-		*out = make([]ValueFromSecret, len(*in))
+	if in.TopConsumers != nil {
+		in, out := &in.TopConsumers, &out.TopConsumers
+		*out = make([]QuotaConsumerUsage, len(*in))
 		copy(*out, *in)
 	}
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RequestSpec.
-func (in *RequestSpec) DeepCopy() *RequestSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaStatus.
+func (in *QuotaStatus) DeepCopy() *QuotaStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(RequestSpec)
+	out := new(QuotaStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ServerBlock) DeepCopyInto(out *ServerBlock) {
+func (in *RateLimitKeySource) DeepCopyInto(out *RateLimitKeySource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitKeySource.
+func (in *RateLimitKeySource) DeepCopy() *RateLimitKeySource {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitKeySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitPolicy) DeepCopyInto(out *RateLimitPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicy.
+func (in *RateLimitPolicy) DeepCopy() *RateLimitPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RateLimitPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitPolicyList) DeepCopyInto(out *RateLimitPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RateLimitPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicyList.
+func (in *RateLimitPolicyList) DeepCopy() *RateLimitPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RateLimitPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitPolicyReference) DeepCopyInto(out *RateLimitPolicyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicyReference.
+func (in *RateLimitPolicyReference) DeepCopy() *RateLimitPolicyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitPolicyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitPolicySpec) DeepCopyInto(out *RateLimitPolicySpec) {
+	*out = *in
+	if in.KeySources != nil {
+		in, out := &in.KeySources, &out.KeySources
+		*out = make([]RateLimitKeySource, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rejection != nil {
+		in, out := &in.Rejection, &out.Rejection
+		*out = new(RateLimitRejection)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Global != nil {
+		in, out := &in.Global, &out.Global
+		*out = new(GlobalRateLimit)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicySpec.
+func (in *RateLimitPolicySpec) DeepCopy() *RateLimitPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitPolicyStatus) DeepCopyInto(out *RateLimitPolicyStatus) {
+	*out = *in
+	if in.LintFindings != nil {
+		in, out := &in.LintFindings, &out.LintFindings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitPolicyStatus.
+func (in *RateLimitPolicyStatus) DeepCopy() *RateLimitPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimitRejection) DeepCopyInto(out *RateLimitRejection) {
+	*out = *in
+	if in.RetryAfterSeconds != nil {
+		in, out := &in.RetryAfterSeconds, &out.RetryAfterSeconds
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimitRejection.
+func (in *RateLimitRejection) DeepCopy() *RateLimitRejection {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimitRejection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedisConnection) DeepCopyInto(out *RedisConnection) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedisConnection.
+func (in *RedisConnection) DeepCopy() *RedisConnection {
+	if in == nil {
+		return nil
+	}
+	out := new(RedisConnection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RequestSpec) DeepCopyInto(out *RequestSpec) {
+	*out = *in
+	if in.Body != nil {
+		in, out := &in.Body, &out.Body
+		*out = make(map[string]apiextensionsv1.JSON, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Query != nil {
+		in, out := &in.Query, &out.Query
+		*out = make(map[string]apiextensionsv1.JSON, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.QueryFromSecret != nil {
+		in, out := &in.QueryFromSecret, &out.QueryFromSecret
+		*out = make([]ValueFromSecret, len(*in))
+		copy(*out, *in)
+	}
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make([]NginxKV, len(*in))
+		copy(*out, *in)
+	}
+	if in.HeadersFromSecret != nil {
+		in, out := &in.HeadersFromSecret, &out.HeadersFromSecret
+		*out = make([]ValueFromSecret, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RequestSpec.
+func (in *RequestSpec) DeepCopy() *RequestSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RequestSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Resolver) DeepCopyInto(out *Resolver) {
+	*out = *in
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Resolver.
+func (in *Resolver) DeepCopy() *Resolver {
+	if in == nil {
+		return nil
+	}
+	out := new(Resolver)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServerBlock) DeepCopyInto(out *ServerBlock) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
@@ -785,6 +1314,31 @@ func (in *ServerBlockStatus) DeepCopyInto(out *ServerBlockStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.UpstreamRefs != nil {
+		in, out := &in.UpstreamRefs, &out.UpstreamRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RateLimitPolicyRefs != nil {
+		in, out := &in.RateLimitPolicyRefs, &out.RateLimitPolicyRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TrafficShapingPolicyRefs != nil {
+		in, out := &in.TrafficShapingPolicyRefs, &out.TrafficShapingPolicyRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.QuotaRefs != nil {
+		in, out := &in.QuotaRefs, &out.QuotaRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LintFindings != nil {
+		in, out := &in.LintFindings, &out.LintFindings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServerBlockStatus.
@@ -826,6 +1380,58 @@ func (in *ServiceMonitor) DeepCopy() *ServiceMonitor {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StaticCacheControl) DeepCopyInto(out *StaticCacheControl) {
+	*out = *in
+	if in.Extensions != nil {
+		in, out := &in.Extensions, &out.Extensions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StaticCacheControl.
+func (in *StaticCacheControl) DeepCopy() *StaticCacheControl {
+	if in == nil {
+		return nil
+	}
+	out := new(StaticCacheControl)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StaticConf) DeepCopyInto(out *StaticConf) {
+	*out = *in
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Image != nil {
+		in, out := &in.Image, &out.Image
+		*out = new(v1.ImageVolumeSource)
+		**out = **in
+	}
+	if in.CacheControl != nil {
+		in, out := &in.CacheControl, &out.CacheControl
+		*out = make([]StaticCacheControl, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StaticConf.
+func (in *StaticConf) DeepCopy() *StaticConf {
+	if in == nil {
+		return nil
+	}
+	out := new(StaticConf)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Timeouts) DeepCopyInto(out *Timeouts) {
 	*out = *in
@@ -841,6 +1447,115 @@ func (in *Timeouts) DeepCopy() *Timeouts {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShapingPolicy) DeepCopyInto(out *TrafficShapingPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShapingPolicy.
+func (in *TrafficShapingPolicy) DeepCopy() *TrafficShapingPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShapingPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TrafficShapingPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShapingPolicyList) DeepCopyInto(out *TrafficShapingPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TrafficShapingPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShapingPolicyList.
+func (in *TrafficShapingPolicyList) DeepCopy() *TrafficShapingPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShapingPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TrafficShapingPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShapingPolicyReference) DeepCopyInto(out *TrafficShapingPolicyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShapingPolicyReference.
+func (in *TrafficShapingPolicyReference) DeepCopy() *TrafficShapingPolicyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShapingPolicyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShapingPolicySpec) DeepCopyInto(out *TrafficShapingPolicySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShapingPolicySpec.
+func (in *TrafficShapingPolicySpec) DeepCopy() *TrafficShapingPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShapingPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShapingPolicyStatus) DeepCopyInto(out *TrafficShapingPolicyStatus) {
+	*out = *in
+	if in.LintFindings != nil {
+		in, out := &in.LintFindings, &out.LintFindings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShapingPolicyStatus.
+func (in *TrafficShapingPolicyStatus) DeepCopy() *TrafficShapingPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShapingPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Upstream) DeepCopyInto(out *Upstream) {
 	*out = *in
@@ -868,6 +1583,61 @@ func (in *Upstream) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamDNS) DeepCopyInto(out *UpstreamDNS) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamDNS.
+func (in *UpstreamDNS) DeepCopy() *UpstreamDNS {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamDNS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamHealthCheck) DeepCopyInto(out *UpstreamHealthCheck) {
+	*out = *in
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make([]NginxKV, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExpectedStatuses != nil {
+		in, out := &in.ExpectedStatuses, &out.ExpectedStatuses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamHealthCheck.
+func (in *UpstreamHealthCheck) DeepCopy() *UpstreamHealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamHealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamKeepalive) DeepCopyInto(out *UpstreamKeepalive) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamKeepalive.
+func (in *UpstreamKeepalive) DeepCopy() *UpstreamKeepalive {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamKeepalive)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UpstreamList) DeepCopyInto(out *UpstreamList) {
 	*out = *in
@@ -900,12 +1670,87 @@ func (in *UpstreamList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamLoadBalancer) DeepCopyInto(out *UpstreamLoadBalancer) {
+	*out = *in
+	if in.Hash != nil {
+		in, out := &in.Hash, &out.Hash
+		*out = new(ConsistentHash)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamLoadBalancer.
+func (in *UpstreamLoadBalancer) DeepCopy() *UpstreamLoadBalancer {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamLoadBalancer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamLuaBlock) DeepCopyInto(out *UpstreamLuaBlock) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamLuaBlock.
+func (in *UpstreamLuaBlock) DeepCopy() *UpstreamLuaBlock {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamLuaBlock)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamPassiveHealthCheck) DeepCopyInto(out *UpstreamPassiveHealthCheck) {
+	*out = *in
+	if in.FailureStatuses != nil {
+		in, out := &in.FailureStatuses, &out.FailureStatuses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamPassiveHealthCheck.
+func (in *UpstreamPassiveHealthCheck) DeepCopy() *UpstreamPassiveHealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamPassiveHealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamReference) DeepCopyInto(out *UpstreamReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamReference.
+func (in *UpstreamReference) DeepCopy() *UpstreamReference {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UpstreamServer) DeepCopyInto(out *UpstreamServer) {
 	*out = *in
+	if in.MaxFails != nil {
+		in, out := &in.MaxFails, &out.MaxFails
+		*out = new(int)
+		**out = **in
+	}
 	if in.NormalizeRequestRef != nil {
 		in, out := &in.NormalizeRequestRef, &out.NormalizeRequestRef
-		*out = new(corev1.LocalObjectReference)
+		*out = new(v1.LocalObjectReference)
 		**out = **in
 	}
 }
@@ -923,6 +1768,15 @@ func (in *UpstreamServer) DeepCopy() *UpstreamServer {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UpstreamServerStatus) DeepCopyInto(out *UpstreamServerStatus) {
 	*out = *in
+	if in.LastTransitionTime != nil {
+		in, out := &in.LastTransitionTime, &out.LastTransitionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CertificateExpiryDays != nil {
+		in, out := &in.CertificateExpiryDays, &out.CertificateExpiryDays
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamServerStatus.
@@ -935,6 +1789,22 @@ func (in *UpstreamServerStatus) DeepCopy() *UpstreamServerStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamServiceRef) DeepCopyInto(out *UpstreamServiceRef) {
+	*out = *in
+	out.Port = in.Port
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamServiceRef.
+func (in *UpstreamServiceRef) DeepCopy() *UpstreamServiceRef {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamServiceRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UpstreamSpec) DeepCopyInto(out *UpstreamSpec) {
 	*out = *in
@@ -945,6 +1815,46 @@ func (in *UpstreamSpec) DeepCopyInto(out *UpstreamSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ServiceRef != nil {
+		in, out := &in.ServiceRef, &out.ServiceRef
+		*out = new(UpstreamServiceRef)
+		**out = **in
+	}
+	if in.LoadBalancer != nil {
+		in, out := &in.LoadBalancer, &out.LoadBalancer
+		*out = new(UpstreamLoadBalancer)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Stickiness != nil {
+		in, out := &in.Stickiness, &out.Stickiness
+		*out = new(UpstreamStickiness)
+		**out = **in
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(UpstreamHealthCheck)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PassiveHealthCheck != nil {
+		in, out := &in.PassiveHealthCheck, &out.PassiveHealthCheck
+		*out = new(UpstreamPassiveHealthCheck)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Keepalive != nil {
+		in, out := &in.Keepalive, &out.Keepalive
+		*out = new(UpstreamKeepalive)
+		**out = **in
+	}
+	if in.DNS != nil {
+		in, out := &in.DNS, &out.DNS
+		*out = new(UpstreamDNS)
+		**out = **in
+	}
+	if in.Lua != nil {
+		in, out := &in.Lua, &out.Lua
+		*out = new(UpstreamLuaBlock)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamSpec.
@@ -963,6 +1873,13 @@ func (in *UpstreamStatus) DeepCopyInto(out *UpstreamStatus) {
 	if in.Servers != nil {
 		in, out := &in.Servers, &out.Servers
 		*out = make([]UpstreamServerStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LintFindings != nil {
+		in, out := &in.LintFindings, &out.LintFindings
+		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
 }
@@ -977,6 +1894,21 @@ func (in *UpstreamStatus) DeepCopy() *UpstreamStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamStickiness) DeepCopyInto(out *UpstreamStickiness) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamStickiness.
+func (in *UpstreamStickiness) DeepCopy() *UpstreamStickiness {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamStickiness)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ValueFromSecret) DeepCopyInto(out *ValueFromSecret) {
 	*out = *in
@@ -4,6 +4,8 @@ local prometheus
 local metric_latency
 local metric_total
 local metric_errors
+local metric_quota_exceeded
+local metric_ejections
 
 function _M.init()
     prometheus = require("prometheus").init("prometheus_metrics")
@@ -25,6 +27,18 @@ function _M.init()
         "Total upstream errors by type",
         {"server", "upstream", "path", "error_type"}
     )
+
+    metric_quota_exceeded = prometheus:counter(
+        "quota_exceeded_requests_total",
+        "Requests sent by consumers over their quota",
+        {"quota", "action"}
+    )
+
+    metric_ejections = prometheus:counter(
+        "upstream_peer_ejections_total",
+        "Servers ejected by the passive health check, result is ejected or max_percent when the ejection was skipped",
+        {"upstream", "peer", "result"}
+    )
 end
 
 function _M.record()
@@ -45,4 +59,16 @@ function _M.record()
     end
 end
 
+-- quota_exceeded counts a request over quota, action is "rejected" or "allowed" by the Quota overage
+function _M.quota_exceeded(quota, action)
+    metric_quota_exceeded:inc(1, {quota, action})
+end
+
+-- ejection counts a server ejected by the passive health check, see upstreams.outlier
+function _M.ejection(upstream, peer, result)
+    if metric_ejections then
+        metric_ejections:inc(1, {upstream, peer, result})
+    end
+end
+
 return _M
\ No newline at end of file
@@ -0,0 +1,225 @@
+local redis = require("ratelimit.redis")
+local key = require("ratelimit.key")
+local metrics = require("metrics")
+local resty_string = require("resty.string")
+
+local _M = {}
+local mt = { __index = _M }
+
+-- lua_shared_dict 中的计数："d:" 为尚未同步到 Redis 的增量，"t:" 为最近一次从 Redis 读到的总量
+local DELTA = "d:"
+local TOTAL = "t:"
+
+-- Redis 连接失败后的这段时间内不再尝试读取总量，避免每个请求都等待连接超时
+local DOWN_PERIOD = 1
+
+-- Redis 中的计数在周期结束后保留一段时间，便于对账
+local RETENTION = 35 * 86400
+
+-- 周期结束前计入的增量在周期结束后仍需保留到下一次同步
+local DELTA_GRACE = 300
+
+local function period_of(period, now)
+    if period == "month" then
+        return os.date("!%Y%m", now)
+    end
+    return os.date("!%Y%m%d", now)
+end
+
+-- period_end returns the unix time a period such as "20251019" or "202510" ends at
+local function period_end(period)
+    local t = {
+        year = tonumber(period:sub(1, 4)),
+        month = tonumber(period:sub(5, 6)),
+        day = tonumber(period:sub(7, 8)) or 1,
+        hour = 0,
+    }
+    if #period == 6 then
+        t.month = t.month + 1
+    else
+        t.day = t.day + 1
+    end
+
+    -- os.time() 按本地时区解释 table，补上本地时区与 UTC 的差值
+    local now = os.time()
+    return os.time(t) + os.difftime(now, os.time(os.date("!*t", now)))
+end
+
+-- ttl keeps dict entries until the period ends, entries of a past period expire right after their last sync
+local function ttl(period, now)
+    return math.max(period_end(period) - now, 1)
+end
+
+local function split(k)
+    return k:match("^(.*):([^:]+)$")
+end
+
+-- conf is rendered by the operator from a Quota
+function _M.new(conf)
+    conf.sha = resty_string.to_hex(ngx.sha1_bin(conf.script))
+    return setmetatable({ conf = conf, dict = ngx.shared[conf.dict], down_until = 0 }, mt)
+end
+
+function _M:redis_key(consumer, period)
+    return self.conf.keyPrefix .. "{" .. consumer .. "}:" .. period
+end
+
+-- load reads the total of a consumer this pod has not seen yet in the period, e.g. after a restart
+function _M:load(consumer, period)
+    local conf = self.conf
+    local now = ngx.now()
+    if now < self.down_until then
+        return nil
+    end
+
+    local red, err = redis.connect(conf.redis)
+    if not red then
+        ngx.log(ngx.WARN, "[quota] ", conf.name, ": redis ", err)
+        self.down_until = now + DOWN_PERIOD
+        return nil
+    end
+
+    local total
+    total, err = red:get(self:redis_key(consumer, period))
+    if not total then
+        ngx.log(ngx.WARN, "[quota] ", conf.name, ": redis get: ", err)
+        red:close()
+        self.down_until = now + DOWN_PERIOD
+        return nil
+    end
+    redis.keepalive(red, conf.redis)
+
+    total = tonumber(total) or 0
+    self.dict:set(TOTAL .. consumer .. ":" .. period, total, ttl(period, now))
+    return total
+end
+
+-- sync flushes the counted requests to Redis and refreshes the totals including the other replicas.
+-- Requests counted while Redis is unreachable stay in the dict and are flushed on the next run.
+function _M:sync()
+    local conf = self.conf
+    local dict = self.dict
+
+    -- 所有 worker 共享同一个 dict，每个同步周期只由一个 worker 执行
+    if not dict:add("sync", true, conf.syncInterval) then
+        return
+    end
+
+    local red, err = redis.connect(conf.redis)
+    if not red then
+        ngx.log(ngx.WARN, "[quota] ", conf.name, ": sync skipped, redis ", err)
+        return
+    end
+
+    local now = ngx.now()
+    local names = dict:get_keys(0)
+    local synced = {}
+
+    for _, name in ipairs(names) do
+        local delta = name:sub(1, #DELTA) == DELTA and dict:get(name)
+        if delta and delta > 0 then
+            local k = name:sub(#DELTA + 1)
+            local consumer, period = split(k)
+            local total
+            total, err = redis.eval(red, conf.script, conf.sha, self:redis_key(consumer, period), delta, period_end(period) + RETENTION)
+            if not total then
+                ngx.log(ngx.WARN, "[quota] ", conf.name, ": sync failed: ", err)
+                red:close()
+                return
+            end
+
+            -- 同步期间新计入的请求保留在增量中
+            dict:incr(name, -delta)
+            dict:set(TOTAL .. k, total, ttl(period, now))
+            synced[k] = true
+        end
+    end
+
+    local pending = {}
+    red:init_pipeline()
+    for _, name in ipairs(names) do
+        local k = name:sub(1, #TOTAL) == TOTAL and name:sub(#TOTAL + 1)
+        if k and not synced[k] then
+            local consumer, period = split(k)
+            red:get(self:redis_key(consumer, period))
+            pending[#pending + 1] = k
+        end
+    end
+    if #pending == 0 then
+        red:cancel_pipeline()
+        redis.keepalive(red, conf.redis)
+        return
+    end
+
+    local results
+    results, err = red:commit_pipeline()
+    if not results then
+        ngx.log(ngx.WARN, "[quota] ", conf.name, ": refresh failed: ", err)
+        red:close()
+        return
+    end
+    redis.keepalive(red, conf.redis)
+
+    for i, k in ipairs(pending) do
+        local total = tonumber(results[i])
+        if total then
+            local _, period = split(k)
+            dict:set(TOTAL .. k, total, ttl(period, now))
+        end
+    end
+end
+
+-- start schedules the sync of this worker, on the first request it counts
+function _M:start()
+    if self.started then
+        return
+    end
+    self.started = true
+
+    local ok, err = ngx.timer.every(self.conf.syncInterval, function(premature)
+        if not premature then
+            self:sync()
+        end
+    end)
+    if not ok then
+        self.started = false
+        ngx.log(ngx.ERR, "[quota] ", self.conf.name, ": failed to start sync: ", err)
+    end
+end
+
+function _M:check()
+    local conf = self.conf
+    local consumer = key.build(conf.consumer)
+    if not consumer:find("[^:]") then
+        -- 无法识别调用方的请求不计入配额
+        return
+    end
+    self:start()
+
+    local period = period_of(conf.period, ngx.time())
+    local k = consumer .. ":" .. period
+    local total = self.dict:get(TOTAL .. k) or self:load(consumer, period) or 0
+    local used = total + (self.dict:get(DELTA .. k) or 0)
+    local limit = conf.overrides[consumer] or conf.limit
+
+    ngx.header["X-Quota-Limit"] = limit
+    if used >= limit then
+        ngx.header["X-Quota-Remaining"] = 0
+        if conf.overage ~= "allow" then
+            metrics.quota_exceeded(conf.name, "rejected")
+            ngx.log(ngx.INFO, "[quota] ", conf.name, ": quota exceeded by consumer \"", consumer, "\", limit: ", limit)
+            return ngx.exit(conf.status)
+        end
+        metrics.quota_exceeded(conf.name, "allowed")
+        ngx.header["X-Quota-Exceeded"] = "true"
+    else
+        ngx.header["X-Quota-Remaining"] = limit - used - 1
+    end
+
+    local _, err = self.dict:incr(DELTA .. k, 1, 0, ttl(period, ngx.now()) + DELTA_GRACE)
+    if err then
+        ngx.log(ngx.ERR, "[quota] ", conf.name, ": failed to count request: ", err)
+    end
+end
+
+return _M
@@ -0,0 +1,97 @@
+local redis = require("ratelimit.redis")
+local resty_string = require("resty.string")
+
+local _M = {}
+local mt = { __index = _M }
+
+local log_levels = {
+    info = ngx.INFO,
+    notice = ngx.NOTICE,
+    warn = ngx.WARN,
+    error = ngx.ERR,
+}
+
+-- Redis 连接失败后的这段时间内直接走降级逻辑，避免每个请求都等待连接超时
+local DOWN_PERIOD = 1
+
+-- conf is rendered by the operator from a RateLimitPolicy with mode global
+function _M.new(conf)
+    conf.sha = resty_string.to_hex(ngx.sha1_bin(conf.script))
+    conf.log_level = log_levels[conf.logLevel] or ngx.ERR
+    return setmetatable({ conf = conf, down_until = 0 }, mt)
+end
+
+function _M:eval(key)
+    local conf = self.conf
+    local red, err = redis.connect(conf.redis)
+    if not red then
+        return nil, err
+    end
+
+    local res
+    res, err = redis.eval(red, conf.script, conf.sha, key, unpack(conf.args))
+    if not res then
+        red:close()
+        return nil, "eval: " .. err
+    end
+
+    redis.keepalive(red, conf.redis)
+    return res
+end
+
+-- fallback_check enforces the per-pod fallbackRate with a fixed window counter in a lua_shared_dict
+function _M:fallback_check(value)
+    local fallback = self.conf.fallback
+    local dict = ngx.shared[fallback.dict]
+    local window = math.floor(ngx.now() / fallback.window)
+
+    local count, err = dict:incr(value .. ":" .. window, 1, 0, fallback.window)
+    if not count then
+        ngx.log(ngx.ERR, "[ratelimit] ", self.conf.name, ": fallback counter failed: ", err)
+        return true
+    end
+    return count <= fallback.limit
+end
+
+function _M:check()
+    local conf = self.conf
+    local value = ngx.var[conf.key]
+    if not value or value == "" then
+        -- 与 limit_req 一致，key 为空的请求不做限制
+        return
+    end
+
+    local res, err
+    local now = ngx.now()
+    if now < self.down_until then
+        err = "unavailable"
+    else
+        res, err = self:eval(conf.keyPrefix .. "{" .. value .. "}")
+        if not res then
+            ngx.log(ngx.WARN, "[ratelimit] ", conf.name, ": redis ", err)
+            self.down_until = now + DOWN_PERIOD
+        end
+    end
+
+    local allowed
+    if res then
+        allowed = res[1] == 1
+    elseif conf.fallback then
+        allowed = self:fallback_check(value)
+    else
+        allowed = conf.failureMode ~= "closed"
+    end
+
+    if allowed then
+        return
+    end
+
+    ngx.log(conf.log_level, "[ratelimit] limiting requests", conf.dryRun and ", dry run," or ",",
+        " by global zone \"", conf.zone, "\", client: ", ngx.var.remote_addr)
+    if conf.dryRun then
+        return
+    end
+    return ngx.exit(conf.status)
+end
+
+return _M
@@ -0,0 +1,62 @@
+local cjson = require("cjson.safe")
+
+local _M = {}
+
+local function decode_base64url(s)
+    s = s:gsub("%-", "+"):gsub("_", "/")
+    local padding = #s % 4
+    if padding > 0 then
+        s = s .. string.rep("=", 4 - padding)
+    end
+    return ngx.decode_base64(s)
+end
+
+-- jwt_claim reads a claim from the JWT payload. The signature is not verified.
+local function jwt_claim(header, claim)
+    local token = ngx.var[header]
+    if not token then
+        return nil
+    end
+
+    token = token:gsub("^[Bb]earer%s+", "")
+    local payload = token:match("^[^.]+%.([^.]+)%.")
+    if not payload then
+        return nil
+    end
+
+    local json = decode_base64url(payload)
+    if not json then
+        return nil
+    end
+
+    local value = cjson.decode(json)
+    for name in claim:gmatch("[^.]+") do
+        if type(value) ~= "table" then
+            return nil
+        end
+        value = value[name]
+    end
+
+    if value == nil or type(value) == "table" then
+        return nil
+    end
+    return tostring(value)
+end
+
+-- build joins the key parts rendered by the operator from RateLimitPolicy keySources,
+-- missing parts are left empty like nginx does for unset variables
+function _M.build(parts)
+    local values = {}
+    for i, part in ipairs(parts) do
+        local value
+        if part.claim then
+            value = jwt_claim(part.header, part.claim)
+        else
+            value = ngx.var[part.var]
+        end
+        values[i] = value or ""
+    end
+    return table.concat(values, ":")
+end
+
+return _M
@@ -0,0 +1,101 @@
+local redis = require("resty.redis")
+
+local _M = {}
+
+-- connections caches the settings read from each mounted Secret, a changed Secret is picked up on reload
+local connections = {}
+
+local function read_secret(dir, key)
+    local f = io.open(dir .. "/" .. key, "r")
+    if not f then
+        return nil
+    end
+    local content = f:read("*a")
+    f:close()
+
+    content = content:gsub("%s+$", "")
+    if content == "" then
+        return nil
+    end
+    return content
+end
+
+local function connection(dir)
+    local conn = connections[dir]
+    if conn then
+        return conn
+    end
+
+    local host = read_secret(dir, "host")
+    if not host then
+        return nil, "host not found in " .. dir
+    end
+
+    conn = {
+        host = host,
+        port = tonumber(read_secret(dir, "port")) or 6379,
+        username = read_secret(dir, "username"),
+        password = read_secret(dir, "password"),
+        database = tonumber(read_secret(dir, "database")),
+    }
+    conn.pool = table.concat({ conn.host, conn.port, conn.database or 0, conn.username or "" }, ":")
+
+    connections[dir] = conn
+    return conn
+end
+
+-- connect returns a client for the Secret mounted at conf.secretDir, authenticated on new connections.
+-- Hand it back with keepalive(), or close() it after an error.
+function _M.connect(conf)
+    local conn, err = connection(conf.secretDir)
+    if not conn then
+        return nil, err
+    end
+
+    local red = redis:new()
+    red:set_timeouts(conf.timeout, conf.timeout, conf.timeout)
+
+    local ok
+    ok, err = red:connect(conn.host, conn.port, { pool = conn.pool, pool_size = conf.poolSize })
+    if not ok then
+        return nil, "connect: " .. err
+    end
+
+    if red:get_reused_times() == 0 then
+        if conn.password then
+            if conn.username then
+                ok, err = red:auth(conn.username, conn.password)
+            else
+                ok, err = red:auth(conn.password)
+            end
+            if not ok then
+                red:close()
+                return nil, "auth: " .. err
+            end
+        end
+        if conn.database then
+            ok, err = red:select(conn.database)
+            if not ok then
+                red:close()
+                return nil, "select: " .. err
+            end
+        end
+    end
+
+    return red
+end
+
+function _M.keepalive(red, conf)
+    red:set_keepalive(60000, conf.poolSize)
+end
+
+-- eval runs a script by its sha1, sending the script itself when Redis does not have it cached
+function _M.eval(red, script, sha, key, ...)
+    local res, err = red:evalsha(sha, 1, key, ...)
+    if not res and err and err:find("NOSCRIPT", 1, true) then
+        res, err = red:eval(script, 1, key, ...)
+    end
+    return res, err
+end
+
+return _M
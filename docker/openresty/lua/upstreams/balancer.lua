@@ -1,10 +1,11 @@
 local balancer = require("ngx.balancer")
-local random_weighted = require("utils.random_weighted")
+local lb = require("upstreams.lb")
+local dns = require("upstreams.dns")
 
 local _M = {}
 
-function _M.randomWeightedBalance(servers)
-    local server = random_weighted.init(servers).pick()
+-- setPeer points the current request at the given { host, port, ips } server, or at one of ips when given
+function _M.setPeer(server, ips)
     if not server or not server.host or not server.port then
         ngx.log(ngx.ERR, "no valid upstream server found")
         return ngx.exit(502)
@@ -12,9 +13,10 @@ function _M.randomWeightedBalance(servers)
 
     ngx.ctx.server_host = server.host
 
+    ips = ips or server.ips
     local ip = server.host
-    if server.ips and #server.ips > 0 then
-        ip = server.ips[math.random(#server.ips)]
+    if ips and #ips > 0 then
+        ip = ips[math.random(#ips)]
     end
 
     local ok, err = balancer.set_current_peer(ip, server.port, server.host)
@@ -22,7 +24,39 @@ function _M.randomWeightedBalance(servers)
         ngx.log(ngx.ERR, "failed to set current peer: ", err)
         return ngx.exit(502)
     end
+end
 
+-- balance picks a server with the algorithm in opts (see upstreams.lb) among the available primaries,
+-- falling back to the backups. A failed attempt is counted against the server and retried once on each other server.
+function _M.balance(servers, opts)
+    local ctx = ngx.ctx
+    local tried = ctx.upstream_tried
+    local first = not tried
+    if first then
+        tried = {}
+        ctx.upstream_tried = tried
+    elseif balancer.get_last_failure() then
+        -- balancer_by_lua 在重试时再次执行，上一次选中的 server 连接失败或返回了 proxy_next_upstream 中的状态
+        lb.retry()
+    end
+
+    opts = opts or {}
+    local server, remaining = lb.select(servers, opts, tried)
+    if first and remaining > 1 then
+        balancer.set_more_tries(remaining - 1)
+    end
+    local ips
+    if server then
+        tried[lb.id(server)] = true
+        if opts.dns then
+            ips = dns.ips(server, opts.dns)
+        end
+    end
+    return _M.setPeer(server, ips)
+end
+
+function _M.randomWeightedBalance(servers)
+    return _M.balance(servers, { algorithm = "random-weighted" })
 end
 
-return _M
\ No newline at end of file
+return _M
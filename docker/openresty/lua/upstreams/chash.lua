@@ -0,0 +1,77 @@
+-- chash.lua
+-- 一致性哈希环按 worker 缓存，server 集合或权重变化时重建
+local _M = {}
+
+local crc32 = ngx.crc32_long
+
+local rings = {}
+
+local function signature(servers, id, vnodes)
+    local parts = { vnodes }
+    for _, s in ipairs(servers) do
+        parts[#parts + 1] = id(s) .. "=" .. (s.weight or 1)
+    end
+    return table.concat(parts, ",")
+end
+
+-- build places vnodes points for the heaviest server on the ring, lighter servers get proportionally fewer
+local function build(servers, id, vnodes)
+    local max_weight = 0
+    for _, s in ipairs(servers) do
+        max_weight = math.max(max_weight, s.weight or 1)
+    end
+
+    local points = {}
+    for _, s in ipairs(servers) do
+        local k = id(s)
+        local n = math.max(1, math.floor(vnodes * (s.weight or 1) / max_weight + 0.5))
+        for i = 1, n do
+            points[#points + 1] = { hash = crc32(k .. "#" .. i), id = k }
+        end
+    end
+    table.sort(points, function(a, b)
+        return a.hash < b.hash
+    end)
+    return points
+end
+
+-- pick maps key onto the ring of servers and returns the first server clockwise found in allowed (id -> server).
+-- Keys of a server missing from allowed move to the next server on the ring only.
+function _M.pick(name, servers, id, key, vnodes, allowed)
+    local sig = signature(servers, id, vnodes)
+    local ring = rings[name]
+    if not ring or ring.signature ~= sig then
+        ring = { signature = sig, points = build(servers, id, vnodes) }
+        rings[name] = ring
+    end
+
+    local points = ring.points
+    local n = #points
+    if n == 0 then
+        return nil
+    end
+
+    local h = crc32(key)
+    local lo, hi = 1, n
+    while lo < hi do
+        local mid = math.floor((lo + hi) / 2)
+        if points[mid].hash < h then
+            lo = mid + 1
+        else
+            hi = mid
+        end
+    end
+    if points[lo].hash < h then
+        lo = 1
+    end
+
+    for i = 0, n - 1 do
+        local server = allowed[points[(lo + i - 1) % n + 1].id]
+        if server then
+            return server
+        end
+    end
+    return nil
+end
+
+return _M
@@ -0,0 +1,166 @@
+-- dns.lua
+-- 运行时 DNS 解析：Address 类型的 upstream 开启 dns.resolution: runtime 后，balancer 按 TTL 缓存 host 的解析结果，
+-- 缓存保存在 upstream_dns 中，同一 Pod 的所有 worker 共享。
+-- balancer_by_lua 中不能使用 cosocket，过期的记录在 timer 中刷新，刷新完成前继续使用旧的结果。
+local resolver = require("resty.dns.resolver")
+
+local _M = {}
+
+local cache = ngx.shared.upstream_dns
+
+-- nameservers 为 { ip, port } 列表，为空时使用 /etc/resolv.conf 中的 nameserver；timeout 单位为秒
+local config = { nameservers = {}, timeout = 2 }
+
+-- 刷新中的 host 加锁，防止多个请求同时查询；查询超时后锁自动释放
+local LOCK_TTL = 10
+
+-- configure is called from init_worker with the resolver of the OpenResty
+function _M.configure(opts)
+    config.nameservers = opts.nameservers or {}
+    config.timeout = opts.timeout or 2
+    config.resolv_conf = nil
+end
+
+local function is_ip(host)
+    return host:match("^%d+%.%d+%.%d+%.%d+$") ~= nil or host:find(":", 1, true) ~= nil
+end
+
+-- resolv_conf returns the name servers of the pod
+local function resolv_conf()
+    local servers = {}
+    local f = io.open("/etc/resolv.conf", "r")
+    if not f then
+        return servers
+    end
+    for line in f:lines() do
+        local ip = line:match("^%s*nameserver%s+(%S+)")
+        if ip then
+            servers[#servers + 1] = ip
+        end
+    end
+    f:close()
+    return servers
+end
+
+local function nameservers()
+    if #config.nameservers > 0 then
+        return config.nameservers
+    end
+    if not config.resolv_conf then
+        config.resolv_conf = resolv_conf()
+    end
+    return config.resolv_conf
+end
+
+local function collect(answers, qtype, ips)
+    local ttl
+    for _, ans in ipairs(answers) do
+        -- CNAME 记录与其指向的地址一起返回，只取地址
+        if ans.type == qtype and ans.address then
+            ips[#ips + 1] = ans.address
+            if not ttl or ans.ttl < ttl then
+                ttl = ans.ttl
+            end
+        end
+    end
+    return ttl
+end
+
+-- query resolves the A records of host, or its AAAA records when it has none, returning the IPs and the lowest TTL
+local function query(host)
+    local r, err = resolver:new({ nameservers = nameservers(), timeout = config.timeout * 1000, retrans = 2 })
+    if not r then
+        return nil, nil, err
+    end
+
+    for _, qtype in ipairs({ r.TYPE_A, r.TYPE_AAAA }) do
+        local answers, qerr = r:query(host, { qtype = qtype })
+        if not answers then
+            return nil, nil, qerr
+        end
+        if answers.errcode then
+            return nil, nil, answers.errstr
+        end
+        local ips = {}
+        local ttl = collect(answers, qtype, ips)
+        if #ips > 0 then
+            return ips, ttl
+        end
+    end
+    return nil, nil, "no address"
+end
+
+local function parse(entry)
+    local expires, list = entry:match("^([^|]+)|(.+)$")
+    if not expires then
+        return nil, nil
+    end
+    local ips = {}
+    for ip in list:gmatch("[^,]+") do
+        ips[#ips + 1] = ip
+    end
+    return tonumber(expires), ips
+end
+
+local function refresh(premature, host, opts)
+    if premature then
+        return
+    end
+
+    local ips, ttl, err = query(host)
+    if not ips then
+        ngx.log(ngx.WARN, "failed to resolve upstream host ", host, ": ", err)
+        cache:set("f:" .. host, true)
+        -- 失败后 min_ttl 秒内不再重试
+        cache:set("l:" .. host, true, opts.min_ttl)
+        return
+    end
+
+    ttl = math.max(opts.min_ttl, math.min(ttl or opts.min_ttl, opts.max_ttl))
+    cache:set("h:" .. host, (ngx.now() + ttl) .. "|" .. table.concat(ips, ","))
+    cache:delete("f:" .. host)
+    cache:delete("l:" .. host)
+end
+
+-- unavailable reports whether the server must be skipped: its host failed to resolve and the fallback is "fail"
+function _M.unavailable(server, opts)
+    if not opts or opts.fallback ~= "fail" or not server.host or is_ip(server.host) then
+        return false
+    end
+    return cache:get("f:" .. server.host) ~= nil
+end
+
+-- ips returns the IPs to connect to the server, refreshing its host in the background once the cached answer
+-- expires. The IPs resolved by the operator are used until the first answer, and on failures when the fallback
+-- is "operator".
+-- opts: { min_ttl, max_ttl, fallback }
+function _M.ips(server, opts)
+    local host = server.host
+    if not host or is_ip(host) then
+        return server.ips
+    end
+
+    local expires, ips
+    local entry = cache:get("h:" .. host)
+    if entry then
+        expires, ips = parse(entry)
+    end
+    if ips and expires > ngx.now() then
+        return ips
+    end
+
+    if cache:add("l:" .. host, true, LOCK_TTL) then
+        local ok, err = ngx.timer.at(0, refresh, host, opts)
+        if not ok then
+            cache:delete("l:" .. host)
+            ngx.log(ngx.ERR, "failed to schedule resolving upstream host ", host, ": ", err)
+        end
+    end
+
+    if not ips or opts.fallback == "operator" and cache:get("f:" .. host) then
+        return server.ips
+    end
+    return ips
+end
+
+return _M
@@ -0,0 +1,93 @@
+-- dynamic.lua
+-- Dynamic upstream：servers 不渲染进 nginx 配置，而是保存在 upstream_peers 中，同一 Pod 的所有 worker 共享。
+-- reload-agent 在 peers 文件变化时推送到状态接口，不需要 reload；worker 首次使用时以挂载的 peers 文件为准，
+-- 推送失败由 reload-agent 退回 reload 处理。
+local cjson = require("cjson.safe")
+
+local _M = {}
+
+local store = ngx.shared.upstream_peers
+
+-- cache 按 upstream 保存本 worker 解码后的 servers，版本变化时才重新解码
+local cache = {}
+
+local function valid(payload)
+    return type(payload) == "table" and type(payload.upstream) == "string" and payload.upstream ~= ""
+        and type(payload.version) == "string" and payload.version ~= "" and type(payload.servers) == "table"
+end
+
+-- store_peers saves the peers unless the same version is stored, returning whether they changed
+local function store_peers(payload)
+    local name = payload.upstream
+    if store:get("v:" .. name) == payload.version then
+        return true, false
+    end
+    local ok, err = store:set("s:" .. name, cjson.encode(payload.servers))
+    if not ok then
+        return nil, err
+    end
+    -- 先写 servers 再写版本，读取方按版本判断是否重新解码
+    store:set("v:" .. name, payload.version)
+    return true, true
+end
+
+local function load_file(path)
+    local f, err = io.open(path, "r")
+    if not f then
+        return nil, err
+    end
+    local data = f:read("*a")
+    f:close()
+    local payload = cjson.decode(data or "")
+    if not valid(payload) then
+        return nil, "invalid peers file " .. path
+    end
+    return payload
+end
+
+-- peers returns the servers of a Dynamic upstream. The first call in a worker loads the mounted peers file, which
+-- is at least as recent as anything pushed, so a reload also recovers from a failed push.
+function _M.peers(name, path)
+    local c = cache[name]
+    if not c then
+        c = {}
+        cache[name] = c
+        local payload, err = load_file(path)
+        if payload then
+            store_peers(payload)
+        else
+            ngx.log(ngx.WARN, "failed to load peers of upstream ", name, ": ", err)
+        end
+    end
+
+    local version = store:get("v:" .. name)
+    if version and version ~= c.version then
+        local servers = cjson.decode(store:get("s:" .. name) or "")
+        if type(servers) == "table" then
+            c.version = version
+            c.servers = servers
+        end
+    end
+    return c.servers or {}
+end
+
+-- push stores the peers sent by the reload-agent: { upstream, version, servers }. Pushing a version already
+-- stored is a no-op, the response tells the stored version.
+function _M.push()
+    ngx.req.read_body()
+    local payload = cjson.decode(ngx.req.get_body_data() or "")
+    if not valid(payload) then
+        return ngx.exit(ngx.HTTP_BAD_REQUEST)
+    end
+
+    local ok, changed = store_peers(payload)
+    if not ok then
+        ngx.log(ngx.ERR, "failed to store peers of upstream ", payload.upstream, ": ", changed)
+        return ngx.exit(ngx.HTTP_INTERNAL_SERVER_ERROR)
+    end
+
+    ngx.header["Content-Type"] = "application/json"
+    ngx.say(cjson.encode({ upstream = payload.upstream, version = payload.version, changed = changed }))
+end
+
+return _M
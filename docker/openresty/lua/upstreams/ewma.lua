@@ -0,0 +1,73 @@
+-- ewma.lua
+-- peak EWMA：延迟升高时立即生效，下降时按 DECAY_TIME 指数衰减，结果保存在 lua_shared_dict upstream_balancer 中
+local least_conn = require("upstreams.least_conn")
+
+local _M = {}
+
+local dict = ngx.shared.upstream_balancer
+
+-- 衰减时间（秒），一段时间没有请求的 server 的平均延迟逐渐回落，重新获得流量
+local DECAY_TIME = 10
+
+-- 长期没有更新的记录已衰减到接近 0，过期即可
+local EXPIRE = 30 * DECAY_TIME
+
+local function key(name, id)
+    return "e:" .. name .. ":" .. id
+end
+
+local function get(name, id)
+    local v = dict:get(key(name, id))
+    if not v then
+        return 0, 0
+    end
+    local ewma, t = v:match("^([^:]+):(.+)$")
+    return tonumber(ewma) or 0, tonumber(t) or 0
+end
+
+local function decay(ewma, t, now)
+    return ewma * math.exp(-math.max(now - t, 0) / DECAY_TIME)
+end
+
+-- observe feeds the time to the first response byte of a request, in seconds
+function _M.observe(name, id, rtt)
+    local now = ngx.now()
+    local ewma, t = get(name, id)
+    if rtt > ewma then
+        ewma = rtt
+    else
+        local w = math.exp(-math.max(now - t, 0) / DECAY_TIME)
+        ewma = ewma * w + rtt * (1 - w)
+    end
+    dict:set(key(name, id), ewma .. ":" .. now, EXPIRE)
+end
+
+local function score(name, s, id, now)
+    local k = id(s)
+    local ewma, t = get(name, k)
+    -- 加 1ms 使尚无延迟数据的 server 之间仍按在途请求数比较
+    return (decay(ewma, t, now) + 0.001) * (least_conn.count(name, k) + 1) / (s.weight or 1)
+end
+
+-- pick compares two random servers and returns the one with the lower score
+function _M.pick(name, servers, id)
+    local n = #servers
+    if n == 1 then
+        return servers[1]
+    end
+
+    local i = math.random(n)
+    local j = math.random(n - 1)
+    if j >= i then
+        j = j + 1
+    end
+
+    local now = ngx.now()
+    local a, b = servers[i], servers[j]
+    if score(name, a, id, now) <= score(name, b, id, now) then
+        return a
+    end
+    return b
+end
+
+return _M
@@ -0,0 +1,41 @@
+-- health.lua
+-- Pod 内健康检查：reload-agent 从 OpenResty Pod 的网络探测 upstream，定期把结果推送到状态接口，
+-- 不健康的 server 保存在 upstream_health 中，同一 Pod 的所有 worker 共享
+local cjson = require("cjson.safe")
+
+local _M = {}
+
+local down = ngx.shared.upstream_health
+
+-- 结果保留 3 个推送间隔（reload-agent 每 5 秒推送一次），agent 停止后自动失效，不再影响选择
+local TTL = 15
+
+-- down reports whether the in-pod health check of the reload-agent reports the server down
+function _M.down(name, id)
+    return down:get(name .. ":" .. id) ~= nil
+end
+
+-- push stores the results sent by the reload-agent: { "<upstream>": { "<server id>": alive } }
+function _M.push()
+    ngx.req.read_body()
+    local payload = cjson.decode(ngx.req.get_body_data() or "")
+    if type(payload) ~= "table" then
+        return ngx.exit(ngx.HTTP_BAD_REQUEST)
+    end
+
+    for name, servers in pairs(payload) do
+        if type(servers) == "table" then
+            for id, alive in pairs(servers) do
+                local k = name .. ":" .. id
+                if alive == false then
+                    down:set(k, true, TTL)
+                else
+                    down:delete(k)
+                end
+            end
+        end
+    end
+    return ngx.exit(ngx.HTTP_NO_CONTENT)
+end
+
+return _M
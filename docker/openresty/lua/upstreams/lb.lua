@@ -0,0 +1,224 @@
+-- lb.lua
+-- 负载均衡入口：Address 类型通过 upstreams.balancer 在 balancer_by_lua 中调用，FullURL 类型由生成的 upstream 模块调用
+local random_weighted = require("upstreams.random_weighted")
+local round_robin = require("upstreams.round_robin")
+local least_conn = require("upstreams.least_conn")
+local chash = require("upstreams.chash")
+local ewma = require("upstreams.ewma")
+local outlier = require("upstreams.outlier")
+local health = require("upstreams.health")
+local dns = require("upstreams.dns")
+local sticky = require("upstreams.sticky")
+
+local _M = {}
+
+-- failures 记录本 worker 内各 server 在 fail_timeout 窗口内的失败次数，与 nginx 的 max_fails 一样按 worker 统计
+local failures = {}
+
+-- id identifies a server within its upstream: host:port for Address upstreams, the URL for FullURL upstreams
+function _M.id(server)
+    return server.address or (server.host .. ":" .. server.port)
+end
+
+-- available reports whether a server is outside its fail_timeout after max_fails failures
+function _M.available(name, server)
+    local max_fails = server.max_fails or 1
+    local k = name .. "|" .. _M.id(server)
+    local f = failures[k]
+    if max_fails == 0 or not f then
+        return true
+    end
+    if ngx.now() - f.checked >= (server.fail_timeout or 10) then
+        failures[k] = nil
+        return true
+    end
+    return f.fails < max_fails
+end
+
+-- fail counts a failed attempt to the server within the fail_timeout window
+function _M.fail(name, server)
+    local now = ngx.now()
+    local k = name .. "|" .. _M.id(server)
+    local f = failures[k]
+    if not f or now - f.checked >= (server.fail_timeout or 10) then
+        f = { fails = 0 }
+        failures[k] = f
+    end
+    f.fails = f.fails + 1
+    f.checked = now
+end
+
+-- candidates returns the primaries that may be picked, or the backups when no primary is left,
+-- along with the number of servers the request may still be retried on. Servers ejected by the
+-- passive health check, reported down by the in-pod health check or failing to resolve are skipped.
+local function candidates(name, servers, tried, opts)
+    local primaries, backups = {}, {}
+    for _, s in ipairs(servers) do
+        local id = _M.id(s)
+        if (s.weight or 1) > 0 and not (tried and tried[id]) and _M.available(name, s)
+            and not (opts.passive and outlier.ejected(name, id)) and not health.down(name, id)
+            and not dns.unavailable(s, opts.dns) then
+            if s.backup then
+                backups[#backups + 1] = s
+            else
+                primaries[#primaries + 1] = s
+            end
+        end
+    end
+    if #primaries > 0 then
+        return primaries, #primaries + #backups
+    end
+    return backups, #backups
+end
+
+local function hash_key(hash)
+    local by = hash.by
+    if by == "ip" then
+        return ngx.var.remote_addr
+    elseif by == "uri" then
+        return ngx.var.uri
+    elseif by == "header" then
+        return ngx.var["http_" .. (hash.name:lower():gsub("-", "_"))]
+    elseif by == "cookie" then
+        return ngx.var["cookie_" .. hash.name]
+    end
+    return nil
+end
+
+-- pick_hash 的环包含同一层级（主节点或 backup）中所有可用权重的 server，
+-- 不可用的 server 被跳过，只有它的 key 落到环上的下一个 server
+local function pick_hash(name, servers, list, hash)
+    local key = hash_key(hash)
+    if not key or key == "" then
+        return nil
+    end
+
+    local allowed = {}
+    for _, s in ipairs(list) do
+        allowed[_M.id(s)] = s
+    end
+
+    local backup = list[1].backup or false
+    local ring = {}
+    for _, s in ipairs(servers) do
+        if (s.weight or 1) > 0 and (s.backup or false) == backup then
+            ring[#ring + 1] = s
+        end
+    end
+
+    return chash.pick(backup and name .. ":backup" or name, ring, _M.id, key, hash.vnodes or 160, allowed)
+end
+
+-- pick applies the algorithm of opts to the candidates, nil falls back to weighted random
+local function pick(name, servers, list, opts)
+    local algorithm = opts.algorithm
+    if algorithm == "round-robin" then
+        return round_robin.pick(name, list, _M.id)
+    elseif algorithm == "least-connections" then
+        return least_conn.pick(name, list, _M.id)
+    elseif algorithm == "consistent-hash" and opts.hash then
+        return pick_hash(name, servers, list, opts.hash)
+    elseif algorithm == "peak-ewma" then
+        return ewma.pick(name, list, _M.id)
+    end
+    return nil
+end
+
+-- select picks a server for the current request, skipping the ids in tried, and returns it with the number of
+-- servers the request may still be retried on.
+-- opts: { name, algorithm, hash = { by, name, vnodes }, passive = { failures, statuses, ejection, max_percent },
+--         dns = { min_ttl, max_ttl, fallback }, sticky = { hash = { by, name, vnodes } } or
+--         sticky = { cookie, ttl, path, samesite, secure } }
+function _M.select(servers, opts, tried)
+    local name = opts.name or ""
+    local list, remaining = candidates(name, servers, tried, opts)
+    if #list == 0 then
+        return nil, 0
+    end
+
+    local algorithm = opts.algorithm
+    local server
+    -- 亲和的 server 不可用时按算法重新均衡
+    local affinity = opts.sticky
+    if affinity then
+        if affinity.hash then
+            server = pick_hash(name, servers, list, affinity.hash)
+        else
+            server = sticky.pick(name, list, _M.id, affinity)
+        end
+    end
+    server = server or pick(name, servers, list, opts) or random_weighted.pick(list)
+    if affinity and not affinity.hash then
+        sticky.bind(_M.id(server))
+    end
+
+    local state = { name = name, id = _M.id(server), server = server, algorithm = algorithm }
+    if opts.passive then
+        state.passive = opts.passive
+        state.servers = servers
+    end
+    -- peak-ewma 同样需要在途请求数
+    if algorithm == "least-connections" or algorithm == "peak-ewma" then
+        least_conn.acquire(name, state.id)
+        state.acquired = true
+    end
+    ngx.ctx.lb = state
+
+    return server, remaining
+end
+
+local function release(state)
+    if state.acquired then
+        state.acquired = false
+        least_conn.release(state.name, state.id)
+    end
+end
+
+-- retry counts the failed attempt of the current request before the balancer picks another server
+function _M.retry()
+    local state = ngx.ctx.lb
+    if state then
+        ngx.ctx.lb = nil
+        release(state)
+        _M.fail(state.name, state.server)
+        if state.passive then
+            outlier.observe(state.name, state.id, state.passive, true, state.servers, _M.id)
+        end
+    end
+end
+
+-- finish runs in the log phase: it releases the in-flight request, counts a last attempt that got no
+-- response header (connect failure or timeout), feeds the response time to peak-ewma and the outcome
+-- to the passive health check
+function _M.finish()
+    local state = ngx.ctx.lb
+    if not state then
+        return
+    end
+    ngx.ctx.lb = nil
+    release(state)
+
+    local header_time = ngx.var.upstream_header_time
+    if not header_time then
+        return
+    end
+
+    -- 多次尝试的值以 ", " 或 " : " 分隔，最后一个对应最终选中的 server
+    local last = header_time:match("([^,:%s]+)$")
+    if state.passive then
+        local status = (ngx.var.upstream_status or ""):match("([^,:%s]+)$")
+        local failed = outlier.failed(state.passive, status, last)
+        outlier.observe(state.name, state.id, state.passive, failed, state.servers, _M.id)
+    end
+
+    if last == "-" then
+        _M.fail(state.name, state.server)
+    elseif state.algorithm == "peak-ewma" then
+        local rtt = tonumber(last)
+        if rtt then
+            ewma.observe(state.name, state.id, rtt)
+        end
+    end
+end
+
+return _M
@@ -0,0 +1,46 @@
+-- least_conn.lua
+-- 在途请求数保存在 lua_shared_dict upstream_balancer 中，同一 Pod 的所有 worker 共享
+local _M = {}
+
+local dict = ngx.shared.upstream_balancer
+
+local function key(name, id)
+    return "c:" .. name .. ":" .. id
+end
+
+function _M.count(name, id)
+    return dict:get(key(name, id)) or 0
+end
+
+function _M.acquire(name, id)
+    local _, err = dict:incr(key(name, id), 1, 0)
+    if err then
+        ngx.log(ngx.WARN, "[lb] ", name, ": failed to count request to ", id, ": ", err)
+    end
+end
+
+function _M.release(name, id)
+    local n = dict:incr(key(name, id), -1, 0)
+    if n and n < 0 then
+        dict:set(key(name, id), 0)
+    end
+end
+
+-- pick returns the server with the fewest in-flight requests relative to its weight, ties are broken randomly
+function _M.pick(name, servers, id)
+    local best, best_score, ties = nil, nil, 0
+    for _, s in ipairs(servers) do
+        local score = (_M.count(name, id(s)) + 1) / (s.weight or 1)
+        if not best or score < best_score then
+            best, best_score, ties = s, score, 1
+        elseif score == best_score then
+            ties = ties + 1
+            if math.random(ties) == 1 then
+                best = s
+            end
+        end
+    end
+    return best
+end
+
+return _M
@@ -0,0 +1,110 @@
+-- outlier.lua
+-- 被动健康检查：在 log 阶段统计每个 server 连续失败的请求，达到阈值后在 ejection 秒内不再被选中。
+-- 连续失败次数保存在 upstream_balancer，摘除状态保存在 upstream_outliers，同一 Pod 的所有 worker 共享
+local cjson = require("cjson.safe")
+
+local _M = {}
+
+local failures = ngx.shared.upstream_balancer
+-- upstream_outliers 只保存 "<name>:<id>" -> 摘除结束时间，过期后自动删除
+local ejections = ngx.shared.upstream_outliers
+
+local function failure_key(name, id)
+    return "o:" .. name .. ":" .. id
+end
+
+local function record(name, id, result)
+    local ok, metrics = pcall(require, "metrics")
+    if ok and metrics.ejection then
+        metrics.ejection(name, id, result)
+    end
+end
+
+-- ejected reports whether the server is ejected in this pod
+function _M.ejected(name, id)
+    return ejections:get(name .. ":" .. id) ~= nil
+end
+
+-- failed reports whether an attempt with the given upstream status and header time counts as a failure:
+-- no response header (connection failure or timeout) or a status in policy.statuses
+function _M.failed(policy, status, header_time)
+    if header_time == "-" then
+        return true
+    end
+    local code = tonumber(status)
+    if not code then
+        return false
+    end
+    for _, r in ipairs(policy.statuses or { { 500, 599 } }) do
+        if code >= r[1] and code <= r[2] then
+            return true
+        end
+    end
+    return false
+end
+
+-- eject stops picking the server for policy.ejection seconds, unless policy.max_percent of the servers
+-- of the upstream are already ejected
+local function eject(name, id, policy, servers, server_id)
+    local total, ejected = 0, 0
+    for _, s in ipairs(servers) do
+        if (s.weight or 1) > 0 then
+            total = total + 1
+            if _M.ejected(name, server_id(s)) then
+                ejected = ejected + 1
+            end
+        end
+    end
+
+    if (ejected + 1) * 100 > total * (policy.max_percent or 50) then
+        ngx.log(ngx.WARN, "[outlier] ", name, ": not ejecting ", id, ", ", ejected, " of ", total, " servers already ejected")
+        record(name, id, "max_percent")
+        return
+    end
+
+    local seconds = policy.ejection or 30
+    -- add 只在尚未摘除时成功，避免多个 worker 重复计数
+    if ejections:add(name .. ":" .. id, ngx.now() + seconds, seconds) then
+        ngx.log(ngx.WARN, "[outlier] ", name, ": ejecting ", id, " for ", seconds, "s")
+        record(name, id, "ejected")
+    end
+end
+
+-- observe counts the outcome of a request to a server. policy: { failures, statuses, ejection, max_percent },
+-- servers and server_id are the servers of the upstream and the id function of upstreams.lb
+function _M.observe(name, id, policy, failed, servers, server_id)
+    local k = failure_key(name, id)
+    if not failed then
+        if failures:get(k) then
+            failures:delete(k)
+        end
+        return
+    end
+
+    local n, err = failures:incr(k, 1, 0)
+    if not n then
+        ngx.log(ngx.WARN, "[outlier] ", name, ": failed to count failure of ", id, ": ", err)
+        return
+    end
+    if n >= (policy.failures or 5) then
+        failures:delete(k)
+        eject(name, id, policy, servers, server_id)
+    end
+end
+
+-- status writes the servers ejected in this pod as JSON: { "<upstream>": [ { "server": "<id>", "until": <unix time> } ] }
+function _M.status()
+    local result = {}
+    for _, k in ipairs(ejections:get_keys(0)) do
+        local ends = ejections:get(k)
+        local name, id = k:match("^([^:]+):(.+)$")
+        if ends and name then
+            result[name] = result[name] or {}
+            table.insert(result[name], { server = id, ["until"] = ends })
+        end
+    end
+    ngx.header.content_type = "application/json"
+    ngx.say(cjson.encode(result))
+end
+
+return _M
@@ -1,21 +1,13 @@
 -- random_weighted.lua
 local _M = {}
 
-local servers = {}
-local total_weight = 0
-
-function _M.init(input)
-    servers = {}
-    total_weight = 0
-    for _, s in ipairs(input) do
-        local weight = s.weight or 1
-        total_weight = total_weight + weight
-        table.insert(servers, { address = s.address, weight = weight })
+-- pick returns a random server in proportion to the weights
+function _M.pick(servers)
+    local total_weight = 0
+    for _, s in ipairs(servers) do
+        total_weight = total_weight + (s.weight or 1)
     end
-end
-
-function _M.pick()
-    if total_weight == 0 or #servers == 0 then
+    if total_weight == 0 then
         return nil
     end
 
@@ -23,13 +15,13 @@ function _M.pick()
     local cumulative = 0
 
     for _, s in ipairs(servers) do
-        cumulative = cumulative + s.weight
+        cumulative = cumulative + (s.weight or 1)
         if rand <= cumulative then
-            return s.address
+            return s
         end
     end
 
-    return servers[#servers].address
+    return servers[#servers]
 end
 
-return _M
\ No newline at end of file
+return _M
@@ -1,26 +1,32 @@
 -- round_robin.lua
-local rr_state = {}
+-- nginx 的平滑加权轮询，每个 upstream 的 current weight 按 worker 保存
 local _M = {}
 
-function _M.init(servers)
-    rr_state.index = 1
-    rr_state.pool = {}
+local states = {}
 
+function _M.pick(name, servers, id)
+    local current = states[name]
+    if not current then
+        current = {}
+        states[name] = current
+    end
+
+    local total, best, best_weight = 0, nil, nil
     for _, s in ipairs(servers) do
         local weight = s.weight or 1
-        for _ = 1, weight do
-            table.insert(rr_state.pool, s.address)
+        local k = id(s)
+        local cw = (current[k] or 0) + weight
+        current[k] = cw
+        total = total + weight
+        if not best or cw > best_weight then
+            best, best_weight = s, cw
         end
     end
-end
 
-function _M.pick()
-    if #rr_state.pool == 0 then
-        return nil
+    if best then
+        current[id(best)] = best_weight - total
     end
-    local addr = rr_state.pool[rr_state.index]
-    rr_state.index = rr_state.index % #rr_state.pool + 1
-    return addr
+    return best
 end
 
 return _M
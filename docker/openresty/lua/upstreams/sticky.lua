@@ -0,0 +1,117 @@
+-- sticky.lua
+-- 会话亲和：upstreams.lb 优先选择亲和 cookie 指向的 server，该 server 不可用时重新均衡，
+-- header_filter 阶段为新选中的 server 签发 cookie。cookie 只包含 server 的摘要，用 HMAC 签名防止伪造。
+local _M = {}
+
+local secrets = ngx.shared.secrets_store
+
+-- token identifies a server in the cookie without exposing its address
+local function token(id)
+    return ngx.md5(id):sub(1, 12)
+end
+
+local function signing_key(name)
+    return secrets:get("sticky/" .. name)
+end
+
+local function sign(key, name, tok, expires)
+    local mac = ngx.encode_base64(ngx.hmac_sha1(key, name .. "|" .. tok .. "|" .. expires), true)
+    return (mac:gsub("%+", "-"):gsub("/", "_"))
+end
+
+-- pinned returns the token of the server named by the affinity cookie and its expiry,
+-- nil when the cookie is missing, expired or not signed by the key of the upstream
+local function pinned(name, opts)
+    local value = ngx.var["cookie_" .. opts.cookie]
+    if not value then
+        return nil
+    end
+    local tok, expires, mac = value:match("^(%x+)%.(%d+)%.([%w_-]+)$")
+    if not tok then
+        return nil
+    end
+    expires = tonumber(expires)
+    if expires > 0 and expires <= ngx.time() then
+        return nil
+    end
+    local key = signing_key(name)
+    if not key or sign(key, name, tok, expires) ~= mac then
+        return nil
+    end
+    return tok, expires
+end
+
+-- pick returns the server of list named by the affinity cookie. nil lets the caller rebalance: the cookie is
+-- missing or invalid, or its server is unavailable or already tried by the request.
+function _M.pick(name, list, id, opts)
+    local tok, expires = pinned(name, opts)
+    ngx.ctx.sticky = { name = name, opts = opts, token = tok, expires = expires }
+    if not tok then
+        return nil
+    end
+    for _, s in ipairs(list) do
+        if token(id(s)) == tok then
+            return s
+        end
+    end
+    return nil
+end
+
+-- bind records the server the request was sent to, the last one when the request was retried
+function _M.bind(server_id)
+    local state = ngx.ctx.sticky
+    if state then
+        state.picked = token(server_id)
+    end
+end
+
+-- set_cookie runs in the header filter: it issues the cookie when the request was sent to another server than
+-- the one in the cookie, and renews it when less than half of its lifetime is left
+function _M.set_cookie()
+    local state = ngx.ctx.sticky
+    if not state or not state.picked then
+        return
+    end
+
+    local opts = state.opts
+    local ttl = opts.ttl or 0
+    local now = ngx.time()
+    if state.picked == state.token and (ttl == 0 or state.expires - now > ttl / 2) then
+        return
+    end
+
+    local key = signing_key(state.name)
+    if not key then
+        ngx.log(ngx.WARN, "no affinity key loaded for upstream ", state.name)
+        return
+    end
+
+    local expires = ttl > 0 and now + ttl or 0
+    local parts = {
+        opts.cookie .. "=" .. state.picked .. "." .. expires .. "." .. sign(key, state.name, state.picked, expires),
+        "Path=" .. (opts.path or "/"),
+    }
+    if ttl > 0 then
+        parts[#parts + 1] = "Max-Age=" .. ttl
+        parts[#parts + 1] = "Expires=" .. ngx.cookie_time(expires)
+    end
+    parts[#parts + 1] = "HttpOnly"
+    parts[#parts + 1] = "SameSite=" .. (opts.samesite or "Lax")
+    if opts.secure then
+        parts[#parts + 1] = "Secure"
+    end
+    local cookie = table.concat(parts, "; ")
+
+    -- 保留上游返回的 Set-Cookie
+    local existing = ngx.header["Set-Cookie"]
+    if type(existing) == "table" then
+        existing[#existing + 1] = cookie
+        ngx.header["Set-Cookie"] = existing
+    elseif existing then
+        ngx.header["Set-Cookie"] = { existing, cookie }
+    else
+        ngx.header["Set-Cookie"] = cookie
+    end
+end
+
+return _M
@@ -4,20 +4,42 @@ local _M = {
     total_weight = 0,
 }
 
+local function add(s)
+    _M.total_weight = _M.total_weight + s.weight
+    table.insert(_M.servers, s)
+end
 
-function _M.init(input)
+-- init keeps the servers this pick chooses from: the primaries with a positive weight, or the backups
+-- when no primary is left. available(s) may exclude more servers, e.g. those down after max_fails.
+function _M.init(input, available)
     _M.servers = {}
     _M.total_weight = 0
 
+    local backups = {}
     for _, s in ipairs(input) do
         local weight = s.weight or 1
-        _M.total_weight = _M.total_weight + weight
-        table.insert(_M.servers, {
-            host = s.host,
-            port = s.port,
-            weight = weight,
-            ips = s.ips
-        })
+        if weight > 0 and (not available or available(s)) then
+            local server = {
+                host = s.host,
+                port = s.port,
+                weight = weight,
+                ips = s.ips,
+                backup = s.backup,
+                max_fails = s.max_fails,
+                fail_timeout = s.fail_timeout,
+            }
+            if s.backup then
+                table.insert(backups, server)
+            else
+                add(server)
+            end
+        end
+    end
+
+    if #_M.servers == 0 then
+        for _, s in ipairs(backups) do
+            add(s)
+        end
     end
 
     return _M
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"reload-agent/internal/agent"
+	"reload-agent/internal/dynamic"
+	"reload-agent/internal/health"
 	"reload-agent/internal/watcher"
 )
 
@@ -27,9 +30,30 @@ func main() {
 			Name: "reload_agent_reload_last_timestamp_seconds",
 			Help: "Unix timestamp of the last reload triggered",
 		})
+
+		configTestFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reload_agent_config_test_failures_total",
+			Help: "Total number of reloads skipped because nginx -t rejected the mounted config",
+		})
 	)
 
-	prometheus.MustRegister(reloadTotal, lastReloadTimestamp)
+	prometheus.MustRegister(reloadTotal, lastReloadTimestamp, configTestFailures)
+	prometheus.MustRegister(dynamic.Collectors()...)
+
+	// 可选的 Pod 内健康检查，从 OpenResty Pod 的网络探测挂载的 upstream
+	if healthData := os.Getenv("HEALTH_CHECK"); healthData != "" {
+		var healthPolicy health.Policy
+		if err := json.Unmarshal([]byte(healthData), &healthPolicy); err != nil {
+			log.Fatalf("invalid HEALTH_CHECK: %v", err)
+		}
+		if healthPolicy.Enable {
+			prometheus.MustRegister(health.Collectors()...)
+			worker := health.NewWorker(healthPolicy, health.DefaultRoots, health.DefaultPushURL)
+			worker.Start()
+			http.Handle("/upstreams/health", worker)
+			log.Println("[reload-agent] in-pod health checks available at :19091/upstreams/health")
+		}
+	}
 
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
@@ -49,17 +73,50 @@ func main() {
 		reloadTotal.Inc()
 		lastReloadTimestamp.SetToCurrentTime()
 	}
+	// ConfigMap 直接挂载进 Pod，operator 的校验 Job 之前就会出现在这里，reload 前先执行 nginx -t
+	r.Validate = func() error {
+		err := agent.TestNginxConfig()
+		if errors.Is(err, agent.ErrConfigTestUnavailable) {
+			// 无法执行校验时保持原有行为，由 nginx 在 reload 失败时保留旧配置
+			fmt.Fprintf(os.Stderr, "[reload-agent] reloading without config test: %v\n", err)
+			return nil
+		}
+		if err != nil {
+			configTestFailures.Inc()
+		}
+		return err
+	}
 	r.StartTicker()
 
 	dirs, err := watcher.DiscoverWatchDirs("/etc/nginx/conf.d")
 	if err != nil {
 		log.Fatalf("failed to discover watch dirs: %v", err)
 	}
+	pusher := dynamic.NewPusher(dynamic.DefaultPushURL)
 	for _, dir := range dirs {
 		dir := dir
+		tracked := dynamic.NewDir(dir)
 		go func() {
 			err := watcher.WatchDirectory(dir, func() {
-				r.RecordChange()
+				hadPeers := tracked.HasPeers()
+				structural, changed := tracked.Changes()
+				// 没有 Dynamic upstream 的目录保持原有行为，每个事件都计入 reload 策略
+				if !hadPeers {
+					r.RecordChange()
+					return
+				}
+				if structural {
+					r.RecordChange()
+				}
+				// 只有 peers 变化时直接推送给 nginx，推送失败时退回 reload
+				for _, path := range changed {
+					if err := pusher.Push(path); err != nil {
+						fmt.Fprintf(os.Stderr, "[reload-agent] failed to push %s, falling back to reload: %v\n", path, err)
+						r.RecordChange()
+						continue
+					}
+					fmt.Printf("[reload-agent] pushed %s without reload\n", path)
+				}
 			})
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "[reload-agent] failed to watch %s: %v\n", dir, err)
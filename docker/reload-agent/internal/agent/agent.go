@@ -23,6 +23,9 @@ type ReloadAgent struct {
 	maxEvents      int
 	lastReloadTime time.Time
 	OnReload       func()
+	// Validate 在发送 SIGHUP 之前检查挂载的配置，返回错误时跳过本次 reload
+	Validate func() error
+	signal   func() error
 }
 
 func NewReloadAgent(windowSeconds int, maxEvents int) *ReloadAgent {
@@ -31,6 +34,7 @@ func NewReloadAgent(windowSeconds int, maxEvents int) *ReloadAgent {
 		window:         time.Duration(windowSeconds) * time.Second,
 		maxEvents:      maxEvents,
 		lastReloadTime: time.Now(),
+		signal:         sendReloadSignalToNginx,
 	}
 }
 
@@ -69,9 +73,19 @@ func (r *ReloadAgent) reload(now time.Time) {
 	fmt.Printf("[reload-agent] ✅ triggering nginx reload (events=%d in %.0fs)\n",
 		len(r.events), now.Sub(r.lastReloadTime).Seconds())
 
+	if r.Validate != nil {
+		if err := r.Validate(); err != nil {
+			// nginx 继续使用上一次有效的配置，下一次文件变化时重新校验
+			fmt.Printf("[reload-agent] ❌ config test failed, keeping the running config: %v\n", err)
+			r.lastReloadTime = now
+			r.events = nil
+			return
+		}
+	}
+
 	r.OnReload()
 
-	if err := sendReloadSignalToNginx(); err != nil {
+	if err := r.signal(); err != nil {
 		fmt.Printf("[reload-agent] ❌ reload failed: %v\n", err)
 		return
 	}
@@ -81,14 +95,24 @@ func (r *ReloadAgent) reload(now time.Time) {
 }
 
 func sendReloadSignalToNginx() error {
+	pid, err := findNginxMasterPID()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[agent] ✅ reloading nginx (pid=%d)\n", pid)
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
+
+func findNginxMasterPID() (int, error) {
 	cmd := exec.Command("ps", "-eo", "pid,comm,args")
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to get ps output: %w", err)
+		return 0, fmt.Errorf("failed to get ps output: %w", err)
 	}
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to run ps: %w", err)
+		return 0, fmt.Errorf("failed to run ps: %w", err)
 	}
+	defer cmd.Wait()
 
 	scanner := bufio.NewScanner(stdout)
 	for scanner.Scan() {
@@ -98,17 +122,16 @@ func sendReloadSignalToNginx() error {
 			if len(fields) >= 1 {
 				pid, err := strconv.Atoi(fields[0])
 				if err == nil {
-					fmt.Printf("[agent] ✅ reloading nginx (pid=%d)\n", pid)
-					return syscall.Kill(pid, syscall.SIGHUP)
+					return pid, nil
 				}
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading ps output: %w", err)
+		return 0, fmt.Errorf("error reading ps output: %w", err)
 	}
 
 	fmt.Println("[agent] ❌ nginx master PID not found")
-	return fmt.Errorf("nginx master PID not found")
+	return 0, fmt.Errorf("nginx master PID not found")
 }
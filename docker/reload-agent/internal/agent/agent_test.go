@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReloadValidatesBeforeSignal(t *testing.T) {
+	tests := []struct {
+		name        string
+		validateErr error
+		wantSignals int
+		wantReloads int
+	}{
+		{"Valid config is reloaded", nil, 1, 1},
+		{"Invalid config keeps the running one", errors.New("nginx -t: unknown directive"), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signals, reloads := 0, 0
+			r := NewReloadAgent(60, 1)
+			r.OnReload = func() { reloads++ }
+			r.Validate = func() error { return tt.validateErr }
+			r.signal = func() error {
+				signals++
+				return nil
+			}
+
+			r.RecordChange()
+
+			if signals != tt.wantSignals || reloads != tt.wantReloads {
+				t.Errorf("got %d signals %d reloads, want %d %d", signals, reloads, tt.wantSignals, tt.wantReloads)
+			}
+			// 校验失败的事件不会在下一个窗口再次触发 reload
+			if len(r.events) != 0 {
+				t.Errorf("events not cleared: %d", len(r.events))
+			}
+		})
+	}
+}
+
+func TestReloadRetriesAfterFailedConfigTest(t *testing.T) {
+	signals := 0
+	valid := false
+	r := NewReloadAgent(60, 1)
+	r.OnReload = func() {}
+	r.Validate = func() error {
+		if !valid {
+			return errors.New("nginx -t: host not found in upstream")
+		}
+		return nil
+	}
+	r.signal = func() error {
+		signals++
+		return nil
+	}
+
+	r.RecordChange()
+	if signals != 0 {
+		t.Fatalf("reloaded an invalid config")
+	}
+
+	valid = true
+	r.RecordChange()
+	if signals != 1 {
+		t.Errorf("got %d signals after the config was fixed, want 1", signals)
+	}
+}
@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// ErrConfigTestUnavailable means `nginx -t` could not be started, e.g. the agent lacks CAP_SYS_CHROOT
+var ErrConfigTestUnavailable = errors.New("nginx config test unavailable")
+
+// TestNginxConfig runs `nginx -t` with the binary and the filesystem of the running nginx master.
+// The agent image has no nginx, and the per-CR ConfigMaps are mounted into both containers, so the
+// test is run chrooted into /proc/<pid>/root to read exactly what the next reload would load.
+func TestNginxConfig() error {
+	pid, err := findNginxMasterPID()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigTestUnavailable, err)
+	}
+
+	// exe 是 nginx 所在 mount namespace 内的路径
+	bin, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigTestUnavailable, err)
+	}
+	bin = strings.TrimSuffix(bin, " (deleted)")
+
+	cmd := exec.Command(bin, "-t")
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{Chroot: fmt.Sprintf("/proc/%d/root", pid)}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("nginx -t: %s", strings.TrimSpace(string(output)))
+		}
+		return fmt.Errorf("%w: %v", ErrConfigTestUnavailable, err)
+	}
+	return nil
+}
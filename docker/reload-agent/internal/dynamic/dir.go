@@ -0,0 +1,100 @@
+package dynamic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// PeersFileSuffix is the peers file of a Dynamic upstream, rendered by the operator
+	PeersFileSuffix = ".peers.json"
+	// healthFileSuffix is read by the in-pod health checks, its changes need no reload either
+	healthFileSuffix = ".health.json"
+)
+
+// Dir tracks the files of a mounted ConfigMap, telling peers-only changes from structural changes
+type Dir struct {
+	mu     sync.Mutex
+	path   string
+	hashes map[string]string
+}
+
+// NewDir records the current files of a directory
+func NewDir(path string) *Dir {
+	d := &Dir{path: path}
+	d.hashes, _ = d.read()
+	return d
+}
+
+// HasPeers reports whether the directory holds the peers file of a Dynamic upstream
+func (d *Dir) HasPeers() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.hashes {
+		if strings.HasSuffix(name, PeersFileSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes compares the files with the last call. It returns whether a file read by nginx changed, and the
+// peers files that changed. A directory that cannot be read counts as structural.
+func (d *Dir) Changes() (bool, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hashes, err := d.read()
+	if err != nil {
+		return true, nil
+	}
+
+	structural := false
+	var peers []string
+	for name, hash := range hashes {
+		if d.hashes[name] == hash {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, PeersFileSuffix):
+			peers = append(peers, filepath.Join(d.path, name))
+		case strings.HasSuffix(name, healthFileSuffix):
+		default:
+			structural = true
+		}
+	}
+	for name := range d.hashes {
+		if _, ok := hashes[name]; !ok && !strings.HasSuffix(name, PeersFileSuffix) && !strings.HasSuffix(name, healthFileSuffix) {
+			structural = true
+		}
+	}
+
+	d.hashes = hashes
+	return structural, peers
+}
+
+// read hashes the keys of the ConfigMap, skipping the "..data" links and timestamped directories of the kubelet
+func (d *Dir) read() (map[string]string, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "..") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(d.path, e.Name()))
+		if err != nil {
+			// 目录等无法读取的项不参与比较
+			continue
+		}
+		sum := sha256.Sum256(data)
+		hashes[e.Name()] = hex.EncodeToString(sum[:])
+	}
+	return hashes, nil
+}
@@ -0,0 +1,88 @@
+package dynamic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultPushURL is the endpoint of the OpenResty status server storing the peers in a shared dict for the balancer
+const DefaultPushURL = "http://127.0.0.1:19090/upstreams/peers"
+
+var pushTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "reload_agent_peers_push_total",
+	Help: "Total number of peer lists pushed to nginx without a reload",
+}, []string{"upstream", "result"})
+
+// Collectors returns the metrics of the peers pusher
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{pushTotal}
+}
+
+// peers is the part of the peers file checked before pushing it
+type peers struct {
+	Upstream string `json:"upstream"`
+	Version  string `json:"version"`
+}
+
+// pushResponse is returned by nginx with the version it stores
+type pushResponse struct {
+	Version string `json:"version"`
+	Changed bool   `json:"changed"`
+}
+
+// Pusher sends the peers files of Dynamic upstreams to nginx
+type Pusher struct {
+	url    string
+	client *http.Client
+}
+
+func NewPusher(url string) *Pusher {
+	return &Pusher{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Push sends a peers file. It succeeds only when nginx answers with the version of the file, pushing the same
+// version again is a no-op in nginx.
+func (p *Pusher) Push(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file peers
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid peers file %s: %w", path, err)
+	}
+
+	err = p.post(data, file.Version)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	pushTotal.WithLabelValues(file.Upstream, result).Inc()
+	return err
+}
+
+func (p *Pusher) post(data []byte, version string) error {
+	resp, err := p.client.Post(p.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var stored pushResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if stored.Version != version {
+		return fmt.Errorf("nginx stored version %q, pushed %q", stored.Version, version)
+	}
+	return nil
+}
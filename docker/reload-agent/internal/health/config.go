@@ -0,0 +1,126 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TargetsFileSuffix is the suffix of the files the operator renders next to every upstream config,
+// listing the servers and the health check of the upstream
+const TargetsFileSuffix = ".health.json"
+
+// DefaultRoots are the mount points of the upstream ConfigMaps: Address upstreams under conf.d, FullURL upstreams under lualib
+var DefaultRoots = []string{"/etc/nginx/conf.d/upstreams", "/usr/local/openresty/lualib/upstreams"}
+
+// Policy is read from the HEALTH_CHECK environment variable, e.g. {"enable":true,"workers":4}
+type Policy struct {
+	Enable  bool `json:"enable"`
+	Workers int  `json:"workers"`
+}
+
+// Targets is the content of a targets file
+type Targets struct {
+	// Upstream is the name of the upstream in the balancer options
+	Upstream    string   `json:"upstream"`
+	Servers     []string `json:"servers"`
+	HealthCheck Check    `json:"healthCheck"`
+}
+
+// Check mirrors the healthCheck of the Upstream CRD, rendered by the operator with its defaults applied
+type Check struct {
+	Type               string   `json:"type,omitempty"`
+	Path               string   `json:"path,omitempty"`
+	Method             string   `json:"method,omitempty"`
+	Host               string   `json:"host,omitempty"`
+	Headers            []Header `json:"headers,omitempty"`
+	ExpectedStatuses   []string `json:"expectedStatuses,omitempty"`
+	BodyMatch          string   `json:"bodyMatch,omitempty"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify,omitempty"`
+	IntervalSeconds    int      `json:"intervalSeconds,omitempty"`
+	TimeoutSeconds     int      `json:"timeoutSeconds,omitempty"`
+	HealthyThreshold   int      `json:"healthyThreshold,omitempty"`
+	UnhealthyThreshold int      `json:"unhealthyThreshold,omitempty"`
+	JitterSeconds      int      `json:"jitterSeconds,omitempty"`
+}
+
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (c Check) interval() time.Duration {
+	return secondsOr(c.IntervalSeconds, 10)
+}
+
+func (c Check) timeout() time.Duration {
+	return secondsOr(c.TimeoutSeconds, 2)
+}
+
+func (c Check) healthyThreshold() int {
+	if c.HealthyThreshold <= 0 {
+		return 2
+	}
+	return c.HealthyThreshold
+}
+
+func (c Check) unhealthyThreshold() int {
+	if c.UnhealthyThreshold <= 0 {
+		return 3
+	}
+	return c.UnhealthyThreshold
+}
+
+func secondsOr(seconds, fallback int) time.Duration {
+	if seconds <= 0 {
+		seconds = fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// statusExpected matches code against ranges like "200" or "200-299", 200-399 when empty
+func (c Check) statusExpected(code int) bool {
+	if len(c.ExpectedStatuses) == 0 {
+		return code >= 200 && code < 400
+	}
+	for _, s := range c.ExpectedStatuses {
+		from, to, isRange := strings.Cut(strings.TrimSpace(s), "-")
+		if !isRange {
+			to = from
+		}
+		lo, err1 := strconv.Atoi(from)
+		hi, err2 := strconv.Atoi(to)
+		if err1 == nil && err2 == nil && code >= lo && code <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadTargets reads every targets file below the roots, a root that does not exist is skipped
+func LoadTargets(roots []string) ([]Targets, error) {
+	var all []Targets
+	for _, root := range roots {
+		// ConfigMap 挂载目录中的文件是指向 ..data 的符号链接，Glob 会跟随链接
+		files, err := filepath.Glob(filepath.Join(root, "*", "*"+TargetsFileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", file, err)
+			}
+			var targets Targets
+			if err := json.Unmarshal(data, &targets); err != nil {
+				return nil, fmt.Errorf("invalid targets file %s: %w", file, err)
+			}
+			all = append(all, targets)
+		}
+	}
+	return all, nil
+}
@@ -0,0 +1,147 @@
+package health
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const maxBodyMatchSize = 64 << 10
+
+// splitAddress returns the host and port of a server address, "host:port" or the URL of a FullURL upstream.
+// A host without port defaults to 443 for TLS checks and 80 otherwise, like the URL schemes.
+func splitAddress(address, checkType string) (string, string, error) {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return "", "", err
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		return u.Hostname(), port, nil
+	}
+	if !strings.Contains(address, ":") {
+		switch checkType {
+		case "https", "tls", "grpcs":
+			return address, "443", nil
+		}
+		return address, "80", nil
+	}
+	return net.SplitHostPort(address)
+}
+
+// probe checks a server once and returns the reason it is down, empty when it is healthy.
+// grpc checks only dial the server and grpcs checks only complete the TLS handshake, the agent has no gRPC client.
+func probe(address string, check Check) (string, string) {
+	host, port, err := splitAddress(address, check.Type)
+	if err != nil {
+		return "INVALID_ADDRESS", err.Error()
+	}
+	hostPort := net.JoinHostPort(host, port)
+
+	switch check.Type {
+	case "http", "https":
+		return probeHTTP(host, hostPort, check)
+	case "tls", "grpcs":
+		return probeTLS(host, hostPort, check)
+	default:
+		conn, err := net.DialTimeout("tcp", hostPort, check.timeout())
+		if err != nil {
+			return "TCP_FAIL", err.Error()
+		}
+		_ = conn.Close()
+		return "", ""
+	}
+}
+
+func tlsConfig(host string, check Check) *tls.Config {
+	serverName := host
+	if check.Host != "" {
+		serverName = check.Host
+	}
+	return &tls.Config{ServerName: serverName, InsecureSkipVerify: check.InsecureSkipVerify}
+}
+
+func probeTLS(host, hostPort string, check Check) (string, string) {
+	dialer := &net.Dialer{Timeout: check.timeout()}
+	conn, err := tls.DialWithDialer(dialer, "tcp", hostPort, tlsConfig(host, check))
+	if err != nil {
+		return "TLS_ERROR", err.Error()
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) > 0 && time.Now().After(certs[0].NotAfter) {
+		return "TLS_EXPIRED", fmt.Sprintf("certificate expired at %s", certs[0].NotAfter.Format(time.RFC3339))
+	}
+	return "", ""
+}
+
+func probeHTTP(host, hostPort string, check Check) (string, string) {
+	client := &http.Client{
+		Timeout: check.timeout(),
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig:   tlsConfig(host, check),
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	path := check.Path
+	if path == "" {
+		path = "/"
+	}
+	method := check.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequest(method, check.Type+"://"+hostPort+path, nil)
+	if err != nil {
+		return "HTTP_ERROR", err.Error()
+	}
+	if check.Host != "" {
+		req.Host = check.Host
+	}
+	req.Header.Set("User-Agent", "reload-agent-health-check")
+	for _, h := range check.Headers {
+		req.Header.Set(h.Key, h.Value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "HTTP_ERROR", err.Error()
+	}
+	defer resp.Body.Close()
+
+	if !check.statusExpected(resp.StatusCode) {
+		return "HTTP_STATUS", fmt.Sprintf("http status %d", resp.StatusCode)
+	}
+	if check.BodyMatch != "" {
+		re, err := regexp.Compile(check.BodyMatch)
+		if err != nil {
+			return "HTTP_BODY", fmt.Sprintf("invalid bodyMatch: %s", err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyMatchSize))
+		if err != nil {
+			return "HTTP_ERROR", err.Error()
+		}
+		if !re.Match(body) {
+			return "HTTP_BODY", "http body does not match"
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,40 @@
+package health
+
+import "testing"
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		name      string
+		address   string
+		checkType string
+		wantHost  string
+		wantPort  string
+	}{
+		{"host:port", "10.0.0.1:8080", "tcp", "10.0.0.1", "8080"},
+		{"Portless host", "backend.default.svc", "http", "backend.default.svc", "80"},
+		{"Portless host of a TLS check", "backend.default.svc", "grpcs", "backend.default.svc", "443"},
+		{"Portless host of an https check", "backend.default.svc", "https", "backend.default.svc", "443"},
+		{"https URL", "https://a.example.com/api", "http", "a.example.com", "443"},
+		{"http URL with port", "http://a.example.com:8080", "http", "a.example.com", "8080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, err := splitAddress(tt.address, tt.checkType)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if host != tt.wantHost || port != tt.wantPort {
+				t.Errorf("got %s %s, want %s %s", host, port, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestProbePortlessAddress(t *testing.T) {
+	// 只验证地址可以解析，端口 80 是否监听取决于运行环境
+	reason, message := probe("127.0.0.1", Check{Type: "tcp", TimeoutSeconds: 1})
+	if reason != "" && reason != "TCP_FAIL" {
+		t.Errorf("unexpected reason %s: %s", reason, message)
+	}
+}
@@ -0,0 +1,284 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// scanInterval 重新读取 targets 文件的间隔，ConfigMap 更新后最迟在这个时间内生效
+	scanInterval = 10 * time.Second
+	// pushInterval 向 nginx 推送结果的间隔，nginx 中的结果保留 3 个间隔，agent 停止后自动失效
+	pushInterval = 5 * time.Second
+)
+
+// DefaultPushURL is the endpoint of the OpenResty status server storing the results in a shared dict for the balancer
+const DefaultPushURL = "http://127.0.0.1:19090/upstreams/health"
+
+var (
+	serverUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "reload_agent_upstream_server_up",
+		Help: "Whether the upstream server passes the in-pod health check (1) or not (0)",
+	}, []string{"upstream", "server"})
+
+	probeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "reload_agent_upstream_probe_duration_seconds",
+		Help: "Duration of the last in-pod health check of the upstream server",
+	}, []string{"upstream", "server"})
+)
+
+// Collectors returns the metrics of the health-check worker
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{serverUp, probeDuration}
+}
+
+// ServerHealth is the in-pod view of a server, served on /upstreams/health
+type ServerHealth struct {
+	Server string `json:"server"`
+	Alive  bool   `json:"alive"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type target struct {
+	upstream string
+	address  string
+	check    Check
+	stop     chan struct{}
+
+	// 以下字段由 Worker.mu 保护
+	checked   bool
+	alive     bool
+	reason    string
+	successes int
+	failures  int
+}
+
+// Worker probes the servers of the upstreams mounted in the pod
+type Worker struct {
+	roots   []string
+	pushURL string
+	sem     chan struct{}
+	client  *http.Client
+
+	mu      sync.Mutex
+	targets map[string]*target
+}
+
+func NewWorker(policy Policy, roots []string, pushURL string) *Worker {
+	workers := policy.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	return &Worker{
+		roots:   roots,
+		pushURL: pushURL,
+		sem:     make(chan struct{}, workers),
+		client:  &http.Client{Timeout: 2 * time.Second},
+		targets: make(map[string]*target),
+	}
+}
+
+// Start scans the targets files and pushes the results to nginx in the background
+func (w *Worker) Start() {
+	go func() {
+		w.scan()
+		ticker := time.NewTicker(scanInterval)
+		for range ticker.C {
+			w.scan()
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(pushInterval)
+		for range ticker.C {
+			if err := w.push(); err != nil {
+				fmt.Printf("[reload-agent] ❌ failed to push health results: %v\n", err)
+			}
+		}
+	}()
+}
+
+func targetKey(upstream, address string, check Check) string {
+	data, _ := json.Marshal(check)
+	return upstream + "|" + address + "|" + string(data)
+}
+
+// scan starts probing new targets and stops the targets no longer listed
+func (w *Worker) scan() {
+	all, err := LoadTargets(w.roots)
+	if err != nil {
+		// 读取失败时保持现有的 targets，等待下一次扫描
+		fmt.Printf("[reload-agent] ❌ failed to load health targets: %v\n", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, t := range all {
+		for _, address := range t.Servers {
+			key := targetKey(t.Upstream, address, t.HealthCheck)
+			seen[key] = true
+			if _, ok := w.targets[key]; ok {
+				continue
+			}
+			tg := &target{upstream: t.Upstream, address: address, check: t.HealthCheck, stop: make(chan struct{})}
+			w.targets[key] = tg
+			go w.run(tg)
+		}
+	}
+
+	for key, tg := range w.targets {
+		if seen[key] {
+			continue
+		}
+		close(tg.stop)
+		delete(w.targets, key)
+		if !w.listed(tg.upstream, tg.address) {
+			serverUp.DeleteLabelValues(tg.upstream, tg.address)
+			probeDuration.DeleteLabelValues(tg.upstream, tg.address)
+		}
+	}
+}
+
+// listed reports whether another target still checks the server, must be called with the lock held
+func (w *Worker) listed(upstream, address string) bool {
+	for _, tg := range w.targets {
+		if tg.upstream == upstream && tg.address == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Worker) run(tg *target) {
+	for {
+		w.sem <- struct{}{}
+		start := time.Now()
+		reason, comment := probe(tg.address, tg.check)
+		elapsed := time.Since(start)
+		<-w.sem
+
+		select {
+		case <-tg.stop:
+			return
+		default:
+		}
+		w.observe(tg, reason, comment, elapsed)
+
+		delay := tg.check.interval()
+		if jitter := tg.check.JitterSeconds; jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(jitter) * int64(time.Second)))
+		}
+		select {
+		case <-tg.stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// observe applies the thresholds of the check: the first result decides the state, later a server goes down
+// after unhealthyThreshold consecutive failures and back up after healthyThreshold consecutive successes
+func (w *Worker) observe(tg *target, reason, comment string, elapsed time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ok := reason == ""
+	if ok {
+		tg.successes++
+		tg.failures = 0
+	} else {
+		tg.failures++
+		tg.successes = 0
+	}
+
+	wasAlive := tg.alive
+	switch {
+	case !tg.checked:
+		tg.alive = ok
+	case tg.alive && !ok && tg.failures >= tg.check.unhealthyThreshold():
+		tg.alive = false
+	case !tg.alive && ok && tg.successes >= tg.check.healthyThreshold():
+		tg.alive = true
+	}
+	if !tg.alive && !ok {
+		tg.reason = reason
+	} else if tg.alive {
+		tg.reason = ""
+	}
+
+	if !tg.checked || wasAlive != tg.alive {
+		fmt.Printf("[reload-agent] upstream %s server %s alive=%v %s %s\n", tg.upstream, tg.address, tg.alive, reason, comment)
+	}
+	tg.checked = true
+
+	up := 0.0
+	if tg.alive {
+		up = 1
+	}
+	serverUp.WithLabelValues(tg.upstream, tg.address).Set(up)
+	probeDuration.WithLabelValues(tg.upstream, tg.address).Set(elapsed.Seconds())
+}
+
+// Snapshot returns the checked servers by upstream, sorted by address
+func (w *Worker) Snapshot() map[string][]ServerHealth {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	result := make(map[string][]ServerHealth)
+	for _, tg := range w.targets {
+		if !tg.checked {
+			continue
+		}
+		result[tg.upstream] = append(result[tg.upstream], ServerHealth{Server: tg.address, Alive: tg.alive, Reason: tg.reason})
+	}
+	for _, servers := range result {
+		sort.Slice(servers, func(i, j int) bool {
+			return servers[i].Server < servers[j].Server
+		})
+	}
+	return result
+}
+
+// push sends { "<upstream>": { "<server>": alive } } to nginx
+func (w *Worker) push() error {
+	payload := make(map[string]map[string]bool)
+	for upstream, servers := range w.Snapshot() {
+		payload[upstream] = make(map[string]bool, len(servers))
+		for _, s := range servers {
+			payload[upstream][s.Server] = s.Alive
+		}
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.pushURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ServeHTTP serves the in-pod view of the servers for the operator
+func (w *Worker) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(w.Snapshot())
+}
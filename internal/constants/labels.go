@@ -13,6 +13,8 @@ const (
 	LabelComponent = "app.kubernetes.io/component"
 
 	LabelOwnedCR = Prefix + "/cr"
+
+	LabelConfigHash = Prefix + "/config-hash"
 )
 
 func BuildCommonLabels(owner client.Object, component string) map[string]string {
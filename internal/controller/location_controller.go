@@ -27,6 +27,7 @@ import (
 	"k8s.io/client-go/tools/record"
 	"openresty-operator/internal/constants"
 	"openresty-operator/internal/handler"
+	"openresty-operator/internal/nginxconf"
 	"openresty-operator/internal/runtime/metrics"
 	"openresty-operator/internal/utils"
 	"strings"
@@ -94,7 +95,74 @@ func (r *LocationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		}
 	}
 
-	conf := handler.GenerateLocationConfig(location.Name, location.Namespace, location.Spec.Entries)
+	upstreamRefs := handler.ResolveUpstreamRefs(r.Get, location.Namespace, location.Spec.Entries)
+	location.Status.UpstreamRefs = upstreamRefs.Resolved
+	if len(upstreamRefs.Missing) > 0 {
+		msg := fmt.Sprintf("Missing Upstreams: %s", strings.Join(upstreamRefs.Missing, ", "))
+		r.Recorder.Eventf(location, corev1.EventTypeWarning, "MissingUpstream", msg)
+		metrics.Recorder(location.Kind, location.Namespace, location.Name, corev1.EventTypeWarning, msg)
+
+		r.updateLocationStatus(ctx, location, false, msg, log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	rateLimitRefs := handler.ResolveRateLimitPolicyRefs(r.Get, location.Namespace, location.Spec.Entries)
+	location.Status.RateLimitPolicyRefs = rateLimitRefs.Resolved
+	if len(rateLimitRefs.Missing) > 0 {
+		msg := fmt.Sprintf("Missing RateLimitPolicies: %s", strings.Join(rateLimitRefs.Missing, ", "))
+		r.Recorder.Eventf(location, corev1.EventTypeWarning, "MissingRateLimitPolicy", msg)
+		metrics.Recorder(location.Kind, location.Namespace, location.Name, corev1.EventTypeWarning, msg)
+
+		r.updateLocationStatus(ctx, location, false, msg, log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	trafficShapingRefs := handler.ResolveTrafficShapingPolicyRefs(r.Get, location.Namespace, location.Spec.Entries)
+	location.Status.TrafficShapingPolicyRefs = trafficShapingRefs.Resolved
+	if len(trafficShapingRefs.Missing) > 0 {
+		msg := fmt.Sprintf("Missing TrafficShapingPolicies: %s", strings.Join(trafficShapingRefs.Missing, ", "))
+		r.Recorder.Eventf(location, corev1.EventTypeWarning, "MissingTrafficShapingPolicy", msg)
+		metrics.Recorder(location.Kind, location.Namespace, location.Name, corev1.EventTypeWarning, msg)
+
+		r.updateLocationStatus(ctx, location, false, msg, log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	quotaRefs := handler.ResolveQuotaRefs(r.Get, location.Namespace, location.Spec.Entries)
+	location.Status.QuotaRefs = quotaRefs.Resolved
+	if len(quotaRefs.Missing) > 0 {
+		msg := fmt.Sprintf("Missing Quotas: %s", strings.Join(quotaRefs.Missing, ", "))
+		r.Recorder.Eventf(location, corev1.EventTypeWarning, "MissingQuota", msg)
+		metrics.Recorder(location.Kind, location.Namespace, location.Name, corev1.EventTypeWarning, msg)
+
+		r.updateLocationStatus(ctx, location, false, msg, log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	conf := handler.GenerateLocationConfig(location.Name, location.Namespace, location.Spec.Entries,
+		upstreamRefs.Types, upstreamRefs.Features, rateLimitRefs.Policies, trafficShapingRefs.Policies, quotaRefs.Quotas)
+
+	// 校验最终渲染出的 *_by_lua_block，失败时保留上一版 ConfigMap
+	if problems := utils.ValidateGeneratedLua(conf); len(problems) > 0 {
+		msg := fmt.Sprintf("Invalid generated lua: %s", strings.Join(problems, " | "))
+		r.Recorder.Eventf(location, corev1.EventTypeWarning, "InvalidLua", msg)
+		metrics.Recorder(location.Kind, location.Namespace, location.Name, corev1.EventTypeWarning, msg)
+
+		r.updateLocationStatus(ctx, location, false, msg, log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	// Location 片段最终被 include 到 server 块中
+	findings := nginxconf.Lint(conf, nginxconf.ContextServer)
+	location.Status.LintFindings = findings.Strings()
+	if findings.HasErrors() {
+		msg := fmt.Sprintf("Invalid nginx config: %s", strings.Join(findings.Errors().Strings(), " | "))
+		r.Recorder.Eventf(location, corev1.EventTypeWarning, "InvalidConfig", msg)
+		metrics.Recorder(location.Kind, location.Namespace, location.Name, corev1.EventTypeWarning, msg)
+
+		r.updateLocationStatus(ctx, location, false, msg, log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
 
 	if err := r.createOrUpdateConfigMap(ctx, location, conf, log); err != nil {
 		return ctrl.Result{}, err
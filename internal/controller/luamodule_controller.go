@@ -0,0 +1,223 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/constants"
+	"openresty-operator/internal/handler"
+	"openresty-operator/internal/runtime/metrics"
+	"openresty-operator/internal/utils"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"strings"
+	"time"
+)
+
+// LuaModuleReconciler reconciles a LuaModule object
+type LuaModuleReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=luamodules,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=luamodules/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=luamodules/finalizers,verbs=update
+
+// Reconcile validates the module, publishes its files into the "luamodule-<name>" ConfigMap
+// and rolls the OpenResty instances consuming it (directly or through a dependency) when
+// its content changes.
+func (r *LuaModuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx).WithValues("luamodule", req.NamespacedName)
+
+	var module webv1alpha1.LuaModule
+	if err := r.Get(ctx, req.NamespacedName, &module); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if valid, problems := handler.ValidateLuaModule(&module); !valid {
+		msg := strings.Join(problems, " | ")
+		r.Recorder.Eventf(&module, corev1.EventTypeWarning, "InvalidModule", msg)
+		metrics.Recorder(module.Kind, module.Namespace, module.Name, corev1.EventTypeWarning, msg)
+		r.updateLuaModuleStatus(ctx, &module, false, msg, module.Status.Dependencies, module.Status.Hash, log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	deps := handler.ResolveLuaModuleDependencies(r.Get, &module)
+	if len(deps.Cycle) > 0 || len(deps.Missing) > 0 {
+		var parts []string
+		if len(deps.Cycle) > 0 {
+			parts = append(parts, fmt.Sprintf("Dependency cycle: %s", strings.Join(deps.Cycle, " -> ")))
+		}
+		if len(deps.Missing) > 0 {
+			parts = append(parts, fmt.Sprintf("Missing LuaModules: %s", strings.Join(deps.Missing, ", ")))
+		}
+		msg := strings.Join(parts, " | ")
+		r.Recorder.Eventf(&module, corev1.EventTypeWarning, "InvalidDependency", msg)
+		metrics.Recorder(module.Kind, module.Namespace, module.Name, corev1.EventTypeWarning, msg)
+		r.updateLuaModuleStatus(ctx, &module, false, msg, module.Status.Dependencies, module.Status.Hash, log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	if err := r.createOrUpdateConfigMap(ctx, &module, handler.RenderLuaModuleFiles(&module), log); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	hash := handler.LuaModuleHash(&module)
+	changed := module.Status.Hash != hash || !utils.EqualSlices(module.Status.Dependencies, deps.Resolved)
+	previousHash := module.Status.Hash
+
+	r.updateLuaModuleStatus(ctx, &module, true, "", deps.Resolved, hash, log)
+
+	// 首次发布时没有正在使用旧版本的 OpenResty, 无需滚动
+	if changed && previousHash != "" {
+		if err := r.updateOpenResty(ctx, &module, log); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+}
+
+func (r *LuaModuleReconciler) updateLuaModuleStatus(ctx context.Context, module *webv1alpha1.LuaModule, ready bool, reason string, dependencies []string, hash string, log logr.Logger) {
+	module.Status.Ready = ready
+	module.Status.Version = fmt.Sprintf("%d", module.Generation)
+	module.Status.Reason = reason
+	module.Status.ModulePath = handler.LuaModulePath(module.Namespace, module.Name)
+	module.Status.Dependencies = dependencies
+	module.Status.Hash = hash
+
+	if err := r.Status().Update(ctx, module); err != nil {
+		if errors.IsConflict(err) {
+			log.Info("LuaModule status conflict, skipping update")
+		} else {
+			log.Error(err, "Failed to update LuaModule status")
+		}
+	}
+}
+
+func (r *LuaModuleReconciler) createOrUpdateConfigMap(ctx context.Context, module *webv1alpha1.LuaModule, data map[string]string, log logr.Logger) error {
+	name := "luamodule-" + module.Name
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: module.Namespace,
+			Labels:    constants.BuildCommonLabels(module, "configmap"),
+		},
+		Data: data,
+	}
+
+	if err := ctrl.SetControllerReference(module, cm, r.Scheme); err != nil {
+		return err
+	}
+
+	var existing corev1.ConfigMap
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: module.Namespace}, &existing)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Creating ConfigMap", "name", name)
+			return r.Create(ctx, cm)
+		}
+		return err
+	}
+
+	// 整体替换, 已删除的文件不能残留在 ConfigMap 中
+	if !utils.DeepEqual(existing.Data, data) {
+		log.Info("Updating ConfigMap", "name", name)
+		existing.Data = data
+		return r.Update(ctx, &existing)
+	}
+
+	return nil
+}
+
+// updateOpenResty triggers OpenResty instances referencing this module, or any module depending on it
+func (r *LuaModuleReconciler) updateOpenResty(ctx context.Context, module *webv1alpha1.LuaModule, log logr.Logger) error {
+	consumers := []string{module.Name}
+
+	var moduleList webv1alpha1.LuaModuleList
+	if err := r.List(ctx, &moduleList, client.InNamespace(module.Namespace)); err != nil {
+		return err
+	}
+	for _, m := range moduleList.Items {
+		for _, dep := range m.Status.Dependencies {
+			if dep == module.Name {
+				consumers = append(consumers, m.Name)
+				break
+			}
+		}
+	}
+
+	triggered := make(map[string]struct{})
+	for _, name := range consumers {
+		var appList webv1alpha1.OpenRestyList
+		if err := r.List(ctx, &appList,
+			client.MatchingFields{"spec.http.luaModuleRefs": fmt.Sprintf("%s/%s", module.Namespace, name)},
+		); err != nil {
+			return err
+		}
+
+		for _, app := range appList.Items {
+			if _, ok := triggered[app.Name]; ok {
+				continue
+			}
+			triggered[app.Name] = struct{}{}
+			log.Info("LuaModule changed, reloading OpenResty", "openresty", app.Name)
+			_ = r.triggerReconcile(ctx, &app)
+		}
+	}
+
+	return nil
+}
+
+func (r *LuaModuleReconciler) triggerReconcile(ctx context.Context, app *webv1alpha1.OpenResty) error {
+	patched := app.DeepCopy()
+
+	if patched.Annotations == nil {
+		patched.Annotations = map[string]string{}
+	}
+
+	patched.Annotations[constants.AnnotationTriggerHash] = fmt.Sprintf("%d", time.Now().UnixNano())
+
+	return r.Patch(ctx, patched, client.MergeFrom(app))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LuaModuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&webv1alpha1.LuaModule{}).
+		Owns(&corev1.ConfigMap{}).
+		WithEventFilter(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return utils.IsSpecChanged(e.ObjectOld, e.ObjectNew)
+			},
+		}).
+		Complete(r)
+}
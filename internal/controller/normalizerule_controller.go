@@ -31,7 +31,9 @@ import (
 	"openresty-operator/api/v1alpha1"
 	"openresty-operator/internal/constants"
 	"openresty-operator/internal/handler"
+	"openresty-operator/internal/utils"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -110,6 +112,13 @@ func (r *NormalizeRuleReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		valid = valid && validateField(item, fieldName)
 	}
 
+	var reason string
+	if problems := utils.ValidateNormalizeRuleLua(&normalizeRule); len(problems) > 0 {
+		reason = fmt.Sprintf("Invalid lua: %s", strings.Join(problems, " | "))
+		r.Recorder.Eventf(&normalizeRule, corev1.EventTypeWarning, "InvalidLua", reason)
+		valid = false
+	}
+
 	if !controllerutil.ContainsFinalizer(&normalizeRule, constants.NormalizeRuleFinalizer) {
 		controllerutil.AddFinalizer(&normalizeRule, constants.NormalizeRuleFinalizer)
 		_ = r.Update(ctx, &normalizeRule)
@@ -138,6 +147,13 @@ func (r *NormalizeRuleReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 			}
 			return &s, nil
 		})
+		// 最终生成的模块也需通过语法检查，否则保留上一版
+		if err := utils.ParseLua(lua); err != nil {
+			reason = fmt.Sprintf("Invalid generated lua: %s", err.Error())
+			r.Recorder.Eventf(&normalizeRule, corev1.EventTypeWarning, "InvalidLua", reason)
+			r.updateNormalizeRuleStatus(ctx, &normalizeRule, false, reason, logger)
+			return ctrl.Result{}, nil
+		}
 		handler.CreateOrUpdateConfigMap(ctx, r.Client, r.Scheme, &normalizeRule, normalizeRule.Namespace+"-normalize",
 			normalizeRule.Namespace, constants.BuildCommonLabels(&normalizeRule, "configmap"), map[string]string{
 				normalizeRule.Name + UpstreamRenderTypeLua: lua,
@@ -147,7 +163,7 @@ func (r *NormalizeRuleReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 			}, nil)
 	}
 
-	r.updateNormalizeRuleStatus(ctx, &normalizeRule, valid, "", logger)
+	r.updateNormalizeRuleStatus(ctx, &normalizeRule, valid, reason, logger)
 
 	return ctrl.Result{}, nil
 }
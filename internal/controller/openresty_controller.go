@@ -22,6 +22,7 @@ import (
 	"github.com/go-logr/logr"
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -29,6 +30,7 @@ import (
 	webv1alpha1 "openresty-operator/api/v1alpha1"
 	"openresty-operator/internal/constants"
 	"openresty-operator/internal/handler"
+	"openresty-operator/internal/nginxconf"
 	"openresty-operator/internal/runtime/metrics"
 	"openresty-operator/internal/utils"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -36,8 +38,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"strings"
+	"time"
 )
 
+// configValidationPollInterval is used while a `nginx -t` Job is running, Job status changes also trigger a reconcile
+const configValidationPollInterval = 5 * time.Second
+
 // OpenRestyReconciler reconciles a OpenResty object
 type OpenRestyReconciler struct {
 	client.Client
@@ -48,6 +55,8 @@ type OpenRestyReconciler struct {
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=openresties,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=openresties/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=openresties/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -68,18 +77,61 @@ func (r *OpenRestyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	serverStatus := handler.ValidateServerRefs(r.Get, app)
 	upstreamStatus := handler.ValidateUpstreamRefs(r.Get, app)
+	luaModuleStatus := handler.ValidateLuaModuleRefs(r.Get, app)
+	rateLimitStatus := handler.ValidateRateLimitPolicyRefs(r.Get, app)
+	trafficShapingStatus := handler.ValidateTrafficShapingPolicyRefs(r.Get, app, rateLimitStatus.Zones)
+	quotaStatus := handler.ValidateQuotaRefs(r.Get, app)
 
-	if !serverStatus.AllReady || !upstreamStatus.AllReady {
-		reason := handler.ComposeDependencyFailureReason(serverStatus, upstreamStatus)
+	if !serverStatus.AllReady || !upstreamStatus.AllReady || !luaModuleStatus.AllReady ||
+		!rateLimitStatus.AllReady || !trafficShapingStatus.AllReady || !quotaStatus.AllReady {
+		reason := handler.ComposeDependencyFailureReason(serverStatus, upstreamStatus, luaModuleStatus, rateLimitStatus, trafficShapingStatus, quotaStatus)
 		r.handleDependencyFailure(ctx, app, reason, log)
 		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
 	}
 
+	if valid, problems := handler.ValidateHttpBlock(app.Spec.Http, app.Spec.MetricsServer); !valid {
+		reason := strings.Join(problems, " | ")
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "InvalidSpec", reason)
+		app.Status.Ready = false
+		app.Status.Reason = reason
+		if err := r.Status().Update(ctx, app); err != nil && !errors.IsConflict(err) {
+			log.Error(err, "Failed to update OpenResty status")
+		}
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
 	nginxConf := handler.RenderNginxConf(
 		app.Spec.Http,
 		app.Spec.MetricsServer,
 		handler.BuildIncludeLines(app, upstreamStatus))
 
+	findings := nginxconf.Lint(nginxConf, nginxconf.ContextMain)
+	app.Status.LintFindings = findings.Strings()
+	if findings.HasErrors() {
+		reason := fmt.Sprintf("Invalid nginx config: %s", strings.Join(findings.Errors().Strings(), " | "))
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "InvalidConfig", reason)
+		// 保留上一版 nginx.conf 与 Deployment，不做滚动
+		app.Status.Ready = false
+		app.Status.Reason = reason
+		if err := r.Status().Update(ctx, app); err != nil && !errors.IsConflict(err) {
+			log.Error(err, "Failed to update OpenResty status")
+		}
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	if handler.ConfigValidationEnabled(app) {
+		result, err := r.validateConfigSet(ctx, app, nginxConf, upstreamStatus, luaModuleStatus, log)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !result.Done {
+			return ctrl.Result{RequeueAfter: configValidationPollInterval}, nil
+		}
+		if !result.Passed {
+			return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+		}
+	}
+
 	if err := handler.CreateOrUpdateConfigMap(
 		ctx, r.Client, r.Scheme, app,
 		"openresty-"+app.Name+"-main",
@@ -90,9 +142,13 @@ func (r *OpenRestyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
-	if err, _ = handler.DeployOpenRestyPod(ctx, r.Client, r.Scheme, app, upstreamStatus.UpstreamsType, log); err != nil {
+	err, vmResult := handler.DeployOpenRestyPod(ctx, r.Client, r.Scheme, app, upstreamStatus.UpstreamsType, luaModuleStatus.Modules, log)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	for _, name := range vmResult.MissingLocations {
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "MissingLocation", "Location %s not found, its static content is not mounted", name)
+	}
 
 	if err := handler.DeployServerBlockServices(ctx, r.Client, r.Scheme, app, log); err != nil {
 		return ctrl.Result{}, err
@@ -123,6 +179,59 @@ func (r *OpenRestyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	return ctrl.Result{}, nil
 }
 
+// validateConfigSet runs `nginx -t` when the config set differs from the last validated one.
+// A rejected config set is recorded in status, the caller must then leave the main ConfigMap and
+// Deployment untouched.
+func (r *OpenRestyReconciler) validateConfigSet(
+	ctx context.Context,
+	app *webv1alpha1.OpenResty,
+	nginxConf string,
+	upstreamStatus handler.UpstreamRefsStatus,
+	luaModuleStatus handler.LuaModuleRefsStatus,
+	log logr.Logger,
+) (*handler.ConfigValidationResult, error) {
+	vmResult, err := handler.BuildVolumesAndMounts(ctx, r.Client, app, upstreamStatus.UpstreamsType, luaModuleStatus.Modules)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := handler.ConfigSetHash(r.Get, app, nginxConf, vmResult.Volumes)
+	if err != nil {
+		return nil, err
+	}
+	if hash == app.Status.ValidatedConfigHash {
+		app.Status.ConfigError = ""
+		return &handler.ConfigValidationResult{Done: true, Passed: true}, nil
+	}
+
+	result, err := handler.ValidateConfigWithJob(ctx, r.Client, r.Scheme, app, nginxConf, hash, vmResult, log)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Done {
+		log.Info("Waiting for nginx -t validation", "hash", hash)
+		return result, nil
+	}
+
+	if !result.Passed {
+		reason := fmt.Sprintf("nginx -t failed: %s", result.Output)
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, "InvalidConfig", reason)
+		// 保留上一版 nginx.conf 与 Deployment，不做滚动
+		app.Status.Ready = false
+		app.Status.Reason = reason
+		app.Status.ConfigError = result.Output
+		if err := r.Status().Update(ctx, app); err != nil && !errors.IsConflict(err) {
+			log.Error(err, "Failed to update OpenResty status")
+		}
+		return result, nil
+	}
+
+	r.Recorder.Eventf(app, corev1.EventTypeNormal, "ConfigValidated", "Config set %s passed nginx -t", hash)
+	app.Status.ValidatedConfigHash = hash
+	app.Status.ConfigError = ""
+	return result, nil
+}
+
 func (r *OpenRestyReconciler) fetchOpenResty(ctx context.Context, req ctrl.Request) (*webv1alpha1.OpenResty, error) {
 	var openresty webv1alpha1.OpenResty
 	if err := r.Get(ctx, req.NamespacedName, &openresty); err != nil {
@@ -167,11 +276,30 @@ func (r *OpenRestyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(),
+		&webv1alpha1.OpenResty{},
+		"spec.http.luaModuleRefs",
+		func(obj client.Object) []string {
+			app := obj.(*webv1alpha1.OpenResty)
+			var keys []string
+			if app.Spec.Http != nil {
+				for _, moduleRef := range app.Spec.Http.LuaModuleRefs {
+					keys = append(keys, fmt.Sprintf("%s/%s", app.Namespace, moduleRef))
+				}
+			}
+			return keys
+		},
+	); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&webv1alpha1.OpenResty{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
 		Owns(&corev1.ConfigMap{}).
+		Owns(&batchv1.Job{}).
 		Owns(&monitoringv1.ServiceMonitor{}).
 		WithEventFilter(predicate.Funcs{
 			DeleteFunc: func(e event.DeleteEvent) bool {
@@ -182,6 +310,15 @@ func (r *OpenRestyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 					for _, upstreamRef := range obj.Spec.Http.UpstreamRefs {
 						metrics.OpenRestyCRDRefStatus.DeleteLabelValues(obj.Namespace, obj.Name, webv1alpha1.Upstream{}.Kind, upstreamRef)
 					}
+					for _, policyRef := range obj.Spec.Http.RateLimitPolicyRefs {
+						metrics.OpenRestyCRDRefStatus.DeleteLabelValues(obj.Namespace, obj.Name, webv1alpha1.RateLimitPolicy{}.Kind, policyRef)
+					}
+					for _, policyRef := range obj.Spec.Http.TrafficShapingPolicyRefs {
+						metrics.OpenRestyCRDRefStatus.DeleteLabelValues(obj.Namespace, obj.Name, webv1alpha1.TrafficShapingPolicy{}.Kind, policyRef)
+					}
+					for _, quotaRef := range obj.Spec.Http.QuotaRefs {
+						metrics.OpenRestyCRDRefStatus.DeleteLabelValues(obj.Namespace, obj.Name, webv1alpha1.Quota{}.Kind, quotaRef)
+					}
 				}
 				return false
 			},
@@ -209,6 +346,33 @@ func (r *OpenRestyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 						metrics.OpenRestyCRDRefStatus.DeleteLabelValues(oldObj.Namespace, oldObj.Name, webv1alpha1.Upstream{}.Kind, upstreamRef)
 					}
 				}
+
+				oldSet = utils.SetFrom(oldObj.Spec.Http.RateLimitPolicyRefs)
+				newSet = utils.SetFrom(newObj.Spec.Http.RateLimitPolicyRefs)
+
+				for policyRef := range oldSet {
+					if _, stillPresent := newSet[policyRef]; !stillPresent {
+						metrics.OpenRestyCRDRefStatus.DeleteLabelValues(oldObj.Namespace, oldObj.Name, webv1alpha1.RateLimitPolicy{}.Kind, policyRef)
+					}
+				}
+
+				oldSet = utils.SetFrom(oldObj.Spec.Http.TrafficShapingPolicyRefs)
+				newSet = utils.SetFrom(newObj.Spec.Http.TrafficShapingPolicyRefs)
+
+				for policyRef := range oldSet {
+					if _, stillPresent := newSet[policyRef]; !stillPresent {
+						metrics.OpenRestyCRDRefStatus.DeleteLabelValues(oldObj.Namespace, oldObj.Name, webv1alpha1.TrafficShapingPolicy{}.Kind, policyRef)
+					}
+				}
+
+				oldSet = utils.SetFrom(oldObj.Spec.Http.QuotaRefs)
+				newSet = utils.SetFrom(newObj.Spec.Http.QuotaRefs)
+
+				for quotaRef := range oldSet {
+					if _, stillPresent := newSet[quotaRef]; !stillPresent {
+						metrics.OpenRestyCRDRefStatus.DeleteLabelValues(oldObj.Namespace, oldObj.Name, webv1alpha1.Quota{}.Kind, quotaRef)
+					}
+				}
 				return true
 			},
 		}).
@@ -0,0 +1,228 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/constants"
+	"openresty-operator/internal/handler"
+	"openresty-operator/internal/nginxconf"
+	"openresty-operator/internal/runtime/metrics"
+	"openresty-operator/internal/utils"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// quotaTopConsumers 是 status 与指标中保留的调用方数量
+	quotaTopConsumers = 10
+	quotaRedisTimeout = 2 * time.Second
+)
+
+// QuotaReconciler reconciles a Quota object
+type QuotaReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=quotas,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=quotas/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=quotas/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile validates the quota and publishes its counter dictionary and Lua module into the "quota-<name>"
+// ConfigMap, which OpenResty instances listing the quota in spec.http.quotaRefs mount. The usage persisted
+// in Redis is summarized into the status and exported as metrics on every run.
+func (r *QuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("quota", req.NamespacedName)
+
+	var quota webv1alpha1.Quota
+	if err := r.Get(ctx, req.NamespacedName, &quota); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Quota not found")
+			metrics.DeleteQuotaMetrics(req.Namespace, req.Name)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if valid, problems := handler.ValidateQuota(&quota); !valid {
+		msg := strings.Join(problems, " | ")
+		r.Recorder.Eventf(&quota, corev1.EventTypeWarning, "InvalidSpec", msg)
+		metrics.Recorder(quota.Kind, quota.Namespace, quota.Name, corev1.EventTypeWarning, msg)
+		r.updateQuotaStatus(ctx, &quota, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	conf := handler.GenerateQuotaConfig(&quota)
+
+	// lua_shared_dict 被 include 到 http 块中
+	findings := nginxconf.Lint(conf, nginxconf.ContextHTTP)
+	quota.Status.LintFindings = findings.Strings()
+	if findings.HasErrors() {
+		msg := fmt.Sprintf("Invalid nginx config: %s", strings.Join(findings.Errors().Strings(), " | "))
+		r.Recorder.Eventf(&quota, corev1.EventTypeWarning, "InvalidConfig", msg)
+		metrics.Recorder(quota.Kind, quota.Namespace, quota.Name, corev1.EventTypeWarning, msg)
+		r.updateQuotaStatus(ctx, &quota, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	var secret corev1.Secret
+	secretName := quota.Spec.Redis.SecretName
+	if err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: quota.Namespace}, &secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		msg := fmt.Sprintf("Redis secret %s not found", secretName)
+		r.Recorder.Eventf(&quota, corev1.EventTypeWarning, "MissingRedisSecret", msg)
+		metrics.Recorder(quota.Kind, quota.Namespace, quota.Name, corev1.EventTypeWarning, msg)
+		r.updateQuotaStatus(ctx, &quota, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+	if err := handler.ValidateRateLimitRedisSecret(&secret); err != nil {
+		msg := fmt.Sprintf("Invalid Redis secret: %v", err)
+		r.Recorder.Eventf(&quota, corev1.EventTypeWarning, "InvalidRedisSecret", msg)
+		metrics.Recorder(quota.Kind, quota.Namespace, quota.Name, corev1.EventTypeWarning, msg)
+		r.updateQuotaStatus(ctx, &quota, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	lua := handler.GenerateQuotaLua(&quota, secret.ResourceVersion)
+	if err := utils.ParseLua(lua); err != nil {
+		msg := fmt.Sprintf("Invalid generated Lua: %s", err.Error())
+		r.Recorder.Eventf(&quota, corev1.EventTypeWarning, "InvalidConfig", msg)
+		metrics.Recorder(quota.Kind, quota.Namespace, quota.Name, corev1.EventTypeWarning, msg)
+		r.updateQuotaStatus(ctx, &quota, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      handler.QuotaConfigMapName(quota.Name),
+			Namespace: quota.Namespace,
+			Labels:    constants.BuildCommonLabels(&quota, "configmap"),
+		},
+		Data: map[string]string{
+			quota.Name + ".conf": conf,
+			handler.QuotaLuaKey:  lua,
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(&quota, configMap, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var existing corev1.ConfigMap
+	err := r.Get(ctx, types.NamespacedName{Name: configMap.Name, Namespace: configMap.Namespace}, &existing)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Creating ConfigMap", "name", configMap.Name)
+			if err := r.Create(ctx, configMap); err != nil {
+				return ctrl.Result{}, err
+			}
+		} else {
+			return ctrl.Result{}, err
+		}
+	} else {
+		if !utils.DeepEqual(existing.Data, configMap.Data) {
+			existing.Data = configMap.Data
+			logger.Info("Updating ConfigMap", "name", configMap.Name)
+			if err := r.Update(ctx, &existing); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	r.syncQuotaUsage(&quota, &secret, logger)
+	r.updateQuotaStatus(ctx, &quota, true, "", logger)
+
+	logger.Info("Quota reconciled successfully")
+	return ctrl.Result{RequeueAfter: 60 * time.Second}, nil
+}
+
+// syncQuotaUsage 从 Redis 读取当前周期的用量写入 status 与指标，读取失败不影响配额生效
+func (r *QuotaReconciler) syncQuotaUsage(quota *webv1alpha1.Quota, secret *corev1.Secret, log logr.Logger) {
+	conn, err := handler.DialQuotaRedis(secret, quotaRedisTimeout)
+	if err != nil {
+		log.Info("Failed to connect to Redis, usage not updated", "error", err.Error())
+		quota.Status.UsageError = err.Error()
+		return
+	}
+	defer conn.Close()
+
+	usage, err := handler.CollectQuotaUsage(conn, quota, time.Now())
+	if err != nil {
+		log.Info("Failed to read usage from Redis", "error", err.Error())
+		quota.Status.UsageError = err.Error()
+		return
+	}
+
+	top := usage.Consumers
+	if len(top) > quotaTopConsumers {
+		top = top[:quotaTopConsumers]
+	}
+
+	now := metav1.Now()
+	quota.Status.Period = usage.Period
+	quota.Status.Consumers = len(usage.Consumers)
+	quota.Status.ExceededConsumers = usage.Exceeded
+	quota.Status.TopConsumers = top
+	quota.Status.UsageError = ""
+	quota.Status.LastSyncTime = &now
+
+	metrics.SetQuotaUsage(quota.Namespace, quota.Name, len(usage.Consumers), usage.Exceeded)
+	for _, c := range top {
+		metrics.SetQuotaConsumerUsage(quota.Namespace, quota.Name, c.Consumer, c.Used, c.Limit)
+	}
+}
+
+func (r *QuotaReconciler) updateQuotaStatus(ctx context.Context, quota *webv1alpha1.Quota, ready bool, reason string, log logr.Logger) {
+	quota.Status.Ready = ready
+	quota.Status.Version = fmt.Sprintf("%d", quota.Generation)
+	quota.Status.Reason = reason
+
+	if err := r.Status().Update(ctx, quota); err != nil {
+		if errors.IsConflict(err) {
+			log.Info("Quota status conflict, skipping update")
+		} else {
+			log.Error(err, "Failed to update Quota status")
+		}
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *QuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&webv1alpha1.Quota{}).
+		Owns(&corev1.ConfigMap{}).
+		Complete(r)
+}
@@ -19,12 +19,20 @@ package controller
 import (
 	"context"
 	"fmt"
+	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/constants"
+	"openresty-operator/internal/handler"
+	"openresty-operator/internal/nginxconf"
+	"openresty-operator/internal/runtime/metrics"
+	"openresty-operator/internal/utils"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -36,22 +44,18 @@ import (
 // RateLimitPolicyReconciler reconciles a RateLimitPolicy object
 type RateLimitPolicyReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=ratelimitpolicies,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=ratelimitpolicies/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=ratelimitpolicies/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the RateLimitPolicy object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.19.0/pkg/reconcile
+// Reconcile validates the policy and publishes its limit_req_zone into the "ratelimit-<name>" ConfigMap,
+// which OpenResty instances listing the policy in spec.http.rateLimitPolicyRefs include in their http block.
+// Global policies additionally publish the Lua module enforcing the limit through Redis.
 func (r *RateLimitPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx).WithValues("ratelimitpolicy", req.NamespacedName)
 
@@ -64,15 +68,71 @@ func (r *RateLimitPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		return ctrl.Result{}, err
 	}
 
-	conf := renderLimitReqZone(&policy)
+	if valid, problems := handler.ValidateRateLimitPolicy(&policy); !valid {
+		msg := strings.Join(problems, " | ")
+		r.Recorder.Eventf(&policy, corev1.EventTypeWarning, "InvalidSpec", msg)
+		metrics.Recorder(policy.Kind, policy.Namespace, policy.Name, corev1.EventTypeWarning, msg)
+		r.updateRateLimitPolicyStatus(ctx, &policy, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	conf := handler.GenerateLimitReqZoneConfig(&policy)
+
+	// limit_req_zone 被 include 到 http 块中
+	findings := nginxconf.Lint(conf, nginxconf.ContextHTTP)
+	policy.Status.LintFindings = findings.Strings()
+	if findings.HasErrors() {
+		msg := fmt.Sprintf("Invalid nginx config: %s", strings.Join(findings.Errors().Strings(), " | "))
+		r.Recorder.Eventf(&policy, corev1.EventTypeWarning, "InvalidConfig", msg)
+		metrics.Recorder(policy.Kind, policy.Namespace, policy.Name, corev1.EventTypeWarning, msg)
+		r.updateRateLimitPolicyStatus(ctx, &policy, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	data := map[string]string{
+		policy.Name + ".conf": conf,
+	}
+
+	// 全局限流：Redis 连接信息来自 Secret，Lua 模块随 ConfigMap 挂载到 lualib
+	if handler.IsGlobalRateLimit(policy.Spec) {
+		var secret corev1.Secret
+		secretName := policy.Spec.Global.Redis.SecretName
+		if err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: policy.Namespace}, &secret); err != nil {
+			if !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			msg := fmt.Sprintf("Redis secret %s not found", secretName)
+			r.Recorder.Eventf(&policy, corev1.EventTypeWarning, "MissingRedisSecret", msg)
+			metrics.Recorder(policy.Kind, policy.Namespace, policy.Name, corev1.EventTypeWarning, msg)
+			r.updateRateLimitPolicyStatus(ctx, &policy, false, msg, logger)
+			return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+		}
+		if err := handler.ValidateRateLimitRedisSecret(&secret); err != nil {
+			msg := fmt.Sprintf("Invalid Redis secret: %v", err)
+			r.Recorder.Eventf(&policy, corev1.EventTypeWarning, "InvalidRedisSecret", msg)
+			metrics.Recorder(policy.Kind, policy.Namespace, policy.Name, corev1.EventTypeWarning, msg)
+			r.updateRateLimitPolicyStatus(ctx, &policy, false, msg, logger)
+			return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+		}
+
+		lua := handler.GenerateGlobalRateLimitLua(&policy, secret.ResourceVersion)
+		if err := utils.ParseLua(lua); err != nil {
+			msg := fmt.Sprintf("Invalid generated Lua: %s", err.Error())
+			r.Recorder.Eventf(&policy, corev1.EventTypeWarning, "InvalidConfig", msg)
+			metrics.Recorder(policy.Kind, policy.Namespace, policy.Name, corev1.EventTypeWarning, msg)
+			r.updateRateLimitPolicyStatus(ctx, &policy, false, msg, logger)
+			return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+		}
+		data[handler.RateLimitPolicyLuaKey] = lua
+	}
+
 	configMap := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "ratelimit-" + policy.Name,
+			Name:      handler.RateLimitPolicyConfigMapName(policy.Name),
 			Namespace: policy.Namespace,
+			Labels:    constants.BuildCommonLabels(&policy, "configmap"),
 		},
-		Data: map[string]string{
-			policy.Name + ".conf": conf,
-		},
+		Data: data,
 	}
 
 	if err := controllerutil.SetControllerReference(&policy, configMap, r.Scheme); err != nil {
@@ -91,7 +151,7 @@ func (r *RateLimitPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 			return ctrl.Result{}, err
 		}
 	} else {
-		if existing.Data[policy.Name+".conf"] != conf {
+		if !utils.DeepEqual(existing.Data, configMap.Data) {
 			existing.Data = configMap.Data
 			logger.Info("Updating ConfigMap", "name", configMap.Name)
 			if err := r.Update(ctx, &existing); err != nil {
@@ -100,32 +160,24 @@ func (r *RateLimitPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		}
 	}
 
-	policy.Status.Ready = true
-	policy.Status.Version = fmt.Sprintf("%d", policy.Generation)
-	policy.Status.Reason = ""
-
-	if err := r.Status().Update(ctx, &policy); err != nil {
-		if errors.IsConflict(err) {
-			logger.Info("Location status conflict, skipping update")
-		} else {
-			logger.Error(err, "Failed to update Location status")
-		}
-	}
+	r.updateRateLimitPolicyStatus(ctx, &policy, true, "", logger)
 
 	logger.Info("RateLimitPolicy reconciled successfully")
 	return ctrl.Result{RequeueAfter: 60 * time.Second}, nil
 }
 
-func renderLimitReqZone(p *webv1alpha1.RateLimitPolicy) string {
-	key := p.Spec.Key
-	if key == "" {
-		key = "$binary_remote_addr"
-	}
-	zoneSize := p.Spec.ZoneSize
-	if zoneSize == "" {
-		zoneSize = "10m"
+func (r *RateLimitPolicyReconciler) updateRateLimitPolicyStatus(ctx context.Context, policy *webv1alpha1.RateLimitPolicy, ready bool, reason string, log logr.Logger) {
+	policy.Status.Ready = ready
+	policy.Status.Version = fmt.Sprintf("%d", policy.Generation)
+	policy.Status.Reason = reason
+
+	if err := r.Status().Update(ctx, policy); err != nil {
+		if errors.IsConflict(err) {
+			log.Info("RateLimitPolicy status conflict, skipping update")
+		} else {
+			log.Error(err, "Failed to update RateLimitPolicy status")
+		}
 	}
-	return fmt.Sprintf("limit_req_zone %s zone=%s:%s rate=%s;", key, p.Spec.ZoneName, zoneSize, p.Spec.Rate)
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -27,6 +27,7 @@ import (
 	"k8s.io/client-go/tools/record"
 	"openresty-operator/internal/constants"
 	"openresty-operator/internal/handler"
+	"openresty-operator/internal/nginxconf"
 	metrics2 "openresty-operator/internal/runtime/metrics"
 	"openresty-operator/internal/utils"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -70,6 +71,14 @@ func (r *ServerBlockReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if valid, problems := handler.ValidateServerBlockSpec(server); !valid {
+		msg := strings.Join(problems, " | ")
+		r.Recorder.Eventf(server, corev1.EventTypeWarning, "InvalidSpec", msg)
+		metrics2.Recorder(server.Kind, server.Namespace, server.Name, corev1.EventTypeWarning, msg)
+		_ = r.updateServerStatus(ctx, server, false, msg, currentServerBlockRefs(server), log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
 	allLocations := make(map[string]*webv1alpha1.Location)
 
 	for _, ref := range server.Spec.LocationRefs {
@@ -92,26 +101,68 @@ func (r *ServerBlockReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		msg := strings.Join(problems, " | ")
 		r.Recorder.Eventf(server, corev1.EventTypeWarning, "InvalidRefs", msg)
 		metrics2.Recorder(server.Kind, server.Namespace, server.Name, corev1.EventTypeWarning, msg)
-		_ = r.updateServerStatus(ctx, server, false, msg, log)
+		_ = r.updateServerStatus(ctx, server, false, msg, currentServerBlockRefs(server), log)
 		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
 	}
 
+	refs := serverBlockRefs{
+		Upstreams:              handler.CollectLocationUpstreamRefs(allLocations, server.Spec.LocationRefs),
+		RateLimitPolicies:      handler.CollectLocationRateLimitPolicyRefs(allLocations, server.Spec.LocationRefs),
+		TrafficShapingPolicies: handler.CollectLocationTrafficShapingPolicyRefs(allLocations, server.Spec.LocationRefs),
+		Quotas:                 handler.CollectLocationQuotaRefs(allLocations, server.Spec.LocationRefs),
+	}
+
 	conf := handler.GenerateServerBlockConfig(server)
 
+	findings := nginxconf.Lint(conf, nginxconf.ContextHTTP)
+	server.Status.LintFindings = findings.Strings()
+	if findings.HasErrors() {
+		msg := fmt.Sprintf("Invalid nginx config: %s", strings.Join(findings.Errors().Strings(), " | "))
+		r.Recorder.Eventf(server, corev1.EventTypeWarning, "InvalidConfig", msg)
+		metrics2.Recorder(server.Kind, server.Namespace, server.Name, corev1.EventTypeWarning, msg)
+		_ = r.updateServerStatus(ctx, server, false, msg, currentServerBlockRefs(server), log)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
 	if err := r.createOrUpdateConfigMap(ctx, server, conf, log); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	_ = r.updateServerStatus(ctx, server, true, "", log)
+	_ = r.updateServerStatus(ctx, server, true, "", refs, log)
 	return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
 }
 
-func (r *ServerBlockReconciler) updateServerStatus(ctx context.Context, srv *webv1alpha1.ServerBlock, ready bool, reason string, log logr.Logger) error {
+// serverBlockRefs 汇总 ServerBlock 下所有 Location 引用的资源，变化时需要触发 OpenResty 重新校验
+type serverBlockRefs struct {
+	Upstreams              []string
+	RateLimitPolicies      []string
+	TrafficShapingPolicies []string
+	Quotas                 []string
+}
+
+func currentServerBlockRefs(srv *webv1alpha1.ServerBlock) serverBlockRefs {
+	return serverBlockRefs{
+		Upstreams:              srv.Status.UpstreamRefs,
+		RateLimitPolicies:      srv.Status.RateLimitPolicyRefs,
+		TrafficShapingPolicies: srv.Status.TrafficShapingPolicyRefs,
+		Quotas:                 srv.Status.QuotaRefs,
+	}
+}
+
+func (r *ServerBlockReconciler) updateServerStatus(ctx context.Context, srv *webv1alpha1.ServerBlock, ready bool, reason string, refs serverBlockRefs, log logr.Logger) error {
 	srv.Status.Ready = ready
 	srv.Status.Version = fmt.Sprintf("%d", srv.Generation)
 	srv.Status.Reason = reason
-	isTriggerOpenResty := !utils.EqualSlices(srv.Spec.LocationRefs, srv.Status.LocationRef)
+	isTriggerOpenResty := !utils.EqualSlices(srv.Spec.LocationRefs, srv.Status.LocationRef) ||
+		!utils.EqualSlices(refs.Upstreams, srv.Status.UpstreamRefs) ||
+		!utils.EqualSlices(refs.RateLimitPolicies, srv.Status.RateLimitPolicyRefs) ||
+		!utils.EqualSlices(refs.TrafficShapingPolicies, srv.Status.TrafficShapingPolicyRefs) ||
+		!utils.EqualSlices(refs.Quotas, srv.Status.QuotaRefs)
 	srv.Status.LocationRef = srv.Spec.LocationRefs
+	srv.Status.UpstreamRefs = refs.Upstreams
+	srv.Status.RateLimitPolicyRefs = refs.RateLimitPolicies
+	srv.Status.TrafficShapingPolicyRefs = refs.TrafficShapingPolicies
+	srv.Status.QuotaRefs = refs.Quotas
 
 	if err := r.Status().Update(ctx, srv); err != nil {
 		if errors.IsConflict(err) {
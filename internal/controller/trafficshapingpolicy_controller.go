@@ -0,0 +1,151 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/constants"
+	"openresty-operator/internal/handler"
+	"openresty-operator/internal/nginxconf"
+	"openresty-operator/internal/runtime/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// TrafficShapingPolicyReconciler reconciles a TrafficShapingPolicy object
+type TrafficShapingPolicyReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=trafficshapingpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=trafficshapingpolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=trafficshapingpolicies/finalizers,verbs=update
+
+// Reconcile validates the policy and publishes its limit_conn_zone into the "trafficshaping-<name>" ConfigMap,
+// which OpenResty instances listing the policy in spec.http.trafficShapingPolicyRefs include in their http block.
+func (r *TrafficShapingPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("trafficshapingpolicy", req.NamespacedName)
+
+	var policy webv1alpha1.TrafficShapingPolicy
+	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("TrafficShapingPolicy not found")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if valid, problems := handler.ValidateTrafficShapingPolicy(&policy); !valid {
+		msg := strings.Join(problems, " | ")
+		r.Recorder.Eventf(&policy, corev1.EventTypeWarning, "InvalidSpec", msg)
+		metrics.Recorder(policy.Kind, policy.Namespace, policy.Name, corev1.EventTypeWarning, msg)
+		r.updateTrafficShapingPolicyStatus(ctx, &policy, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	conf := handler.GenerateLimitConnZoneConfig(&policy)
+
+	// limit_conn_zone 被 include 到 http 块中
+	findings := nginxconf.Lint(conf, nginxconf.ContextHTTP)
+	policy.Status.LintFindings = findings.Strings()
+	if findings.HasErrors() {
+		msg := fmt.Sprintf("Invalid nginx config: %s", strings.Join(findings.Errors().Strings(), " | "))
+		r.Recorder.Eventf(&policy, corev1.EventTypeWarning, "InvalidConfig", msg)
+		metrics.Recorder(policy.Kind, policy.Namespace, policy.Name, corev1.EventTypeWarning, msg)
+		r.updateTrafficShapingPolicyStatus(ctx, &policy, false, msg, logger)
+		return ctrl.Result{RequeueAfter: DefaultRequeue}, nil
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      handler.TrafficShapingPolicyConfigMapName(policy.Name),
+			Namespace: policy.Namespace,
+			Labels:    constants.BuildCommonLabels(&policy, "configmap"),
+		},
+		Data: map[string]string{
+			policy.Name + ".conf": conf,
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(&policy, configMap, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var existing corev1.ConfigMap
+	err := r.Get(ctx, types.NamespacedName{Name: configMap.Name, Namespace: configMap.Namespace}, &existing)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Creating ConfigMap", "name", configMap.Name)
+			if err := r.Create(ctx, configMap); err != nil {
+				return ctrl.Result{}, err
+			}
+		} else {
+			return ctrl.Result{}, err
+		}
+	} else {
+		if existing.Data[policy.Name+".conf"] != conf {
+			existing.Data = configMap.Data
+			logger.Info("Updating ConfigMap", "name", configMap.Name)
+			if err := r.Update(ctx, &existing); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	r.updateTrafficShapingPolicyStatus(ctx, &policy, true, "", logger)
+
+	logger.Info("TrafficShapingPolicy reconciled successfully")
+	return ctrl.Result{RequeueAfter: 60 * time.Second}, nil
+}
+
+func (r *TrafficShapingPolicyReconciler) updateTrafficShapingPolicyStatus(ctx context.Context, policy *webv1alpha1.TrafficShapingPolicy, ready bool, reason string, log logr.Logger) {
+	policy.Status.Ready = ready
+	policy.Status.Version = fmt.Sprintf("%d", policy.Generation)
+	policy.Status.Reason = reason
+
+	if err := r.Status().Update(ctx, policy); err != nil {
+		if errors.IsConflict(err) {
+			log.Info("TrafficShapingPolicy status conflict, skipping update")
+		} else {
+			log.Error(err, "Failed to update TrafficShapingPolicy status")
+		}
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TrafficShapingPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&webv1alpha1.TrafficShapingPolicy{}).
+		Owns(&corev1.ConfigMap{}).
+		Complete(r)
+}
@@ -21,19 +21,26 @@ import (
 	"fmt"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
+	"net/http"
 	webv1alpha1 "openresty-operator/api/v1alpha1"
 	"openresty-operator/internal/constants"
 	"openresty-operator/internal/handler"
+	"openresty-operator/internal/nginxconf"
 	"openresty-operator/internal/runtime/health"
 	"openresty-operator/internal/runtime/metrics"
 	"openresty-operator/internal/utils"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	crhandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -57,6 +64,12 @@ var DnsCache = struct {
 	Data: make(map[string][]string),
 }
 
+// podViewClient reads the pod-local state of the OpenResty pods, see collectPodViews
+var podViewClient = &http.Client{Timeout: 2 * time.Second}
+
+// upstreamServiceRefIndex indexes Upstreams by the "<namespace>/<name>" of the Service selected by serviceRef
+const upstreamServiceRefIndex = "spec.serviceRef"
+
 const (
 	// 文件扩展名
 	UpstreamRenderTypeConf = ".conf"
@@ -71,6 +84,11 @@ var UpstreamRenderTypeMap = map[webv1alpha1.UpstreamType]string{
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=upstreams,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=upstreams/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=upstreams/finalizers,verbs=update
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=openresty.huangzehong.me,resources=openresties,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -89,7 +107,22 @@ func (r *UpstreamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	var statusList []webv1alpha1.UpstreamServerStatus
+	if valid, problems := handler.ValidateUpstream(upstream); !valid {
+		msg := strings.Join(problems, " | ")
+		r.Recorder.Eventf(upstream, corev1.EventTypeWarning, "InvalidSpec", msg)
+		metrics.Recorder(upstream.Kind, upstream.Namespace, upstream.Name, corev1.EventTypeWarning, msg)
+		r.updateStatus(ctx, upstream, false, upstream.Status.NginxConfig, upstream.Status.Servers, msg, log)
+		return reconcile.Result{RequeueAfter: 15 * time.Second}, nil
+	}
+
+	if err := r.reconcileStickinessSecret(ctx, upstream); err != nil {
+		msg := fmt.Sprintf("Stickiness secret error: %v", err)
+		r.Recorder.Eventf(upstream, corev1.EventTypeWarning, "StickinessSecretError", msg)
+		metrics.Recorder(upstream.Kind, upstream.Namespace, upstream.Name, corev1.EventTypeWarning, msg)
+		r.updateStatus(ctx, upstream, false, upstream.Status.NginxConfig, upstream.Status.Servers, msg, log)
+		return reconcile.Result{RequeueAfter: 15 * time.Second}, nil
+	}
+
 	results := handler.ProbeUpstreamServers(ctx, upstream)
 	for addr, check := range results {
 		if check == nil {
@@ -111,19 +144,56 @@ func (r *UpstreamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 				metrics.SetUpstreamDNSResolvable(upstream.Namespace, upstream.Name, check.Address, ip, check.Alive)
 			}
 			metrics.SetUpstreamDNSResolvable(upstream.Namespace, upstream.Name, check.Address, "ALL", check.Alive)
-			statusList = append(statusList, webv1alpha1.UpstreamServerStatus{
-				Address: check.Address,
-				Alive:   check.Alive,
-			})
 		}
 	}
 
-	nginxConfig := handler.GenerateUpstreamConfig(upstream, utils.MapValuesNonNil(results))
+	checked := utils.MapValuesNonNil(results)
+	// 按地址排序，避免 map 遍历顺序导致生成的配置变化
+	sort.Slice(checked, func(i, j int) bool {
+		return checked[i].Address < checked[j].Address
+	})
+
+	// serviceRef 的 endpoint 由 EndpointSlice 给出就绪状态，不经过 health checker
+	rendered := upstream
+	if upstream.Spec.ServiceRef != nil {
+		endpoints, err := handler.ResolveServiceEndpoints(ctx, r.Get, r.List, upstream)
+		if err != nil {
+			msg := err.Error()
+			r.Recorder.Eventf(upstream, corev1.EventTypeWarning, "ServiceRefError", msg)
+			metrics.Recorder(upstream.Kind, upstream.Namespace, upstream.Name, corev1.EventTypeWarning, msg)
+			r.updateStatus(ctx, upstream, false, upstream.Status.NginxConfig, upstream.Status.Servers, msg, log)
+			return reconcile.Result{RequeueAfter: 15 * time.Second}, nil
+		}
+		servers, endpointResults := handler.ServiceEndpointServers(endpoints)
+		rendered = upstream.DeepCopy()
+		rendered.Spec.Servers = append(rendered.Spec.Servers, servers...)
+		checked = append(checked, endpointResults...)
+	}
+
+	statusList := handler.BuildUpstreamServerStatuses(rendered, checked)
+	ejected, unhealthy := r.collectPodViews(ctx, upstream, log)
+	handler.ApplyEjectedPods(statusList, ejected)
+	handler.ApplyUnhealthyPods(statusList, unhealthy)
+	nginxConfig := handler.GenerateUpstreamConfig(rendered, checked)
+
+	// FullURL 类型渲染的是 Lua 模块，只有 Address 类型是 nginx upstream 块
+	upstream.Status.LintFindings = nil
+	if upstream.Spec.Type == webv1alpha1.UpstreamTypeAddress && len(nginxConfig) > 0 {
+		findings := nginxconf.Lint(nginxConfig, nginxconf.ContextHTTP)
+		upstream.Status.LintFindings = findings.Strings()
+		if findings.HasErrors() {
+			msg := fmt.Sprintf("Invalid nginx config: %s", strings.Join(findings.Errors().Strings(), " | "))
+			r.Recorder.Eventf(upstream, corev1.EventTypeWarning, "InvalidConfig", msg)
+			metrics.Recorder(upstream.Kind, upstream.Namespace, upstream.Name, corev1.EventTypeWarning, msg)
+			r.updateStatus(ctx, upstream, false, upstream.Status.NginxConfig, statusList, msg, log)
+			return reconcile.Result{RequeueAfter: 15 * time.Second}, nil
+		}
+	}
 
 	// 写入 ConfigMap
 	allDown := false
 	if len(nginxConfig) > 0 {
-		if err := r.createOrUpdateConfigMap(ctx, upstream, nginxConfig, log); err != nil {
+		if err := r.createOrUpdateConfigMap(ctx, upstream, nginxConfig, handler.GenerateUpstreamPeers(rendered, checked), handler.GenerateInPodHealthTargets(rendered), log); err != nil {
 			log.Error(err, "Failed to update ConfigMap")
 			return ctrl.Result{}, err
 		}
@@ -142,9 +212,69 @@ func (r *UpstreamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	return reconcile.Result{RequeueAfter: 15 * time.Second}, nil
 }
 
-func (r *UpstreamReconciler) createOrUpdateConfigMap(ctx context.Context, upstream *webv1alpha1.Upstream, config string, log logr.Logger) error {
+// reconcileStickinessSecret keeps the Secret with the key signing the affinity cookies, mounted into the OpenResty
+// pods like the secret headers of the Locations. It is deleted when the Upstream issues no cookie.
+func (r *UpstreamReconciler) reconcileStickinessSecret(ctx context.Context, upstream *webv1alpha1.Upstream) error {
+	var managed corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Name: handler.StickinessSecretName(upstream), Namespace: upstream.Namespace}, &managed)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if !handler.UsesAffinityCookie(upstream) {
+		if exists && metav1.IsControlledBy(&managed, upstream) {
+			return client.IgnoreNotFound(r.Delete(ctx, &managed))
+		}
+		return nil
+	}
+
+	var source *corev1.Secret
+	if secretName := upstream.Spec.Stickiness.SecretName; secretName != "" {
+		source = &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: upstream.Namespace}, source); err != nil {
+			return fmt.Errorf("failed to get secret %s/%s: %w", upstream.Namespace, secretName, err)
+		}
+	}
+	var current *corev1.Secret
+	if exists {
+		current = &managed
+	}
+	key, err := handler.StickinessSigningKey(upstream, source, current)
+	if err != nil {
+		return err
+	}
+	desired, err := handler.GenerateStickinessSecret(upstream, key)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err := ctrl.SetControllerReference(upstream, desired, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, desired)
+	}
+	if utils.DeepEqualMapStringByteSlice(managed.Data, desired.Data) && utils.DeepEqual(managed.Annotations, desired.Annotations) {
+		return nil
+	}
+	managed.Data = desired.Data
+	managed.Labels = desired.Labels
+	managed.Annotations = desired.Annotations
+	return r.Update(ctx, &managed)
+}
+
+// createOrUpdateConfigMap writes the rendered config, the peers of a Dynamic upstream and the in-pod health targets
+func (r *UpstreamReconciler) createOrUpdateConfigMap(ctx context.Context, upstream *webv1alpha1.Upstream, config, peers, healthTargets string, log logr.Logger) error {
 	name := "upstream-" + upstream.Name
 	dataName := upstream.Name + UpstreamRenderTypeMap[upstream.Spec.Type]
+	data := map[string]string{
+		dataName: config,
+		upstream.Name + handler.InPodHealthTargetsSuffix: healthTargets,
+	}
+	if peers != "" {
+		data[upstream.Name+handler.UpstreamPeersSuffix] = peers
+	}
 	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -154,9 +284,7 @@ func (r *UpstreamReconciler) createOrUpdateConfigMap(ctx context.Context, upstre
 				constants.AnnotationGeneratedFromGeneration: fmt.Sprintf("%d", upstream.GetGeneration()),
 			},
 		},
-		Data: map[string]string{
-			dataName: config,
-		},
+		Data: data,
 	}
 
 	if err := ctrl.SetControllerReference(upstream, cm, r.Scheme); err != nil {
@@ -173,19 +301,16 @@ func (r *UpstreamReconciler) createOrUpdateConfigMap(ctx context.Context, upstre
 		return err
 	}
 
-	needsUpdate := false
-	if existing.Data[dataName] != config {
-		needsUpdate = true
-	}
-	if _, ok := existing.Data[dataName]; !ok {
-		needsUpdate = true
+	needsUpdate := len(existing.Data) != len(data)
+	for key, value := range data {
+		if current, ok := existing.Data[key]; !ok || current != value {
+			needsUpdate = true
+		}
 	}
 
 	if needsUpdate {
 		log.Info("Updating ConfigMap", "name", name)
-		existing.Data = map[string]string{
-			dataName: config,
-		}
+		existing.Data = data
 		existing.Annotations = map[string]string{
 			constants.AnnotationGeneratedFromGeneration: fmt.Sprintf("%d", upstream.GetGeneration()),
 		}
@@ -218,6 +343,58 @@ func (r *UpstreamReconciler) updateStatus(
 	}
 }
 
+// collectPodViews asks the pods of the OpenResty instances using an Upstream which of its servers they eject
+// (passiveHealthCheck) and which they see down (inPodHealthCheck), returning the number of pods for each server.
+// Pods that cannot be reached are skipped, their views expire on their own.
+func (r *UpstreamReconciler) collectPodViews(ctx context.Context, upstream *webv1alpha1.Upstream, log logr.Logger) (map[string]int, map[string]int) {
+	var apps webv1alpha1.OpenRestyList
+	if err := r.List(ctx, &apps, client.InNamespace(upstream.Namespace)); err != nil {
+		log.Error(err, "Failed to list OpenResty")
+		return nil, nil
+	}
+
+	var outliers []handler.PodOutliers
+	var healths []handler.PodHealth
+	for i := range apps.Items {
+		app := &apps.Items[i]
+		if app.Spec.Http == nil || !slices.Contains(app.Spec.Http.UpstreamRefs, upstream.Name) {
+			continue
+		}
+		inPod := app.Spec.InPodHealthCheck != nil && app.Spec.InPodHealthCheck.Enable
+		if upstream.Spec.PassiveHealthCheck == nil && !inPod {
+			continue
+		}
+
+		var pods corev1.PodList
+		if err := r.List(ctx, &pods, client.InNamespace(app.Namespace), client.MatchingLabels(constants.BuildSelectorLabels(app))); err != nil {
+			log.Error(err, "Failed to list OpenResty pods", "openresty", app.Name)
+			continue
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+				continue
+			}
+			if upstream.Spec.PassiveHealthCheck != nil {
+				podOutliers, err := handler.FetchPodOutliers(ctx, podViewClient, handler.PodOutliersURL(pod.Status.PodIP))
+				if err != nil {
+					log.V(1).Info("Failed to read ejected servers", "pod", pod.Name, "error", err.Error())
+				} else {
+					outliers = append(outliers, podOutliers)
+				}
+			}
+			if inPod {
+				podHealth, err := handler.FetchPodHealth(ctx, podViewClient, handler.PodHealthURL(pod.Status.PodIP))
+				if err != nil {
+					log.V(1).Info("Failed to read in-pod health checks", "pod", pod.Name, "error", err.Error())
+				} else {
+					healths = append(healths, podHealth)
+				}
+			}
+		}
+	}
+	return handler.CountEjectedPods(upstream, outliers), handler.CountUnhealthyPods(upstream, healths)
+}
+
 func (r *UpstreamReconciler) fetchUpstream(ctx context.Context, req ctrl.Request) (*webv1alpha1.Upstream, error) {
 	var upstream webv1alpha1.Upstream
 	if err := r.Get(ctx, req.NamespacedName, &upstream); err != nil {
@@ -226,19 +403,65 @@ func (r *UpstreamReconciler) fetchUpstream(ctx context.Context, req ctrl.Request
 	return &upstream, nil
 }
 
+// upstreamsForService maps a Service, or one of its EndpointSlices, to the Upstreams selecting it with serviceRef
+func (r *UpstreamReconciler) upstreamsForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetName()
+	if slice, ok := obj.(*discoveryv1.EndpointSlice); ok {
+		name = slice.Labels[discoveryv1.LabelServiceName]
+		if name == "" {
+			return nil
+		}
+	}
+
+	var upstreams webv1alpha1.UpstreamList
+	if err := r.List(ctx, &upstreams, client.MatchingFields{upstreamServiceRefIndex: obj.GetNamespace() + "/" + name}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list Upstreams by serviceRef")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(upstreams.Items))
+	for _, upstream := range upstreams.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: upstream.Namespace, Name: upstream.Name},
+		})
+	}
+	return requests
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *UpstreamReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(),
+		&webv1alpha1.Upstream{},
+		upstreamServiceRefIndex,
+		func(obj client.Object) []string {
+			if key := handler.ServiceRefKey(obj.(*webv1alpha1.Upstream)); key != "" {
+				return []string{key}
+			}
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		// Uncomment the following line adding a pointer to an instance of the controlled resource as an argument
 		For(&webv1alpha1.Upstream{}).
 		Owns(&corev1.ConfigMap{}).
+		Watches(&discoveryv1.EndpointSlice{}, crhandler.EnqueueRequestsFromMapFunc(r.upstreamsForService)).
+		Watches(&corev1.Service{}, crhandler.EnqueueRequestsFromMapFunc(r.upstreamsForService)).
 		WithEventFilter(predicate.Funcs{
 			DeleteFunc: func(e event.DeleteEvent) bool {
+				switch e.Object.(type) {
+				case *discoveryv1.EndpointSlice, *corev1.Service:
+					// Service 或其 EndpointSlice 被删除时重新渲染引用它的 Upstream
+					return true
+				}
 				if obj, ok := e.Object.(*webv1alpha1.Upstream); ok {
+					health.Checker.Release(handler.UpstreamHealthOwner(obj), handler.UpstreamHealthTargets(obj))
 					addresses := utils.MapList(obj.Spec.Servers, func(server webv1alpha1.UpstreamServer) string {
 						return server.Address
 					})
-					health.Checker.Release(addresses)
 					for _, server := range addresses {
 						host, _, _ := utils.SplitHostPort(server)
 						metrics.UpstreamDNSResolvable.DeleteLabelValues(obj.Namespace, obj.Name, host)
@@ -262,10 +485,19 @@ func (r *UpstreamReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 				for server := range oldSet {
 					if _, stillPresent := newSet[server]; !stillPresent {
-						health.Checker.Release([]string{server})
 						metrics.UpstreamDNSResolvable.DeleteLabelValues(oldObj.Namespace, oldObj.Name, server)
 					}
 				}
+
+				// 地址被移除或 healthCheck 变化后，旧的检查不再需要
+				newTargets := utils.SetFrom(utils.MapList(handler.UpstreamHealthTargets(newObj), health.Target.Key))
+				var released []health.Target
+				for _, target := range handler.UpstreamHealthTargets(oldObj) {
+					if _, stillPresent := newTargets[target.Key()]; !stillPresent {
+						released = append(released, target)
+					}
+				}
+				health.Checker.Release(handler.UpstreamHealthOwner(oldObj), released)
 				return true
 			},
 		}).
@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/constants"
+	"openresty-operator/internal/utils"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+)
+
+const defaultConfigValidationTimeout = int64(60)
+
+// ConfigValidationResult is the outcome of a `nginx -t` Job, Done is false while the Job is still running
+type ConfigValidationResult struct {
+	Done   bool
+	Passed bool
+	Output string
+}
+
+func ConfigValidationEnabled(app *webv1alpha1.OpenResty) bool {
+	return app.Spec.ConfigValidation == nil || app.Spec.ConfigValidation.Enable
+}
+
+// ConfigSetHash hashes everything `nginx -t` would read: the image, the candidate nginx.conf and
+// the data of every ConfigMap mounted into the pod. The main ConfigMap is replaced by nginxConf.
+func ConfigSetHash(get GetFunc, app *webv1alpha1.OpenResty, nginxConf string, volumes []corev1.Volume) (string, error) {
+	ctx := context.Background()
+	h := sha256.New()
+
+	fmt.Fprintf(h, "image=%s\n", openRestyImage(app))
+	fmt.Fprintf(h, "nginx.conf=%s\n", nginxConf)
+
+	for _, v := range volumes {
+		if v.ConfigMap == nil || v.Name == "main-config" {
+			continue
+		}
+
+		var cm corev1.ConfigMap
+		if err := get(ctx, types.NamespacedName{Name: v.ConfigMap.Name, Namespace: app.Namespace}, &cm); err != nil {
+			if errors.IsNotFound(err) {
+				fmt.Fprintf(h, "configmap=%s missing\n", v.ConfigMap.Name)
+				continue
+			}
+			return "", err
+		}
+
+		keys := make([]string, 0, len(cm.Data))
+		for k := range cm.Data {
+			// peers 与健康检查 targets 只在运行时读取，变化不需要重新执行 nginx -t
+			if IsRuntimeUpstreamFile(k) {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(h, "configmap=%s\n", cm.Name)
+		for _, k := range keys {
+			fmt.Fprintf(h, "%s=%s\n", k, cm.Data[k])
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// ValidateConfigWithJob runs the candidate nginx.conf together with the currently mounted config tree
+// through `nginx -t` in the OpenResty image. The Job is named after the hash, so its result is reused
+// until the config set changes again.
+func ValidateConfigWithJob(
+	ctx context.Context,
+	c client.Client,
+	scheme *runtime.Scheme,
+	app *webv1alpha1.OpenResty,
+	nginxConf string,
+	hash string,
+	vm *VolumeMountResult,
+	log logr.Logger,
+) (*ConfigValidationResult, error) {
+	if err := CleanupConfigValidation(ctx, c, app, hash); err != nil {
+		return nil, err
+	}
+
+	name := configValidationName(app, hash)
+	labels := configValidationLabels(app, hash)
+
+	if err := CreateOrUpdateConfigMap(ctx, c, scheme, app, name, app.Namespace, labels,
+		map[string]string{"nginx.conf": nginxConf}, log, nil, nil); err != nil {
+		return nil, err
+	}
+
+	var job batchv1.Job
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, &job)
+	if errors.IsNotFound(err) {
+		newJob := BuildConfigValidationJob(app, name, labels, vm)
+		if err := ctrl.SetControllerReference(app, newJob, scheme); err != nil {
+			return nil, err
+		}
+		log.Info("Creating config validation Job", "name", name, "hash", hash)
+		if err := c.Create(ctx, newJob); err != nil && !errors.IsAlreadyExists(err) {
+			return nil, err
+		}
+		return &ConfigValidationResult{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return &ConfigValidationResult{Done: true, Passed: true}, nil
+		case batchv1.JobFailed:
+			output, err := readConfigValidationOutput(ctx, c, &job)
+			if err != nil {
+				return nil, err
+			}
+			if output == "" {
+				output = cond.Message
+			}
+			return &ConfigValidationResult{Done: true, Output: output}, nil
+		}
+	}
+
+	return &ConfigValidationResult{}, nil
+}
+
+func BuildConfigValidationJob(app *webv1alpha1.OpenResty, name string, labels map[string]string, vm *VolumeMountResult) *batchv1.Job {
+	volumes := make([]corev1.Volume, 0, len(vm.Volumes))
+	for _, v := range vm.Volumes {
+		switch v.Name {
+		case "main-config":
+			v.VolumeSource = corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				},
+			}
+		case "nginx-logs":
+			// 日志 PVC 可能是 ReadWriteOnce，nginx -t 只需要一个可写目录
+			v.VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+		default:
+			// 静态内容 PVC 同样可能是 ReadWriteOnce，被其他节点的 Pod 占用时 Job 无法调度；
+			// nginx -t 不读取静态文件，挂载路径存在即可
+			if v.PersistentVolumeClaim != nil {
+				v.VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+			}
+		}
+		volumes = append(volumes, v)
+	}
+
+	timeout := defaultConfigValidationTimeout
+	if app.Spec.ConfigValidation != nil && app.Spec.ConfigValidation.TimeoutSeconds > 0 {
+		timeout = app.Spec.ConfigValidation.TimeoutSeconds
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: app.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          ptr.To(int32(0)),
+			ActiveDeadlineSeconds: ptr.To(timeout),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					NodeSelector:  app.Spec.NodeSelector,
+					Tolerations:   app.Spec.Tolerations,
+					Volumes:       volumes,
+					Containers: []corev1.Container{
+						{
+							Name:                     "nginx-test",
+							Image:                    openRestyImage(app),
+							Command:                  []string{"nginx", "-t", "-c", utils.NginxConfPath},
+							VolumeMounts:             vm.Mounts,
+							TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// CleanupConfigValidation deletes validation Jobs and ConfigMaps of this OpenResty except the ones for keepHash
+func CleanupConfigValidation(ctx context.Context, c client.Client, app *webv1alpha1.OpenResty, keepHash string) error {
+	selector := client.MatchingLabels{
+		constants.LabelComponent: "config-validation",
+		constants.LabelOwnedCR:   app.Name,
+	}
+
+	var jobs batchv1.JobList
+	if err := c.List(ctx, &jobs, client.InNamespace(app.Namespace), selector); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if jobs.Items[i].Labels[constants.LabelConfigHash] == keepHash {
+			continue
+		}
+		if err := c.Delete(ctx, &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	var cms corev1.ConfigMapList
+	if err := c.List(ctx, &cms, client.InNamespace(app.Namespace), selector); err != nil {
+		return err
+	}
+	for i := range cms.Items {
+		if cms.Items[i].Labels[constants.LabelConfigHash] == keepHash {
+			continue
+		}
+		if err := c.Delete(ctx, &cms.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExtractNginxTestError keeps the error lines of `nginx -t` output, e.g.
+// `nginx: [emerg] unknown directive "foo" in /etc/nginx/conf.d/servers/a/a.conf:3`
+func ExtractNginxTestError(output string) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		for _, level := range []string{"[emerg]", "[alert]", "[crit]", "[error]"} {
+			if strings.Contains(line, level) {
+				lines = append(lines, strings.TrimPrefix(line, "nginx: "))
+				break
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return strings.TrimSpace(output)
+	}
+	return strings.Join(lines, " | ")
+}
+
+func readConfigValidationOutput(ctx context.Context, c client.Client, job *batchv1.Job) (string, error) {
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Terminated != nil && status.State.Terminated.Message != "" {
+				return ExtractNginxTestError(status.State.Terminated.Message), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func configValidationName(app *webv1alpha1.OpenResty, hash string) string {
+	return fmt.Sprintf("openresty-%s-validate-%s", app.Name, hash[:8])
+}
+
+// configValidationLabels 不包含 selector 标签，避免校验 Pod 被 OpenResty 的 Service 选中
+func configValidationLabels(app *webv1alpha1.OpenResty, hash string) map[string]string {
+	return map[string]string{
+		constants.LabelManagedBy:  "openresty-operator",
+		constants.LabelComponent:  "config-validation",
+		constants.LabelOwnedCR:    app.Name,
+		constants.LabelConfigHash: hash,
+	}
+}
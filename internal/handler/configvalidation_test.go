@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/constants"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+func configMapGetFunc(cms map[string]map[string]string) GetFunc {
+	return func(ctx context.Context, key types.NamespacedName, obj client.Object, opts ...client.GetOption) error {
+		data, ok := cms[key.Name]
+		if !ok {
+			return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, key.Name)
+		}
+		cm := obj.(*corev1.ConfigMap)
+		cm.Name = key.Name
+		cm.Data = data
+		return nil
+	}
+}
+
+func configMapVolume(name, cmName string) corev1.Volume {
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: cmName}},
+		},
+	}
+}
+
+func TestConfigSetHash(t *testing.T) {
+	app := &webv1alpha1.OpenResty{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"}}
+	volumes := []corev1.Volume{
+		configMapVolume("main-config", "openresty-demo-main"),
+		configMapVolume("serverblock-web", "serverblock-web"),
+		{Name: "nginx-logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+	cms := map[string]map[string]string{
+		"openresty-demo-main": {"nginx.conf": "old"},
+		"serverblock-web":     {"web.conf": "server { listen 80; }", "a.conf": "x"},
+	}
+
+	base, err := ConfigSetHash(configMapGetFunc(cms), app, "conf", volumes)
+	assert.NoError(t, err)
+	assert.Len(t, base, 16)
+
+	again, _ := ConfigSetHash(configMapGetFunc(cms), app, "conf", volumes)
+	assert.Equal(t, base, again, "hash must be stable")
+
+	cms["openresty-demo-main"]["nginx.conf"] = "changed"
+	mainChanged, _ := ConfigSetHash(configMapGetFunc(cms), app, "conf", volumes)
+	assert.Equal(t, base, mainChanged, "main ConfigMap is replaced by the candidate nginx.conf")
+
+	candidateChanged, _ := ConfigSetHash(configMapGetFunc(cms), app, "conf2", volumes)
+	assert.NotEqual(t, base, candidateChanged)
+
+	cms["serverblock-web"]["web.conf"] = "server { listen 81; }"
+	serverChanged, _ := ConfigSetHash(configMapGetFunc(cms), app, "conf", volumes)
+	assert.NotEqual(t, base, serverChanged)
+
+	cms["serverblock-web"]["api.peers.json"] = `{"version":"a"}`
+	cms["serverblock-web"]["api.health.json"] = "{}"
+	runtimeChanged, _ := ConfigSetHash(configMapGetFunc(cms), app, "conf", volumes)
+	assert.Equal(t, serverChanged, runtimeChanged, "peers and health targets are only read at runtime")
+
+	app.Spec.Image = "openresty/openresty:1.25.3.1-alpine"
+	imageChanged, _ := ConfigSetHash(configMapGetFunc(cms), app, "conf", volumes)
+	assert.NotEqual(t, serverChanged, imageChanged)
+
+	delete(cms, "serverblock-web")
+	missing, err := ConfigSetHash(configMapGetFunc(cms), app, "conf", volumes)
+	assert.NoError(t, err)
+	assert.NotEqual(t, imageChanged, missing)
+}
+
+func TestExtractNginxTestError(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name: "Emerg line",
+			output: "nginx: [emerg] unknown directive \"proxy_passs\" in /etc/nginx/conf.d/locations/api/api.conf:3\n" +
+				"nginx: configuration file /usr/local/openresty/nginx/conf/nginx.conf test failed\n",
+			want: "[emerg] unknown directive \"proxy_passs\" in /etc/nginx/conf.d/locations/api/api.conf:3",
+		},
+		{
+			name: "Several error lines",
+			output: "nginx: [warn] could not build optimal types_hash\n" +
+				"nginx: [emerg] zone \"api\" is unknown in /etc/nginx/conf.d/locations/api/api.conf:5\n" +
+				"nginx: [alert] could not open error log file\n",
+			want: "[emerg] zone \"api\" is unknown in /etc/nginx/conf.d/locations/api/api.conf:5 | [alert] could not open error log file",
+		},
+		{
+			name:   "No recognizable level",
+			output: "  exec: \"nginx\": executable file not found in $PATH\n",
+			want:   "exec: \"nginx\": executable file not found in $PATH",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ExtractNginxTestError(tt.output))
+		})
+	}
+}
+
+func TestBuildConfigValidationJob(t *testing.T) {
+	app := &webv1alpha1.OpenResty{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: webv1alpha1.OpenRestySpec{
+			ConfigValidation: &webv1alpha1.ConfigValidation{Enable: true, TimeoutSeconds: 30},
+		},
+	}
+	vm := &VolumeMountResult{
+		Volumes: []corev1.Volume{
+			configMapVolume("main-config", "openresty-demo-main"),
+			configMapVolume("serverblock-web", "serverblock-web"),
+			{Name: "nginx-logs", VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "logs"},
+			}},
+			{Name: "static-docs-0", VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "docs", ReadOnly: true},
+			}},
+		},
+		Mounts: []corev1.VolumeMount{{Name: "main-config", MountPath: "/usr/local/openresty/nginx/conf/nginx.conf", SubPath: "nginx.conf"}},
+	}
+
+	hash := "0123456789abcdef"
+	name := configValidationName(app, hash)
+	job := BuildConfigValidationJob(app, name, configValidationLabels(app, hash), vm)
+
+	assert.Equal(t, "openresty-demo-validate-01234567", job.Name)
+	assert.Equal(t, int32(0), *job.Spec.BackoffLimit)
+	assert.Equal(t, int64(30), *job.Spec.ActiveDeadlineSeconds)
+	assert.Equal(t, hash, job.Spec.Template.Labels[constants.LabelConfigHash])
+	assert.NotContains(t, job.Spec.Template.Labels, constants.LabelInstance, "validation pod must not match the Service selector")
+
+	volumes := job.Spec.Template.Spec.Volumes
+	assert.Equal(t, name, volumes[0].ConfigMap.Name, "main-config is replaced by the candidate ConfigMap")
+	assert.Equal(t, "serverblock-web", volumes[1].ConfigMap.Name)
+	assert.NotNil(t, volumes[2].EmptyDir)
+	assert.Nil(t, volumes[3].PersistentVolumeClaim, "ReadWriteOnce static PVCs would block scheduling")
+	assert.NotNil(t, volumes[3].EmptyDir)
+	assert.NotNil(t, vm.Volumes[3].PersistentVolumeClaim)
+	assert.Equal(t, "openresty-demo-main", vm.Volumes[0].ConfigMap.Name, "deployment volumes must not be modified")
+
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []string{"nginx", "-t", "-c", "/usr/local/openresty/nginx/conf/nginx.conf"}, container.Command)
+	assert.Equal(t, corev1.TerminationMessageFallbackToLogsOnError, container.TerminationMessagePolicy)
+	assert.Equal(t, "gintonic1glass/openresty:alpine-1.1.12", container.Image)
+}
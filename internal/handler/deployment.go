@@ -2,6 +2,8 @@ package handler
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/google/go-cmp/cmp"
@@ -13,6 +15,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/utils/ptr"
 	webv1alpha1 "openresty-operator/api/v1alpha1"
 	"openresty-operator/internal/constants"
@@ -20,20 +23,26 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
 )
 
-func DeployOpenRestyPod(ctx context.Context, c client.Client, scheme *runtime.Scheme, app *webv1alpha1.OpenResty, upstreamsType map[string]webv1alpha1.UpstreamType, log logr.Logger) (error, *appsv1.Deployment) {
-	vmResult, err := BuildVolumesAndMounts(ctx, c, app, upstreamsType)
+// DeployOpenRestyPod creates or updates the Deployment, returning the volumes it mounts
+func DeployOpenRestyPod(ctx context.Context, c client.Client, scheme *runtime.Scheme, app *webv1alpha1.OpenResty, upstreamsType map[string]webv1alpha1.UpstreamType, luaModules []string, log logr.Logger) (error, *VolumeMountResult) {
+	vmResult, err := BuildVolumesAndMounts(ctx, c, app, upstreamsType, luaModules)
 	if err != nil {
 		return err, nil
 	}
+	for _, name := range vmResult.MissingLocations {
+		log.Info("Location not found, skipping its static content", "location", name)
+	}
 
 	defaulted := &appsv1.Deployment{}
 	scheme.Default(defaulted)
 
 	deployment := BuildDeploymentSpec(app, defaulted, vmResult.Volumes, vmResult.Mounts, vmResult.MetricsPort)
 
-	return CreateOrUpdateDeployment(ctx, c, scheme, app, deployment, log)
+	err, _ = CreateOrUpdateDeployment(ctx, c, scheme, app, deployment, log)
+	return err, vmResult
 }
 
 func CreateOrUpdateDeployment(
@@ -82,12 +91,15 @@ type VolumeMountResult struct {
 	Volumes     []corev1.Volume
 	Mounts      []corev1.VolumeMount
 	MetricsPort *corev1.ContainerPort
+	// MissingLocations lists the referenced Locations that were not found, their static content is not mounted
+	MissingLocations []string
 }
 
-func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha1.OpenResty, upstreamTypes map[string]webv1alpha1.UpstreamType) (*VolumeMountResult, error) {
+func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha1.OpenResty, upstreamTypes map[string]webv1alpha1.UpstreamType, luaModules []string) (*VolumeMountResult, error) {
 	var volumes []corev1.Volume
 	var mounts []corev1.VolumeMount
 	locationSeen := map[string]bool{}
+	var missingLocations []string
 
 	// --- Mount main nginx.conf ---
 	volumes = append(volumes, corev1.Volume{
@@ -110,7 +122,7 @@ func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha
 	for _, serverName := range app.Spec.Http.ServerRefs {
 		// mount serverblock ConfigMap
 		volumes = append(volumes, corev1.Volume{
-			Name: "serverblock-" + serverName,
+			Name: volumeName("serverblock-" + serverName),
 			VolumeSource: corev1.VolumeSource{
 				ConfigMap: &corev1.ConfigMapVolumeSource{
 					LocalObjectReference: corev1.LocalObjectReference{
@@ -120,7 +132,7 @@ func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha
 			},
 		})
 		mounts = append(mounts, corev1.VolumeMount{
-			Name:      "serverblock-" + serverName,
+			Name:      volumeName("serverblock-" + serverName),
 			MountPath: utils.NginxServerConfigDir + "/" + serverName,
 		})
 
@@ -137,7 +149,7 @@ func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha
 			locationSeen[locName] = true
 
 			volumes = append(volumes, corev1.Volume{
-				Name: "location-" + locName,
+				Name: volumeName("location-" + locName),
 				VolumeSource: corev1.VolumeSource{
 					ConfigMap: &corev1.ConfigMapVolumeSource{
 						LocalObjectReference: corev1.LocalObjectReference{
@@ -147,16 +159,30 @@ func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha
 				},
 			})
 			mounts = append(mounts, corev1.VolumeMount{
-				Name:      "location-" + locName,
+				Name:      volumeName("location-" + locName),
 				MountPath: utils.NginxLocationConfigDir + "/" + locName,
 			})
+
+			// --- Mount static content referenced by Location entries ---
+			var loc webv1alpha1.Location
+			if err := c.Get(ctx, types.NamespacedName{Name: locName, Namespace: app.Namespace}, &loc); err != nil {
+				// 缺失的 Location 不阻塞其他 Location 的滚动
+				if errors.IsNotFound(err) {
+					missingLocations = append(missingLocations, locName)
+					continue
+				}
+				return nil, err
+			}
+			staticVolumes, staticMounts := buildStaticVolumes(&loc)
+			volumes = append(volumes, staticVolumes...)
+			mounts = append(mounts, staticMounts...)
 		}
 	}
 
 	// --- Mount Upstream ---
 	for _, upstreamName := range app.Spec.Http.UpstreamRefs {
 		volumes = append(volumes, corev1.Volume{
-			Name: "upstream-" + upstreamName,
+			Name: volumeName("upstream-" + upstreamName),
 			VolumeSource: corev1.VolumeSource{
 				ConfigMap: &corev1.ConfigMapVolumeSource{
 					LocalObjectReference: corev1.LocalObjectReference{
@@ -174,11 +200,140 @@ func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha
 		}
 
 		mounts = append(mounts, corev1.VolumeMount{
-			Name:      "upstream-" + upstreamName,
+			Name:      volumeName("upstream-" + upstreamName),
 			MountPath: path,
 		})
 	}
 
+	// --- Mount RateLimitPolicy ---
+	for _, policyName := range app.Spec.Http.RateLimitPolicyRefs {
+		cmName := RateLimitPolicyConfigMapName(policyName)
+		volName := volumeName(cmName)
+		redisVolName := volumeName(cmName + "-redis")
+		volumes = append(volumes, corev1.Volume{
+			Name: volName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: cmName,
+					},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volName,
+			MountPath: utils.NginxRateLimitConfigDir + "/" + policyName,
+		})
+
+		// 全局限流策略的 Lua 模块挂载到 lualib，Redis 连接信息以 Secret 文件形式挂载
+		var policy webv1alpha1.RateLimitPolicy
+		if err := c.Get(ctx, types.NamespacedName{Name: policyName, Namespace: app.Namespace}, &policy); err != nil {
+			return nil, err
+		}
+		if !IsGlobalRateLimit(policy.Spec) || policy.Spec.Global == nil {
+			continue
+		}
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volName,
+			MountPath: rateLimitPolicyLuaMountPath(policyName),
+			ReadOnly:  true,
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: redisVolName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: policy.Spec.Global.Redis.SecretName,
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      redisVolName,
+			MountPath: rateLimitPolicySecretMountPath(policyName),
+			ReadOnly:  true,
+		})
+	}
+
+	// --- Mount TrafficShapingPolicy ---
+	for _, policyName := range app.Spec.Http.TrafficShapingPolicyRefs {
+		cmName := TrafficShapingPolicyConfigMapName(policyName)
+		volName := volumeName(cmName)
+		volumes = append(volumes, corev1.Volume{
+			Name: volName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: cmName,
+					},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volName,
+			MountPath: utils.NginxTrafficShapingDir + "/" + policyName,
+		})
+	}
+
+	// --- Mount Quota ---
+	for _, quotaName := range app.Spec.Http.QuotaRefs {
+		cmName := QuotaConfigMapName(quotaName)
+		volName := volumeName(cmName)
+		redisVolName := volumeName(cmName + "-redis")
+		var quota webv1alpha1.Quota
+		if err := c.Get(ctx, types.NamespacedName{Name: quotaName, Namespace: app.Namespace}, &quota); err != nil {
+			return nil, err
+		}
+
+		volumes = append(volumes, corev1.Volume{
+			Name: volName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: cmName,
+					},
+				},
+			},
+		}, corev1.Volume{
+			Name: redisVolName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: quota.Spec.Redis.SecretName,
+				},
+			},
+		})
+		// 同一 ConfigMap 分别挂载到 conf.d（lua_shared_dict）与 lualib（Lua 模块）
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volName,
+			MountPath: utils.NginxQuotaConfigDir + "/" + quotaName,
+		}, corev1.VolumeMount{
+			Name:      volName,
+			MountPath: quotaLuaMountPath(quotaName),
+			ReadOnly:  true,
+		}, corev1.VolumeMount{
+			Name:      redisVolName,
+			MountPath: quotaSecretMountPath(quotaName),
+			ReadOnly:  true,
+		})
+	}
+
+	// --- Mount LuaModule (including dependencies) ---
+	for _, moduleName := range luaModules {
+		volumes = append(volumes, corev1.Volume{
+			Name: volumeName("luamodule-" + moduleName),
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: "luamodule-" + moduleName,
+					},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volumeName("luamodule-" + moduleName),
+			MountPath: luaModuleMountPath(app.Namespace, moduleName),
+			ReadOnly:  true,
+		})
+	}
+
 	var secretList corev1.SecretList
 	if err := c.List(ctx, &secretList, client.InNamespace(app.Namespace),
 		client.MatchingLabels{
@@ -189,7 +344,7 @@ func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha
 	}
 	for _, secret := range secretList.Items {
 		volumes = append(volumes, corev1.Volume{
-			Name: "secret-" + secret.Name,
+			Name: volumeName("secret-" + secret.Name),
 			VolumeSource: corev1.VolumeSource{
 				Secret: &corev1.SecretVolumeSource{
 					SecretName: secret.Name,
@@ -199,7 +354,7 @@ func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha
 
 		locName := secret.Annotations[constants.AnnotationSecretHeaders]
 		mounts = append(mounts, corev1.VolumeMount{
-			Name:      "secret-" + secret.Name,
+			Name:      volumeName("secret-" + secret.Name),
 			MountPath: utils.NginxLuaLibSecretDir + "/" + locName,
 		})
 	}
@@ -258,12 +413,70 @@ func BuildVolumesAndMounts(ctx context.Context, c client.Client, app *webv1alpha
 	}
 
 	return &VolumeMountResult{
-		Volumes:     volumes,
-		Mounts:      mounts,
-		MetricsPort: metricsPort,
+		Volumes:          volumes,
+		Mounts:           mounts,
+		MetricsPort:      metricsPort,
+		MissingLocations: missingLocations,
 	}, nil
 }
 
+// volumeName 返回合法的 volume 名称（DNS-1123 label）：过长或含 "." 的名称截断后追加哈希，避免 Deployment 被拒绝
+func volumeName(name string) string {
+	if len(validation.IsDNS1123Label(name)) == 0 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	base := strings.ReplaceAll(name, ".", "-")
+	if len(base) > validation.DNS1123LabelMaxLength-9 {
+		base = base[:validation.DNS1123LabelMaxLength-9]
+	}
+	return strings.TrimRight(base, "-") + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+func buildStaticVolumes(loc *webv1alpha1.Location) ([]corev1.Volume, []corev1.VolumeMount) {
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+
+	for i, entry := range loc.Spec.Entries {
+		static := entry.Static
+		if static == nil {
+			continue
+		}
+
+		name := volumeName(fmt.Sprintf("static-%s-%d", loc.Name, i))
+		volume := corev1.Volume{Name: name}
+		switch {
+		case static.ConfigMap != nil:
+			volume.VolumeSource.ConfigMap = &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: *static.ConfigMap,
+			}
+		case static.PersistentVolumeClaim != "":
+			volume.VolumeSource.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: static.PersistentVolumeClaim,
+				ReadOnly:  true,
+			}
+		case static.Image != nil:
+			volume.VolumeSource.Image = static.Image
+		default:
+			continue
+		}
+
+		volumes = append(volumes, volume)
+		// ConfigMap 不使用 subPath 挂载，内容更新无需重启 Pod
+		mount := corev1.VolumeMount{
+			Name:      name,
+			MountPath: staticMountPath(loc.Name, i),
+			ReadOnly:  true,
+		}
+		if static.ConfigMap == nil {
+			mount.SubPath = static.SubPath
+		}
+		mounts = append(mounts, mount)
+	}
+
+	return volumes, mounts
+}
+
 func BuildDeploymentSpec(app *webv1alpha1.OpenResty, defaulted *appsv1.Deployment, volumes []corev1.Volume, mounts []corev1.VolumeMount, metricsPort *corev1.ContainerPort) *appsv1.Deployment {
 	name := "openresty-" + app.Name
 	replicas := int32(1)
@@ -303,9 +516,7 @@ func BuildDeploymentSpec(app *webv1alpha1.OpenResty, defaulted *appsv1.Deploymen
 	dep.Spec.Template.Spec.PriorityClassName = app.Spec.PriorityClassName
 
 	// 注入 containers
-	if len(app.Spec.Image) == 0 {
-		app.Spec.Image = "gintonic1glass/openresty:alpine-1.1.12"
-	}
+	app.Spec.Image = openRestyImage(app)
 	openrestyContainer := corev1.Container{
 		Name:      "openresty",
 		Image:     app.Spec.Image,
@@ -316,6 +527,12 @@ func BuildDeploymentSpec(app *webv1alpha1.OpenResty, defaulted *appsv1.Deploymen
 				ContainerPort: 80,
 				Protocol:      corev1.ProtocolTCP,
 			},
+			{
+				// operator 从这里读取 Pod 内被动健康检查摘除的 server
+				Name:          "status",
+				ContainerPort: utils.NginxStatusPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
 		},
 		VolumeMounts: mounts,
 	}
@@ -330,11 +547,11 @@ func BuildDeploymentSpec(app *webv1alpha1.OpenResty, defaulted *appsv1.Deploymen
 		Ports: []corev1.ContainerPort{
 			{
 				Name:          "reload-metrics",
-				ContainerPort: 19091,
+				ContainerPort: utils.ReloadAgentPort,
 				Protocol:      corev1.ProtocolTCP,
 			},
 		},
-		Env:          app.Spec.ReloadAgentEnv,
+		Env:          reloadAgentEnv(app),
 		VolumeMounts: mounts[1:], // reload agent 不挂主 nginx.conf
 	}
 
@@ -346,6 +563,26 @@ func BuildDeploymentSpec(app *webv1alpha1.OpenResty, defaulted *appsv1.Deploymen
 	return dep
 }
 
+// reloadAgentEnv 在用户配置的环境变量之前加入 HEALTH_CHECK，用户可以覆盖
+func reloadAgentEnv(app *webv1alpha1.OpenResty) []corev1.EnvVar {
+	check := app.Spec.InPodHealthCheck
+	if check == nil || !check.Enable {
+		return app.Spec.ReloadAgentEnv
+	}
+	env := []corev1.EnvVar{{
+		Name:  "HEALTH_CHECK",
+		Value: fmt.Sprintf(`{"enable":true,"workers":%d}`, defaultInt(check.Workers, 4)),
+	}}
+	return append(env, app.Spec.ReloadAgentEnv...)
+}
+
+func openRestyImage(app *webv1alpha1.OpenResty) string {
+	if len(app.Spec.Image) == 0 {
+		return "gintonic1glass/openresty:alpine-1.1.12"
+	}
+	return app.Spec.Image
+}
+
 func buildPrometheusAnnotations(metrics *webv1alpha1.MetricsServer) map[string]string {
 	if metrics == nil || !metrics.Enable {
 		return map[string]string{}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBuildVolumesAndMountsStatic(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = webv1alpha1.AddToScheme(scheme)
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&webv1alpha1.ServerBlock{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       webv1alpha1.ServerBlockSpec{LocationRefs: []string{"ghost", "site"}},
+		},
+		&webv1alpha1.Location{
+			ObjectMeta: metav1.ObjectMeta{Name: "site", Namespace: "default"},
+			Spec: webv1alpha1.LocationSpec{Entries: []webv1alpha1.LocationEntry{
+				{Path: "/", Static: &webv1alpha1.StaticConf{ConfigMap: &corev1.LocalObjectReference{Name: "site-html"}}},
+				{Path: "/docs/", Static: &webv1alpha1.StaticConf{PersistentVolumeClaim: "docs", SubPath: "public"}},
+			}},
+		},
+	).Build()
+	app := &webv1alpha1.OpenResty{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec:       webv1alpha1.OpenRestySpec{Http: &webv1alpha1.HttpBlock{ServerRefs: []string{"web"}}},
+	}
+
+	result, err := BuildVolumesAndMounts(context.Background(), c, app, nil, nil)
+	assert.NoError(t, err, "a missing Location must not block the other Locations")
+	assert.Equal(t, []string{"ghost"}, result.MissingLocations)
+
+	mounts := map[string]corev1.VolumeMount{}
+	for _, m := range result.Mounts {
+		mounts[m.Name] = m
+	}
+	assert.Contains(t, mounts, "location-ghost", "the Location config keeps its mount")
+	assert.Empty(t, mounts["static-site-0"].SubPath, "ConfigMap content is mounted as a directory to receive updates")
+	assert.Equal(t, "public", mounts["static-site-1"].SubPath)
+}
+
+func TestBuildVolumesAndMountsLongNames(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = webv1alpha1.AddToScheme(scheme)
+
+	long := strings.Repeat("a", 63)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&webv1alpha1.ServerBlock{
+			ObjectMeta: metav1.ObjectMeta{Name: long, Namespace: "default"},
+			Spec:       webv1alpha1.ServerBlockSpec{LocationRefs: []string{long, "site.v1"}},
+		},
+		&webv1alpha1.Location{
+			ObjectMeta: metav1.ObjectMeta{Name: long, Namespace: "default"},
+			Spec: webv1alpha1.LocationSpec{Entries: []webv1alpha1.LocationEntry{
+				{Path: "/", Static: &webv1alpha1.StaticConf{ConfigMap: &corev1.LocalObjectReference{Name: "html"}}},
+			}},
+		},
+		&webv1alpha1.Location{ObjectMeta: metav1.ObjectMeta{Name: "site.v1", Namespace: "default"}},
+	).Build()
+	app := &webv1alpha1.OpenResty{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: webv1alpha1.OpenRestySpec{Http: &webv1alpha1.HttpBlock{
+			ServerRefs:   []string{long},
+			UpstreamRefs: []string{long, long[:62] + "b"},
+		}},
+	}
+
+	result, err := BuildVolumesAndMounts(context.Background(), c, app, nil, []string{long})
+	assert.NoError(t, err)
+
+	volumes := map[string]bool{}
+	for _, v := range result.Volumes {
+		assert.Empty(t, validation.IsDNS1123Label(v.Name), v.Name)
+		assert.False(t, volumes[v.Name], "volume names stay unique after truncation: %s", v.Name)
+		volumes[v.Name] = true
+	}
+	for _, m := range result.Mounts {
+		assert.True(t, volumes[m.Name], "mount %s refers to a volume", m.Name)
+	}
+	assert.Regexp(t, `^location-site-v1-[0-9a-f]{8}$`, volumeName("location-site.v1"))
+	assert.Len(t, volumeName("location-"+long), 63)
+	assert.Equal(t, "upstream-short", volumeName("upstream-short"), "valid names are kept as is")
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"net/url"
 	"openresty-operator/api/v1alpha1"
 	"openresty-operator/internal/constants"
@@ -33,80 +35,265 @@ func ValidateLocationEntries(entries []v1alpha1.LocationEntry) (bool, []string)
 		} else {
 			pathSeen[path] = struct{}{}
 		}
+
+		if valid, reason := utils.ValidateStaticEntry(entry); !valid {
+			problems = append(problems, fmt.Sprintf("Invalid static: %s (%s)", path, reason))
+		}
+
+		if valid, reason := utils.ValidateUpstreamRefEntry(entry); !valid {
+			problems = append(problems, fmt.Sprintf("Invalid upstreamRef: %s (%s)", path, reason))
+		}
+
+		if valid, reason := utils.ValidateEntryValues(entry); !valid {
+			problems = append(problems, fmt.Sprintf("Invalid value: %s (%s)", path, reason))
+		}
+
+		if valid, reason := utils.ValidateLuaEntry(entry); !valid {
+			problems = append(problems, fmt.Sprintf("Invalid lua: %s (%s)", path, reason))
+		}
 	}
 
 	return len(problems) == 0, problems
 }
 
-func GenerateLocationConfig(name, namespace string, entries []v1alpha1.LocationEntry) string {
-	var b strings.Builder
-	for _, e := range entries {
-		b.WriteString(fmt.Sprintf("location %s {\n", e.Path))
-
-		needRewrite := e.ProxyPassIsFullURL || len(e.HeadersFromSecret) > 0
-		b.WriteString(fmt.Sprintf("    set $location_path \"%s\";\n", e.Path))
-		if needRewrite {
-			if e.ProxyPassIsFullURL {
-				b.WriteString("    set $target \"\";\n")
-			}
-			b.WriteString(fmt.Sprintf("    set $location_prefix \"%s\";\n", e.Path))
-			b.WriteString("    rewrite_by_lua_block {\n")
-
-			if len(e.HeadersFromSecret) > 0 {
-				b.WriteString(fmt.Sprintf("        local namespace = ngx.var.namespace or \"%s\"\n", namespace))
-				b.WriteString(fmt.Sprintf("        local locationName = \"%s\"\n", name))
-				b.WriteString(fmt.Sprintf("        local path = \"%s\"\n", e.Path))
-				b.WriteString("        local headers = {\n")
-				for _, h := range e.HeadersFromSecret {
-					b.WriteString(fmt.Sprintf("            \"%s\",\n", h.Name))
-				}
-				b.WriteString("        }\n")
-				b.WriteString("        for _, headerName in ipairs(headers) do\n")
-				b.WriteString("            local key = namespace .. \"/\" .. locationName .. \"/\" .. path .. \"/\" .. headerName\n")
-				b.WriteString("            local value = ngx.shared.secrets_store:get(key)\n")
-				b.WriteString("            if value then\n")
-				b.WriteString("                ngx.req.set_header(headerName, value)\n")
-				b.WriteString("            end\n")
-				b.WriteString("        end\n")
-			}
+// UpstreamRefsResult 记录 Location 中 upstreamRef 的解析结果
+type UpstreamRefsResult struct {
+	// Types maps each resolved Upstream name to its type, including the Upstreams named by a proxyPass host
+	Types map[string]v1alpha1.UpstreamType
+	// Resolved lists the resolved Upstream names in order of first reference
+	Resolved []string
+	// Missing lists the referenced Upstreams that could not be found
+	Missing []string
+	// Features holds the settings of each resolved Upstream the Location entries depend on
+	Features map[string]UpstreamFeatures
+}
+
+// UpstreamFeatures 记录影响 Location 渲染的 Upstream 配置
+type UpstreamFeatures struct {
+	// Keepalive 时使用 HTTP/1.1 并清空 Connection 头
+	Keepalive bool
+	// AffinityCookie 时在 header_filter 中签发亲和 cookie
+	AffinityCookie bool
+}
+
+func ResolveUpstreamRefs(get GetFunc, namespace string, entries []v1alpha1.LocationEntry) UpstreamRefsResult {
+	ctx := context.Background()
+	result := UpstreamRefsResult{Types: make(map[string]v1alpha1.UpstreamType), Features: make(map[string]UpstreamFeatures)}
+	seen := make(map[string]struct{})
 
-			// FullURL upstream动态分流
-			if e.ProxyPassIsFullURL {
-				b.WriteString(fmt.Sprintf("        require(\"upstreams.%s.%s\").default()\n", safeName(e.ProxyPass), safeName(e.ProxyPass)))
+	for _, entry := range entries {
+		if entry.UpstreamRef == nil {
+			continue
+		}
+		name := entry.UpstreamRef.Name
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		var ups v1alpha1.Upstream
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &ups); err != nil {
+			if errors.IsNotFound(err) {
+				result.Missing = append(result.Missing, name)
+			} else {
+				result.Missing = append(result.Missing, fmt.Sprintf("%s (error: %v)", name, err))
 			}
+			continue
+		}
 
-			b.WriteString("    }\n")
+		result.Types[name] = ups.Spec.Type
+		result.Features[name] = UpstreamFeatures{
+			Keepalive:      ups.Spec.Keepalive != nil && ups.Spec.Type == v1alpha1.UpstreamTypeAddress,
+			AffinityCookie: UsesAffinityCookie(&ups),
 		}
-		if e.ProxyPassIsFullURL {
-			b.WriteString("    header_filter_by_lua_block\n {\n")
-			b.WriteString("      ngx.header[\"Content-Length\"] = nil")
-			b.WriteString("    }\n")
+		result.Resolved = append(result.Resolved, name)
+	}
 
-			b.WriteString("    body_filter_by_lua_block {\n")
-			b.WriteString(fmt.Sprintf("        require(\"upstreams.%s.%s\").normalizeResponse()\n", safeName(e.ProxyPass), safeName(e.ProxyPass)))
-			b.WriteString("    }\n")
+	// proxyPass 直接写 Upstream 名称时同样经过 balancer，需要 finish()、keepalive 与亲和 cookie；host 不是 Upstream 时忽略
+	for _, entry := range entries {
+		name := proxyPassUpstream(entry)
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		var ups v1alpha1.Upstream
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &ups); err != nil {
+			continue
+		}
+		result.Types[name] = ups.Spec.Type
+		result.Features[name] = UpstreamFeatures{
+			Keepalive:      ups.Spec.Keepalive != nil && ups.Spec.Type == v1alpha1.UpstreamTypeAddress,
+			AffinityCookie: UsesAffinityCookie(&ups),
+		}
+	}
+
+	return result
+}
+
+// proxyPassUpstream 返回 proxyPass 的 host，即 "http://<upstream-name>" 写法中的 Upstream 名称
+func proxyPassUpstream(e v1alpha1.LocationEntry) string {
+	if e.UpstreamRef != nil || e.ProxyPass == "" {
+		return ""
+	}
+	u, err := url.Parse(e.ProxyPass)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// renderKeepaliveProxy 为启用 keepalive 的 upstream 使用 HTTP/1.1 并清空 Connection 头，
+// entry 自行设置的 Connection 头或 Extra 中的 proxy_http_version 优先
+func renderKeepaliveProxy(e v1alpha1.LocationEntry) string {
+	var b strings.Builder
+	hasVersion := false
+	for _, extra := range e.Extra {
+		if fields := strings.Fields(extra); len(fields) > 0 && fields[0] == "proxy_http_version" {
+			hasVersion = true
+		}
+	}
+	if !hasVersion {
+		b.WriteString("    proxy_http_version 1.1;\n")
+	}
+	for _, h := range e.Headers {
+		if strings.EqualFold(h.Key, "Connection") {
+			return b.String()
+		}
+	}
+	b.WriteString("    proxy_set_header Connection \"\";\n")
+	return b.String()
+}
+
+// resolveFullURLModule 返回 FullURL 类型 upstream 对应的 Lua 模块名
+func resolveFullURLModule(e v1alpha1.LocationEntry, upstreamTypes map[string]v1alpha1.UpstreamType) (string, bool) {
+	if e.UpstreamRef != nil {
+		if upstreamTypes[e.UpstreamRef.Name] == v1alpha1.UpstreamTypeFullURL {
+			return e.UpstreamRef.Name, true
+		}
+		return "", false
+	}
+	if e.ProxyPassIsFullURL {
+		return safeName(e.ProxyPass), true
+	}
+	return "", false
+}
+
+func GenerateLocationConfig(
+	name, namespace string,
+	entries []v1alpha1.LocationEntry,
+	upstreamTypes map[string]v1alpha1.UpstreamType,
+	upstreamFeatures map[string]UpstreamFeatures,
+	rateLimitPolicies map[string]v1alpha1.RateLimitPolicySpec,
+	trafficShapingPolicies map[string]v1alpha1.TrafficShapingPolicySpec,
+	quotas map[string]v1alpha1.QuotaSpec,
+) string {
+	var b strings.Builder
+	// 同一 Location 中使用同一策略的 entry 共享一个拒绝响应的 named location
+	var rejectLocations []string
+	rejectPolicies := make(map[string]v1alpha1.RateLimitPolicySpec)
+
+	for i, e := range entries {
+		b.WriteString(fmt.Sprintf("location %s {\n", locationArgs(e.Path)))
+
+		lua := e.Lua
+		if lua == nil {
+			lua = &v1alpha1.LuaBlock{}
 		}
 
-		if e.ProxyPassIsFullURL {
+		module, isFullURL := resolveFullURLModule(e, upstreamTypes)
+		// upstreamName 为 entry 使用的 Upstream：upstreamRef 或 "http://<upstream-name>" 写法的 proxyPass
+		upstreamName := proxyPassUpstream(e)
+		if e.UpstreamRef != nil {
+			upstreamName = e.UpstreamRef.Name
+		}
+		b.WriteString(fmt.Sprintf("    set $location_path %s;\n", utils.QuoteNginx(e.Path)))
+		if isFullURL && e.UpstreamRef != nil && e.UpstreamRef.PathPrefix != "" {
+			b.WriteString(fmt.Sprintf("    set $upstream_path_prefix %s;\n", utils.QuoteNginx(e.UpstreamRef.PathPrefix)))
+		}
+		if isFullURL {
+			b.WriteString("    set $target \"\";\n")
+		}
+		if isFullURL || len(e.HeadersFromSecret) > 0 {
+			b.WriteString(fmt.Sprintf("    set $location_prefix %s;\n", utils.QuoteNginx(e.Path)))
+		}
+
+		// rewrite 阶段：secret headers -> 用户代码 -> FullURL 动态分流，
+		// 用户代码在分流之前执行，以便改写 uri/header 影响路由
+		var secretHeaders, routing string
+		if len(e.HeadersFromSecret) > 0 {
+			var rb strings.Builder
+			rb.WriteString(fmt.Sprintf("local namespace = ngx.var.namespace or %s\n", utils.QuoteLua(namespace)))
+			rb.WriteString(fmt.Sprintf("local locationName = %s\n", utils.QuoteLua(name)))
+			rb.WriteString(fmt.Sprintf("local path = %s\n", utils.QuoteLua(e.Path)))
+			rb.WriteString("local headers = {\n")
+			for _, h := range e.HeadersFromSecret {
+				rb.WriteString(fmt.Sprintf("    %s,\n", utils.QuoteLua(h.Name)))
+			}
+			rb.WriteString("}\n")
+			rb.WriteString("for _, headerName in ipairs(headers) do\n")
+			rb.WriteString("    local key = namespace .. \"/\" .. locationName .. \"/\" .. path .. \"/\" .. headerName\n")
+			rb.WriteString("    local value = ngx.shared.secrets_store:get(key)\n")
+			rb.WriteString("    if value then\n")
+			rb.WriteString("        ngx.req.set_header(headerName, value)\n")
+			rb.WriteString("    end\n")
+			rb.WriteString("end\n")
+			secretHeaders = rb.String()
+		}
+		// FullURL upstream动态分流
+		if isFullURL {
+			routing = fmt.Sprintf("require(%s).default()\n", utils.QuoteLua("upstreams."+module+"."+module))
+		}
+		writeLuaPhase(&b, "rewrite", secretHeaders, lua.Rewrite, routing)
+
+		var headerFilter, bodyFilter string
+		if isFullURL {
+			headerFilter = "ngx.header[\"Content-Length\"] = nil\n"
+			bodyFilter = fmt.Sprintf("require(%s).normalizeResponse()\n", utils.QuoteLua("upstreams."+module+"."+module))
+		}
+		// 签发或更新亲和 cookie，先于用户的 header_filter 执行
+		if upstreamFeatures[upstreamName].AffinityCookie {
+			headerFilter += "require(\"upstreams.sticky\").set_cookie()\n"
+		}
+		writeLuaPhase(&b, "header_filter", headerFilter, lua.HeaderFilter, "")
+		writeLuaPhase(&b, "body_filter", bodyFilter, lua.BodyFilter, "")
+
+		if isFullURL {
 			b.WriteString("    proxy_pass $target;\n")
+		} else if e.UpstreamRef != nil {
+			if _, ok := upstreamTypes[e.UpstreamRef.Name]; ok {
+				scheme := defaultOr(e.UpstreamRef.Scheme, "http")
+				b.WriteString(fmt.Sprintf("    proxy_pass %s;\n", utils.NginxArg(scheme+"://"+utils.SanitizeName(e.UpstreamRef.Name)+e.UpstreamRef.PathPrefix)))
+				if upstreamFeatures[e.UpstreamRef.Name].Keepalive {
+					b.WriteString(renderKeepaliveProxy(e))
+				}
+			}
 		} else if e.ProxyPass != "" {
-			b.WriteString(fmt.Sprintf("    proxy_pass %s;\n", e.ProxyPass))
+			b.WriteString(fmt.Sprintf("    proxy_pass %s;\n", utils.NginxArg(e.ProxyPass)))
+			if upstreamFeatures[upstreamName].Keepalive {
+				b.WriteString(renderKeepaliveProxy(e))
+			}
+		} else if e.Static != nil {
+			b.WriteString(renderStaticLocation(e.Path, staticMountPath(name, i), e.Static))
 		}
 
 		// 明文 Headers
 		for _, h := range e.Headers {
-			b.WriteString(fmt.Sprintf("    proxy_set_header %s %s;\n", h.Key, h.Value))
+			b.WriteString(fmt.Sprintf("    proxy_set_header %s %s;\n", utils.NginxArg(h.Key), utils.NginxArg(h.Value)))
 		}
 
 		if e.Timeout != nil {
 			if e.Timeout.Connect != "" {
-				b.WriteString(fmt.Sprintf("    proxy_connect_timeout %s;\n", e.Timeout.Connect))
+				b.WriteString(fmt.Sprintf("    proxy_connect_timeout %s;\n", utils.NginxArg(e.Timeout.Connect)))
 			}
 			if e.Timeout.Send != "" {
-				b.WriteString(fmt.Sprintf("    proxy_send_timeout %s;\n", e.Timeout.Send))
+				b.WriteString(fmt.Sprintf("    proxy_send_timeout %s;\n", utils.NginxArg(e.Timeout.Send)))
 			}
 			if e.Timeout.Read != "" {
-				b.WriteString(fmt.Sprintf("    proxy_read_timeout %s;\n", e.Timeout.Read))
+				b.WriteString(fmt.Sprintf("    proxy_read_timeout %s;\n", utils.NginxArg(e.Timeout.Read)))
 			}
 		}
 
@@ -114,52 +301,117 @@ func GenerateLocationConfig(name, namespace string, entries []v1alpha1.LocationE
 			b.WriteString("    access_log off;\n")
 		}
 
-		if e.LimitReq != nil {
-			b.WriteString(fmt.Sprintf("    limit_req %s;\n", *e.LimitReq))
+		var globalRateLimit string
+		if e.RateLimitPolicyRef != nil {
+			if policy, ok := rateLimitPolicies[e.RateLimitPolicyRef.Name]; ok {
+				if IsGlobalRateLimit(policy) {
+					globalRateLimit = renderGlobalRateLimitCheck(e.RateLimitPolicyRef.Name)
+				}
+				b.WriteString(renderRateLimitKey(policy))
+				rejectLocation := rateLimitRejectLocation(name, e.RateLimitPolicyRef.Name, policy)
+				if _, seen := rejectPolicies[rejectLocation]; rejectLocation != "" && !seen {
+					rejectPolicies[rejectLocation] = policy
+					rejectLocations = append(rejectLocations, rejectLocation)
+				}
+				b.WriteString(renderLimitReq(policy, rejectLocation))
+			}
+		}
+
+		if e.TrafficShapingPolicyRef != nil {
+			if policy, ok := trafficShapingPolicies[e.TrafficShapingPolicyRef.Name]; ok {
+				b.WriteString(renderTrafficShaping(policy))
+			}
+		}
+
+		var quota string
+		if e.QuotaRef != nil {
+			if _, ok := quotas[e.QuotaRef.Name]; ok {
+				quota = renderQuotaCheck(e.QuotaRef.Name)
+			}
 		}
 
 		if e.Gzip != nil && e.Gzip.Enable {
 			b.WriteString("    gzip on;\n")
 			if len(e.Gzip.Types) > 0 {
-				b.WriteString(fmt.Sprintf("    gzip_types %s;\n", strings.Join(e.Gzip.Types, " ")))
+				types := make([]string, 0, len(e.Gzip.Types))
+				for _, t := range e.Gzip.Types {
+					types = append(types, utils.NginxArg(t))
+				}
+				b.WriteString(fmt.Sprintf("    gzip_types %s;\n", strings.Join(types, " ")))
 			}
 		}
 
 		if e.Cache != nil {
 			if e.Cache.Zone != "" {
-				b.WriteString(fmt.Sprintf("    proxy_cache %s;\n", e.Cache.Zone))
+				b.WriteString(fmt.Sprintf("    proxy_cache %s;\n", utils.NginxArg(e.Cache.Zone)))
 			}
 			if e.Cache.Valid != "" {
 				b.WriteString(fmt.Sprintf("    proxy_cache_valid %s;\n", e.Cache.Valid))
 			}
 		}
 
-		if e.Lua != nil && e.Lua.Access != "" {
-			b.WriteString("    access_by_lua_block {\n")
-			b.WriteString(indentLua(e.Lua.Access, "        "))
-			b.WriteString("    }\n")
-		}
+		// 全局限流与配额在用户 access 代码之前执行，被限流的请求不计入配额
+		writeLuaPhase(&b, "access", globalRateLimit+quota, lua.Access, "")
+		writeLuaPhase(&b, "content", "", lua.Content, "")
 
 		for _, extra := range e.Extra {
 			b.WriteString(fmt.Sprintf("    %s\n", extra))
 		}
 
+		// 释放 least-connections 的在途计数、记录 peak-ewma 延迟，并把未收到响应头的请求计入 max_fails
+		var logRecord string
+		if isFullURL || upstreamTypes[upstreamName] != "" {
+			logRecord = "require(\"upstreams.lb\").finish()\n"
+		}
 		if e.EnableUpstreamMetrics {
-			b.WriteString("    log_by_lua_block {\n")
-			//b.WriteString("        local addr = (ngx.var.upstream_addr or \"unknown\"):match(\"^[^,]+\")\n")
-			//b.WriteString("        local status = ngx.var.status\n")
-			//b.WriteString("        local latency = tonumber(ngx.var.upstream_response_time) or 0\n")
-			//b.WriteString("        metric_upstream_latency:observe(latency, {addr})\n")
-			//b.WriteString("        metric_upstream_total:inc(1, {addr, status})\n")
-			b.WriteString("        require(\"metrics\").record()\n")
-			b.WriteString("    }\n")
+			logRecord += "require(\"metrics\").record()\n"
 		}
+		writeLuaPhase(&b, "log", logRecord, lua.Log, "")
 
 		b.WriteString("}\n\n")
 	}
+
+	for _, rejectLocation := range rejectLocations {
+		b.WriteString(renderRateLimitRejectLocation(rejectLocation, rejectPolicies[rejectLocation]))
+	}
 	return b.String()
 }
 
+// locationArgs 渲染 location 的修饰符与匹配串，正则中的 { } ; 等需要加引号
+func locationArgs(path string) string {
+	path = strings.TrimSpace(path)
+	for _, modifier := range []string{"~*", "^~", "~", "="} {
+		if strings.HasPrefix(path, modifier) {
+			return modifier + " " + utils.NginxArg(strings.TrimSpace(strings.TrimPrefix(path, modifier)))
+		}
+	}
+	return utils.NginxArg(path)
+}
+
+// writeLuaPhase 渲染单个 *_by_lua_block，按 prologue -> 用户代码 -> epilogue 的顺序组合。
+// 用户代码包在局部函数里执行，其中的 return 不会跳过 operator 生成的逻辑。
+func writeLuaPhase(b *strings.Builder, phase, prologue, user, epilogue string) {
+	if prologue == "" && strings.TrimSpace(user) == "" && epilogue == "" {
+		return
+	}
+
+	b.WriteString(fmt.Sprintf("    %s_by_lua_block {\n", phase))
+	if prologue != "" {
+		b.WriteString(indentLua(strings.TrimSuffix(prologue, "\n"), "        "))
+	}
+	if strings.TrimSpace(user) != "" {
+		fn := "user_" + phase
+		b.WriteString(fmt.Sprintf("        local function %s()\n", fn))
+		b.WriteString(indentLua(strings.TrimSuffix(user, "\n"), "            "))
+		b.WriteString("        end\n")
+		b.WriteString(fmt.Sprintf("        %s()\n", fn))
+	}
+	if epilogue != "" {
+		b.WriteString(indentLua(strings.TrimSuffix(epilogue, "\n"), "        "))
+	}
+	b.WriteString("    }\n")
+}
+
 func GenerateSecretFromLocations(ctx context.Context, location *v1alpha1.Location, getSecretFunc func(ns, name string) (*corev1.Secret, error)) (*corev1.Secret, error) {
 	data := make(map[string]string)
 
@@ -211,6 +463,54 @@ func GenerateSecretFromLocations(ctx context.Context, location *v1alpha1.Locatio
 	return secret, nil
 }
 
+func renderStaticLocation(path, dir string, static *v1alpha1.StaticConf) string {
+	var b strings.Builder
+
+	index := static.Index
+	if index == "" {
+		index = "index.html"
+	}
+
+	if static.Mode == v1alpha1.StaticModeRoot {
+		b.WriteString(fmt.Sprintf("    root %s;\n", dir))
+	} else {
+		// alias 末尾斜杠需要与 location 保持一致，否则会拼出错误路径
+		if strings.HasSuffix(path, "/") {
+			dir += "/"
+		}
+		b.WriteString(fmt.Sprintf("    alias %s;\n", dir))
+	}
+	b.WriteString(fmt.Sprintf("    index %s;\n", utils.NginxArg(index)))
+
+	if static.SPA {
+		fallback := strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(path, "^~")), "/") + "/" + index
+		b.WriteString(fmt.Sprintf("    try_files $uri $uri/ %s;\n", utils.NginxArg(fallback)))
+	} else {
+		b.WriteString("    try_files $uri $uri/ =404;\n")
+	}
+
+	if static.GzipStatic {
+		b.WriteString("    gzip_static on;\n")
+	}
+
+	if len(static.CacheControl) > 0 {
+		b.WriteString("    set $static_cache_control \"\";\n")
+		for _, rule := range static.CacheControl {
+			b.WriteString(fmt.Sprintf("    if ($uri ~* \\.(%s)$) {\n", strings.Join(rule.Extensions, "|")))
+			b.WriteString(fmt.Sprintf("        set $static_cache_control %s;\n", utils.QuoteNginx(rule.Value)))
+			b.WriteString("    }\n")
+		}
+		b.WriteString("    add_header Cache-Control $static_cache_control;\n")
+	}
+
+	return b.String()
+}
+
+// staticMountPath 返回 Location 第 index 个 entry 的静态文件挂载目录
+func staticMountPath(locationName string, index int) string {
+	return fmt.Sprintf("%s/%s/%d", utils.NginxStaticDir, locationName, index)
+}
+
 func safeName(proxyPass string) string {
 	u, err := url.Parse(proxyPass)
 	if err != nil || u.Host == "" {
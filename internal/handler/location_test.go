@@ -1,8 +1,18 @@
 package handler
 
 import (
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/nginxconf"
+	"openresty-operator/internal/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"strings"
 	"testing"
 )
@@ -48,6 +58,122 @@ func TestValidateLocationEntries(t *testing.T) {
 			wantValid:    false,
 			wantProblems: []string{"Invalid path: foo", "Duplicate path: foo"},
 		},
+		{
+			name: "Static together with proxyPass",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:      "/app/",
+					ProxyPass: "http://backend",
+					Static: &webv1alpha1.StaticConf{
+						ConfigMap: &corev1.LocalObjectReference{Name: "app-bundle"},
+					},
+				},
+			},
+			wantValid:    false,
+			wantProblems: []string{"static and proxyPass cannot be set together"},
+		},
+		{
+			name: "UpstreamRef together with proxyPass",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:        "/api/",
+					ProxyPass:   "http://backend",
+					UpstreamRef: &webv1alpha1.UpstreamReference{Name: "backend"},
+				},
+			},
+			wantValid:    false,
+			wantProblems: []string{"upstreamRef and proxyPass cannot be set together"},
+		},
+		{
+			name: "Lua content together with proxyPass",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:      "/hello",
+					ProxyPass: "http://backend",
+					Lua:       &webv1alpha1.LuaBlock{Content: "ngx.say('hi')"},
+				},
+			},
+			wantValid:    false,
+			wantProblems: []string{"lua content cannot be combined"},
+		},
+		{
+			name: "Lua syntax error reported with phase and line",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path: "/hello",
+					Lua: &webv1alpha1.LuaBlock{
+						Access:  "local ok = true\nif ok then\n  ngx.log(ngx.INFO, 'ok')\n",
+						Content: "ngx.say('hi') end",
+					},
+				},
+			},
+			wantValid: false,
+			wantProblems: []string{
+				"access: line 3: unexpected end of script",
+				"content: line 1, column 17 near 'end'",
+			},
+		},
+		{
+			name: "Static without source",
+			entries: []webv1alpha1.LocationEntry{
+				{Path: "/app/", Static: &webv1alpha1.StaticConf{}},
+			},
+			wantValid:    false,
+			wantProblems: []string{"exactly one of configMap, persistentVolumeClaim or image"},
+		},
+		{
+			name: "Static subPath of a ConfigMap",
+			entries: []webv1alpha1.LocationEntry{
+				{Path: "/app/", Static: &webv1alpha1.StaticConf{
+					ConfigMap: &corev1.LocalObjectReference{Name: "site"},
+					SubPath:   "dist",
+				}},
+			},
+			wantValid:    false,
+			wantProblems: []string{"static subPath is not supported by configMap"},
+		},
+		{
+			name: "Injected header name and value",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:      "/api/",
+					ProxyPass: "http://backend",
+					Headers: []webv1alpha1.NginxKV{
+						{Key: "X-A;", Value: "a"},
+						{Key: "X-B", Value: "b\r\nX-Injected: 1"},
+					},
+				},
+			},
+			wantValid: false,
+			wantProblems: []string{
+				`Invalid value: /api/ (headers invalid header name "X-A;"; headers[X-B] contains control character '\r')`,
+			},
+		},
+		{
+			name: "Values rendered verbatim",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:               "/api/",
+					ProxyPass:          "http://backend; return 200",
+					RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: " "},
+					Timeout:            &webv1alpha1.Timeouts{Read: "30s }"},
+				},
+			},
+			wantValid: false,
+			wantProblems: []string{
+				"rateLimitPolicyRef name cannot be empty",
+				"proxyPass must be a single value",
+				"timeout.read must be a single value",
+			},
+		},
+		{
+			name: "Control character in path",
+			entries: []webv1alpha1.LocationEntry{
+				{Path: "/api/\n}"},
+			},
+			wantValid:    false,
+			wantProblems: []string{"path contains control character"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -72,9 +198,14 @@ func TestValidateLocationEntries(t *testing.T) {
 
 func TestGenerateLocationConfig(t *testing.T) {
 	tests := []struct {
-		name         string
-		entries      []webv1alpha1.LocationEntry
-		wantContains []string
+		name                   string
+		entries                []webv1alpha1.LocationEntry
+		upstreamTypes          map[string]webv1alpha1.UpstreamType
+		rateLimitPolicies      map[string]webv1alpha1.RateLimitPolicySpec
+		trafficShapingPolicies map[string]webv1alpha1.TrafficShapingPolicySpec
+		quotas                 map[string]webv1alpha1.QuotaSpec
+		wantContains           []string
+		wantMissing            []string
 	}{
 		{
 			name: "Simple proxy_pass",
@@ -106,6 +237,219 @@ func TestGenerateLocationConfig(t *testing.T) {
 				"ngx.say('Hello World')",
 			},
 		},
+		{
+			name: "User Lua composed with generated phases",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:                  "/mix/",
+					ProxyPass:             "https://example.com/api",
+					ProxyPassIsFullURL:    true,
+					EnableUpstreamMetrics: true,
+					HeadersFromSecret: []webv1alpha1.ValueFromSecret{
+						{Name: "Authorization", SecretName: "token", SecretKey: "value"},
+					},
+					Lua: &webv1alpha1.LuaBlock{
+						Rewrite:      "ngx.req.set_header(\"X-Rewrite\", \"1\")",
+						HeaderFilter: "ngx.header[\"X-Filtered\"] = \"1\"",
+						BodyFilter:   "return",
+						Log:          "ngx.log(ngx.INFO, \"done\")",
+					},
+				},
+			},
+			wantContains: []string{
+				"                ngx.req.set_header(headerName, value)\n" +
+					"            end\n" +
+					"        end\n" +
+					"        local function user_rewrite()\n" +
+					"            ngx.req.set_header(\"X-Rewrite\", \"1\")\n" +
+					"        end\n" +
+					"        user_rewrite()\n" +
+					"        require(\"upstreams.example-com.example-com\").default()\n",
+				"    header_filter_by_lua_block {\n" +
+					"        ngx.header[\"Content-Length\"] = nil\n" +
+					"        local function user_header_filter()\n",
+				"        require(\"upstreams.example-com.example-com\").normalizeResponse()\n" +
+					"        local function user_body_filter()\n" +
+					"            return\n",
+				"    log_by_lua_block {\n" +
+					"        require(\"upstreams.lb\").finish()\n" +
+					"        require(\"metrics\").record()\n" +
+					"        local function user_log()\n",
+			},
+		},
+		{
+			name: "UpstreamRef to Address upstream",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:        "/api/",
+					UpstreamRef: &webv1alpha1.UpstreamReference{Name: "backend.v1", PathPrefix: "/v1/", Scheme: "https"},
+				},
+			},
+			upstreamTypes: map[string]webv1alpha1.UpstreamType{"backend.v1": webv1alpha1.UpstreamTypeAddress},
+			wantContains:  []string{"proxy_pass https://backend-v1/v1/;"},
+			wantMissing:   []string{"rewrite_by_lua_block"},
+		},
+		{
+			name: "ProxyPass to least-connections upstream",
+			entries: []webv1alpha1.LocationEntry{
+				{Path: "/api/", ProxyPass: "http://backend/api/"},
+			},
+			upstreamTypes: map[string]webv1alpha1.UpstreamType{"backend": webv1alpha1.UpstreamTypeAddress},
+			wantContains: []string{
+				"    proxy_pass http://backend/api/;\n" +
+					"    log_by_lua_block {\n" +
+					"        require(\"upstreams.lb\").finish()\n" +
+					"    }\n",
+			},
+		},
+		{
+			name: "ProxyPass to a host that is not an Upstream",
+			entries: []webv1alpha1.LocationEntry{
+				{Path: "/ext/", ProxyPass: "http://example.com"},
+			},
+			upstreamTypes: map[string]webv1alpha1.UpstreamType{"backend": webv1alpha1.UpstreamTypeAddress},
+			wantContains:  []string{"    proxy_pass http://example.com;\n"},
+			wantMissing:   []string{"log_by_lua_block"},
+		},
+		{
+			name: "UpstreamRef to FullURL upstream",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:        "/ext/",
+					UpstreamRef: &webv1alpha1.UpstreamReference{Name: "partner-api", PathPrefix: "/v2"},
+				},
+			},
+			upstreamTypes: map[string]webv1alpha1.UpstreamType{"partner-api": webv1alpha1.UpstreamTypeFullURL},
+			wantContains: []string{
+				"set $upstream_path_prefix \"/v2\";",
+				"require(\"upstreams.partner-api.partner-api\").default()",
+				"require(\"upstreams.partner-api.partner-api\").normalizeResponse()",
+				"proxy_pass $target;",
+			},
+		},
+		{
+			name: "Unresolved UpstreamRef renders no proxy_pass",
+			entries: []webv1alpha1.LocationEntry{
+				{Path: "/gone/", UpstreamRef: &webv1alpha1.UpstreamReference{Name: "missing"}},
+			},
+			wantContains: []string{"location /gone/ {"},
+			wantMissing:  []string{"proxy_pass"},
+		},
+		{
+			name: "Static SPA from ConfigMap",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path: "/app/",
+					Static: &webv1alpha1.StaticConf{
+						ConfigMap:  &corev1.LocalObjectReference{Name: "app-bundle"},
+						SPA:        true,
+						GzipStatic: true,
+						CacheControl: []webv1alpha1.StaticCacheControl{
+							{Extensions: []string{"js", "css"}, Value: "public, max-age=31536000, immutable"},
+						},
+					},
+				},
+			},
+			wantContains: []string{
+				"alias /usr/share/nginx/static/test/0/;",
+				"index index.html;",
+				"try_files $uri $uri/ /app/index.html;",
+				"gzip_static on;",
+				"if ($uri ~* \\.(js|css)$) {",
+				"set $static_cache_control \"public, max-age=31536000, immutable\";",
+				"add_header Cache-Control $static_cache_control;",
+			},
+		},
+		{
+			name: "Static root mode",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path: "/",
+					Static: &webv1alpha1.StaticConf{
+						PersistentVolumeClaim: "site",
+						Mode:                  webv1alpha1.StaticModeRoot,
+					},
+				},
+			},
+			wantContains: []string{
+				"root /usr/share/nginx/static/test/0;",
+				"try_files $uri $uri/ =404;",
+			},
+		},
+		{
+			name: "RateLimitPolicyRef",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:               "/api/",
+					ProxyPass:          "http://backend",
+					RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "api-limit"},
+				},
+				{
+					Path:               "/login",
+					ProxyPass:          "http://backend",
+					RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "login-limit"},
+				},
+				{
+					Path:               "/missing",
+					ProxyPass:          "http://backend",
+					RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "ghost"},
+				},
+			},
+			rateLimitPolicies: map[string]webv1alpha1.RateLimitPolicySpec{
+				"api-limit":   {ZoneName: "api", Rate: "10r/s", Burst: 20, NoDelay: true},
+				"login-limit": {ZoneName: "login", Rate: "1r/s"},
+			},
+			wantContains: []string{
+				"limit_req zone=api burst=20 nodelay;",
+				"limit_req zone=login;",
+			},
+			wantMissing: []string{"ghost"},
+		},
+		{
+			name: "TrafficShapingPolicyRef",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:                    "/downloads/",
+					ProxyPass:               "http://files",
+					TrafficShapingPolicyRef: &webv1alpha1.TrafficShapingPolicyReference{Name: "downloads"},
+				},
+				{
+					Path:                    "/videos/",
+					ProxyPass:               "http://files",
+					TrafficShapingPolicyRef: &webv1alpha1.TrafficShapingPolicyReference{Name: "bandwidth-only"},
+				},
+			},
+			trafficShapingPolicies: map[string]webv1alpha1.TrafficShapingPolicySpec{
+				"downloads":      {ZoneName: "dl_conn", Connections: 2, RejectStatus: 429, DryRun: true, LimitRate: "500k", LimitRateAfter: "10m"},
+				"bandwidth-only": {LimitRate: "1m"},
+			},
+			wantContains: []string{
+				"limit_conn dl_conn 2;\n    limit_conn_status 429;\n    limit_conn_dry_run on;\n",
+				"limit_rate 500k;\n    limit_rate_after 10m;\n",
+				"limit_rate 1m;\n",
+			},
+		},
+		{
+			name: "QuotaRef",
+			entries: []webv1alpha1.LocationEntry{
+				{
+					Path:      "/api/",
+					ProxyPass: "http://backend",
+					QuotaRef:  &webv1alpha1.QuotaReference{Name: "api.plan"},
+					Lua:       &webv1alpha1.LuaBlock{Access: "ngx.ctx.checked = true"},
+				},
+				{
+					Path:      "/missing/",
+					ProxyPass: "http://backend",
+					QuotaRef:  &webv1alpha1.QuotaReference{Name: "missing"},
+				},
+			},
+			quotas: map[string]webv1alpha1.QuotaSpec{"api.plan": {Period: webv1alpha1.QuotaPeriodMonth, Limit: 1000}},
+			wantContains: []string{
+				"    access_by_lua_block {\n        require(\"quotas.api-plan.policy\"):check()\n        local function user_access()\n",
+			},
+			wantMissing: []string{"quotas.missing.policy"},
+		},
 		{
 			name:         "Empty entries",
 			entries:      []webv1alpha1.LocationEntry{},
@@ -115,11 +459,316 @@ func TestGenerateLocationConfig(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := GenerateLocationConfig("test", "test", tt.entries)
+			got := GenerateLocationConfig("test", "test", tt.entries, tt.upstreamTypes, nil, tt.rateLimitPolicies, tt.trafficShapingPolicies, tt.quotas)
 
 			for _, expect := range tt.wantContains {
 				assert.Contains(t, got, expect, "expected rendered config to contain %q", expect)
 			}
+			for _, unexpected := range tt.wantMissing {
+				assert.NotContains(t, got, unexpected, "expected rendered config not to contain %q", unexpected)
+			}
 		})
 	}
 }
+
+func TestResolveUpstreamRefs(t *testing.T) {
+	get := func(ctx context.Context, key types.NamespacedName, obj client.Object, opts ...client.GetOption) error {
+		switch key.Name {
+		case "backend":
+			obj.(*webv1alpha1.Upstream).Spec.Type = webv1alpha1.UpstreamTypeFullURL
+			obj.(*webv1alpha1.Upstream).Spec.Keepalive = &webv1alpha1.UpstreamKeepalive{Connections: 8}
+			return nil
+		case "sticky":
+			obj.(*webv1alpha1.Upstream).Spec.Type = webv1alpha1.UpstreamTypeAddress
+			obj.(*webv1alpha1.Upstream).Spec.Stickiness = &webv1alpha1.UpstreamStickiness{}
+			obj.(*webv1alpha1.Upstream).Spec.Keepalive = &webv1alpha1.UpstreamKeepalive{Connections: 8}
+			return nil
+		}
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "upstreams"}, key.Name)
+	}
+
+	entries := []webv1alpha1.LocationEntry{
+		{Path: "/a", UpstreamRef: &webv1alpha1.UpstreamReference{Name: "backend"}},
+		{Path: "/b", UpstreamRef: &webv1alpha1.UpstreamReference{Name: "backend"}},
+		{Path: "/c", UpstreamRef: &webv1alpha1.UpstreamReference{Name: "ghost"}},
+		{Path: "/d", ProxyPass: "http://static"},
+		{Path: "/e", ProxyPass: "http://sticky/api"},
+	}
+
+	result := ResolveUpstreamRefs(get, "default", entries)
+
+	assert.Equal(t, []string{"backend"}, result.Resolved)
+	assert.Equal(t, []string{"ghost"}, result.Missing, "proxyPass hosts that are not Upstreams are not missing")
+	assert.Equal(t, webv1alpha1.UpstreamTypeFullURL, result.Types["backend"])
+	assert.False(t, result.Features["backend"].Keepalive, "keepalive only applies to Address upstreams")
+	assert.True(t, result.Features["sticky"].AffinityCookie, "proxyPass to an Upstream name issues the affinity cookie")
+	assert.Equal(t, webv1alpha1.UpstreamTypeAddress, result.Types["sticky"], "proxyPass to an Upstream name goes through its balancer")
+	assert.True(t, result.Features["sticky"].Keepalive, "proxyPass to an Upstream name uses its keepalive pool")
+	assert.NotContains(t, result.Types, "static")
+}
+
+func TestGenerateLocationConfigAffinityCookie(t *testing.T) {
+	entries := []webv1alpha1.LocationEntry{
+		{
+			Path:        "/app",
+			UpstreamRef: &webv1alpha1.UpstreamReference{Name: "legacy"},
+			Lua:         &webv1alpha1.LuaBlock{HeaderFilter: "ngx.header[\"X-App\"] = \"1\""},
+		},
+		{Path: "/api", UpstreamRef: &webv1alpha1.UpstreamReference{Name: "api"}},
+	}
+	upstreamTypes := map[string]webv1alpha1.UpstreamType{
+		"legacy": webv1alpha1.UpstreamTypeFullURL,
+		"api":    webv1alpha1.UpstreamTypeAddress,
+	}
+
+	conf := GenerateLocationConfig("demo", "default", entries, upstreamTypes, map[string]UpstreamFeatures{"legacy": {AffinityCookie: true}}, nil, nil, nil)
+	assert.Contains(t, conf, "    header_filter_by_lua_block {\n"+
+		"        ngx.header[\"Content-Length\"] = nil\n"+
+		"        require(\"upstreams.sticky\").set_cookie()\n"+
+		"        local function user_header_filter()\n")
+	assert.Equal(t, 1, strings.Count(conf, "set_cookie()"))
+}
+
+func TestGenerateLocationConfigAffinityCookieProxyPass(t *testing.T) {
+	entries := []webv1alpha1.LocationEntry{
+		{Path: "/app", ProxyPass: "http://sticky"},
+		{Path: "/ext", ProxyPass: "http://legacy", ProxyPassIsFullURL: true},
+		{Path: "/other", ProxyPass: "http://example.com"},
+	}
+	features := map[string]UpstreamFeatures{"sticky": {AffinityCookie: true}, "legacy": {AffinityCookie: true}}
+
+	conf := GenerateLocationConfig("demo", "default", entries, nil, features, nil, nil, nil)
+	assert.Contains(t, conf, "location /app {\n"+
+		"    set $location_path \"/app\";\n"+
+		"    header_filter_by_lua_block {\n"+
+		"        require(\"upstreams.sticky\").set_cookie()\n"+
+		"    }\n"+
+		"    proxy_pass http://sticky;\n")
+	assert.Contains(t, conf, "        ngx.header[\"Content-Length\"] = nil\n"+
+		"        require(\"upstreams.sticky\").set_cookie()\n")
+	assert.Equal(t, 2, strings.Count(conf, "set_cookie()"))
+}
+
+func TestGenerateLocationConfigKeepalive(t *testing.T) {
+	entries := []webv1alpha1.LocationEntry{
+		{Path: "/a", UpstreamRef: &webv1alpha1.UpstreamReference{Name: "pooled"}},
+		{
+			Path:        "/ws",
+			UpstreamRef: &webv1alpha1.UpstreamReference{Name: "pooled"},
+			Headers:     []webv1alpha1.NginxKV{{Key: "connection", Value: "$connection_upgrade"}},
+			Extra:       []string{"proxy_http_version 1.0;"},
+		},
+		{Path: "/b", UpstreamRef: &webv1alpha1.UpstreamReference{Name: "plain"}},
+		{Path: "/c", ProxyPass: "http://pooled/c/"},
+	}
+	upstreamTypes := map[string]webv1alpha1.UpstreamType{
+		"pooled": webv1alpha1.UpstreamTypeAddress,
+		"plain":  webv1alpha1.UpstreamTypeAddress,
+	}
+
+	conf := GenerateLocationConfig("demo", "default", entries, upstreamTypes, map[string]UpstreamFeatures{"pooled": {Keepalive: true}}, nil, nil, nil)
+	assert.Contains(t, conf, "location /a {\n"+
+		"    set $location_path \"/a\";\n"+
+		"    proxy_pass http://pooled;\n"+
+		"    proxy_http_version 1.1;\n"+
+		"    proxy_set_header Connection \"\";\n")
+	assert.Contains(t, conf, "location /c {\n"+
+		"    set $location_path \"/c\";\n"+
+		"    proxy_pass http://pooled/c/;\n"+
+		"    proxy_http_version 1.1;\n"+
+		"    proxy_set_header Connection \"\";\n")
+	assert.Equal(t, 2, strings.Count(conf, "proxy_http_version 1.1;"), "the entry's own proxy_http_version wins")
+	assert.Equal(t, 2, strings.Count(conf, "proxy_set_header Connection \"\";"), "the entry's own Connection header wins")
+	assert.Contains(t, conf, "    proxy_pass http://plain;\n    log_by_lua_block {\n")
+}
+
+func TestGeneratedLocationLuaIsValid(t *testing.T) {
+	entries := []webv1alpha1.LocationEntry{
+		{
+			Path:               "/api/",
+			ProxyPass:          "http://example.com/api",
+			ProxyPassIsFullURL: true,
+			HeadersFromSecret: []webv1alpha1.ValueFromSecret{
+				{Name: "X-Token", SecretName: "token", SecretKey: "value"},
+			},
+			EnableUpstreamMetrics: true,
+			Lua: &webv1alpha1.LuaBlock{
+				Rewrite: "if ngx.var.arg_debug then\n  return\nend",
+				Log:     "ngx.log(ngx.INFO, 'done')",
+			},
+		},
+	}
+
+	conf := GenerateLocationConfig("demo", "default", entries, nil, nil, nil, nil, nil)
+	assert.Len(t, utils.ExtractLuaBlocks(conf), 4)
+	assert.Empty(t, utils.ValidateGeneratedLua(conf))
+
+	broken := strings.Replace(conf, "ngx.log(ngx.INFO, 'done')", "ngx.log(ngx.INFO, 'done'", 1)
+	problems := utils.ValidateGeneratedLua(broken)
+	if assert.Len(t, problems, 1) {
+		assert.Contains(t, problems[0], "log_by_lua_block: line ")
+	}
+}
+
+func FuzzGenerateLocationConfig(f *testing.F) {
+	f.Add("/api/", "prod", "http://backend")
+	f.Add("~ ^/v[0-9]{1,2}/", "a;b}c{", "http://backend/$1")
+	f.Add("= /exact", `"quoted" 'value'`, "https://example.com")
+	f.Add("^~ /static/", `\"; proxy_pass http://evil; #`, "http://$host")
+	f.Add("/api/", "$http_x_forwarded_for, ${remote_addr}", "http://backend")
+
+	f.Fuzz(func(t *testing.T, path, headerValue, proxyPass string) {
+		entries := []webv1alpha1.LocationEntry{
+			{
+				Path:              path,
+				ProxyPass:         proxyPass,
+				Headers:           []webv1alpha1.NginxKV{{Key: "X-Fuzz", Value: headerValue}},
+				HeadersFromSecret: []webv1alpha1.ValueFromSecret{{Name: "X-Token", SecretName: "s", SecretKey: "k"}},
+			},
+		}
+		if valid, _ := ValidateLocationEntries(entries); !valid {
+			return
+		}
+
+		conf := GenerateLocationConfig("fuzz", "default", entries, nil, nil, nil, nil, nil)
+
+		findings := nginxconf.Lint(conf, nginxconf.ContextServer)
+		if findings.HasErrors() {
+			t.Fatalf("rendered config has lint errors %v:\n%s", findings.Strings(), conf)
+		}
+		if problems := utils.ValidateGeneratedLua(conf); len(problems) > 0 {
+			t.Fatalf("rendered Lua is invalid %v:\n%s", problems, conf)
+		}
+
+		dirs, _ := nginxconf.Parse(conf)
+		if len(dirs) != 1 || dirs[0].Name != "location" {
+			t.Fatalf("expected a single location block:\n%s", conf)
+		}
+		values := map[string][]string{}
+		for _, d := range dirs[0].Block {
+			if _, ok := values[d.Name]; !ok {
+				values[d.Name] = d.Args
+			}
+		}
+		assert.Equal(t, []string{"X-Fuzz", headerValue}, values["proxy_set_header"])
+		if proxyPass != "" {
+			assert.Equal(t, []string{proxyPass}, values["proxy_pass"])
+		}
+		assert.Equal(t, []string{"$location_path", path}, values["set"])
+	})
+}
+
+// balancerLuaStub 模拟 upstreams.lb 用到的 ngx API 与 shared dict，在 gopher-lua 中执行 docker/openresty/lua 下的模块
+const balancerLuaStub = `
+local function newdict()
+    local d = { data = {} }
+    function d:get(k) return self.data[k] end
+    function d:set(k, v) self.data[k] = v; return true end
+    function d:delete(k) self.data[k] = nil end
+    function d:add(k, v) if self.data[k] ~= nil then return false, "exists" end self.data[k] = v; return true end
+    function d:get_keys() local ks = {} for k in pairs(self.data) do ks[#ks + 1] = k end return ks end
+    function d:incr(k, v, init)
+        local n = self.data[k]
+        if n == nil then
+            if init == nil then return nil, "not found" end
+            n = init
+        end
+        self.data[k] = n + v
+        return n + v
+    end
+    return d
+end
+ngx = {
+    shared = { upstream_balancer = newdict(), upstream_outliers = newdict(), upstream_health = newdict(),
+               upstream_dns = newdict(), secrets_store = newdict() },
+    now = function() return 1000 end,
+    time = function() return 1000 end,
+    ctx = {}, var = {}, header = {},
+    log = function() end, WARN = 1, ERR = 2,
+}
+package.preload["cjson.safe"] = function() return {} end
+package.preload["resty.dns.resolver"] = function() return {} end
+package.path = "../../docker/openresty/lua/?.lua;" .. package.path
+`
+
+// runLogPhase 模拟一个请求：balancer 阶段由 upstreams.lb 选择 server，响应后执行 Location 渲染出的 log 阶段代码
+func runLogPhase(t *testing.T, L *lua.LState, logPhase, status, headerTime string) {
+	t.Helper()
+	code := fmt.Sprintf(`
+        ngx.ctx = {}
+        require("upstreams.lb").select(servers, opts)
+        ngx.var.upstream_status = %q
+        ngx.var.upstream_header_time = %q
+    `, status, headerTime) + logPhase
+	if err := L.DoString(code); err != nil {
+		t.Fatalf("failed to run the log phase: %v", err)
+	}
+}
+
+// proxyPassLogPhase 渲染 proxyPass 到 Address upstream "backend" 的 Location，返回 log 阶段的 Lua 代码
+func proxyPassLogPhase(t *testing.T) string {
+	t.Helper()
+	entries := []webv1alpha1.LocationEntry{{Path: "/api/", ProxyPass: "http://backend"}}
+	conf := GenerateLocationConfig("demo", "default", entries,
+		map[string]webv1alpha1.UpstreamType{"backend": webv1alpha1.UpstreamTypeAddress}, nil, nil, nil, nil)
+	for _, block := range utils.ExtractLuaBlocks(conf) {
+		if block.Directive == "log_by_lua_block" {
+			return block.Code
+		}
+	}
+	t.Fatalf("no log_by_lua_block in:\n%s", conf)
+	return ""
+}
+
+func newBalancerLuaState(t *testing.T, servers, opts string) *lua.LState {
+	t.Helper()
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+	if err := L.DoString(balancerLuaStub + "servers = " + servers + "\nopts = " + opts); err != nil {
+		t.Fatalf("failed to set up Lua: %v", err)
+	}
+	return L
+}
+
+func luaBool(t *testing.T, L *lua.LState, expr string) bool {
+	t.Helper()
+	if err := L.DoString("return " + expr); err != nil {
+		t.Fatalf("failed to evaluate %s: %v", expr, err)
+	}
+	defer L.Pop(1)
+	return lua.LVAsBool(L.Get(-1))
+}
+
+func TestProxyPassLocationObservesOutliers(t *testing.T) {
+	logPhase := proxyPassLogPhase(t)
+	L := newBalancerLuaState(t,
+		`{ { host = "10.0.0.1", port = 80, max_fails = 0 } }`,
+		`{ name = "backend", algorithm = "least-connections", passive = { failures = 2, ejection = 30, max_percent = 100 } }`)
+
+	runLogPhase(t, L, logPhase, "502", "0.010")
+	assert.False(t, luaBool(t, L, `require("upstreams.outlier").ejected("backend", "10.0.0.1:80")`))
+	assert.True(t, luaBool(t, L, `require("upstreams.least_conn").count("backend", "10.0.0.1:80") == 0`),
+		"the in-flight request is released")
+
+	runLogPhase(t, L, logPhase, "502", "0.010")
+	assert.True(t, luaBool(t, L, `require("upstreams.outlier").ejected("backend", "10.0.0.1:80")`),
+		"consecutive failures of a proxyPass location eject the server")
+}
+
+func TestProxyPassLocationCountsMaxFails(t *testing.T) {
+	logPhase := proxyPassLogPhase(t)
+	L := newBalancerLuaState(t,
+		`{ { host = "10.0.0.1", port = 80, max_fails = 2, fail_timeout = 10 } }`,
+		`{ name = "backend" }`)
+
+	// 收到响应头的失败不计入 max_fails
+	runLogPhase(t, L, logPhase, "502", "0.010")
+	runLogPhase(t, L, logPhase, "502", "0.010")
+	assert.True(t, luaBool(t, L, `require("upstreams.lb").available("backend", servers[1])`))
+
+	runLogPhase(t, L, logPhase, "502", "-")
+	assert.True(t, luaBool(t, L, `require("upstreams.lb").available("backend", servers[1])`))
+	runLogPhase(t, L, logPhase, "504", "-")
+	assert.False(t, luaBool(t, L, `require("upstreams.lb").available("backend", servers[1])`),
+		"attempts without a response header count toward max_fails")
+}
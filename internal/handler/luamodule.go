@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/utils"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var luaModuleFileNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// LuaModulePath returns the require() prefix of a LuaModule, e.g. "luamodules.default.jsonutil"
+func LuaModulePath(namespace, name string) string {
+	return fmt.Sprintf("luamodules.%s.%s", namespace, name)
+}
+
+func luaModuleMountPath(namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", utils.NginxLuaLibModuleDir, namespace, name)
+}
+
+func ValidateLuaModule(module *webv1alpha1.LuaModule) (bool, []string) {
+	var problems []string
+
+	if len(module.Spec.Files) == 0 {
+		problems = append(problems, "At least one file is required")
+	}
+
+	seen := make(map[string]struct{})
+	for i, f := range module.Spec.Files {
+		if !luaModuleFileNamePattern.MatchString(f.Name) {
+			problems = append(problems, fmt.Sprintf("Invalid file name at index %d: %q", i, f.Name))
+			continue
+		}
+		if _, ok := seen[f.Name]; ok {
+			problems = append(problems, fmt.Sprintf("Duplicated file name: %s", f.Name))
+			continue
+		}
+		seen[f.Name] = struct{}{}
+		if strings.TrimSpace(f.Content) == "" {
+			problems = append(problems, fmt.Sprintf("Empty content in file: %s", f.Name))
+		} else if err := utils.ParseLua(f.Content); err != nil {
+			// 语法错误在 Pod 中 require 时才会暴露，提前拒绝
+			problems = append(problems, fmt.Sprintf("Invalid Lua in file %s: %s", f.Name, err.Error()))
+		}
+	}
+
+	for _, dep := range module.Spec.Dependencies {
+		if dep == module.Name {
+			problems = append(problems, fmt.Sprintf("Module depends on itself: %s", dep))
+		}
+	}
+
+	return len(problems) == 0, problems
+}
+
+// RenderLuaModuleFiles returns the ConfigMap data of a LuaModule, keyed by "<file>.lua"
+func RenderLuaModuleFiles(module *webv1alpha1.LuaModule) map[string]string {
+	data := make(map[string]string, len(module.Spec.Files))
+	for _, f := range module.Spec.Files {
+		data[f.Name+".lua"] = f.Content
+	}
+	return data
+}
+
+// LuaModuleHash is a stable content hash over all files of a module
+func LuaModuleHash(module *webv1alpha1.LuaModule) string {
+	files := make([]webv1alpha1.LuaModuleFile, len(module.Spec.Files))
+	copy(files, module.Spec.Files)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	h := sha256.New()
+	for _, f := range files {
+		h.Write([]byte(f.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(f.Content))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+type LuaModuleDependencies struct {
+	// Resolved is the transitive dependency list in depth-first order, excluding the module itself
+	Resolved []string
+	Missing  []string
+	// Cycle holds the dependency chain that loops back, if any
+	Cycle []string
+}
+
+func ResolveLuaModuleDependencies(get GetFunc, module *webv1alpha1.LuaModule) LuaModuleDependencies {
+	ctx := context.Background()
+	result := LuaModuleDependencies{}
+	visited := map[string]bool{module.Name: true}
+	missing := map[string]bool{}
+
+	var walk func(deps []string, chain []string)
+	walk = func(deps []string, chain []string) {
+		for _, dep := range deps {
+			if result.Cycle != nil {
+				return
+			}
+			for _, c := range chain {
+				if c == dep {
+					result.Cycle = append(append([]string{}, chain...), dep)
+					return
+				}
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+
+			var m webv1alpha1.LuaModule
+			if err := get(ctx, types.NamespacedName{Name: dep, Namespace: module.Namespace}, &m); err != nil {
+				if !missing[dep] {
+					missing[dep] = true
+					if errors.IsNotFound(err) {
+						result.Missing = append(result.Missing, dep)
+					} else {
+						result.Missing = append(result.Missing, fmt.Sprintf("%s (error: %v)", dep, err))
+					}
+				}
+				continue
+			}
+
+			result.Resolved = append(result.Resolved, dep)
+			walk(m.Spec.Dependencies, append(chain, dep))
+		}
+	}
+	walk(module.Spec.Dependencies, []string{module.Name})
+
+	return result
+}
+
+type LuaModuleRefsStatus struct {
+	AllReady         bool
+	MissingModules   []string
+	NotReadyModules  []string
+	MissingModuleCMs []string
+	// Modules is every module to mount, i.e. spec.http.luaModuleRefs plus their dependencies
+	Modules []string
+}
+
+func ValidateLuaModuleRefs(get GetFunc, app *webv1alpha1.OpenResty) LuaModuleRefsStatus {
+	ctx := context.Background()
+	status := LuaModuleRefsStatus{AllReady: true}
+	seen := make(map[string]struct{})
+
+	var visit func(name string)
+	visit = func(name string) {
+		if _, ok := seen[name]; ok {
+			return
+		}
+		seen[name] = struct{}{}
+
+		var m webv1alpha1.LuaModule
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, &m); err != nil {
+			if errors.IsNotFound(err) {
+				status.MissingModules = append(status.MissingModules, name)
+			} else {
+				status.MissingModules = append(status.MissingModules, fmt.Sprintf("%s (error: %v)", name, err))
+			}
+			status.AllReady = false
+			return
+		}
+
+		if !m.Status.Ready {
+			status.NotReadyModules = append(status.NotReadyModules, name)
+			status.AllReady = false
+			return
+		}
+
+		var cm corev1.ConfigMap
+		cmName := "luamodule-" + name
+		if err := get(ctx, types.NamespacedName{Name: cmName, Namespace: app.Namespace}, &cm); err != nil {
+			if errors.IsNotFound(err) {
+				status.MissingModuleCMs = append(status.MissingModuleCMs, cmName)
+			} else {
+				status.MissingModuleCMs = append(status.MissingModuleCMs, fmt.Sprintf("%s (error: %v)", cmName, err))
+			}
+			status.AllReady = false
+			return
+		}
+
+		status.Modules = append(status.Modules, name)
+		for _, dep := range m.Status.Dependencies {
+			visit(dep)
+		}
+	}
+
+	for _, name := range app.Spec.Http.LuaModuleRefs {
+		visit(name)
+	}
+
+	return status
+}
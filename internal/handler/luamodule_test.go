@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+func TestValidateLuaModule(t *testing.T) {
+	tests := []struct {
+		name         string
+		module       webv1alpha1.LuaModule
+		wantValid    bool
+		wantProblems []string
+	}{
+		{
+			name: "Valid module",
+			module: webv1alpha1.LuaModule{
+				ObjectMeta: metav1.ObjectMeta{Name: "utils"},
+				Spec: webv1alpha1.LuaModuleSpec{
+					Files:        []webv1alpha1.LuaModuleFile{{Name: "init", Content: "return {}"}, {Name: "json_util", Content: "return {}"}},
+					Dependencies: []string{"base"},
+				},
+			},
+			wantValid: true,
+		},
+		{
+			name:         "No files",
+			module:       webv1alpha1.LuaModule{ObjectMeta: metav1.ObjectMeta{Name: "utils"}},
+			wantValid:    false,
+			wantProblems: []string{"At least one file is required"},
+		},
+		{
+			name: "Invalid and duplicated file names",
+			module: webv1alpha1.LuaModule{
+				ObjectMeta: metav1.ObjectMeta{Name: "utils"},
+				Spec: webv1alpha1.LuaModuleSpec{
+					Files: []webv1alpha1.LuaModuleFile{
+						{Name: "a.b", Content: "return {}"},
+						{Name: "init", Content: "return {}"},
+						{Name: "init", Content: "return {}"},
+					},
+				},
+			},
+			wantValid: false,
+			wantProblems: []string{
+				`Invalid file name at index 0: "a.b"`,
+				"Duplicated file name: init",
+			},
+		},
+		{
+			name: "Empty content and self dependency",
+			module: webv1alpha1.LuaModule{
+				ObjectMeta: metav1.ObjectMeta{Name: "utils"},
+				Spec: webv1alpha1.LuaModuleSpec{
+					Files:        []webv1alpha1.LuaModuleFile{{Name: "init", Content: "  \n"}},
+					Dependencies: []string{"utils"},
+				},
+			},
+			wantValid: false,
+			wantProblems: []string{
+				"Empty content in file: init",
+				"Module depends on itself: utils",
+			},
+		},
+		{
+			name: "Lua syntax error",
+			module: webv1alpha1.LuaModule{
+				ObjectMeta: metav1.ObjectMeta{Name: "utils"},
+				Spec: webv1alpha1.LuaModuleSpec{
+					Files: []webv1alpha1.LuaModuleFile{
+						{Name: "init", Content: "local _M = {}\nfunction _M.run(\n  return 1\nend\nreturn _M\n"},
+						{Name: "ffi_util", Content: "local n = 1ULL\nreturn { n = n }\n"},
+					},
+				},
+			},
+			wantValid: false,
+			wantProblems: []string{
+				"Invalid Lua in file init: line 3, column 8 near 'return': syntax error",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, problems := ValidateLuaModule(&tt.module)
+			assert.Equal(t, tt.wantValid, valid)
+			assert.Equal(t, tt.wantProblems, problems)
+		})
+	}
+}
+
+func TestLuaModuleHash(t *testing.T) {
+	a := &webv1alpha1.LuaModule{Spec: webv1alpha1.LuaModuleSpec{Files: []webv1alpha1.LuaModuleFile{
+		{Name: "init", Content: "return 1"},
+		{Name: "util", Content: "return 2"},
+	}}}
+	reordered := &webv1alpha1.LuaModule{Spec: webv1alpha1.LuaModuleSpec{Files: []webv1alpha1.LuaModuleFile{
+		{Name: "util", Content: "return 2"},
+		{Name: "init", Content: "return 1"},
+	}}}
+	changed := &webv1alpha1.LuaModule{Spec: webv1alpha1.LuaModuleSpec{Files: []webv1alpha1.LuaModuleFile{
+		{Name: "init", Content: "return 1"},
+		{Name: "util", Content: "return 3"},
+	}}}
+
+	assert.Equal(t, LuaModuleHash(a), LuaModuleHash(reordered))
+	assert.NotEqual(t, LuaModuleHash(a), LuaModuleHash(changed))
+	assert.Equal(t, map[string]string{"init.lua": "return 1", "util.lua": "return 2"}, RenderLuaModuleFiles(a))
+}
+
+func luaModuleGetFunc(modules map[string]*webv1alpha1.LuaModule) GetFunc {
+	return func(ctx context.Context, key types.NamespacedName, obj client.Object, opts ...client.GetOption) error {
+		switch o := obj.(type) {
+		case *webv1alpha1.LuaModule:
+			m, ok := modules[key.Name]
+			if !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "luamodules"}, key.Name)
+			}
+			m.DeepCopyInto(o)
+			return nil
+		case *corev1.ConfigMap:
+			if _, ok := modules[key.Name[len("luamodule-"):]]; !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, key.Name)
+			}
+			return nil
+		}
+		return nil
+	}
+}
+
+func TestResolveLuaModuleDependencies(t *testing.T) {
+	module := func(name string, deps ...string) *webv1alpha1.LuaModule {
+		return &webv1alpha1.LuaModule{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       webv1alpha1.LuaModuleSpec{Dependencies: deps},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		modules      map[string]*webv1alpha1.LuaModule
+		root         string
+		wantResolved []string
+		wantMissing  []string
+		wantCycle    []string
+	}{
+		{
+			name: "Transitive dependencies are resolved once",
+			modules: map[string]*webv1alpha1.LuaModule{
+				"app":  module("app", "http", "json"),
+				"http": module("http", "json"),
+				"json": module("json"),
+			},
+			root:         "app",
+			wantResolved: []string{"http", "json"},
+		},
+		{
+			name: "Missing dependency",
+			modules: map[string]*webv1alpha1.LuaModule{
+				"app":  module("app", "http"),
+				"http": module("http", "ghost"),
+			},
+			root:         "app",
+			wantResolved: []string{"http"},
+			wantMissing:  []string{"ghost"},
+		},
+		{
+			name: "Dependency cycle",
+			modules: map[string]*webv1alpha1.LuaModule{
+				"app":  module("app", "http"),
+				"http": module("http", "json"),
+				"json": module("json", "app"),
+			},
+			root:         "app",
+			wantResolved: []string{"http", "json"},
+			wantCycle:    []string{"app", "http", "json", "app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := ResolveLuaModuleDependencies(luaModuleGetFunc(tt.modules), tt.modules[tt.root])
+			assert.Equal(t, tt.wantResolved, deps.Resolved)
+			assert.Equal(t, tt.wantMissing, deps.Missing)
+			assert.Equal(t, tt.wantCycle, deps.Cycle)
+		})
+	}
+}
+
+func TestValidateLuaModuleRefs(t *testing.T) {
+	modules := map[string]*webv1alpha1.LuaModule{
+		"app":     {Status: webv1alpha1.LuaModuleStatus{Ready: true, Dependencies: []string{"json"}}},
+		"json":    {Status: webv1alpha1.LuaModuleStatus{Ready: true}},
+		"pending": {Status: webv1alpha1.LuaModuleStatus{Ready: false}},
+	}
+	app := &webv1alpha1.OpenResty{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "default"},
+		Spec: webv1alpha1.OpenRestySpec{Http: &webv1alpha1.HttpBlock{
+			LuaModuleRefs: []string{"app", "json", "pending", "ghost"},
+		}},
+	}
+
+	status := ValidateLuaModuleRefs(luaModuleGetFunc(modules), app)
+
+	assert.False(t, status.AllReady)
+	assert.Equal(t, []string{"app", "json"}, status.Modules)
+	assert.Equal(t, []string{"pending"}, status.NotReadyModules)
+	assert.Equal(t, []string{"ghost"}, status.MissingModules)
+	assert.Empty(t, status.MissingModuleCMs)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	corev1 "k8s.io/api/core/v1"
 	"openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/utils"
 	"strings"
 )
 
@@ -27,7 +28,7 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 				// Try parse as string
 				var str string
 				if err := json.Unmarshal(val.Raw, &str); err == nil {
-					builder.WriteString(fmt.Sprintf("    output[%q] = utils.get(requestObj, %q)\n", key, str))
+					builder.WriteString(fmt.Sprintf("    output[%s] = utils.get(requestObj, %s)\n", utils.QuoteLua(key), utils.QuoteLua(str)))
 					continue
 				}
 
@@ -36,12 +37,12 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 				if err := json.Unmarshal(val.Raw, &obj); err == nil {
 					if luaVal, ok := obj["lua"]; ok {
 						if luaStr, ok := luaVal.(string); ok {
-							builder.WriteString(fmt.Sprintf("    output[%q] = (function()\n      %s  end)()\n", key, indentLua(luaStr, "        ")))
+							builder.WriteString(fmt.Sprintf("    output[%s] = (function()\n      %s  end)()\n", utils.QuoteLua(key), indentLua(luaStr, "        ")))
 							continue
 						}
 					}
 					if staticVal, ok := obj["value"]; ok {
-						builder.WriteString(fmt.Sprintf("    output[%q] = %q\n", key, fmt.Sprintf("%v", staticVal)))
+						builder.WriteString(fmt.Sprintf("    output[%s] = %s\n", utils.QuoteLua(key), utils.QuoteLua(fmt.Sprintf("%v", staticVal))))
 						continue
 					}
 				}
@@ -57,7 +58,7 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 				// Try parse as string
 				var str string
 				if err := json.Unmarshal(val.Raw, &str); err == nil {
-					builder.WriteString(fmt.Sprintf("    query[%q] = utils.get(requestObj, %q)\n", key, str))
+					builder.WriteString(fmt.Sprintf("    query[%s] = utils.get(requestObj, %s)\n", utils.QuoteLua(key), utils.QuoteLua(str)))
 					continue
 				}
 
@@ -66,12 +67,12 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 				if err := json.Unmarshal(val.Raw, &obj); err == nil {
 					if luaVal, ok := obj["lua"]; ok {
 						if luaStr, ok := luaVal.(string); ok {
-							builder.WriteString(fmt.Sprintf("    query[%q] = (function()\n      %s  end)()\n", key, indentLua(luaStr, "        ")))
+							builder.WriteString(fmt.Sprintf("    query[%s] = (function()\n      %s  end)()\n", utils.QuoteLua(key), indentLua(luaStr, "        ")))
 							continue
 						}
 					}
 					if staticVal, ok := obj["value"]; ok {
-						builder.WriteString(fmt.Sprintf("    query[%q] = %q\n", key, fmt.Sprintf("%v", staticVal)))
+						builder.WriteString(fmt.Sprintf("    query[%s] = %s\n", utils.QuoteLua(key), utils.QuoteLua(fmt.Sprintf("%v", staticVal))))
 						continue
 					}
 				}
@@ -86,7 +87,7 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 					continue
 				}
 				if b64, ok := secret.Data[val.SecretKey]; ok {
-					builder.WriteString(fmt.Sprintf("    query[%q] = %q\n", val.Name, string(b64)))
+					builder.WriteString(fmt.Sprintf("    query[%s] = %s\n", utils.QuoteLua(val.Name), utils.QuoteLua(string(b64))))
 				} else {
 					builder.WriteString(fmt.Sprintf("    -- key %q not found in secret %q\n", val.SecretKey, val.SecretName))
 				}
@@ -99,8 +100,8 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 			builder.WriteString("    end\n")
 		}
 
-		for headerKey, val := range rule.Spec.Request.Headers {
-			builder.WriteString(fmt.Sprintf("    ngx.req.set_header(%q, %q)\n", headerKey, val.Value))
+		for _, val := range rule.Spec.Request.Headers {
+			builder.WriteString(fmt.Sprintf("    ngx.req.set_header(%s, %s)\n", utils.QuoteLua(val.Key), utils.QuoteLua(val.Value)))
 		}
 
 		for _, val := range rule.Spec.Request.HeadersFromSecret {
@@ -110,7 +111,7 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 				continue
 			}
 			if b64, ok := secret.Data[val.SecretKey]; ok {
-				builder.WriteString(fmt.Sprintf("    ngx.req.set_header(%q, %q)\n", val.Name, string(b64)))
+				builder.WriteString(fmt.Sprintf("    ngx.req.set_header(%s, %s)\n", utils.QuoteLua(val.Name), utils.QuoteLua(string(b64))))
 			} else {
 				builder.WriteString(fmt.Sprintf("    -- key %q not found in secret %q\n", val.SecretKey, val.SecretName))
 			}
@@ -143,7 +144,7 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 			// Try parse as string
 			var str string
 			if err := json.Unmarshal(val.Raw, &str); err == nil {
-				builder.WriteString(fmt.Sprintf("    output[%q] = utils.get(responseObj, %q)\n", key, str))
+				builder.WriteString(fmt.Sprintf("    output[%s] = utils.get(responseObj, %s)\n", utils.QuoteLua(key), utils.QuoteLua(str)))
 				continue
 			}
 
@@ -152,7 +153,7 @@ func RenderNormalizeRuleLua(rule *v1alpha1.NormalizeRule, getSecretFunc func(ns,
 			if err := json.Unmarshal(val.Raw, &obj); err == nil {
 				if luaVal, ok := obj["lua"]; ok {
 					if luaStr, ok := luaVal.(string); ok {
-						builder.WriteString(fmt.Sprintf("    output[%q] = (function()\n      %s  end)()\n", key, indentLua(luaStr, "        ")))
+						builder.WriteString(fmt.Sprintf("    output[%s] = (function()\n      %s  end)()\n", utils.QuoteLua(key), indentLua(luaStr, "        ")))
 						continue
 					}
 				}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/utils"
+	"testing"
+)
+
+func TestNormalizeRuleLuaSyntax(t *testing.T) {
+	tests := []struct {
+		name         string
+		rule         webv1alpha1.NormalizeRule
+		wantProblems []string
+	}{
+		{
+			name: "Valid mappings and lua",
+			rule: webv1alpha1.NormalizeRule{Spec: webv1alpha1.NormalizeRuleSpec{
+				Request: &webv1alpha1.RequestSpec{
+					Body: map[string]apiextensionsv1.JSON{
+						"orderNo": {Raw: []byte(`"$.order.id"`)},
+						"amount":  {Raw: []byte(`{"lua": "return string.format(\"%.2f\", requestObj.amount)"}`)},
+					},
+					Query: map[string]apiextensionsv1.JSON{
+						"sign": {Raw: []byte(`{"value": "static"}`)},
+					},
+				},
+				Response: map[string]apiextensionsv1.JSON{
+					"code": {Raw: []byte(`{"lua": "if responseObj.ok then\n  return 0\nend\nreturn 1"}`)},
+				},
+			}},
+		},
+		{
+			name: "Broken lua reported with field and line",
+			rule: webv1alpha1.NormalizeRule{Spec: webv1alpha1.NormalizeRuleSpec{
+				Request: &webv1alpha1.RequestSpec{
+					Body: map[string]apiextensionsv1.JSON{
+						"amount": {Raw: []byte(`{"lua": "local a = 1\nreturn a +"}`)},
+					},
+				},
+				Response: map[string]apiextensionsv1.JSON{
+					"code": {Raw: []byte(`{"lua": "return end"}`)},
+				},
+			}},
+			wantProblems: []string{
+				"spec.request.body[amount]: line 2: unexpected end of script: syntax error",
+				"spec.response[code]: line 1, column 10 near 'end': syntax error",
+			},
+		},
+	}
+
+	noSecret := func(ns, name string) (*corev1.Secret, error) { return nil, nil }
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantProblems, utils.ValidateNormalizeRuleLua(&tt.rule))
+
+			if len(tt.wantProblems) == 0 {
+				lua := RenderNormalizeRuleLua(&tt.rule, noSecret)
+				assert.Nil(t, utils.ParseLua(lua), lua)
+			}
+		})
+	}
+}
+
+func FuzzRenderNormalizeRuleLua(f *testing.F) {
+	f.Add("user_id", "data.user.id", "static", "X-Api-Key", "secret")
+	f.Add(`a"]=os.exit()--`, "a\nb", `\" .. os.exit() .. "`, "X-\x00", "\xff\xfe")
+	f.Add("名字", "数据.名字", "值\t]]", "X-Trace", "--[[ ]] \\")
+
+	f.Fuzz(func(t *testing.T, key, path, value, headerName, secretValue string) {
+		rule := &webv1alpha1.NormalizeRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "fuzz", Namespace: "default"},
+			Spec: webv1alpha1.NormalizeRuleSpec{
+				Request: &webv1alpha1.RequestSpec{
+					Body: map[string]apiextensionsv1.JSON{
+						key:          {Raw: mustJSON(t, path)},
+						key + "_val": {Raw: mustJSON(t, map[string]string{"value": value})},
+					},
+					Query: map[string]apiextensionsv1.JSON{
+						key: {Raw: mustJSON(t, map[string]string{"value": value})},
+					},
+					QueryFromSecret:   []webv1alpha1.ValueFromSecret{{Name: key, SecretName: "s", SecretKey: "k"}},
+					Headers:           []webv1alpha1.NginxKV{{Key: headerName, Value: value}},
+					HeadersFromSecret: []webv1alpha1.ValueFromSecret{{Name: headerName, SecretName: "s", SecretKey: "k"}},
+				},
+				Response: map[string]apiextensionsv1.JSON{
+					key: {Raw: mustJSON(t, path)},
+				},
+			},
+		}
+		getSecret := func(ns, name string) (*corev1.Secret, error) {
+			return &corev1.Secret{Data: map[string][]byte{"k": []byte(secretValue)}}, nil
+		}
+
+		code := RenderNormalizeRuleLua(rule, getSecret)
+		if err := utils.ParseLua(code); err != nil {
+			t.Fatalf("rendered Lua is invalid: %v\n%s", err, code)
+		}
+	})
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
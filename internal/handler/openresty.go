@@ -7,6 +7,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"net"
 	webv1alpha1 "openresty-operator/api/v1alpha1"
 	"openresty-operator/internal/runtime/metrics"
 	"openresty-operator/internal/template"
@@ -30,7 +31,9 @@ type UpstreamRefsStatus struct {
 	MissingUpstreams   []string
 	NotReadyUpstreams  []string
 	MissingUpstreamCMs []string
-	UpstreamsType      map[string]webv1alpha1.UpstreamType
+	// UnattachedUpstreams lists Upstreams referenced by Locations but not by spec.http.upstreamRefs
+	UnattachedUpstreams []string
+	UpstreamsType       map[string]webv1alpha1.UpstreamType
 }
 
 func ValidateServerRefs(get GetFunc, app *webv1alpha1.OpenResty) ServerRefsStatus {
@@ -112,10 +115,33 @@ func ValidateUpstreamRefs(get GetFunc, app *webv1alpha1.OpenResty) UpstreamRefsS
 		}
 	}
 
+	// Locations 通过 upstreamRef 引用的 Upstream 必须挂载到当前 OpenResty
+	attached := utils.SetFrom(app.Spec.Http.UpstreamRefs)
+	for _, serverName := range app.Spec.Http.ServerRefs {
+		var srv webv1alpha1.ServerBlock
+		if err := get(ctx, types.NamespacedName{Name: serverName, Namespace: app.Namespace}, &srv); err != nil {
+			// 缺失的 ServerBlock 已由 ValidateServerRefs 报告
+			continue
+		}
+		for _, name := range srv.Status.UpstreamRefs {
+			if _, ok := attached[name]; !ok {
+				status.UnattachedUpstreams = append(status.UnattachedUpstreams, fmt.Sprintf("%s (from %s)", name, serverName))
+				status.AllReady = false
+			}
+		}
+	}
+
 	return status
 }
 
-func ComposeDependencyFailureReason(serverStatus ServerRefsStatus, upstreamStatus UpstreamRefsStatus) string {
+func ComposeDependencyFailureReason(
+	serverStatus ServerRefsStatus,
+	upstreamStatus UpstreamRefsStatus,
+	luaModuleStatus LuaModuleRefsStatus,
+	rateLimitStatus RateLimitPolicyRefsStatus,
+	trafficShapingStatus TrafficShapingPolicyRefsStatus,
+	quotaStatus QuotaRefsStatus,
+) string {
 	var parts []string
 
 	if len(serverStatus.MissingServers) > 0 {
@@ -137,6 +163,64 @@ func ComposeDependencyFailureReason(serverStatus ServerRefsStatus, upstreamStatu
 	if len(upstreamStatus.MissingUpstreamCMs) > 0 {
 		parts = append(parts, fmt.Sprintf("Missing Upstream ConfigMaps: %s", strings.Join(upstreamStatus.MissingUpstreamCMs, ", ")))
 	}
+	if len(upstreamStatus.UnattachedUpstreams) > 0 {
+		parts = append(parts, fmt.Sprintf("Unattached Upstreams: %s", strings.Join(upstreamStatus.UnattachedUpstreams, ", ")))
+	}
+
+	if len(luaModuleStatus.MissingModules) > 0 {
+		parts = append(parts, fmt.Sprintf("Missing LuaModules: %s", strings.Join(luaModuleStatus.MissingModules, ", ")))
+	}
+	if len(luaModuleStatus.NotReadyModules) > 0 {
+		parts = append(parts, fmt.Sprintf("NotReady LuaModules: %s", strings.Join(luaModuleStatus.NotReadyModules, ", ")))
+	}
+	if len(luaModuleStatus.MissingModuleCMs) > 0 {
+		parts = append(parts, fmt.Sprintf("Missing LuaModule ConfigMaps: %s", strings.Join(luaModuleStatus.MissingModuleCMs, ", ")))
+	}
+
+	if len(rateLimitStatus.MissingPolicies) > 0 {
+		parts = append(parts, fmt.Sprintf("Missing RateLimitPolicies: %s", strings.Join(rateLimitStatus.MissingPolicies, ", ")))
+	}
+	if len(rateLimitStatus.NotReadyPolicies) > 0 {
+		parts = append(parts, fmt.Sprintf("NotReady RateLimitPolicies: %s", strings.Join(rateLimitStatus.NotReadyPolicies, ", ")))
+	}
+	if len(rateLimitStatus.MissingPolicyCMs) > 0 {
+		parts = append(parts, fmt.Sprintf("Missing RateLimitPolicy ConfigMaps: %s", strings.Join(rateLimitStatus.MissingPolicyCMs, ", ")))
+	}
+	if len(rateLimitStatus.DuplicatedZones) > 0 {
+		parts = append(parts, fmt.Sprintf("Duplicated limit_req zones: %s", strings.Join(rateLimitStatus.DuplicatedZones, ", ")))
+	}
+	if len(rateLimitStatus.UnattachedPolicies) > 0 {
+		parts = append(parts, fmt.Sprintf("Unattached RateLimitPolicies: %s", strings.Join(rateLimitStatus.UnattachedPolicies, ", ")))
+	}
+
+	if len(trafficShapingStatus.MissingPolicies) > 0 {
+		parts = append(parts, fmt.Sprintf("Missing TrafficShapingPolicies: %s", strings.Join(trafficShapingStatus.MissingPolicies, ", ")))
+	}
+	if len(trafficShapingStatus.NotReadyPolicies) > 0 {
+		parts = append(parts, fmt.Sprintf("NotReady TrafficShapingPolicies: %s", strings.Join(trafficShapingStatus.NotReadyPolicies, ", ")))
+	}
+	if len(trafficShapingStatus.MissingPolicyCMs) > 0 {
+		parts = append(parts, fmt.Sprintf("Missing TrafficShapingPolicy ConfigMaps: %s", strings.Join(trafficShapingStatus.MissingPolicyCMs, ", ")))
+	}
+	if len(trafficShapingStatus.DuplicatedZones) > 0 {
+		parts = append(parts, fmt.Sprintf("Duplicated limit_conn zones: %s", strings.Join(trafficShapingStatus.DuplicatedZones, ", ")))
+	}
+	if len(trafficShapingStatus.UnattachedPolicies) > 0 {
+		parts = append(parts, fmt.Sprintf("Unattached TrafficShapingPolicies: %s", strings.Join(trafficShapingStatus.UnattachedPolicies, ", ")))
+	}
+
+	if len(quotaStatus.MissingQuotas) > 0 {
+		parts = append(parts, fmt.Sprintf("Missing Quotas: %s", strings.Join(quotaStatus.MissingQuotas, ", ")))
+	}
+	if len(quotaStatus.NotReadyQuotas) > 0 {
+		parts = append(parts, fmt.Sprintf("NotReady Quotas: %s", strings.Join(quotaStatus.NotReadyQuotas, ", ")))
+	}
+	if len(quotaStatus.MissingQuotaCMs) > 0 {
+		parts = append(parts, fmt.Sprintf("Missing Quota ConfigMaps: %s", strings.Join(quotaStatus.MissingQuotaCMs, ", ")))
+	}
+	if len(quotaStatus.UnattachedQuotas) > 0 {
+		parts = append(parts, fmt.Sprintf("Unattached Quotas: %s", strings.Join(quotaStatus.UnattachedQuotas, ", ")))
+	}
 
 	if len(parts) == 0 {
 		return "Unknown dependency error"
@@ -144,9 +228,56 @@ func ComposeDependencyFailureReason(serverStatus ServerRefsStatus, upstreamStatu
 	return strings.Join(parts, " | ")
 }
 
+// ValidateHttpBlock checks the values rendered verbatim into nginx.conf
+func ValidateHttpBlock(http *webv1alpha1.HttpBlock, metrics *webv1alpha1.MetricsServer) (bool, []string) {
+	var problems []string
+	check := func(field, value string, validate func(string) error) {
+		if value == "" {
+			return
+		}
+		if err := validate(value); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid %s: %s", field, err.Error()))
+		}
+	}
+
+	for _, include := range http.Include {
+		check("include", include, utils.ValidateNginxValue)
+	}
+	check("logFormat", utils.SanitizeLogFormat(http.LogFormat), utils.ValidateNginxValue)
+	check("accessLog", http.AccessLog, utils.ValidateNginxArgs)
+	check("errorLog", http.ErrorLog, utils.ValidateNginxArgs)
+	check("clientMaxBodySize", http.ClientMaxBodySize, utils.ValidateNginxToken)
+	if http.Resolver != nil {
+		for _, address := range http.Resolver.Addresses {
+			check("resolver.addresses", address, utils.ValidateNginxToken)
+		}
+	}
+	if metrics != nil && metrics.Enable {
+		check("metrics.listen", metrics.Listen, utils.ValidateNginxArgs)
+		check("metrics.path", metrics.Path, utils.ValidateNginxValue)
+	}
+
+	return len(problems) == 0, problems
+}
+
 func BuildIncludeLines(app *webv1alpha1.OpenResty, upstreamStatus UpstreamRefsStatus) []string {
 	var lines []string
 
+	for _, name := range app.Spec.Http.RateLimitPolicyRefs {
+		line := fmt.Sprintf("include %s/%s/%s.conf;", utils.NginxRateLimitConfigDir, name, name)
+		lines = append(lines, line)
+	}
+
+	for _, name := range app.Spec.Http.TrafficShapingPolicyRefs {
+		line := fmt.Sprintf("include %s/%s/%s.conf;", utils.NginxTrafficShapingDir, name, name)
+		lines = append(lines, line)
+	}
+
+	for _, name := range app.Spec.Http.QuotaRefs {
+		line := fmt.Sprintf("include %s/%s/%s.conf;", utils.NginxQuotaConfigDir, name, name)
+		lines = append(lines, line)
+	}
+
 	for _, name := range app.Spec.Http.ServerRefs {
 		line := fmt.Sprintf("include %s/%s/%s.conf;", utils.NginxServerConfigDir, name, name)
 		lines = append(lines, line)
@@ -164,9 +295,12 @@ func BuildIncludeLines(app *webv1alpha1.OpenResty, upstreamStatus UpstreamRefsSt
 
 type nginxConfData struct {
 	InitLua           string
+	Resolver          string
+	ResolverTimeout   int
 	EnableMetrics     bool
 	MetricsPort       string
 	MetricsPath       string
+	StatusPort        int
 	Includes          []string
 	LogFormat         string
 	AccessLog         string
@@ -178,13 +312,31 @@ type nginxConfData struct {
 }
 
 func RenderNginxConf(http *webv1alpha1.HttpBlock, metrics *webv1alpha1.MetricsServer, includeLines []string) string {
+	includes := make([]string, 0, len(http.Include))
+	for _, include := range http.Include {
+		includes = append(includes, utils.NginxArg(include))
+	}
+
+	logFormat := utils.SanitizeLogFormat(http.LogFormat)
+	if logFormat != "" {
+		logFormat = utils.QuoteNginx(logFormat)
+	}
+
+	resolver := http.Resolver
+	if resolver == nil {
+		resolver = &webv1alpha1.Resolver{}
+	}
+
 	data := nginxConfData{
-		InitLua:           template.DefaultInitLua,
+		InitLua:           template.DefaultInitLua + renderResolverLua(resolver),
+		Resolver:          renderResolver(resolver),
+		ResolverTimeout:   resolver.TimeoutSeconds,
 		EnableMetrics:     metrics != nil && metrics.Enable,
 		MetricsPort:       defaultOr(metrics.Listen, "9091"),
-		MetricsPath:       defaultOr(metrics.Path, "/metrics"),
-		Includes:          http.Include,
-		LogFormat:         utils.SanitizeLogFormat(http.LogFormat),
+		MetricsPath:       utils.NginxArg(defaultOr(metrics.Path, "/metrics")),
+		StatusPort:        utils.NginxStatusPort,
+		Includes:          includes,
+		LogFormat:         logFormat,
 		AccessLog:         http.AccessLog,
 		ErrorLog:          http.ErrorLog,
 		ClientMaxBodySize: http.ClientMaxBodySize,
@@ -212,9 +364,47 @@ func RenderNginxConf(http *webv1alpha1.HttpBlock, metrics *webv1alpha1.MetricsSe
 	return buf.String()
 }
 
+// renderResolver 渲染 resolver 指令的参数
+func renderResolver(resolver *webv1alpha1.Resolver) string {
+	addresses := resolver.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{utils.DefaultResolver}
+	}
+	return fmt.Sprintf("%s valid=%ds", strings.Join(addresses, " "), defaultInt(resolver.ValidSeconds, 30))
+}
+
+// renderResolverLua configures the name servers of upstreams.dns. lua-resty-dns only accepts IPs, without any
+// the module falls back to /etc/resolv.conf.
+func renderResolverLua(resolver *webv1alpha1.Resolver) string {
+	var nameservers []string
+	for _, address := range resolver.Addresses {
+		host, port := address, "53"
+		if h, p, err := net.SplitHostPort(address); err == nil {
+			host, port = h, p
+		}
+		if net.ParseIP(strings.Trim(host, "[]")) == nil || utils.ValidatePort(port) != nil {
+			continue
+		}
+		nameservers = append(nameservers, fmt.Sprintf("{ %s, %s }", utils.QuoteLua(strings.Trim(host, "[]")), port))
+	}
+	list := "{}"
+	if len(nameservers) > 0 {
+		list = "{ " + strings.Join(nameservers, ", ") + " }"
+	}
+	return fmt.Sprintf("require(\"upstreams.dns\").configure({ nameservers = %s, timeout = %d })\n",
+		list, defaultInt(resolver.TimeoutSeconds, 2))
+}
+
 func defaultOr(s, fallback string) string {
 	if s == "" {
 		return fallback
 	}
 	return s
 }
+
+func defaultInt(v, fallback int) int {
+	if v == 0 {
+		return fallback
+	}
+	return v
+}
@@ -1 +1,134 @@
 package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/nginxconf"
+	"openresty-operator/internal/utils"
+	"testing"
+)
+
+func TestValidateHttpBlock(t *testing.T) {
+	tests := []struct {
+		name         string
+		http         webv1alpha1.HttpBlock
+		wantValid    bool
+		wantProblems []string
+	}{
+		{
+			name: "Valid http block",
+			http: webv1alpha1.HttpBlock{
+				Include:           []string{"mime.types"},
+				LogFormat:         "$remote_addr - [$time_local] \"$request\"\n$status",
+				AccessLog:         "/var/log/nginx/access.log main",
+				ClientMaxBodySize: "10m",
+			},
+			wantValid: true,
+		},
+		{
+			name: "Injected values",
+			http: webv1alpha1.HttpBlock{
+				AccessLog:         "off; include /etc/passwd",
+				ClientMaxBodySize: "10m }",
+			},
+			wantValid: false,
+			wantProblems: []string{
+				`Invalid accessLog: must not contain quotes or ';', '{', '}', '#', '\'`,
+				"Invalid clientMaxBodySize: must be a single value without spaces, quotes or ';', '{', '}', '#'",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, problems := ValidateHttpBlock(&tt.http, nil)
+			assert.Equal(t, tt.wantValid, valid)
+			assert.Equal(t, tt.wantProblems, problems)
+		})
+	}
+}
+
+func TestRenderNginxConfResolver(t *testing.T) {
+	tests := []struct {
+		name      string
+		resolver  *webv1alpha1.Resolver
+		wantParts []string
+	}{
+		{
+			name: "Default resolver",
+			wantParts: []string{
+				"    resolver kube-dns.kube-system.svc.cluster.local valid=30s;\n",
+				`require("upstreams.dns").configure({ nameservers = {}, timeout = 2 })`,
+			},
+		},
+		{
+			name: "Custom resolver",
+			resolver: &webv1alpha1.Resolver{
+				Addresses:      []string{"10.96.0.10", "[fd00::10]:5353", "dns.example.com"},
+				ValidSeconds:   10,
+				TimeoutSeconds: 3,
+			},
+			wantParts: []string{
+				"    resolver 10.96.0.10 [fd00::10]:5353 dns.example.com valid=10s;\n    resolver_timeout 3s;\n",
+				`require("upstreams.dns").configure({ nameservers = { { "10.96.0.10", 53 }, { "fd00::10", 5353 } }, timeout = 3 })`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := RenderNginxConf(&webv1alpha1.HttpBlock{Resolver: tt.resolver}, &webv1alpha1.MetricsServer{}, nil)
+			for _, part := range tt.wantParts {
+				assert.Contains(t, conf, part)
+			}
+			assert.False(t, nginxconf.Lint(conf, nginxconf.ContextMain).HasErrors())
+		})
+	}
+
+	valid, problems := ValidateHttpBlock(&webv1alpha1.HttpBlock{
+		Resolver: &webv1alpha1.Resolver{Addresses: []string{"10.96.0.10; include /etc/passwd"}},
+	}, nil)
+	assert.False(t, valid)
+	assert.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "Invalid resolver.addresses")
+}
+
+func FuzzRenderNginxConf(f *testing.F) {
+	f.Add(`$remote_addr [$time_local] "$request"`, "mime.types", "/metrics")
+	f.Add(`'single' "double" \ ; } {`, "conf.d/*.conf", "/m;etrics }")
+	f.Add("$a\n$b", "a b", "/stats")
+
+	f.Fuzz(func(t *testing.T, logFormat, include, metricsPath string) {
+		http := &webv1alpha1.HttpBlock{Include: []string{include}, LogFormat: logFormat}
+		metrics := &webv1alpha1.MetricsServer{Enable: true, Listen: "9090", Path: metricsPath}
+		if valid, _ := ValidateHttpBlock(http, metrics); !valid {
+			return
+		}
+
+		conf := RenderNginxConf(http, metrics, nil)
+		findings := nginxconf.Lint(conf, nginxconf.ContextMain)
+		if findings.HasErrors() {
+			t.Fatalf("rendered config has lint errors %v:\n%s", findings.Strings(), conf)
+		}
+
+		dirs, _ := nginxconf.Parse(conf)
+		for _, d := range dirs {
+			if d.Name != "http" {
+				continue
+			}
+			for _, child := range d.Block {
+				switch child.Name {
+				case "log_format":
+					assert.Equal(t, []string{"main", utils.SanitizeLogFormat(logFormat)}, child.Args)
+				case "include":
+					assert.Equal(t, []string{include}, child.Args)
+				case "server":
+					// 只检查 metrics server，另一个是 NginxStatusPort 上的状态接口
+					if child.Block[0].Args[0] == "9090" {
+						assert.Equal(t, []string{defaultOr(metricsPath, "/metrics")}, child.Block[1].Args)
+					}
+				}
+			}
+		}
+	})
+}
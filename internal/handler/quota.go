@@ -0,0 +1,400 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/runtime/metrics"
+	"openresty-operator/internal/template"
+	"openresty-operator/internal/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuotaLuaKey is the ConfigMap key of the Lua module enforcing a Quota
+const QuotaLuaKey = "policy.lua"
+
+// QuotaConfigMapName returns the ConfigMap holding the counter dictionary and Lua module of a Quota
+func QuotaConfigMapName(name string) string {
+	return "quota-" + name
+}
+
+// QuotaLuaModule returns the require() name of the Lua module of a Quota
+func QuotaLuaModule(name string) string {
+	return "quotas." + utils.SanitizeName(name) + ".policy"
+}
+
+func quotaLuaMountPath(name string) string {
+	return utils.NginxLuaLibQuotaDir + "/" + utils.SanitizeName(name)
+}
+
+func quotaSecretMountPath(name string) string {
+	return utils.NginxQuotaSecretDir + "/" + name
+}
+
+// quotaDict is the lua_shared_dict caching the counters of a Quota
+func quotaDict(name string) string {
+	return "quota_" + strings.ReplaceAll(utils.SanitizeName(name), "-", "_")
+}
+
+// quotaClientVariable is the variable holding the client address resolved from X-Forwarded-For
+func quotaClientVariable(name string) string {
+	return "quota_client_" + strings.ReplaceAll(utils.SanitizeName(name), "-", "_")
+}
+
+// QuotaKeyPrefix returns the prefix of the Redis counters of a Quota, followed by "{<consumer>}:<period>"
+func QuotaKeyPrefix(quota *webv1alpha1.Quota) string {
+	return defaultOr(quota.Spec.Redis.KeyPrefix, fmt.Sprintf("quota:%s:%s:", quota.Namespace, quota.Name))
+}
+
+// QuotaPeriodID identifies the calendar period containing now, in UTC
+func QuotaPeriodID(period webv1alpha1.QuotaPeriod, now time.Time) string {
+	if period == webv1alpha1.QuotaPeriodMonth {
+		return now.UTC().Format("200601")
+	}
+	return now.UTC().Format("20060102")
+}
+
+func quotaLimit(spec webv1alpha1.QuotaSpec, consumer string) int64 {
+	for _, o := range spec.Overrides {
+		if o.Consumer == consumer {
+			return o.Limit
+		}
+	}
+	return spec.Limit
+}
+
+// hasQuotaIdentity reports whether the consumer identifies the caller, clientIP and route alone only tell
+// where a request comes from or goes to
+func hasQuotaIdentity(sources []webv1alpha1.RateLimitKeySource) bool {
+	for _, source := range sources {
+		switch source.Type {
+		case webv1alpha1.RateLimitKeySourceHeader, webv1alpha1.RateLimitKeySourceCookie, webv1alpha1.RateLimitKeySourceQuery:
+			return true
+		case webv1alpha1.RateLimitKeySourceJWTClaim:
+			if source.Authenticated {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ValidateQuota(quota *webv1alpha1.Quota) (bool, []string) {
+	var problems []string
+	spec := quota.Spec
+
+	if len(spec.Consumer) == 0 {
+		problems = append(problems, "consumer requires at least one source")
+	}
+	problems = append(problems, validateKeySources("consumer", spec.Consumer)...)
+	// 配额按调用方计费，伪造的 JWT claim 可以使用其它调用方的配额或绕过自己的配额
+	problems = append(problems, validateJWTClaimsAuthenticated("consumer", spec.Consumer)...)
+	if len(spec.Consumer) > 0 && !hasQuotaIdentity(spec.Consumer) {
+		problems = append(problems, "consumer requires an identity source: an API key header, cookie or query argument, or an authenticated jwtClaim")
+	}
+
+	switch spec.Period {
+	case webv1alpha1.QuotaPeriodDay, webv1alpha1.QuotaPeriodMonth:
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid period: %q (day or month)", spec.Period))
+	}
+	if spec.Limit < 1 {
+		problems = append(problems, fmt.Sprintf("Invalid limit: %d (must be greater than 0)", spec.Limit))
+	}
+
+	seen := make(map[string]struct{})
+	for i, o := range spec.Overrides {
+		if o.Consumer == "" {
+			problems = append(problems, fmt.Sprintf("Invalid overrides[%d].consumer: cannot be empty", i))
+		} else if _, ok := seen[o.Consumer]; ok {
+			problems = append(problems, fmt.Sprintf("Duplicate overrides[%d].consumer: %q", i, o.Consumer))
+		}
+		seen[o.Consumer] = struct{}{}
+		if o.Limit < 1 {
+			problems = append(problems, fmt.Sprintf("Invalid overrides[%d].limit: %d (must be greater than 0)", i, o.Limit))
+		}
+	}
+
+	switch spec.Overage {
+	case "", webv1alpha1.QuotaOverageReject, webv1alpha1.QuotaOverageAllow:
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid overage: %q", spec.Overage))
+	}
+	if spec.RejectStatus != 0 && (spec.RejectStatus < 400 || spec.RejectStatus > 599) {
+		problems = append(problems, fmt.Sprintf("Invalid rejectStatus: %d (must be between 400 and 599)", spec.RejectStatus))
+	}
+	if spec.Redis.SecretName == "" {
+		problems = append(problems, "redis.secretName is required")
+	}
+	if spec.Redis.TimeoutMs < 0 {
+		problems = append(problems, fmt.Sprintf("Invalid redis.timeoutMs: %d", spec.Redis.TimeoutMs))
+	}
+	if spec.Redis.PoolSize < 0 {
+		problems = append(problems, fmt.Sprintf("Invalid redis.poolSize: %d", spec.Redis.PoolSize))
+	}
+	if spec.SyncIntervalSeconds < 0 {
+		problems = append(problems, fmt.Sprintf("Invalid syncIntervalSeconds: %d", spec.SyncIntervalSeconds))
+	}
+	if spec.DictSize != "" && !rateLimitZoneSizePattern.MatchString(spec.DictSize) {
+		problems = append(problems, fmt.Sprintf("Invalid dictSize: %q (expected e.g. \"10m\")", spec.DictSize))
+	}
+
+	return len(problems) == 0, problems
+}
+
+// GenerateQuotaConfig renders the http-level lua_shared_dict counting the requests of a Quota,
+// preceded by the map resolving the client address when consumers are identified by a trusted clientIP
+func GenerateQuotaConfig(quota *webv1alpha1.Quota) string {
+	conf := renderTrustedClientMap(quota.Spec.Consumer, quotaClientVariable(quota.Name))
+	return conf + fmt.Sprintf("lua_shared_dict %s %s;\n", quotaDict(quota.Name), defaultOr(quota.Spec.DictSize, "10m"))
+}
+
+// GenerateQuotaLua renders the Lua module of a Quota, loaded by the quota.quota library of the OpenResty
+// image. secretVersion is the resourceVersion of the Redis Secret, so that a rotated Secret changes the
+// module and reloads OpenResty.
+func GenerateQuotaLua(quota *webv1alpha1.Quota, secretVersion string) string {
+	var b strings.Builder
+	spec := quota.Spec
+
+	b.WriteString(fmt.Sprintf("-- Quota %s/%s, redis secret %s (version %s)\n", quota.Namespace, quota.Name, spec.Redis.SecretName, secretVersion))
+	b.WriteString("return require(\"quota.quota\").new({\n")
+	b.WriteString(fmt.Sprintf("    name = %s,\n", utils.QuoteLua(quota.Namespace+"/"+quota.Name)))
+	b.WriteString(fmt.Sprintf("    dict = %s,\n", utils.QuoteLua(quotaDict(quota.Name))))
+	b.WriteString("    consumer = {\n")
+	b.WriteString(renderKeySourceParts(spec.Consumer, quotaClientVariable(quota.Name), "        "))
+	b.WriteString("    },\n")
+	b.WriteString(fmt.Sprintf("    period = %s,\n", utils.QuoteLua(string(spec.Period))))
+	b.WriteString(fmt.Sprintf("    limit = %d,\n", spec.Limit))
+	b.WriteString("    overrides = {\n")
+	for _, o := range spec.Overrides {
+		b.WriteString(fmt.Sprintf("        [%s] = %d,\n", utils.QuoteLua(o.Consumer), o.Limit))
+	}
+	b.WriteString("    },\n")
+	b.WriteString(fmt.Sprintf("    overage = %s,\n", utils.QuoteLua(defaultOr(string(spec.Overage), string(webv1alpha1.QuotaOverageReject)))))
+	b.WriteString(fmt.Sprintf("    status = %d,\n", defaultInt(spec.RejectStatus, 429)))
+	b.WriteString(fmt.Sprintf("    keyPrefix = %s,\n", utils.QuoteLua(QuotaKeyPrefix(quota))))
+	b.WriteString(fmt.Sprintf("    syncInterval = %d,\n", defaultInt(spec.SyncIntervalSeconds, 5)))
+	b.WriteString("    redis = {\n")
+	b.WriteString(fmt.Sprintf("        secretDir = %s,\n", utils.QuoteLua(quotaSecretMountPath(quota.Name))))
+	b.WriteString(fmt.Sprintf("        timeout = %d,\n", defaultInt(spec.Redis.TimeoutMs, 100)))
+	b.WriteString(fmt.Sprintf("        poolSize = %d,\n", defaultInt(spec.Redis.PoolSize, 32)))
+	b.WriteString("    },\n")
+	b.WriteString("    script = [==[\n")
+	b.WriteString(template.QuotaFlushScript)
+	b.WriteString("]==],\n")
+	b.WriteString("})\n")
+
+	return b.String()
+}
+
+// renderQuotaCheck renders the access phase Lua counting a request against a Quota
+func renderQuotaCheck(quotaName string) string {
+	return fmt.Sprintf("require(%s):check()\n", utils.QuoteLua(QuotaLuaModule(quotaName)))
+}
+
+// QuotaRefsResult 记录 Location 中 quotaRef 的解析结果
+type QuotaRefsResult struct {
+	// Quotas maps each resolved Quota name to its spec
+	Quotas map[string]webv1alpha1.QuotaSpec
+	// Resolved lists the resolved Quota names in order of first reference
+	Resolved []string
+	// Missing lists the referenced Quotas that could not be found
+	Missing []string
+}
+
+func ResolveQuotaRefs(get GetFunc, namespace string, entries []webv1alpha1.LocationEntry) QuotaRefsResult {
+	ctx := context.Background()
+	result := QuotaRefsResult{Quotas: make(map[string]webv1alpha1.QuotaSpec)}
+	seen := make(map[string]struct{})
+
+	for _, entry := range entries {
+		if entry.QuotaRef == nil {
+			continue
+		}
+		name := entry.QuotaRef.Name
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		var quota webv1alpha1.Quota
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &quota); err != nil {
+			if errors.IsNotFound(err) {
+				result.Missing = append(result.Missing, name)
+			} else {
+				result.Missing = append(result.Missing, fmt.Sprintf("%s (error: %v)", name, err))
+			}
+			continue
+		}
+
+		result.Quotas[name] = quota.Spec
+		result.Resolved = append(result.Resolved, name)
+	}
+
+	return result
+}
+
+type QuotaRefsStatus struct {
+	AllReady        bool
+	MissingQuotas   []string
+	NotReadyQuotas  []string
+	MissingQuotaCMs []string
+	// UnattachedQuotas lists Quotas referenced by Locations but not by spec.http.quotaRefs
+	UnattachedQuotas []string
+}
+
+func ValidateQuotaRefs(get GetFunc, app *webv1alpha1.OpenResty) QuotaRefsStatus {
+	ctx := context.Background()
+	status := QuotaRefsStatus{AllReady: true}
+
+	for _, name := range app.Spec.Http.QuotaRefs {
+		var quota webv1alpha1.Quota
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, &quota); err != nil {
+			if errors.IsNotFound(err) {
+				status.MissingQuotas = append(status.MissingQuotas, name)
+			} else {
+				status.MissingQuotas = append(status.MissingQuotas, fmt.Sprintf("%s (error: %v)", name, err))
+			}
+			status.AllReady = false
+			continue
+		}
+
+		metrics.SetCRDRefStatus(app.Namespace, app.Name, quota.Kind, quota.Name, quota.Status.Ready)
+
+		if !quota.Status.Ready {
+			status.NotReadyQuotas = append(status.NotReadyQuotas, name)
+			status.AllReady = false
+			continue
+		}
+
+		var cm corev1.ConfigMap
+		cmName := QuotaConfigMapName(name)
+		if err := get(ctx, types.NamespacedName{Name: cmName, Namespace: app.Namespace}, &cm); err != nil {
+			if errors.IsNotFound(err) {
+				status.MissingQuotaCMs = append(status.MissingQuotaCMs, cmName)
+			} else {
+				status.MissingQuotaCMs = append(status.MissingQuotaCMs, fmt.Sprintf("%s (error: %v)", cmName, err))
+			}
+			status.AllReady = false
+		}
+	}
+
+	// Locations 通过 quotaRef 使用的计数 dict 必须在当前 OpenResty 的 http 块中定义
+	attached := utils.SetFrom(app.Spec.Http.QuotaRefs)
+	for _, serverName := range app.Spec.Http.ServerRefs {
+		var srv webv1alpha1.ServerBlock
+		if err := get(ctx, types.NamespacedName{Name: serverName, Namespace: app.Namespace}, &srv); err != nil {
+			// 缺失的 ServerBlock 已由 ValidateServerRefs 报告
+			continue
+		}
+		for _, name := range srv.Status.QuotaRefs {
+			if _, ok := attached[name]; !ok {
+				status.UnattachedQuotas = append(status.UnattachedQuotas, fmt.Sprintf("%s (from %s)", name, serverName))
+				status.AllReady = false
+			}
+		}
+	}
+
+	return status
+}
+
+// DialQuotaRedis connects to the Redis described by the Secret of a Quota to read its usage
+func DialQuotaRedis(secret *corev1.Secret, timeout time.Duration) (redis.Conn, error) {
+	if err := ValidateRateLimitRedisSecret(secret); err != nil {
+		return nil, err
+	}
+
+	value := func(key string) string {
+		return strings.TrimSpace(string(secret.Data[key]))
+	}
+	port := defaultOr(value("port"), "6379")
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(timeout),
+		redis.DialReadTimeout(timeout),
+		redis.DialWriteTimeout(timeout),
+		redis.DialUsername(value("username")),
+		redis.DialPassword(value("password")),
+	}
+	if db := value("database"); db != "" {
+		n, _ := strconv.Atoi(db)
+		options = append(options, redis.DialDatabase(n))
+	}
+
+	return redis.Dial("tcp", net.JoinHostPort(value("host"), port), options...)
+}
+
+// QuotaUsage summarizes the counters of a Quota in one period
+type QuotaUsage struct {
+	Period string
+	// Consumers lists the usage of every consumer, highest first
+	Consumers []webv1alpha1.QuotaConsumerUsage
+	// Exceeded is the number of consumers that used up their quota
+	Exceeded int
+}
+
+// redisGlobEscaper escapes the characters SCAN MATCH treats as a pattern
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// CollectQuotaUsage reads the counters of the period containing now. The counters only include requests
+// already flushed by the OpenResty pods, i.e. lag up to syncIntervalSeconds behind.
+func CollectQuotaUsage(conn redis.Conn, quota *webv1alpha1.Quota, now time.Time) (QuotaUsage, error) {
+	usage := QuotaUsage{Period: QuotaPeriodID(quota.Spec.Period, now)}
+	prefix := QuotaKeyPrefix(quota) + "{"
+	suffix := "}:" + usage.Period
+	match := redisGlobEscaper.Replace(prefix) + "*" + redisGlobEscaper.Replace(suffix)
+
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", match, "COUNT", 1000))
+		if err != nil {
+			return usage, err
+		}
+		cursor, _ = redis.String(values[0], nil)
+		keys, _ := redis.Strings(values[1], nil)
+
+		if len(keys) > 0 {
+			args := make([]interface{}, len(keys))
+			for i, key := range keys {
+				args[i] = key
+			}
+			totals, err := redis.Values(conn.Do("MGET", args...))
+			if err != nil {
+				return usage, err
+			}
+			for i, key := range keys {
+				used, err := redis.Int64(totals[i], nil)
+				if err != nil {
+					// 扫描与读取之间过期的 key
+					continue
+				}
+				consumer := strings.TrimSuffix(strings.TrimPrefix(key, prefix), suffix)
+				limit := quotaLimit(quota.Spec, consumer)
+				usage.Consumers = append(usage.Consumers, webv1alpha1.QuotaConsumerUsage{Consumer: consumer, Used: used, Limit: limit})
+				if used >= limit {
+					usage.Exceeded++
+				}
+			}
+		}
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	sort.SliceStable(usage.Consumers, func(i, j int) bool {
+		if usage.Consumers[i].Used != usage.Consumers[j].Used {
+			return usage.Consumers[i].Used > usage.Consumers[j].Used
+		}
+		return usage.Consumers[i].Consumer < usage.Consumers[j].Consumer
+	})
+
+	return usage, nil
+}
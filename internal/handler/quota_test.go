@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"context"
+	"github.com/alicebob/miniredis"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/nginxconf"
+	"openresty-operator/internal/template"
+	"openresty-operator/internal/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateQuota(t *testing.T) {
+	valid := webv1alpha1.QuotaSpec{
+		Consumer: []webv1alpha1.RateLimitKeySource{{Type: webv1alpha1.RateLimitKeySourceHeader, Name: "X-Api-Key"}},
+		Period:   webv1alpha1.QuotaPeriodMonth,
+		Limit:    1000,
+		Redis:    webv1alpha1.RedisConnection{SecretName: "redis"},
+	}
+
+	tests := []struct {
+		name         string
+		mutate       func(spec *webv1alpha1.QuotaSpec)
+		wantProblems []string
+	}{
+		{
+			name:   "Valid quota",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {},
+		},
+		{
+			name: "Valid with overrides and allow",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {
+				spec.Overrides = []webv1alpha1.QuotaOverride{{Consumer: "partner", Limit: 5000}}
+				spec.Overage = webv1alpha1.QuotaOverageAllow
+				spec.Period = webv1alpha1.QuotaPeriodDay
+				spec.DictSize = "20m"
+			},
+		},
+		{
+			name: "Missing consumer, period and limit",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {
+				spec.Consumer = nil
+				spec.Period = "week"
+				spec.Limit = 0
+			},
+			wantProblems: []string{
+				"consumer requires at least one source",
+				"Invalid period",
+				"Invalid limit",
+			},
+		},
+		{
+			name: "Invalid consumer source",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {
+				spec.Consumer = []webv1alpha1.RateLimitKeySource{{Type: webv1alpha1.RateLimitKeySourceCookie, Name: "my-cookie"}}
+			},
+			wantProblems: []string{"Invalid consumer[0].name"},
+		},
+		{
+			name: "Unauthenticated JWT claim",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {
+				spec.Consumer = []webv1alpha1.RateLimitKeySource{{Type: webv1alpha1.RateLimitKeySourceJWTClaim, Name: "sub"}}
+			},
+			wantProblems: []string{
+				"Invalid consumer[0]: the jwtClaim signature is not verified",
+				"consumer requires an identity source",
+			},
+		},
+		{
+			name: "Authenticated JWT claim",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {
+				spec.Consumer = []webv1alpha1.RateLimitKeySource{{Type: webv1alpha1.RateLimitKeySourceJWTClaim, Name: "sub", Authenticated: true}}
+			},
+		},
+		{
+			name: "No identity",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {
+				spec.Consumer = []webv1alpha1.RateLimitKeySource{
+					{Type: webv1alpha1.RateLimitKeySourceClientIP},
+					{Type: webv1alpha1.RateLimitKeySourceRoute},
+				}
+			},
+			wantProblems: []string{"consumer requires an identity source"},
+		},
+		{
+			name: "Invalid overrides",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {
+				spec.Overrides = []webv1alpha1.QuotaOverride{
+					{Consumer: "partner", Limit: 5000},
+					{Consumer: "partner", Limit: 0},
+					{Limit: 10},
+				}
+			},
+			wantProblems: []string{
+				"Duplicate overrides[1].consumer",
+				"Invalid overrides[1].limit",
+				"Invalid overrides[2].consumer",
+			},
+		},
+		{
+			name: "Invalid options",
+			mutate: func(spec *webv1alpha1.QuotaSpec) {
+				spec.Overage = "queue"
+				spec.RejectStatus = 302
+				spec.Redis = webv1alpha1.RedisConnection{TimeoutMs: -1}
+				spec.SyncIntervalSeconds = -5
+				spec.DictSize = "lots"
+			},
+			wantProblems: []string{
+				"Invalid overage",
+				"Invalid rejectStatus",
+				"redis.secretName is required",
+				"Invalid redis.timeoutMs",
+				"Invalid syncIntervalSeconds",
+				"Invalid dictSize",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := valid
+			tt.mutate(&spec)
+			ok, problems := ValidateQuota(&webv1alpha1.Quota{Spec: spec})
+
+			assert.Equal(t, len(tt.wantProblems) == 0, ok, problems)
+			for _, expected := range tt.wantProblems {
+				assert.Contains(t, strings.Join(problems, " | "), expected)
+			}
+		})
+	}
+}
+
+func TestGenerateQuota(t *testing.T) {
+	quota := &webv1alpha1.Quota{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-plan", Namespace: "default"},
+		Spec: webv1alpha1.QuotaSpec{
+			Consumer: []webv1alpha1.RateLimitKeySource{
+				{Type: webv1alpha1.RateLimitKeySourceJWTClaim, Name: "sub", Authenticated: true},
+				{Type: webv1alpha1.RateLimitKeySourceClientIP, TrustedHops: 1},
+			},
+			Period:    webv1alpha1.QuotaPeriodMonth,
+			Limit:     1000,
+			Overrides: []webv1alpha1.QuotaOverride{{Consumer: `acme"corp`, Limit: 5000}},
+			Redis:     webv1alpha1.RedisConnection{SecretName: "redis"},
+		},
+	}
+
+	conf := GenerateQuotaConfig(quota)
+	assert.Equal(t, `map $http_x_forwarded_for $quota_client_api_plan {
+    "~(?:^|,)\\s*([^,\\s]+)\\s*$" $1;
+    default $remote_addr;
+}
+lua_shared_dict quota_api_plan 10m;
+`, conf)
+	assert.Empty(t, nginxconf.Lint(conf, nginxconf.ContextHTTP).Strings())
+
+	lua := GenerateQuotaLua(quota, "42")
+	assert.Nil(t, utils.ParseLua(lua))
+	for _, expected := range []string{
+		"-- Quota default/api-plan, redis secret redis (version 42)\n",
+		"return require(\"quota.quota\").new({\n",
+		`    dict = "quota_api_plan",`,
+		"    consumer = {\n        { header = \"http_authorization\", claim = \"sub\" },\n        { var = \"quota_client_api_plan\" },\n    },\n",
+		`    period = "month",`,
+		"    limit = 1000,\n",
+		`        ["acme\"corp"] = 5000,`,
+		`    overage = "reject",`,
+		"    status = 429,\n",
+		`    keyPrefix = "quota:default:api-plan:",`,
+		"    syncInterval = 5,\n",
+		`        secretDir = "/etc/nginx/secrets/quotas/api-plan",`,
+		template.QuotaFlushScript,
+	} {
+		assert.Contains(t, lua, expected)
+	}
+
+	assert.Equal(t, "require(\"quotas.api-plan.policy\"):check()\n", renderQuotaCheck("api-plan"))
+}
+
+func TestQuotaFlushScript(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := redigo.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	expireAt := time.Now().Add(time.Hour).Unix()
+	flush := func(delta int64) int64 {
+		total, err := redigo.Int64(conn.Do("EVAL", template.QuotaFlushScript, 1, "quota:{acme}:202510", delta, expireAt))
+		if err != nil {
+			t.Fatalf("EVAL failed: %v", err)
+		}
+		return total
+	}
+
+	// 多个副本的增量累加到同一个计数
+	assert.Equal(t, int64(3), flush(3))
+	assert.Equal(t, int64(10), flush(7))
+	assert.InDelta(t, time.Hour.Seconds(), s.TTL("quota:{acme}:202510").Seconds(), 5)
+}
+
+func TestCollectQuotaUsage(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.RequireAuth("secret")
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "redis"},
+		Data: map[string][]byte{
+			"host":     []byte(s.Host()),
+			"port":     []byte(s.Port() + "\n"),
+			"password": []byte("secret"),
+		},
+	}
+	conn, err := DialQuotaRedis(secret, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	quota := &webv1alpha1.Quota{
+		ObjectMeta: metav1.ObjectMeta{Name: "plan", Namespace: "default"},
+		Spec: webv1alpha1.QuotaSpec{
+			Period:    webv1alpha1.QuotaPeriodDay,
+			Limit:     100,
+			Overrides: []webv1alpha1.QuotaOverride{{Consumer: "partner", Limit: 1000}},
+		},
+	}
+	for key, value := range map[string]string{
+		"quota:default:plan:{alice}:20251019":       "40",
+		"quota:default:plan:{bob}:20251019":         "100",
+		"quota:default:plan:{partner}:20251019":     "500",
+		"quota:default:plan:{1.2.3.4:*}:20251019":   "7",
+		"quota:default:plan:{alice}:20251018":       "90",
+		"quota:default:plan-b:{alice}:20251019":     "3",
+		"quota:default:planetary:{carol}:20251019":  "5",
+		"quota:default:plan:{dave}:20251019:backup": "1",
+	} {
+		if err := s.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := CollectQuotaUsage(conn, quota, time.Date(2025, 10, 19, 23, 30, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "20251019", usage.Period)
+	assert.Equal(t, []webv1alpha1.QuotaConsumerUsage{
+		{Consumer: "partner", Used: 500, Limit: 1000},
+		{Consumer: "bob", Used: 100, Limit: 100},
+		{Consumer: "alice", Used: 40, Limit: 100},
+		{Consumer: "1.2.3.4:*", Used: 7, Limit: 100},
+	}, usage.Consumers)
+	assert.Equal(t, 1, usage.Exceeded)
+
+	// 月度周期使用 UTC 的自然月
+	quota.Spec.Period = webv1alpha1.QuotaPeriodMonth
+	assert.Equal(t, "202510", QuotaPeriodID(quota.Spec.Period, time.Date(2025, 11, 1, 1, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))))
+	usage, err = CollectQuotaUsage(conn, quota, time.Date(2025, 10, 19, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Empty(t, usage.Consumers)
+
+	secret.Data["password"] = []byte("wrong")
+	_, err = DialQuotaRedis(secret, time.Second)
+	assert.Error(t, err)
+}
+
+func TestValidateQuotaRefs(t *testing.T) {
+	quotas := map[string]*webv1alpha1.Quota{
+		"plan":    {Status: webv1alpha1.QuotaStatus{Ready: true}},
+		"pending": {Status: webv1alpha1.QuotaStatus{Ready: false}},
+		"partner": {Status: webv1alpha1.QuotaStatus{Ready: true}},
+	}
+	servers := map[string]*webv1alpha1.ServerBlock{
+		"web": {Status: webv1alpha1.ServerBlockStatus{QuotaRefs: []string{"plan", "partner"}}},
+	}
+	get := func(ctx context.Context, key types.NamespacedName, obj client.Object, opts ...client.GetOption) error {
+		switch o := obj.(type) {
+		case *webv1alpha1.Quota:
+			q, ok := quotas[key.Name]
+			if !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "quotas"}, key.Name)
+			}
+			q.DeepCopyInto(o)
+		case *webv1alpha1.ServerBlock:
+			servers[key.Name].DeepCopyInto(o)
+		case *corev1.ConfigMap:
+			if _, ok := quotas[strings.TrimPrefix(key.Name, "quota-")]; !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, key.Name)
+			}
+		}
+		return nil
+	}
+
+	app := &webv1alpha1.OpenResty{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "default"},
+		Spec: webv1alpha1.OpenRestySpec{Http: &webv1alpha1.HttpBlock{
+			ServerRefs: []string{"web"},
+			QuotaRefs:  []string{"plan", "pending", "ghost"},
+		}},
+	}
+
+	status := ValidateQuotaRefs(get, app)
+
+	assert.False(t, status.AllReady)
+	assert.Equal(t, []string{"ghost"}, status.MissingQuotas)
+	assert.Equal(t, []string{"pending"}, status.NotReadyQuotas)
+	assert.Equal(t, []string{"partner (from web)"}, status.UnattachedQuotas)
+
+	app.Spec.Http.QuotaRefs = []string{"plan", "partner"}
+	status = ValidateQuotaRefs(get, app)
+	assert.True(t, status.AllReady)
+	assert.Contains(t, BuildIncludeLines(app, UpstreamRefsStatus{}), "include /etc/nginx/conf.d/quotas/plan/plan.conf;")
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"fmt"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/utils"
+	"regexp"
+	"strings"
+)
+
+var (
+	rateLimitKeyNamePattern  = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	rateLimitHeaderPattern   = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	rateLimitJWTClaimPattern = regexp.MustCompile(`^[A-Za-z0-9_:-]+(\.[A-Za-z0-9_:-]+)*$`)
+)
+
+// nginx 变量名只允许字母、数字和下划线
+func rateLimitVariableSuffix(spec webv1alpha1.RateLimitPolicySpec) string {
+	return strings.ReplaceAll(spec.ZoneName, "-", "_")
+}
+
+// rateLimitKeyVariable is the variable holding the key built from keySources
+func rateLimitKeyVariable(spec webv1alpha1.RateLimitPolicySpec) string {
+	return "ratelimit_key_" + rateLimitVariableSuffix(spec)
+}
+
+// rateLimitClientVariable is the variable holding the client address resolved from X-Forwarded-For
+func rateLimitClientVariable(spec webv1alpha1.RateLimitPolicySpec) string {
+	return "ratelimit_client_" + rateLimitVariableSuffix(spec)
+}
+
+// rateLimitKey returns the key expression of a policy as used by limit_req_zone
+func rateLimitKey(spec webv1alpha1.RateLimitPolicySpec) string {
+	if len(spec.KeySources) > 0 {
+		return "$" + rateLimitKeyVariable(spec)
+	}
+	return defaultOr(spec.Key, "$binary_remote_addr")
+}
+
+// headerVariable returns the nginx variable of a request header, e.g. "X-Tenant" -> "http_x_tenant"
+func headerVariable(name string) string {
+	return "http_" + strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+}
+
+// rateLimitKeyNeedsLua reports whether a source cannot be expressed with nginx variables
+func rateLimitKeyNeedsLua(spec webv1alpha1.RateLimitPolicySpec) bool {
+	for _, source := range spec.KeySources {
+		if source.Type == webv1alpha1.RateLimitKeySourceJWTClaim {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRateLimitKeySources(spec webv1alpha1.RateLimitPolicySpec) []string {
+	var problems []string
+
+	if len(spec.KeySources) > 0 && spec.Key != "" {
+		problems = append(problems, "key and keySources cannot be set together")
+	}
+
+	problems = append(problems, validateKeySources("keySources", spec.KeySources)...)
+	return append(problems, validateJWTClaimsAuthenticated("keySources", spec.KeySources)...)
+}
+
+// validateJWTClaimsAuthenticated refuses jwtClaim sources not declared authenticated: the claim is read without
+// verifying the token signature
+func validateJWTClaimsAuthenticated(field string, sources []webv1alpha1.RateLimitKeySource) []string {
+	var problems []string
+	for i, source := range sources {
+		if source.Type == webv1alpha1.RateLimitKeySourceJWTClaim && !source.Authenticated {
+			problems = append(problems, fmt.Sprintf("Invalid %s[%d]: the jwtClaim signature is not verified, "+
+				"set authenticated once requests are authenticated before OpenResty", field, i))
+		}
+	}
+	return problems
+}
+
+// validateKeySources checks the sources of a RateLimitPolicy key or a Quota consumer, field names the list in messages
+func validateKeySources(field string, sources []webv1alpha1.RateLimitKeySource) []string {
+	var problems []string
+
+	for i, source := range sources {
+		field := fmt.Sprintf("%s[%d]", field, i)
+
+		switch source.Type {
+		case webv1alpha1.RateLimitKeySourceHeader:
+			if !rateLimitHeaderPattern.MatchString(source.Name) {
+				problems = append(problems, fmt.Sprintf("Invalid %s.name: %q (header name required)", field, source.Name))
+			}
+		case webv1alpha1.RateLimitKeySourceCookie, webv1alpha1.RateLimitKeySourceQuery:
+			// $cookie_<name> / $arg_<name> 只能引用由字母、数字和下划线组成的名字
+			if !rateLimitKeyNamePattern.MatchString(source.Name) {
+				problems = append(problems, fmt.Sprintf("Invalid %s.name: %q (letters, digits and '_' only)", field, source.Name))
+			}
+		case webv1alpha1.RateLimitKeySourceJWTClaim:
+			if !rateLimitJWTClaimPattern.MatchString(source.Name) {
+				problems = append(problems, fmt.Sprintf("Invalid %s.name: %q (claim name required, nested claims separated by '.')", field, source.Name))
+			}
+			if source.TokenHeader != "" && !rateLimitHeaderPattern.MatchString(source.TokenHeader) {
+				problems = append(problems, fmt.Sprintf("Invalid %s.tokenHeader: %q", field, source.TokenHeader))
+			}
+		case webv1alpha1.RateLimitKeySourceClientIP, webv1alpha1.RateLimitKeySourceRoute:
+			if source.Name != "" {
+				problems = append(problems, fmt.Sprintf("Invalid %s: name is not used by %s", field, source.Type))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("Invalid %s.type: %q", field, source.Type))
+			continue
+		}
+
+		if source.TrustedHops < 0 {
+			problems = append(problems, fmt.Sprintf("Invalid %s.trustedHops: %d", field, source.TrustedHops))
+		} else if source.TrustedHops > 0 && source.Type != webv1alpha1.RateLimitKeySourceClientIP {
+			problems = append(problems, fmt.Sprintf("Invalid %s: trustedHops only applies to clientIP", field))
+		}
+		if source.TokenHeader != "" && source.Type != webv1alpha1.RateLimitKeySourceJWTClaim {
+			problems = append(problems, fmt.Sprintf("Invalid %s: tokenHeader only applies to jwtClaim", field))
+		}
+		if source.Authenticated && source.Type != webv1alpha1.RateLimitKeySourceJWTClaim {
+			problems = append(problems, fmt.Sprintf("Invalid %s: authenticated only applies to jwtClaim", field))
+		}
+	}
+
+	return problems
+}
+
+// rateLimitKeyParts returns the nginx variable of each source, jwtClaim sources are resolved in Lua
+func rateLimitKeyParts(spec webv1alpha1.RateLimitPolicySpec) []string {
+	return keySourceVariables(spec.KeySources, rateLimitClientVariable(spec))
+}
+
+// keySourceVariables returns the nginx variable of each source, clientVariable holds the client address
+// resolved from X-Forwarded-For
+func keySourceVariables(sources []webv1alpha1.RateLimitKeySource, clientVariable string) []string {
+	parts := make([]string, 0, len(sources))
+	for _, source := range sources {
+		switch source.Type {
+		case webv1alpha1.RateLimitKeySourceClientIP:
+			if source.TrustedHops > 0 {
+				parts = append(parts, clientVariable)
+			} else {
+				parts = append(parts, "remote_addr")
+			}
+		case webv1alpha1.RateLimitKeySourceHeader:
+			parts = append(parts, headerVariable(source.Name))
+		case webv1alpha1.RateLimitKeySourceCookie:
+			parts = append(parts, "cookie_"+source.Name)
+		case webv1alpha1.RateLimitKeySourceQuery:
+			parts = append(parts, "arg_"+source.Name)
+		case webv1alpha1.RateLimitKeySourceRoute:
+			parts = append(parts, "location_path")
+		default:
+			parts = append(parts, "")
+		}
+	}
+	return parts
+}
+
+// renderTrustedClientMap renders the map resolving the client address behind the trusted proxies of a clientIP source
+func renderTrustedClientMap(sources []webv1alpha1.RateLimitKeySource, clientVariable string) string {
+	for _, source := range sources {
+		if source.Type != webv1alpha1.RateLimitKeySourceClientIP || source.TrustedHops == 0 {
+			continue
+		}
+
+		// 第 N 个可信代理追加的地址即客户端地址，X-Forwarded-For 条目不足时退回到连接地址
+		pattern := `~(?:^|,)\s*([^,\s]+)\s*`
+		if source.TrustedHops > 1 {
+			pattern += fmt.Sprintf("(?:,[^,]*){%d}", source.TrustedHops-1)
+		}
+		pattern += "$"
+
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("map $http_x_forwarded_for $%s {\n", clientVariable))
+		b.WriteString(fmt.Sprintf("    %s $1;\n", utils.QuoteNginx(pattern)))
+		b.WriteString("    default $remote_addr;\n")
+		b.WriteString("}\n")
+		return b.String()
+	}
+	return ""
+}
+
+// renderKeySourceParts renders the Lua table entries passed to ratelimit.key build(), one line per source
+func renderKeySourceParts(sources []webv1alpha1.RateLimitKeySource, clientVariable, indent string) string {
+	var b strings.Builder
+	vars := keySourceVariables(sources, clientVariable)
+	for i, source := range sources {
+		if source.Type == webv1alpha1.RateLimitKeySourceJWTClaim {
+			header := headerVariable(defaultOr(source.TokenHeader, "Authorization"))
+			b.WriteString(fmt.Sprintf("%s{ header = %s, claim = %s },\n", indent, utils.QuoteLua(header), utils.QuoteLua(source.Name)))
+		} else {
+			b.WriteString(fmt.Sprintf("%s{ var = %s },\n", indent, utils.QuoteLua(vars[i])))
+		}
+	}
+	return b.String()
+}
+
+// GenerateRateLimitKeyConfig renders the http-level maps computing the key of a policy with keySources.
+// The client address is taken from X-Forwarded-For when trusted proxies are in front of OpenResty.
+// Keys using a jwtClaim are declared here and set in each Location by renderRateLimitKey.
+func GenerateRateLimitKeyConfig(spec webv1alpha1.RateLimitPolicySpec) string {
+	if len(spec.KeySources) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(renderTrustedClientMap(spec.KeySources, rateLimitClientVariable(spec)))
+
+	value := `""`
+	if !rateLimitKeyNeedsLua(spec) {
+		vars := rateLimitKeyParts(spec)
+		for i := range vars {
+			vars[i] = "$" + vars[i]
+		}
+		value = utils.QuoteNginx(strings.Join(vars, ":"))
+	}
+	b.WriteString(fmt.Sprintf("map $host $%s {\n", rateLimitKeyVariable(spec)))
+	b.WriteString(fmt.Sprintf("    default %s;\n", value))
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// renderRateLimitKey renders the location-level set_by_lua_block building a key that uses a jwtClaim
+func renderRateLimitKey(spec webv1alpha1.RateLimitPolicySpec) string {
+	if !rateLimitKeyNeedsLua(spec) {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("    set_by_lua_block $%s {\n", rateLimitKeyVariable(spec)))
+	b.WriteString("        return require(\"ratelimit.key\").build({\n")
+	b.WriteString(renderKeySourceParts(spec.KeySources, rateLimitClientVariable(spec), "            "))
+	b.WriteString("        })\n")
+	b.WriteString("    }\n")
+
+	return b.String()
+}
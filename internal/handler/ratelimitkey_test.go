@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/nginxconf"
+	"openresty-operator/internal/utils"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidateRateLimitKeySources(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		sources      []webv1alpha1.RateLimitKeySource
+		wantProblems []string
+	}{
+		{
+			name: "Valid sources",
+			sources: []webv1alpha1.RateLimitKeySource{
+				{Type: webv1alpha1.RateLimitKeySourceClientIP, TrustedHops: 2},
+				{Type: webv1alpha1.RateLimitKeySourceHeader, Name: "X-Tenant-Id"},
+				{Type: webv1alpha1.RateLimitKeySourceCookie, Name: "session_id"},
+				{Type: webv1alpha1.RateLimitKeySourceQuery, Name: "api_key"},
+				{Type: webv1alpha1.RateLimitKeySourceRoute},
+				{Type: webv1alpha1.RateLimitKeySourceJWTClaim, Name: "tenant.id", TokenHeader: "X-Token", Authenticated: true},
+			},
+		},
+		{
+			name: "Unauthenticated JWT claim",
+			sources: []webv1alpha1.RateLimitKeySource{
+				{Type: webv1alpha1.RateLimitKeySourceClientIP},
+				{Type: webv1alpha1.RateLimitKeySourceJWTClaim, Name: "sub"},
+			},
+			wantProblems: []string{"Invalid keySources[1]: the jwtClaim signature is not verified"},
+		},
+		{
+			name:         "Key and sources",
+			key:          "$remote_addr",
+			sources:      []webv1alpha1.RateLimitKeySource{{Type: webv1alpha1.RateLimitKeySourceRoute}},
+			wantProblems: []string{"key and keySources cannot be set together"},
+		},
+		{
+			name: "Missing or invalid names",
+			sources: []webv1alpha1.RateLimitKeySource{
+				{Type: webv1alpha1.RateLimitKeySourceHeader},
+				{Type: webv1alpha1.RateLimitKeySourceCookie, Name: "my-cookie"},
+				{Type: webv1alpha1.RateLimitKeySourceQuery, Name: "a;b"},
+				{Type: webv1alpha1.RateLimitKeySourceJWTClaim, Name: "tenant..id", Authenticated: true},
+				{Type: webv1alpha1.RateLimitKeySourceRoute, Name: "web"},
+			},
+			wantProblems: []string{
+				"Invalid keySources[0].name",
+				"Invalid keySources[1].name",
+				"Invalid keySources[2].name",
+				"Invalid keySources[3].name",
+				"Invalid keySources[4]: name is not used by route",
+			},
+		},
+		{
+			name: "Misplaced options",
+			sources: []webv1alpha1.RateLimitKeySource{
+				{Type: webv1alpha1.RateLimitKeySourceHeader, Name: "X-Tenant", TrustedHops: 1},
+				{Type: webv1alpha1.RateLimitKeySourceClientIP, TokenHeader: "X-Token"},
+				{Type: "path"},
+				{Type: webv1alpha1.RateLimitKeySourceHeader, Name: "X-Api-Key", Authenticated: true},
+			},
+			wantProblems: []string{
+				"Invalid keySources[0]: trustedHops only applies to clientIP",
+				"Invalid keySources[1]: tokenHeader only applies to jwtClaim",
+				"Invalid keySources[2].type",
+				"Invalid keySources[3]: authenticated only applies to jwtClaim",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "10r/s", Key: tt.key, KeySources: tt.sources}
+			valid, problems := ValidateRateLimitPolicy(&webv1alpha1.RateLimitPolicy{Spec: spec})
+
+			assert.Equal(t, len(tt.wantProblems) == 0, valid, problems)
+			for _, expected := range tt.wantProblems {
+				assert.Contains(t, strings.Join(problems, " | "), expected)
+			}
+		})
+	}
+}
+
+func TestGenerateRateLimitKeyConfig(t *testing.T) {
+	policy := &webv1alpha1.RateLimitPolicy{Spec: webv1alpha1.RateLimitPolicySpec{
+		ZoneName: "tenant-api",
+		Rate:     "10r/s",
+		KeySources: []webv1alpha1.RateLimitKeySource{
+			{Type: webv1alpha1.RateLimitKeySourceHeader, Name: "X-Tenant"},
+			{Type: webv1alpha1.RateLimitKeySourceRoute},
+			{Type: webv1alpha1.RateLimitKeySourceClientIP, TrustedHops: 2},
+		},
+	}}
+
+	conf := GenerateLimitReqZoneConfig(policy)
+	assert.Equal(t, `map $http_x_forwarded_for $ratelimit_client_tenant_api {
+    "~(?:^|,)\\s*([^,\\s]+)\\s*(?:,[^,]*){1}$" $1;
+    default $remote_addr;
+}
+map $host $ratelimit_key_tenant_api {
+    default "$http_x_tenant:$location_path:$ratelimit_client_tenant_api";
+}
+limit_req_zone $ratelimit_key_tenant_api zone=tenant-api:10m rate=10r/s;
+`, conf)
+	assert.Empty(t, nginxconf.Lint(conf, nginxconf.ContextHTTP).Strings())
+
+	// 与 nginx 相同的匹配结果：从右往左第 trustedHops 个地址
+	pattern := regexp.MustCompile(`(?:^|,)\s*([^,\s]+)\s*(?:,[^,]*){1}$`)
+	assert.Equal(t, "203.0.113.7", pattern.FindStringSubmatch("198.51.100.1, 203.0.113.7, 10.0.0.2")[1])
+	assert.Equal(t, "203.0.113.7", pattern.FindStringSubmatch("203.0.113.7,10.0.0.2")[1])
+	assert.Nil(t, pattern.FindStringSubmatch("10.0.0.2"), "too few hops falls back to $remote_addr")
+
+	locations := GenerateLocationConfig("web", "default", []webv1alpha1.LocationEntry{
+		{Path: "/api/", ProxyPass: "http://backend", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "tenant"}},
+	}, nil, nil, map[string]webv1alpha1.RateLimitPolicySpec{"tenant": policy.Spec}, nil, nil)
+	assert.NotContains(t, locations, "set_by_lua_block", "variable-only keys are computed by map")
+}
+
+func TestRateLimitKeyFromJWTClaim(t *testing.T) {
+	policy := &webv1alpha1.RateLimitPolicy{Spec: webv1alpha1.RateLimitPolicySpec{
+		ZoneName: "api",
+		Rate:     "10r/s",
+		KeySources: []webv1alpha1.RateLimitKeySource{
+			{Type: webv1alpha1.RateLimitKeySourceJWTClaim, Name: "tenant.id", Authenticated: true},
+			{Type: webv1alpha1.RateLimitKeySourceQuery, Name: "api_key"},
+		},
+	}}
+
+	conf := GenerateLimitReqZoneConfig(policy)
+	assert.Contains(t, conf, "map $host $ratelimit_key_api {\n    default \"\";\n}\n")
+	assert.Empty(t, nginxconf.Lint(conf, nginxconf.ContextHTTP).Strings())
+
+	locations := GenerateLocationConfig("web", "default", []webv1alpha1.LocationEntry{
+		{Path: "/api/", ProxyPass: "http://backend", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "api"}},
+	}, nil, nil, map[string]webv1alpha1.RateLimitPolicySpec{"api": policy.Spec}, nil, nil)
+
+	assert.Contains(t, locations, `    set_by_lua_block $ratelimit_key_api {
+        return require("ratelimit.key").build({
+            { header = "http_authorization", claim = "tenant.id" },
+            { var = "arg_api_key" },
+        })
+    }
+`)
+	assert.Contains(t, locations, "limit_req zone=api;")
+	assert.Empty(t, nginxconf.Lint(locations, nginxconf.ContextServer).Strings())
+
+	// 全局模式从同一变量读取 key
+	policy.Spec.Mode = webv1alpha1.RateLimitModeGlobal
+	policy.Spec.Global = &webv1alpha1.GlobalRateLimit{Redis: webv1alpha1.RedisConnection{SecretName: "redis"}}
+	valid, problems := ValidateRateLimitPolicy(policy)
+	assert.True(t, valid, problems)
+	lua := GenerateGlobalRateLimitLua(policy, "1")
+	assert.Contains(t, lua, `key = "ratelimit_key_api",`)
+	assert.Nil(t, utils.ParseLua(lua))
+}
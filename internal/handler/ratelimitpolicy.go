@@ -0,0 +1,454 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/runtime/metrics"
+	"openresty-operator/internal/template"
+	"openresty-operator/internal/utils"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	rateLimitZoneNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	rateLimitRatePattern     = regexp.MustCompile(`^[0-9]+r/[sm]$`)
+	rateLimitZoneSizePattern = regexp.MustCompile(`^[0-9]+[kKmM]?$`)
+	rateLimitVariablePattern = regexp.MustCompile(`^\$[A-Za-z0-9_]+$`)
+)
+
+// RateLimitPolicyLuaKey is the ConfigMap key of the Lua module rendered for global policies
+const RateLimitPolicyLuaKey = "policy.lua"
+
+// RateLimitPolicyConfigMapName returns the ConfigMap holding the limit_req_zone of a RateLimitPolicy
+func RateLimitPolicyConfigMapName(name string) string {
+	return "ratelimit-" + name
+}
+
+// RateLimitPolicyLuaModule returns the require() name of the Lua module of a global RateLimitPolicy
+func RateLimitPolicyLuaModule(name string) string {
+	return "ratelimits." + utils.SanitizeName(name) + ".policy"
+}
+
+func rateLimitPolicyLuaMountPath(name string) string {
+	return utils.NginxLuaLibRateLimitDir + "/" + utils.SanitizeName(name)
+}
+
+func rateLimitPolicySecretMountPath(name string) string {
+	return utils.NginxRateLimitSecretDir + "/" + name
+}
+
+// rateLimitFallbackDict is the lua_shared_dict counting requests while Redis is unreachable
+func rateLimitFallbackDict(spec webv1alpha1.RateLimitPolicySpec) string {
+	return "ratelimit_" + spec.ZoneName
+}
+
+// IsGlobalRateLimit reports whether the policy is enforced through Redis instead of limit_req
+func IsGlobalRateLimit(spec webv1alpha1.RateLimitPolicySpec) bool {
+	return spec.Mode == webv1alpha1.RateLimitModeGlobal
+}
+
+// parseRate splits a validated rate such as "10r/s" into the request count and its period
+func parseRate(rate string) (int, time.Duration) {
+	parts := strings.SplitN(rate, "r/", 2)
+	count, _ := strconv.Atoi(parts[0])
+	if len(parts) == 2 && parts[1] == "m" {
+		return count, time.Minute
+	}
+	return count, time.Second
+}
+
+func ValidateRateLimitPolicy(policy *webv1alpha1.RateLimitPolicy) (bool, []string) {
+	var problems []string
+	spec := policy.Spec
+
+	if !rateLimitZoneNamePattern.MatchString(spec.ZoneName) {
+		problems = append(problems, fmt.Sprintf("Invalid zoneName: %q (letters, digits, '-' and '_' only)", spec.ZoneName))
+	}
+	if !rateLimitRatePattern.MatchString(spec.Rate) {
+		problems = append(problems, fmt.Sprintf("Invalid rate: %q (expected e.g. \"10r/s\" or \"600r/m\")", spec.Rate))
+	}
+	if spec.ZoneSize != "" && !rateLimitZoneSizePattern.MatchString(spec.ZoneSize) {
+		problems = append(problems, fmt.Sprintf("Invalid zoneSize: %q (expected e.g. \"10m\")", spec.ZoneSize))
+	}
+	if spec.Key != "" {
+		if err := utils.ValidateNginxToken(spec.Key); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid key: %s", err.Error()))
+		}
+	}
+	if spec.Burst < 0 {
+		problems = append(problems, fmt.Sprintf("Invalid burst: %d", spec.Burst))
+	}
+	if spec.Delay < 0 || spec.Delay > spec.Burst {
+		problems = append(problems, fmt.Sprintf("Invalid delay: %d (must be between 0 and burst)", spec.Delay))
+	} else if spec.Delay > 0 && spec.NoDelay {
+		problems = append(problems, "delay and nodelay cannot be set together")
+	}
+	switch spec.LogLevel {
+	case "", "info", "notice", "warn", "error":
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid logLevel: %q", spec.LogLevel))
+	}
+	if r := spec.Rejection; r != nil {
+		if r.Status != 0 && (r.Status < 400 || r.Status > 599) {
+			problems = append(problems, fmt.Sprintf("Invalid rejection.status: %d (must be between 400 and 599)", r.Status))
+		}
+		if r.RetryAfterSeconds != nil && *r.RetryAfterSeconds < 0 {
+			problems = append(problems, fmt.Sprintf("Invalid rejection.retryAfterSeconds: %d", *r.RetryAfterSeconds))
+		}
+	}
+	problems = append(problems, validateRateLimitKeySources(spec)...)
+	if IsGlobalRateLimit(spec) {
+		problems = append(problems, validateGlobalRateLimit(spec)...)
+	}
+
+	return len(problems) == 0, problems
+}
+
+func validateGlobalRateLimit(spec webv1alpha1.RateLimitPolicySpec) []string {
+	var problems []string
+
+	if spec.Rate != "" && rateLimitRatePattern.MatchString(spec.Rate) {
+		if count, _ := parseRate(spec.Rate); count == 0 {
+			problems = append(problems, "Invalid rate: must be greater than 0 in global mode")
+		}
+	}
+	// key 在 Lua 中通过 ngx.var 读取，只支持单个变量
+	if spec.Key != "" && !rateLimitVariablePattern.MatchString(spec.Key) {
+		problems = append(problems, fmt.Sprintf("Invalid key: %q (global mode requires a single variable such as \"$http_x_api_key\")", spec.Key))
+	}
+	if spec.Delay > 0 {
+		problems = append(problems, "delay is not supported in global mode, excessive requests are rejected immediately")
+	}
+
+	global := spec.Global
+	if global == nil {
+		return append(problems, "global is required when mode is global")
+	}
+	if global.Redis.SecretName == "" {
+		problems = append(problems, "global.redis.secretName is required")
+	}
+	switch global.Algorithm {
+	case "", "gcra", "sliding-window":
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid global.algorithm: %q", global.Algorithm))
+	}
+	switch global.FailureMode {
+	case "", "open", "closed":
+	default:
+		problems = append(problems, fmt.Sprintf("Invalid global.failureMode: %q", global.FailureMode))
+	}
+	if global.FallbackRate != "" {
+		if !rateLimitRatePattern.MatchString(global.FallbackRate) {
+			problems = append(problems, fmt.Sprintf("Invalid global.fallbackRate: %q (expected e.g. \"5r/s\")", global.FallbackRate))
+		} else if count, _ := parseRate(global.FallbackRate); count == 0 {
+			problems = append(problems, "Invalid global.fallbackRate: must be greater than 0")
+		}
+	}
+	if global.Redis.TimeoutMs < 0 {
+		problems = append(problems, fmt.Sprintf("Invalid global.redis.timeoutMs: %d", global.Redis.TimeoutMs))
+	}
+	if global.Redis.PoolSize < 0 {
+		problems = append(problems, fmt.Sprintf("Invalid global.redis.poolSize: %d", global.Redis.PoolSize))
+	}
+
+	return problems
+}
+
+// ValidateRateLimitRedisSecret checks the Secret holding the Redis connection of a global policy
+func ValidateRateLimitRedisSecret(secret *corev1.Secret) error {
+	if len(secret.Data["host"]) == 0 {
+		return fmt.Errorf("key \"host\" not found in secret %s", secret.Name)
+	}
+	if port, ok := secret.Data["port"]; ok {
+		if _, err := strconv.Atoi(strings.TrimSpace(string(port))); err != nil {
+			return fmt.Errorf("invalid port in secret %s: %q", secret.Name, port)
+		}
+	}
+	if db, ok := secret.Data["database"]; ok {
+		if _, err := strconv.Atoi(strings.TrimSpace(string(db))); err != nil {
+			return fmt.Errorf("invalid database in secret %s: %q", secret.Name, db)
+		}
+	}
+	return nil
+}
+
+// GenerateLimitReqZoneConfig renders the http-level limit_req_zone of a RateLimitPolicy, preceded by the
+// maps computing its keySources. Global policies count requests in Redis and only define the
+// lua_shared_dict used by their fallbackRate.
+func GenerateLimitReqZoneConfig(policy *webv1alpha1.RateLimitPolicy) string {
+	conf := GenerateRateLimitKeyConfig(policy.Spec)
+	zoneSize := defaultOr(policy.Spec.ZoneSize, "10m")
+
+	if IsGlobalRateLimit(policy.Spec) {
+		if policy.Spec.Global == nil || policy.Spec.Global.FallbackRate == "" {
+			return conf
+		}
+		return conf + fmt.Sprintf("lua_shared_dict %s %s;\n", rateLimitFallbackDict(policy.Spec), zoneSize)
+	}
+
+	return conf + fmt.Sprintf("limit_req_zone %s zone=%s:%s rate=%s;\n",
+		utils.NginxArg(rateLimitKey(policy.Spec)), policy.Spec.ZoneName, zoneSize, policy.Spec.Rate)
+}
+
+// GenerateGlobalRateLimitLua renders the Lua module of a global RateLimitPolicy, loaded by the
+// ratelimit.global library of the OpenResty image. secretVersion is the resourceVersion of the Redis
+// Secret, so that a rotated Secret changes the module and reloads OpenResty.
+func GenerateGlobalRateLimitLua(policy *webv1alpha1.RateLimitPolicy, secretVersion string) string {
+	var b strings.Builder
+	spec := policy.Spec
+	global := spec.Global
+	count, period := parseRate(spec.Rate)
+
+	algorithm := defaultOr(global.Algorithm, "gcra")
+	script := template.GlobalRateLimitGCRAScript
+	// gcra: 发放间隔与 burst 容忍度（微秒）；sliding-window: 窗口内请求上限与窗口长度（毫秒）
+	interval := period.Microseconds() / int64(count)
+	args := []int64{interval, int64(spec.Burst) * interval}
+	if algorithm == "sliding-window" {
+		script = template.GlobalRateLimitSlidingWindowScript
+		args = []int64{int64(count + spec.Burst), period.Milliseconds()}
+	}
+
+	b.WriteString(fmt.Sprintf("-- RateLimitPolicy %s/%s, redis secret %s (version %s)\n", policy.Namespace, policy.Name, global.Redis.SecretName, secretVersion))
+	b.WriteString("return require(\"ratelimit.global\").new({\n")
+	b.WriteString(fmt.Sprintf("    name = %s,\n", utils.QuoteLua(policy.Namespace+"/"+policy.Name)))
+	b.WriteString(fmt.Sprintf("    zone = %s,\n", utils.QuoteLua(spec.ZoneName)))
+	b.WriteString(fmt.Sprintf("    key = %s,\n", utils.QuoteLua(strings.TrimPrefix(rateLimitKey(spec), "$"))))
+	b.WriteString(fmt.Sprintf("    keyPrefix = %s,\n", utils.QuoteLua(defaultOr(global.Redis.KeyPrefix, fmt.Sprintf("ratelimit:%s:%s:", policy.Namespace, policy.Name)))))
+	b.WriteString(fmt.Sprintf("    algorithm = %s,\n", utils.QuoteLua(algorithm)))
+	b.WriteString(fmt.Sprintf("    args = { %d, %d },\n", args[0], args[1]))
+	b.WriteString(fmt.Sprintf("    status = %d,\n", rateLimitRejectStatus(spec.Rejection)))
+	b.WriteString(fmt.Sprintf("    logLevel = %s,\n", utils.QuoteLua(defaultOr(spec.LogLevel, "error"))))
+	b.WriteString(fmt.Sprintf("    dryRun = %t,\n", spec.DryRun))
+	b.WriteString(fmt.Sprintf("    failureMode = %s,\n", utils.QuoteLua(defaultOr(global.FailureMode, "open"))))
+	if global.FallbackRate != "" {
+		fallbackCount, fallbackPeriod := parseRate(global.FallbackRate)
+		b.WriteString(fmt.Sprintf("    fallback = { dict = %s, limit = %d, window = %d },\n",
+			utils.QuoteLua(rateLimitFallbackDict(spec)), fallbackCount, int(fallbackPeriod.Seconds())))
+	}
+	b.WriteString("    redis = {\n")
+	b.WriteString(fmt.Sprintf("        secretDir = %s,\n", utils.QuoteLua(rateLimitPolicySecretMountPath(policy.Name))))
+	b.WriteString(fmt.Sprintf("        timeout = %d,\n", defaultInt(global.Redis.TimeoutMs, 100)))
+	b.WriteString(fmt.Sprintf("        poolSize = %d,\n", defaultInt(global.Redis.PoolSize, 32)))
+	b.WriteString("    },\n")
+	b.WriteString("    script = [==[\n")
+	b.WriteString(script)
+	b.WriteString("]==],\n")
+	b.WriteString("})\n")
+
+	return b.String()
+}
+
+// renderGlobalRateLimitCheck renders the access phase Lua enforcing a global policy
+func renderGlobalRateLimitCheck(policyName string) string {
+	return fmt.Sprintf("require(%s):check()\n", utils.QuoteLua(RateLimitPolicyLuaModule(policyName)))
+}
+
+// renderLimitReq renders the location-level limit_req and the directives controlling how excessive
+// requests are handled. rejectLocation is the named location serving the custom rejection, if any.
+// Global policies are enforced in the access phase, rejected requests reach the same error_page.
+func renderLimitReq(spec webv1alpha1.RateLimitPolicySpec, rejectLocation string) string {
+	var b strings.Builder
+
+	if IsGlobalRateLimit(spec) {
+		if rejectLocation != "" {
+			b.WriteString(fmt.Sprintf("    error_page %d = %s;\n", rateLimitRejectStatus(spec.Rejection), rejectLocation))
+		}
+		return b.String()
+	}
+
+	args := []string{"zone=" + spec.ZoneName}
+	if spec.Burst > 0 {
+		args = append(args, fmt.Sprintf("burst=%d", spec.Burst))
+	}
+	if spec.NoDelay {
+		args = append(args, "nodelay")
+	} else if spec.Delay > 0 {
+		args = append(args, fmt.Sprintf("delay=%d", spec.Delay))
+	}
+	b.WriteString(fmt.Sprintf("    limit_req %s;\n", strings.Join(args, " ")))
+
+	if spec.Rejection != nil {
+		b.WriteString(fmt.Sprintf("    limit_req_status %d;\n", rateLimitRejectStatus(spec.Rejection)))
+	}
+	if spec.LogLevel != "" {
+		b.WriteString(fmt.Sprintf("    limit_req_log_level %s;\n", spec.LogLevel))
+	}
+	if spec.DryRun {
+		b.WriteString("    limit_req_dry_run on;\n")
+	}
+	if rejectLocation != "" {
+		b.WriteString(fmt.Sprintf("    error_page %d = %s;\n", rateLimitRejectStatus(spec.Rejection), rejectLocation))
+	}
+
+	return b.String()
+}
+
+// rateLimitRejectLocation returns the named location serving the rejection of a policy used by a Location,
+// or "" when nginx's default error page is kept
+func rateLimitRejectLocation(locationName, policyName string, spec webv1alpha1.RateLimitPolicySpec) string {
+	r := spec.Rejection
+	if r == nil || (r.Body == "" && r.RetryAfterSeconds == nil && !r.RateLimitHeaders) {
+		return ""
+	}
+	return fmt.Sprintf("@ratelimit_%s_%s", utils.SanitizeName(locationName), utils.SanitizeName(policyName))
+}
+
+// renderRateLimitRejectLocation renders the named location reached through error_page when a request is rejected
+func renderRateLimitRejectLocation(name string, spec webv1alpha1.RateLimitPolicySpec) string {
+	var b strings.Builder
+	r := spec.Rejection
+	status := rateLimitRejectStatus(r)
+
+	b.WriteString(fmt.Sprintf("location %s {\n", name))
+	if r.RetryAfterSeconds != nil {
+		b.WriteString(fmt.Sprintf("    add_header Retry-After %d always;\n", *r.RetryAfterSeconds))
+	}
+	if r.RateLimitHeaders {
+		b.WriteString(fmt.Sprintf("    add_header X-RateLimit-Limit %s always;\n", strings.SplitN(spec.Rate, "r/", 2)[0]))
+		b.WriteString("    add_header X-RateLimit-Remaining 0 always;\n")
+		if r.RetryAfterSeconds != nil {
+			b.WriteString(fmt.Sprintf("    add_header X-RateLimit-Reset %d always;\n", *r.RetryAfterSeconds))
+		}
+	}
+	if r.Body != "" {
+		b.WriteString("    default_type application/json;\n")
+		b.WriteString(fmt.Sprintf("    return %d %s;\n", status, utils.QuoteNginx(r.Body)))
+	} else {
+		b.WriteString(fmt.Sprintf("    return %d;\n", status))
+	}
+	b.WriteString("}\n\n")
+
+	return b.String()
+}
+
+func rateLimitRejectStatus(r *webv1alpha1.RateLimitRejection) int {
+	if r == nil || r.Status == 0 {
+		return 429
+	}
+	return r.Status
+}
+
+// RateLimitPolicyRefsResult 记录 Location 中 rateLimitPolicyRef 的解析结果
+type RateLimitPolicyRefsResult struct {
+	// Policies maps each resolved RateLimitPolicy name to its spec
+	Policies map[string]webv1alpha1.RateLimitPolicySpec
+	// Resolved lists the resolved RateLimitPolicy names in order of first reference
+	Resolved []string
+	// Missing lists the referenced RateLimitPolicies that could not be found
+	Missing []string
+}
+
+func ResolveRateLimitPolicyRefs(get GetFunc, namespace string, entries []webv1alpha1.LocationEntry) RateLimitPolicyRefsResult {
+	ctx := context.Background()
+	result := RateLimitPolicyRefsResult{Policies: make(map[string]webv1alpha1.RateLimitPolicySpec)}
+	seen := make(map[string]struct{})
+
+	for _, entry := range entries {
+		if entry.RateLimitPolicyRef == nil {
+			continue
+		}
+		name := entry.RateLimitPolicyRef.Name
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		var policy webv1alpha1.RateLimitPolicy
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &policy); err != nil {
+			if errors.IsNotFound(err) {
+				result.Missing = append(result.Missing, name)
+			} else {
+				result.Missing = append(result.Missing, fmt.Sprintf("%s (error: %v)", name, err))
+			}
+			continue
+		}
+
+		result.Policies[name] = policy.Spec
+		result.Resolved = append(result.Resolved, name)
+	}
+
+	return result
+}
+
+type RateLimitPolicyRefsStatus struct {
+	AllReady         bool
+	MissingPolicies  []string
+	NotReadyPolicies []string
+	MissingPolicyCMs []string
+	DuplicatedZones  []string
+	// Zones maps each defined limit_req zone to the policy defining it
+	Zones map[string]string
+	// UnattachedPolicies lists RateLimitPolicies referenced by Locations but not by spec.http.rateLimitPolicyRefs
+	UnattachedPolicies []string
+}
+
+func ValidateRateLimitPolicyRefs(get GetFunc, app *webv1alpha1.OpenResty) RateLimitPolicyRefsStatus {
+	ctx := context.Background()
+	status := RateLimitPolicyRefsStatus{AllReady: true, Zones: make(map[string]string)}
+
+	for _, name := range app.Spec.Http.RateLimitPolicyRefs {
+		var policy webv1alpha1.RateLimitPolicy
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, &policy); err != nil {
+			if errors.IsNotFound(err) {
+				status.MissingPolicies = append(status.MissingPolicies, name)
+			} else {
+				status.MissingPolicies = append(status.MissingPolicies, fmt.Sprintf("%s (error: %v)", name, err))
+			}
+			status.AllReady = false
+			continue
+		}
+
+		metrics.SetCRDRefStatus(app.Namespace, app.Name, policy.Kind, policy.Name, policy.Status.Ready)
+
+		// 同名 zone 重复定义会导致 nginx 启动失败
+		if other, ok := status.Zones[policy.Spec.ZoneName]; ok {
+			status.DuplicatedZones = append(status.DuplicatedZones, fmt.Sprintf("%s (%s, %s)", policy.Spec.ZoneName, other, name))
+			status.AllReady = false
+		} else {
+			status.Zones[policy.Spec.ZoneName] = name
+		}
+
+		if !policy.Status.Ready {
+			status.NotReadyPolicies = append(status.NotReadyPolicies, name)
+			status.AllReady = false
+			continue
+		}
+
+		var cm corev1.ConfigMap
+		cmName := RateLimitPolicyConfigMapName(name)
+		if err := get(ctx, types.NamespacedName{Name: cmName, Namespace: app.Namespace}, &cm); err != nil {
+			if errors.IsNotFound(err) {
+				status.MissingPolicyCMs = append(status.MissingPolicyCMs, cmName)
+			} else {
+				status.MissingPolicyCMs = append(status.MissingPolicyCMs, fmt.Sprintf("%s (error: %v)", cmName, err))
+			}
+			status.AllReady = false
+		}
+	}
+
+	// Locations 通过 rateLimitPolicyRef 使用的 zone 必须在当前 OpenResty 的 http 块中定义
+	attached := utils.SetFrom(app.Spec.Http.RateLimitPolicyRefs)
+	for _, serverName := range app.Spec.Http.ServerRefs {
+		var srv webv1alpha1.ServerBlock
+		if err := get(ctx, types.NamespacedName{Name: serverName, Namespace: app.Namespace}, &srv); err != nil {
+			// 缺失的 ServerBlock 已由 ValidateServerRefs 报告
+			continue
+		}
+		for _, name := range srv.Status.RateLimitPolicyRefs {
+			if _, ok := attached[name]; !ok {
+				status.UnattachedPolicies = append(status.UnattachedPolicies, fmt.Sprintf("%s (from %s)", name, serverName))
+				status.AllReady = false
+			}
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,423 @@
+package handler
+
+import (
+	"context"
+	"github.com/alicebob/miniredis"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/nginxconf"
+	"openresty-operator/internal/template"
+	"openresty-operator/internal/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRateLimitPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         webv1alpha1.RateLimitPolicySpec
+		wantValid    bool
+		wantProblems []string
+	}{
+		{
+			name:      "Valid policy",
+			spec:      webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "10r/s", Key: "$http_x_api_key", ZoneSize: "20m", Burst: 5},
+			wantValid: true,
+		},
+		{
+			name:      "Per minute rate",
+			spec:      webv1alpha1.RateLimitPolicySpec{ZoneName: "login_zone", Rate: "30r/m"},
+			wantValid: true,
+		},
+		{
+			name:         "Invalid zone name",
+			spec:         webv1alpha1.RateLimitPolicySpec{ZoneName: "api:10m", Rate: "10r/s"},
+			wantValid:    false,
+			wantProblems: []string{"Invalid zoneName"},
+		},
+		{
+			name:         "Invalid rate",
+			spec:         webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "10/s"},
+			wantValid:    false,
+			wantProblems: []string{"Invalid rate"},
+		},
+		{
+			name:         "Injected key and size",
+			spec:         webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "1r/s", Key: "$a; deny all", ZoneSize: "10m;"},
+			wantValid:    false,
+			wantProblems: []string{"Invalid key", "Invalid zoneSize"},
+		},
+		{
+			name:         "Delay with nodelay",
+			spec:         webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "1r/s", Burst: 10, Delay: 5, NoDelay: true},
+			wantValid:    false,
+			wantProblems: []string{"delay and nodelay cannot be set together"},
+		},
+		{
+			name:         "Delay larger than burst",
+			spec:         webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "1r/s", Burst: 2, Delay: 5},
+			wantValid:    false,
+			wantProblems: []string{"Invalid delay"},
+		},
+		{
+			name: "Invalid rejection",
+			spec: webv1alpha1.RateLimitPolicySpec{
+				ZoneName:  "api",
+				Rate:      "1r/s",
+				LogLevel:  "debug",
+				Rejection: &webv1alpha1.RateLimitRejection{Status: 302},
+			},
+			wantValid:    false,
+			wantProblems: []string{"Invalid logLevel", "Invalid rejection.status"},
+		},
+		{
+			name:         "Negative burst",
+			spec:         webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "1r/s", Burst: -1},
+			wantValid:    false,
+			wantProblems: []string{"Invalid burst"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, problems := ValidateRateLimitPolicy(&webv1alpha1.RateLimitPolicy{Spec: tt.spec})
+
+			assert.Equal(t, tt.wantValid, valid)
+			for _, expected := range tt.wantProblems {
+				assert.Contains(t, strings.Join(problems, " | "), expected)
+			}
+		})
+	}
+}
+
+func TestGenerateLimitReqZoneConfig(t *testing.T) {
+	policy := &webv1alpha1.RateLimitPolicy{Spec: webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "10r/s"}}
+
+	conf := GenerateLimitReqZoneConfig(policy)
+	assert.Equal(t, "limit_req_zone $binary_remote_addr zone=api:10m rate=10r/s;\n", conf)
+	assert.Empty(t, nginxconf.Lint(conf, nginxconf.ContextHTTP).Strings())
+
+	policy.Spec.Key = "$http_x_api_key"
+	policy.Spec.ZoneSize = "1m"
+	assert.Equal(t, "limit_req_zone $http_x_api_key zone=api:1m rate=10r/s;\n", GenerateLimitReqZoneConfig(policy))
+}
+
+func TestRateLimitRejection(t *testing.T) {
+	retryAfter := 2
+	policies := map[string]webv1alpha1.RateLimitPolicySpec{
+		"api-limit": {
+			ZoneName: "api",
+			Rate:     "10r/s",
+			Burst:    20,
+			Delay:    5,
+			LogLevel: "warn",
+			DryRun:   true,
+			Rejection: &webv1alpha1.RateLimitRejection{
+				Body:              `{"error":"rate_limited","requestId":"$request_id"}`,
+				RetryAfterSeconds: &retryAfter,
+				RateLimitHeaders:  true,
+			},
+		},
+		"status-only": {ZoneName: "login", Rate: "1r/m", Rejection: &webv1alpha1.RateLimitRejection{Status: 503}},
+		"default":     {ZoneName: "search", Rate: "5r/s"},
+	}
+	entries := []webv1alpha1.LocationEntry{
+		{Path: "/api/", ProxyPass: "http://backend", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "api-limit"}},
+		{Path: "/api/v2/", ProxyPass: "http://backend", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "api-limit"}},
+		{Path: "/login", ProxyPass: "http://backend", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "status-only"}},
+		{Path: "/search", ProxyPass: "http://backend", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "default"}},
+	}
+
+	conf := GenerateLocationConfig("web.v1", "default", entries, nil, nil, policies, nil, nil)
+
+	for _, expect := range []string{
+		"limit_req zone=api burst=20 delay=5;",
+		"limit_req_status 429;",
+		"limit_req_log_level warn;",
+		"limit_req_dry_run on;",
+		"error_page 429 = @ratelimit_web-v1_api-limit;",
+		"location @ratelimit_web-v1_api-limit {",
+		"add_header Retry-After 2 always;",
+		"add_header X-RateLimit-Limit 10 always;",
+		"add_header X-RateLimit-Remaining 0 always;",
+		"add_header X-RateLimit-Reset 2 always;",
+		"default_type application/json;",
+		`return 429 '{"error":"rate_limited","requestId":"$request_id"}';`,
+		"limit_req zone=login;\n    limit_req_status 503;\n",
+		"limit_req zone=search;\n",
+	} {
+		assert.Contains(t, conf, expect)
+	}
+	assert.Equal(t, 1, strings.Count(conf, "location @ratelimit_"), "entries sharing a policy share the named location")
+	assert.Equal(t, 3, strings.Count(conf, "limit_req_status"), "policies without rejection keep the nginx default")
+	assert.Empty(t, nginxconf.Lint(conf, nginxconf.ContextServer).Strings())
+}
+
+func rateLimitGetFunc(policies map[string]*webv1alpha1.RateLimitPolicy, servers map[string]*webv1alpha1.ServerBlock) GetFunc {
+	return func(ctx context.Context, key types.NamespacedName, obj client.Object, opts ...client.GetOption) error {
+		switch o := obj.(type) {
+		case *webv1alpha1.RateLimitPolicy:
+			p, ok := policies[key.Name]
+			if !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "ratelimitpolicies"}, key.Name)
+			}
+			p.DeepCopyInto(o)
+			return nil
+		case *webv1alpha1.ServerBlock:
+			s, ok := servers[key.Name]
+			if !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "serverblocks"}, key.Name)
+			}
+			s.DeepCopyInto(o)
+			return nil
+		case *corev1.ConfigMap:
+			if _, ok := policies[strings.TrimPrefix(key.Name, "ratelimit-")]; !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, key.Name)
+			}
+			return nil
+		}
+		return nil
+	}
+}
+
+func TestValidateRateLimitPolicyRefs(t *testing.T) {
+	policy := func(zone string, ready bool) *webv1alpha1.RateLimitPolicy {
+		return &webv1alpha1.RateLimitPolicy{
+			Spec:   webv1alpha1.RateLimitPolicySpec{ZoneName: zone, Rate: "10r/s"},
+			Status: webv1alpha1.RateLimitPolicyStatus{Ready: ready},
+		}
+	}
+	policies := map[string]*webv1alpha1.RateLimitPolicy{
+		"api":       policy("api", true),
+		"api-copy":  policy("api", true),
+		"login":     policy("login", true),
+		"pending":   policy("pending", false),
+		"unrelated": policy("unrelated", true),
+	}
+	servers := map[string]*webv1alpha1.ServerBlock{
+		"web": {Status: webv1alpha1.ServerBlockStatus{RateLimitPolicyRefs: []string{"api", "unrelated"}}},
+	}
+
+	app := &webv1alpha1.OpenResty{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "default"},
+		Spec: webv1alpha1.OpenRestySpec{Http: &webv1alpha1.HttpBlock{
+			ServerRefs:          []string{"web", "ghost-server"},
+			RateLimitPolicyRefs: []string{"api", "login", "pending", "ghost", "api-copy"},
+		}},
+	}
+
+	status := ValidateRateLimitPolicyRefs(rateLimitGetFunc(policies, servers), app)
+
+	assert.False(t, status.AllReady)
+	assert.Equal(t, []string{"ghost"}, status.MissingPolicies)
+	assert.Equal(t, []string{"pending"}, status.NotReadyPolicies)
+	assert.Equal(t, []string{"api (api, api-copy)"}, status.DuplicatedZones)
+	assert.Equal(t, []string{"unrelated (from web)"}, status.UnattachedPolicies)
+	assert.Empty(t, status.MissingPolicyCMs)
+
+	app.Spec.Http.RateLimitPolicyRefs = []string{"api", "unrelated"}
+	status = ValidateRateLimitPolicyRefs(rateLimitGetFunc(policies, servers), app)
+	assert.True(t, status.AllReady)
+
+	lines := BuildIncludeLines(app, UpstreamRefsStatus{})
+	assert.Equal(t, "include /etc/nginx/conf.d/ratelimits/api/api.conf;", lines[0])
+	assert.Equal(t, "include /etc/nginx/conf.d/ratelimits/unrelated/unrelated.conf;", lines[1])
+}
+
+func TestResolveRateLimitPolicyRefs(t *testing.T) {
+	policies := map[string]*webv1alpha1.RateLimitPolicy{
+		"api": {Spec: webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "10r/s", Burst: 10, NoDelay: true}},
+	}
+	entries := []webv1alpha1.LocationEntry{
+		{Path: "/a", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "api"}},
+		{Path: "/b", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "api"}},
+		{Path: "/c", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "ghost"}},
+		{Path: "/d"},
+	}
+
+	result := ResolveRateLimitPolicyRefs(rateLimitGetFunc(policies, nil), "default", entries)
+
+	assert.Equal(t, []string{"api"}, result.Resolved)
+	assert.Equal(t, []string{"ghost"}, result.Missing)
+	assert.Equal(t, 10, result.Policies["api"].Burst)
+}
+
+func TestValidateGlobalRateLimitPolicy(t *testing.T) {
+	redisConn := webv1alpha1.RedisConnection{SecretName: "redis"}
+	tests := []struct {
+		name         string
+		spec         webv1alpha1.RateLimitPolicySpec
+		wantProblems []string
+	}{
+		{
+			name: "Valid global policy",
+			spec: webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "100r/m", Key: "$http_x_api_key", Mode: webv1alpha1.RateLimitModeGlobal,
+				Global: &webv1alpha1.GlobalRateLimit{Algorithm: "sliding-window", Redis: redisConn, FailureMode: "closed", FallbackRate: "5r/s"}},
+		},
+		{
+			name:         "Missing global settings",
+			spec:         webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "10r/s", Mode: webv1alpha1.RateLimitModeGlobal},
+			wantProblems: []string{"global is required when mode is global"},
+		},
+		{
+			name: "Invalid global settings",
+			spec: webv1alpha1.RateLimitPolicySpec{ZoneName: "api", Rate: "0r/s", Key: "$a$b", Burst: 5, Delay: 2, Mode: webv1alpha1.RateLimitModeGlobal,
+				Global: &webv1alpha1.GlobalRateLimit{Algorithm: "leaky", FailureMode: "half", FallbackRate: "5/s"}},
+			wantProblems: []string{
+				"Invalid rate: must be greater than 0 in global mode",
+				"Invalid key",
+				"delay is not supported in global mode",
+				"global.redis.secretName is required",
+				"Invalid global.algorithm",
+				"Invalid global.failureMode",
+				"Invalid global.fallbackRate",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, problems := ValidateRateLimitPolicy(&webv1alpha1.RateLimitPolicy{Spec: tt.spec})
+
+			assert.Equal(t, len(tt.wantProblems) == 0, valid, problems)
+			for _, expected := range tt.wantProblems {
+				assert.Contains(t, strings.Join(problems, " | "), expected)
+			}
+		})
+	}
+}
+
+func TestGenerateGlobalRateLimit(t *testing.T) {
+	policy := &webv1alpha1.RateLimitPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-global", Namespace: "default"},
+		Spec: webv1alpha1.RateLimitPolicySpec{
+			ZoneName:  "api",
+			Rate:      "10r/s",
+			Burst:     5,
+			Key:       "$http_x_api_key",
+			Mode:      webv1alpha1.RateLimitModeGlobal,
+			Rejection: &webv1alpha1.RateLimitRejection{Body: `{"error":"rate_limited"}`},
+			Global: &webv1alpha1.GlobalRateLimit{
+				Redis:        webv1alpha1.RedisConnection{SecretName: "redis", TimeoutMs: 50},
+				FallbackRate: "2r/s",
+			},
+		},
+	}
+
+	conf := GenerateLimitReqZoneConfig(policy)
+	assert.Equal(t, "lua_shared_dict ratelimit_api 10m;\n", conf)
+	assert.Empty(t, nginxconf.Lint(conf, nginxconf.ContextHTTP).Strings())
+
+	lua := GenerateGlobalRateLimitLua(policy, "42")
+	assert.Nil(t, utils.ParseLua(lua), lua)
+	for _, expect := range []string{
+		`-- RateLimitPolicy default/api-global, redis secret redis (version 42)`,
+		`key = "http_x_api_key",`,
+		`keyPrefix = "ratelimit:default:api-global:",`,
+		`algorithm = "gcra",`,
+		`args = { 100000, 500000 },`,
+		`status = 429,`,
+		`failureMode = "open",`,
+		`fallback = { dict = "ratelimit_api", limit = 2, window = 1 },`,
+		`secretDir = "/etc/nginx/secrets/ratelimits/api-global",`,
+		`timeout = 50,`,
+		`poolSize = 32,`,
+	} {
+		assert.Contains(t, lua, expect)
+	}
+
+	policy.Spec.Global.Algorithm = "sliding-window"
+	assert.Contains(t, GenerateGlobalRateLimitLua(policy, "42"), `args = { 15, 1000 },`)
+
+	entries := []webv1alpha1.LocationEntry{
+		{Path: "/api/", ProxyPass: "http://backend", RateLimitPolicyRef: &webv1alpha1.RateLimitPolicyReference{Name: "api-global"},
+			Lua: &webv1alpha1.LuaBlock{Access: "ngx.var.checked = 1"}},
+	}
+	location := GenerateLocationConfig("web", "default", entries, nil, nil, map[string]webv1alpha1.RateLimitPolicySpec{"api-global": policy.Spec}, nil, nil)
+
+	assert.NotContains(t, location, "limit_req")
+	assert.Contains(t, location, "    access_by_lua_block {\n        require(\"ratelimits.api-global.policy\"):check()\n        local function user_access()\n")
+	assert.Contains(t, location, "error_page 429 = @ratelimit_web_api-global;")
+	assert.Empty(t, nginxconf.Lint(location, nginxconf.ContextServer).Strings())
+}
+
+// evalRateLimitScript runs a global rate limit script like the ratelimit.global library does
+func evalRateLimitScript(t *testing.T, conn redigo.Conn, script, key string, args ...int64) (bool, int64, int64) {
+	evalArgs := []interface{}{script, 1, key}
+	for _, arg := range args {
+		evalArgs = append(evalArgs, arg)
+	}
+	res, err := redigo.Int64s(conn.Do("EVAL", evalArgs...))
+	if err != nil {
+		t.Fatalf("EVAL failed: %v", err)
+	}
+	return res[0] == 1, res[1], res[2]
+}
+
+func TestGlobalRateLimitScripts(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := redigo.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	now := time.Unix(1700000000, 0)
+	s.SetTime(now)
+
+	t.Run("gcra", func(t *testing.T) {
+		// 10r/s, burst 2: 100ms interval, 200ms tolerance
+		key := "ratelimit:gcra:{client}"
+		for i := 0; i < 3; i++ {
+			allowed, remaining, _ := evalRateLimitScript(t, conn, template.GlobalRateLimitGCRAScript, key, 100000, 200000)
+			assert.True(t, allowed, "request %d is within the burst", i)
+			assert.Equal(t, int64(2-i), remaining)
+		}
+
+		allowed, _, retryAfter := evalRateLimitScript(t, conn, template.GlobalRateLimitGCRAScript, key, 100000, 200000)
+		assert.False(t, allowed)
+		assert.Equal(t, int64(100), retryAfter)
+
+		s.SetTime(now.Add(100 * time.Millisecond))
+		allowed, remaining, _ := evalRateLimitScript(t, conn, template.GlobalRateLimitGCRAScript, key, 100000, 200000)
+		assert.True(t, allowed)
+		assert.Equal(t, int64(0), remaining)
+		assert.True(t, s.Exists(key))
+	})
+
+	t.Run("sliding-window", func(t *testing.T) {
+		// 3 requests per second
+		s.SetTime(now)
+		key := "ratelimit:window:{client}"
+		for i := 0; i < 3; i++ {
+			allowed, _, _ := evalRateLimitScript(t, conn, template.GlobalRateLimitSlidingWindowScript, key, 3, 1000)
+			assert.True(t, allowed)
+		}
+		allowed, _, retryAfter := evalRateLimitScript(t, conn, template.GlobalRateLimitSlidingWindowScript, key, 3, 1000)
+		assert.False(t, allowed)
+		assert.Equal(t, int64(1000), retryAfter)
+
+		// 下一个窗口过去 1/3 时，上一窗口的 3 个请求按 2/3 计入
+		s.SetTime(now.Add(1334 * time.Millisecond))
+		allowed, remaining, _ := evalRateLimitScript(t, conn, template.GlobalRateLimitSlidingWindowScript, key, 3, 1000)
+		assert.True(t, allowed)
+		assert.Equal(t, int64(0), remaining)
+
+		allowed, _, retryAfter = evalRateLimitScript(t, conn, template.GlobalRateLimitSlidingWindowScript, key, 3, 1000)
+		assert.False(t, allowed)
+		assert.Equal(t, int64(333), retryAfter)
+	})
+}
@@ -36,6 +36,73 @@ func ValidateLocationRefs(locations map[string]*webv1alpha1.Location, locationRe
 	return len(problems) == 0, problems
 }
 
+// ValidateServerBlockSpec checks the values rendered verbatim into the server block
+func ValidateServerBlockSpec(s *webv1alpha1.ServerBlock) (bool, []string) {
+	var problems []string
+
+	if strings.TrimSpace(s.Spec.Listen) == "" {
+		problems = append(problems, "Invalid listen: cannot be empty")
+	} else if err := utils.ValidateNginxArgs(s.Spec.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("Invalid listen: %s", err.Error()))
+	}
+
+	for _, h := range s.Spec.Headers {
+		if err := utils.ValidateHeaderName(h.Key); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid header: %s", err.Error()))
+		} else if err := utils.ValidateNginxValue(h.Value); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid header: %s value %s", h.Key, err.Error()))
+		}
+	}
+
+	return len(problems) == 0, problems
+}
+
+func CollectLocationUpstreamRefs(locations map[string]*webv1alpha1.Location, locationRefs []string) []string {
+	return collectLocationRefs(locations, locationRefs, func(loc *webv1alpha1.Location) []string {
+		return loc.Status.UpstreamRefs
+	})
+}
+
+func CollectLocationRateLimitPolicyRefs(locations map[string]*webv1alpha1.Location, locationRefs []string) []string {
+	return collectLocationRefs(locations, locationRefs, func(loc *webv1alpha1.Location) []string {
+		return loc.Status.RateLimitPolicyRefs
+	})
+}
+
+func CollectLocationTrafficShapingPolicyRefs(locations map[string]*webv1alpha1.Location, locationRefs []string) []string {
+	return collectLocationRefs(locations, locationRefs, func(loc *webv1alpha1.Location) []string {
+		return loc.Status.TrafficShapingPolicyRefs
+	})
+}
+
+func CollectLocationQuotaRefs(locations map[string]*webv1alpha1.Location, locationRefs []string) []string {
+	return collectLocationRefs(locations, locationRefs, func(loc *webv1alpha1.Location) []string {
+		return loc.Status.QuotaRefs
+	})
+}
+
+// collectLocationRefs 按 locationRefs 的顺序去重合并各 Location status 中解析出的引用
+func collectLocationRefs(locations map[string]*webv1alpha1.Location, locationRefs []string, refsOf func(*webv1alpha1.Location) []string) []string {
+	var refs []string
+	seen := make(map[string]struct{})
+
+	for _, refName := range locationRefs {
+		loc := locations[refName]
+		if loc == nil {
+			continue
+		}
+		for _, name := range refsOf(loc) {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			refs = append(refs, name)
+		}
+	}
+
+	return refs
+}
+
 func GenerateServerBlockConfig(s *webv1alpha1.ServerBlock) string {
 	var b strings.Builder
 
@@ -51,7 +118,7 @@ func GenerateServerBlockConfig(s *webv1alpha1.ServerBlock) string {
 	}
 
 	for _, h := range s.Spec.Headers {
-		b.WriteString(fmt.Sprintf("    add_header %s %s;\n", h.Key, h.Value))
+		b.WriteString(fmt.Sprintf("    add_header %s %s;\n", utils.NginxArg(h.Key), utils.NginxArg(h.Value)))
 	}
 
 	for _, line := range s.Spec.Extra {
@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/nginxconf"
 	"testing"
 )
 
@@ -103,3 +104,92 @@ func TestGenerateServerBlockConfig(t *testing.T) {
 	assert.Contains(t, conf, "add_header X-Frame-Options DENY;")
 	assert.Contains(t, conf, "client_max_body_size 20m;")
 }
+
+func TestCollectLocationUpstreamRefs(t *testing.T) {
+	locations := map[string]*webv1alpha1.Location{
+		"loc1": {Status: webv1alpha1.LocationStatus{UpstreamRefs: []string{"api", "auth"}}},
+		"loc2": {Status: webv1alpha1.LocationStatus{UpstreamRefs: []string{"auth", "files"}}},
+		"loc3": nil,
+	}
+
+	refs := CollectLocationUpstreamRefs(locations, []string{"loc1", "loc2", "loc3"})
+
+	assert.Equal(t, []string{"api", "auth", "files"}, refs)
+}
+
+func TestValidateServerBlockSpec(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         webv1alpha1.ServerBlockSpec
+		wantValid    bool
+		wantProblems []string
+	}{
+		{
+			name:      "Valid spec",
+			spec:      webv1alpha1.ServerBlockSpec{Listen: "443 ssl", Headers: []webv1alpha1.NginxKV{{Key: "X-Frame-Options", Value: "DENY"}}},
+			wantValid: true,
+		},
+		{
+			name:         "Injected listen",
+			spec:         webv1alpha1.ServerBlockSpec{Listen: "80; } server { listen 81"},
+			wantValid:    false,
+			wantProblems: []string{`Invalid listen: must not contain quotes or ';', '{', '}', '#', '\'`},
+		},
+		{
+			name: "Invalid headers",
+			spec: webv1alpha1.ServerBlockSpec{Listen: "80", Headers: []webv1alpha1.NginxKV{
+				{Key: "X Frame", Value: "DENY"},
+				{Key: "X-A", Value: "a\nb"},
+			}},
+			wantValid: false,
+			wantProblems: []string{
+				`Invalid header: invalid header name "X Frame"`,
+				`Invalid header: X-A value contains control character '\n'`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, problems := ValidateServerBlockSpec(&webv1alpha1.ServerBlock{Spec: tt.spec})
+			assert.Equal(t, tt.wantValid, valid)
+			assert.Equal(t, tt.wantProblems, problems)
+		})
+	}
+}
+
+func FuzzGenerateServerBlockConfig(f *testing.F) {
+	f.Add("80", "X-Frame-Options", "DENY")
+	f.Add("443 ssl", "Content-Security-Policy", "default-src 'self'; script-src 'none'")
+	f.Add("8080", "X-A", `a"} server { listen 81; } #`)
+
+	f.Fuzz(func(t *testing.T, listen, headerKey, headerValue string) {
+		s := &webv1alpha1.ServerBlock{
+			ObjectMeta: metav1.ObjectMeta{Name: "fuzz", Namespace: "default"},
+			Spec: webv1alpha1.ServerBlockSpec{
+				Listen:       listen,
+				LocationRefs: []string{"loc"},
+				Headers:      []webv1alpha1.NginxKV{{Key: headerKey, Value: headerValue}},
+			},
+		}
+		if valid, _ := ValidateServerBlockSpec(s); !valid {
+			return
+		}
+
+		conf := GenerateServerBlockConfig(s)
+		findings := nginxconf.Lint(conf, nginxconf.ContextHTTP)
+		if findings.HasErrors() {
+			t.Fatalf("rendered config has lint errors %v:\n%s", findings.Strings(), conf)
+		}
+
+		dirs, _ := nginxconf.Parse(conf)
+		if len(dirs) != 1 || dirs[0].Name != "server" {
+			t.Fatalf("expected a single server block:\n%s", conf)
+		}
+		for _, d := range dirs[0].Block {
+			if d.Name == "add_header" {
+				assert.Equal(t, []string{headerKey, headerValue}, d.Args)
+			}
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/runtime/metrics"
+	"openresty-operator/internal/utils"
+	"regexp"
+	"strings"
+)
+
+var trafficShapingSizePattern = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// TrafficShapingPolicyConfigMapName returns the ConfigMap holding the limit_conn_zone of a TrafficShapingPolicy
+func TrafficShapingPolicyConfigMapName(name string) string {
+	return "trafficshaping-" + name
+}
+
+func ValidateTrafficShapingPolicy(policy *webv1alpha1.TrafficShapingPolicy) (bool, []string) {
+	var problems []string
+	spec := policy.Spec
+
+	if spec.Connections < 0 {
+		problems = append(problems, fmt.Sprintf("Invalid connections: %d", spec.Connections))
+	}
+	if spec.Connections > 0 && spec.ZoneName == "" {
+		problems = append(problems, "zoneName is required when connections is set")
+	}
+	if spec.ZoneName != "" && !rateLimitZoneNamePattern.MatchString(spec.ZoneName) {
+		problems = append(problems, fmt.Sprintf("Invalid zoneName: %q (letters, digits, '-' and '_' only)", spec.ZoneName))
+	}
+	if spec.ZoneSize != "" && !rateLimitZoneSizePattern.MatchString(spec.ZoneSize) {
+		problems = append(problems, fmt.Sprintf("Invalid zoneSize: %q (expected e.g. \"10m\")", spec.ZoneSize))
+	}
+	if spec.Key != "" {
+		if err := utils.ValidateNginxToken(spec.Key); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid key: %s", err.Error()))
+		}
+	}
+	if spec.RejectStatus != 0 && (spec.RejectStatus < 400 || spec.RejectStatus > 599) {
+		problems = append(problems, fmt.Sprintf("Invalid rejectStatus: %d (must be between 400 and 599)", spec.RejectStatus))
+	}
+	if spec.LimitRate != "" && !trafficShapingSizePattern.MatchString(spec.LimitRate) {
+		problems = append(problems, fmt.Sprintf("Invalid limitRate: %q (expected e.g. \"500k\")", spec.LimitRate))
+	}
+	if spec.LimitRateAfter != "" && !trafficShapingSizePattern.MatchString(spec.LimitRateAfter) {
+		problems = append(problems, fmt.Sprintf("Invalid limitRateAfter: %q (expected e.g. \"10m\")", spec.LimitRateAfter))
+	}
+	if spec.Connections == 0 && spec.LimitRate == "" {
+		problems = append(problems, "At least one of connections or limitRate is required")
+	}
+
+	return len(problems) == 0, problems
+}
+
+// GenerateLimitConnZoneConfig renders the http-level limit_conn_zone of a TrafficShapingPolicy.
+// Policies that only limit bandwidth define no zone and render an empty file.
+func GenerateLimitConnZoneConfig(policy *webv1alpha1.TrafficShapingPolicy) string {
+	if policy.Spec.ZoneName == "" {
+		return ""
+	}
+	key := defaultOr(policy.Spec.Key, "$binary_remote_addr")
+	zoneSize := defaultOr(policy.Spec.ZoneSize, "10m")
+	return fmt.Sprintf("limit_conn_zone %s zone=%s:%s;\n", utils.NginxArg(key), policy.Spec.ZoneName, zoneSize)
+}
+
+// renderTrafficShaping renders the location-level limit_conn and limit_rate directives of a policy
+func renderTrafficShaping(spec webv1alpha1.TrafficShapingPolicySpec) string {
+	var b strings.Builder
+
+	if spec.ZoneName != "" && spec.Connections > 0 {
+		b.WriteString(fmt.Sprintf("    limit_conn %s %d;\n", spec.ZoneName, spec.Connections))
+		if spec.RejectStatus != 0 {
+			b.WriteString(fmt.Sprintf("    limit_conn_status %d;\n", spec.RejectStatus))
+		}
+		if spec.DryRun {
+			b.WriteString("    limit_conn_dry_run on;\n")
+		}
+	}
+	if spec.LimitRate != "" {
+		b.WriteString(fmt.Sprintf("    limit_rate %s;\n", spec.LimitRate))
+		if spec.LimitRateAfter != "" {
+			b.WriteString(fmt.Sprintf("    limit_rate_after %s;\n", spec.LimitRateAfter))
+		}
+	}
+
+	return b.String()
+}
+
+// TrafficShapingPolicyRefsResult 记录 Location 中 trafficShapingPolicyRef 的解析结果
+type TrafficShapingPolicyRefsResult struct {
+	// Policies maps each resolved TrafficShapingPolicy name to its spec
+	Policies map[string]webv1alpha1.TrafficShapingPolicySpec
+	// Resolved lists the resolved TrafficShapingPolicy names in order of first reference
+	Resolved []string
+	// Missing lists the referenced TrafficShapingPolicies that could not be found
+	Missing []string
+}
+
+func ResolveTrafficShapingPolicyRefs(get GetFunc, namespace string, entries []webv1alpha1.LocationEntry) TrafficShapingPolicyRefsResult {
+	ctx := context.Background()
+	result := TrafficShapingPolicyRefsResult{Policies: make(map[string]webv1alpha1.TrafficShapingPolicySpec)}
+	seen := make(map[string]struct{})
+
+	for _, entry := range entries {
+		if entry.TrafficShapingPolicyRef == nil {
+			continue
+		}
+		name := entry.TrafficShapingPolicyRef.Name
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		var policy webv1alpha1.TrafficShapingPolicy
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, &policy); err != nil {
+			if errors.IsNotFound(err) {
+				result.Missing = append(result.Missing, name)
+			} else {
+				result.Missing = append(result.Missing, fmt.Sprintf("%s (error: %v)", name, err))
+			}
+			continue
+		}
+
+		result.Policies[name] = policy.Spec
+		result.Resolved = append(result.Resolved, name)
+	}
+
+	return result
+}
+
+type TrafficShapingPolicyRefsStatus struct {
+	AllReady         bool
+	MissingPolicies  []string
+	NotReadyPolicies []string
+	MissingPolicyCMs []string
+	DuplicatedZones  []string
+	// UnattachedPolicies lists TrafficShapingPolicies referenced by Locations but not by spec.http.trafficShapingPolicyRefs
+	UnattachedPolicies []string
+}
+
+// ValidateTrafficShapingPolicyRefs checks the referenced policies. rateLimitZones are the limit_req zones already
+// defined in the http block, nginx rejects a limit_conn_zone reusing one of their names.
+func ValidateTrafficShapingPolicyRefs(get GetFunc, app *webv1alpha1.OpenResty, rateLimitZones map[string]string) TrafficShapingPolicyRefsStatus {
+	ctx := context.Background()
+	status := TrafficShapingPolicyRefsStatus{AllReady: true}
+	zones := make(map[string]string)
+	for zone, name := range rateLimitZones {
+		zones[zone] = "RateLimitPolicy/" + name
+	}
+
+	for _, name := range app.Spec.Http.TrafficShapingPolicyRefs {
+		var policy webv1alpha1.TrafficShapingPolicy
+		if err := get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, &policy); err != nil {
+			if errors.IsNotFound(err) {
+				status.MissingPolicies = append(status.MissingPolicies, name)
+			} else {
+				status.MissingPolicies = append(status.MissingPolicies, fmt.Sprintf("%s (error: %v)", name, err))
+			}
+			status.AllReady = false
+			continue
+		}
+
+		metrics.SetCRDRefStatus(app.Namespace, app.Name, policy.Kind, policy.Name, policy.Status.Ready)
+
+		if zone := policy.Spec.ZoneName; zone != "" {
+			if other, ok := zones[zone]; ok {
+				status.DuplicatedZones = append(status.DuplicatedZones, fmt.Sprintf("%s (%s, %s)", zone, other, name))
+				status.AllReady = false
+			} else {
+				zones[zone] = name
+			}
+		}
+
+		if !policy.Status.Ready {
+			status.NotReadyPolicies = append(status.NotReadyPolicies, name)
+			status.AllReady = false
+			continue
+		}
+
+		var cm corev1.ConfigMap
+		cmName := TrafficShapingPolicyConfigMapName(name)
+		if err := get(ctx, types.NamespacedName{Name: cmName, Namespace: app.Namespace}, &cm); err != nil {
+			if errors.IsNotFound(err) {
+				status.MissingPolicyCMs = append(status.MissingPolicyCMs, cmName)
+			} else {
+				status.MissingPolicyCMs = append(status.MissingPolicyCMs, fmt.Sprintf("%s (error: %v)", cmName, err))
+			}
+			status.AllReady = false
+		}
+	}
+
+	// Locations 通过 trafficShapingPolicyRef 使用的 zone 必须在当前 OpenResty 的 http 块中定义
+	attached := utils.SetFrom(app.Spec.Http.TrafficShapingPolicyRefs)
+	for _, serverName := range app.Spec.Http.ServerRefs {
+		var srv webv1alpha1.ServerBlock
+		if err := get(ctx, types.NamespacedName{Name: serverName, Namespace: app.Namespace}, &srv); err != nil {
+			// 缺失的 ServerBlock 已由 ValidateServerRefs 报告
+			continue
+		}
+		for _, name := range srv.Status.TrafficShapingPolicyRefs {
+			if _, ok := attached[name]; !ok {
+				status.UnattachedPolicies = append(status.UnattachedPolicies, fmt.Sprintf("%s (from %s)", name, serverName))
+				status.AllReady = false
+			}
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/nginxconf"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"testing"
+)
+
+func TestValidateTrafficShapingPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         webv1alpha1.TrafficShapingPolicySpec
+		wantValid    bool
+		wantProblems []string
+	}{
+		{
+			name:      "Connections and bandwidth",
+			spec:      webv1alpha1.TrafficShapingPolicySpec{ZoneName: "dl", Connections: 2, LimitRate: "500k", LimitRateAfter: "10m"},
+			wantValid: true,
+		},
+		{
+			name:      "Bandwidth only",
+			spec:      webv1alpha1.TrafficShapingPolicySpec{LimitRate: "1m"},
+			wantValid: true,
+		},
+		{
+			name:         "Connections without zone",
+			spec:         webv1alpha1.TrafficShapingPolicySpec{Connections: 2},
+			wantValid:    false,
+			wantProblems: []string{"zoneName is required when connections is set"},
+		},
+		{
+			name:         "Nothing to limit",
+			spec:         webv1alpha1.TrafficShapingPolicySpec{ZoneName: "dl"},
+			wantValid:    false,
+			wantProblems: []string{"At least one of connections or limitRate is required"},
+		},
+		{
+			name:         "Invalid values",
+			spec:         webv1alpha1.TrafficShapingPolicySpec{ZoneName: "dl", Connections: 1, RejectStatus: 200, LimitRate: "1 m", LimitRateAfter: "x"},
+			wantValid:    false,
+			wantProblems: []string{"Invalid rejectStatus", "Invalid limitRate", "Invalid limitRateAfter"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, problems := ValidateTrafficShapingPolicy(&webv1alpha1.TrafficShapingPolicy{Spec: tt.spec})
+
+			assert.Equal(t, tt.wantValid, valid)
+			for _, expected := range tt.wantProblems {
+				assert.Contains(t, strings.Join(problems, " | "), expected)
+			}
+		})
+	}
+}
+
+func TestGenerateLimitConnZoneConfig(t *testing.T) {
+	policy := &webv1alpha1.TrafficShapingPolicy{Spec: webv1alpha1.TrafficShapingPolicySpec{ZoneName: "dl", Connections: 2}}
+
+	conf := GenerateLimitConnZoneConfig(policy)
+	assert.Equal(t, "limit_conn_zone $binary_remote_addr zone=dl:10m;\n", conf)
+	assert.Empty(t, nginxconf.Lint(conf, nginxconf.ContextHTTP).Strings())
+
+	policy.Spec = webv1alpha1.TrafficShapingPolicySpec{LimitRate: "1m"}
+	assert.Empty(t, GenerateLimitConnZoneConfig(policy), "bandwidth-only policies define no zone")
+}
+
+func TestValidateTrafficShapingPolicyRefs(t *testing.T) {
+	policies := map[string]*webv1alpha1.TrafficShapingPolicy{
+		"downloads": {
+			Spec:   webv1alpha1.TrafficShapingPolicySpec{ZoneName: "dl", Connections: 2},
+			Status: webv1alpha1.TrafficShapingPolicyStatus{Ready: true},
+		},
+		"clash": {
+			Spec:   webv1alpha1.TrafficShapingPolicySpec{ZoneName: "api", Connections: 2},
+			Status: webv1alpha1.TrafficShapingPolicyStatus{Ready: true},
+		},
+		"videos": {
+			Spec:   webv1alpha1.TrafficShapingPolicySpec{LimitRate: "1m"},
+			Status: webv1alpha1.TrafficShapingPolicyStatus{Ready: true},
+		},
+	}
+	servers := map[string]*webv1alpha1.ServerBlock{
+		"web": {Status: webv1alpha1.ServerBlockStatus{TrafficShapingPolicyRefs: []string{"downloads", "videos"}}},
+	}
+	get := func(ctx context.Context, key types.NamespacedName, obj client.Object, opts ...client.GetOption) error {
+		switch o := obj.(type) {
+		case *webv1alpha1.TrafficShapingPolicy:
+			p, ok := policies[key.Name]
+			if !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "trafficshapingpolicies"}, key.Name)
+			}
+			p.DeepCopyInto(o)
+		case *webv1alpha1.ServerBlock:
+			servers[key.Name].DeepCopyInto(o)
+		case *corev1.ConfigMap:
+			if _, ok := policies[strings.TrimPrefix(key.Name, "trafficshaping-")]; !ok {
+				return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, key.Name)
+			}
+		}
+		return nil
+	}
+
+	app := &webv1alpha1.OpenResty{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "default"},
+		Spec: webv1alpha1.OpenRestySpec{Http: &webv1alpha1.HttpBlock{
+			ServerRefs:               []string{"web"},
+			TrafficShapingPolicyRefs: []string{"downloads", "clash", "ghost"},
+		}},
+	}
+
+	status := ValidateTrafficShapingPolicyRefs(get, app, map[string]string{"api": "api-limit"})
+
+	assert.False(t, status.AllReady)
+	assert.Equal(t, []string{"ghost"}, status.MissingPolicies)
+	assert.Equal(t, []string{"api (RateLimitPolicy/api-limit, clash)"}, status.DuplicatedZones)
+	assert.Equal(t, []string{"videos (from web)"}, status.UnattachedPolicies)
+
+	app.Spec.Http.TrafficShapingPolicyRefs = []string{"downloads", "videos"}
+	status = ValidateTrafficShapingPolicyRefs(get, app, map[string]string{"api": "api-limit"})
+	assert.True(t, status.AllReady)
+	assert.Contains(t, BuildIncludeLines(app, UpstreamRefsStatus{}), "include /etc/nginx/conf.d/trafficshaping/downloads/downloads.conf;")
+}
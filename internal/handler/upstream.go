@@ -3,64 +3,412 @@ package handler
 import (
 	"context"
 	"fmt"
+	"math"
 	webv1alpha1 "openresty-operator/api/v1alpha1"
 	"openresty-operator/internal/runtime/health"
 	"openresty-operator/internal/utils"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func ProbeUpstreamServers(ctx context.Context, upstream *webv1alpha1.Upstream) map[string]*health.CheckResult {
-	return health.Checker.Submit(utils.MapList(upstream.Spec.Servers, func(server webv1alpha1.UpstreamServer) string {
-		return server.Address
-	}))
+	return health.Checker.Submit(UpstreamHealthOwner(upstream), UpstreamHealthTargets(upstream))
+}
+
+// UpstreamHealthOwner identifies an Upstream among the owners of the addresses in the health checker
+func UpstreamHealthOwner(upstream *webv1alpha1.Upstream) string {
+	return upstream.Namespace + "/" + upstream.Name
+}
+
+// UpstreamHealthTargets returns the servers of an Upstream checked by the health checker
+func UpstreamHealthTargets(upstream *webv1alpha1.Upstream) []health.Target {
+	probe := UpstreamHealthProbe(upstream.Spec.HealthCheck)
+	return utils.MapList(upstream.Spec.Servers, func(server webv1alpha1.UpstreamServer) health.Target {
+		return health.Target{Address: server.Address, Probe: probe}
+	})
+}
+
+// UpstreamHealthProbe converts a healthCheck into the probe of the health checker, nil keeps the DNS and TCP check
+func UpstreamHealthProbe(check *webv1alpha1.UpstreamHealthCheck) *health.Probe {
+	if check == nil {
+		return nil
+	}
+
+	probe := &health.Probe{
+		Method:             check.Method,
+		Path:               check.Path,
+		Host:               check.Host,
+		BodyMatch:          check.BodyMatch,
+		InsecureSkipVerify: check.InsecureSkipVerify,
+		ServiceName:        check.GRPCService,
+		MinCertValidDays:   check.MinCertValidDays,
+		Interval:           secondsOr(check.IntervalSeconds, 10),
+		Timeout:            secondsOr(check.TimeoutSeconds, 2),
+		Jitter:             time.Duration(check.JitterSeconds) * time.Second,
+		HealthyThreshold:   intOr(check.HealthyThreshold, 2),
+		UnhealthyThreshold: intOr(check.UnhealthyThreshold, 3),
+	}
+	if check.Type != "" && check.Type != webv1alpha1.HealthCheckTypeTCP {
+		probe.Scheme = string(check.Type)
+	}
+	for _, h := range check.Headers {
+		probe.Headers = append(probe.Headers, health.Header{Name: h.Key, Value: h.Value})
+	}
+	for _, s := range check.ExpectedStatuses {
+		// 非法的范围已在 ValidateUpstream 中拒绝
+		if r, err := health.ParseStatusRange(s); err == nil {
+			probe.ExpectedStatuses = append(probe.ExpectedStatuses, r)
+		}
+	}
+	return probe
+}
+
+func secondsOr(seconds, fallback int) time.Duration {
+	return time.Duration(intOr(seconds, fallback)) * time.Second
+}
+
+func intOr(n, fallback int) int {
+	if n <= 0 {
+		return fallback
+	}
+	return n
 }
 
 func GenerateUpstreamConfig(upstream *webv1alpha1.Upstream, results []*health.CheckResult) string {
 	name := utils.SanitizeName(upstream.Name)
+	servers := upstreamServerIndex(upstream.Spec.Servers)
+
+	balancer := ""
+	if upstream.Spec.Lua != nil {
+		balancer = upstream.Spec.Lua.Balancer
+	}
+
+	options := renderBalancerOptions(name, upstream.Spec.LoadBalancer, upstream.Spec.PassiveHealthCheck, upstream.Spec.DNS, upstream.Spec.Stickiness)
 
 	switch upstream.Spec.Type {
 	case webv1alpha1.UpstreamTypeAddress:
-		return renderNginxUpstreamBlock(name, buildConfigLines(results))
+		lines := buildConfigLines(results, servers)
+		if IsDynamicUpstream(upstream) && len(lines) > 0 {
+			// server 列表渲染到 peers 文件，由 reload-agent 推送，变化时配置本身保持不变
+			return renderNginxUpstreamBlock(name, nil, upstreamPeersFile(upstream), balancer, options, upstream.Spec.Keepalive)
+		}
+		return renderNginxUpstreamBlock(name, lines, "", balancer, options, upstream.Spec.Keepalive)
 	case webv1alpha1.UpstreamTypeFullURL:
-		return renderNginxUpstreamLua(name, results, upstream.Spec.Servers)
+		return renderNginxUpstreamLua(name, results, upstream.Spec.Servers, balancer, options)
 	default:
 		return ""
 	}
 }
 
-func buildConfigLines(results []*health.CheckResult) []string {
+// ValidateUpstream checks the parts of an Upstream the CRD schema cannot express
+func ValidateUpstream(upstream *webv1alpha1.Upstream) (bool, []string) {
+	var problems []string
+
+	if len(upstream.Spec.Servers) == 0 && upstream.Spec.ServiceRef == nil {
+		problems = append(problems, "either servers or serviceRef is required")
+	}
+	if upstream.Spec.ServiceRef != nil && upstream.Spec.Type != webv1alpha1.UpstreamTypeAddress {
+		problems = append(problems, "serviceRef is only supported by Address upstreams")
+	}
+
+	if check := upstream.Spec.HealthCheck; check != nil {
+		problems = append(problems, validateUpstreamHealthCheck(check)...)
+	}
+	if passive := upstream.Spec.PassiveHealthCheck; passive != nil {
+		for _, s := range passive.FailureStatuses {
+			if _, err := health.ParseStatusRange(s); err != nil {
+				problems = append(problems, fmt.Sprintf("passiveHealthCheck.failureStatuses: %v", err))
+			}
+		}
+	}
+
+	if upstream.Spec.Keepalive != nil && upstream.Spec.Type != webv1alpha1.UpstreamTypeAddress {
+		problems = append(problems, "keepalive is only supported by Address upstreams")
+	}
+	if upstream.Spec.UpdateStrategy == webv1alpha1.UpstreamUpdateDynamic && upstream.Spec.Type != webv1alpha1.UpstreamTypeAddress {
+		problems = append(problems, "updateStrategy Dynamic is only supported by Address upstreams")
+	}
+
+	if dns := upstream.Spec.DNS; dns != nil {
+		if dns.Resolution == webv1alpha1.DNSResolutionRuntime && upstream.Spec.Type != webv1alpha1.UpstreamTypeAddress {
+			problems = append(problems, "dns.resolution runtime is only supported by Address upstreams")
+		}
+		if dns.MinTTLSeconds > 0 && dns.MaxTTLSeconds > 0 && dns.MinTTLSeconds > dns.MaxTTLSeconds {
+			problems = append(problems, "dns.minTTLSeconds must not exceed dns.maxTTLSeconds")
+		}
+	}
+
+	if sticky := upstream.Spec.Stickiness; sticky != nil {
+		problems = append(problems, validateStickiness(sticky)...)
+	}
+
+	if lb := upstream.Spec.LoadBalancer; lb != nil {
+		if lb.Algorithm == webv1alpha1.LoadBalancerConsistentHash && lb.Hash == nil {
+			problems = append(problems, "loadBalancer.hash is required by the consistent-hash algorithm")
+		}
+		if lb.Hash != nil {
+			switch lb.Hash.By {
+			case webv1alpha1.ConsistentHashKeyHeader:
+				if err := utils.ValidateHeaderName(lb.Hash.Name); err != nil {
+					problems = append(problems, fmt.Sprintf("loadBalancer.hash.name: %v", err))
+				}
+			case webv1alpha1.ConsistentHashKeyCookie:
+				if utils.ValidateNginxToken(lb.Hash.Name) != nil {
+					problems = append(problems, fmt.Sprintf("loadBalancer.hash.name: invalid cookie name %q", lb.Hash.Name))
+				}
+			}
+		}
+	}
+
+	return len(problems) == 0, problems
+}
+
+func validateUpstreamHealthCheck(check *webv1alpha1.UpstreamHealthCheck) []string {
+	var problems []string
+	if check.Path != "" && (!strings.HasPrefix(check.Path, "/") || strings.ContainsAny(check.Path, " \t\r\n")) {
+		problems = append(problems, fmt.Sprintf("healthCheck.path: must start with '/' and contain no whitespace: %q", check.Path))
+	}
+	if check.Host != "" && utils.ValidateNginxToken(check.Host) != nil {
+		problems = append(problems, fmt.Sprintf("healthCheck.host: invalid host %q", check.Host))
+	}
+	for _, h := range check.Headers {
+		if err := utils.ValidateHeaderName(h.Key); err != nil {
+			problems = append(problems, fmt.Sprintf("healthCheck.headers: %v", err))
+		} else if err := utils.ValidateNginxValue(h.Value); err != nil {
+			problems = append(problems, fmt.Sprintf("healthCheck.headers[%s]: %v", h.Key, err))
+		}
+	}
+	for _, s := range check.ExpectedStatuses {
+		if _, err := health.ParseStatusRange(s); err != nil {
+			problems = append(problems, fmt.Sprintf("healthCheck.expectedStatuses: %v", err))
+		}
+	}
+	if check.BodyMatch != "" {
+		if _, err := regexp.Compile(check.BodyMatch); err != nil {
+			problems = append(problems, fmt.Sprintf("healthCheck.bodyMatch: %v", err))
+		}
+	}
+	if check.GRPCService != "" && check.Type != webv1alpha1.HealthCheckTypeGRPC && check.Type != webv1alpha1.HealthCheckTypeGRPCS {
+		problems = append(problems, "healthCheck.grpcService: only supported by grpc and grpcs checks")
+	}
+	if check.MinCertValidDays > 0 && check.Type != webv1alpha1.HealthCheckTypeHTTPS &&
+		check.Type != webv1alpha1.HealthCheckTypeGRPCS && check.Type != webv1alpha1.HealthCheckTypeTLS {
+		problems = append(problems, "healthCheck.minCertValidDays: only supported by https, grpcs and tls checks")
+	}
+	return problems
+}
+
+// renderBalancerOptions 渲染传给 upstreams.lb 的 options 表
+func renderBalancerOptions(name string, lb *webv1alpha1.UpstreamLoadBalancer, passive *webv1alpha1.UpstreamPassiveHealthCheck, dns *webv1alpha1.UpstreamDNS, sticky *webv1alpha1.UpstreamStickiness) string {
+	algorithm := webv1alpha1.LoadBalancerRandomWeighted
+	if lb != nil && lb.Algorithm != "" {
+		algorithm = lb.Algorithm
+	}
+
+	options := fmt.Sprintf("{ name = %s, algorithm = %s", utils.QuoteLua(name), utils.QuoteLua(string(algorithm)))
+	if algorithm == webv1alpha1.LoadBalancerConsistentHash && lb.Hash != nil {
+		vnodes := lb.Hash.VirtualNodes
+		if vnodes <= 0 {
+			vnodes = 160
+		}
+		options += fmt.Sprintf(", hash = { by = %s", utils.QuoteLua(string(lb.Hash.By)))
+		if lb.Hash.Name != "" {
+			options += fmt.Sprintf(", name = %s", utils.QuoteLua(lb.Hash.Name))
+		}
+		options += fmt.Sprintf(", vnodes = %d }", vnodes)
+	}
+	if passive != nil {
+		options += ", passive = " + renderPassiveOptions(passive)
+	}
+	if dns != nil && dns.Resolution == webv1alpha1.DNSResolutionRuntime {
+		options += ", dns = " + renderDNSOptions(dns)
+	}
+	if sticky != nil {
+		options += ", sticky = " + renderStickyOptions(sticky)
+	}
+	return options + " }"
+}
+
+// renderDNSOptions 渲染 upstreams.dns 在 balancer 中解析 host 使用的参数
+func renderDNSOptions(dns *webv1alpha1.UpstreamDNS) string {
+	fallback := webv1alpha1.DNSFallbackStale
+	if dns.Fallback != "" {
+		fallback = dns.Fallback
+	}
+	return fmt.Sprintf("{ min_ttl = %d, max_ttl = %d, fallback = %s }",
+		intOr(dns.MinTTLSeconds, 5), intOr(dns.MaxTTLSeconds, 300), utils.QuoteLua(string(fallback)))
+}
+
+// renderPassiveOptions 渲染 upstreams.outlier 使用的被动健康检查参数，statuses 为闭区间列表
+func renderPassiveOptions(passive *webv1alpha1.UpstreamPassiveHealthCheck) string {
+	statuses := []string{"{ 500, 599 }"}
+	if len(passive.FailureStatuses) > 0 {
+		statuses = nil
+		for _, s := range passive.FailureStatuses {
+			// 非法的范围已在 ValidateUpstream 中拒绝
+			if r, err := health.ParseStatusRange(s); err == nil {
+				statuses = append(statuses, fmt.Sprintf("{ %d, %d }", r.From, r.To))
+			}
+		}
+	}
+	return fmt.Sprintf("{ failures = %d, statuses = { %s }, ejection = %d, max_percent = %d }",
+		intOr(passive.ConsecutiveFailures, 5),
+		strings.Join(statuses, ", "),
+		intOr(passive.EjectionSeconds, 30),
+		intOr(passive.MaxEjectionPercent, 50))
+}
+
+// upstreamServerIndex 按地址索引 servers，重复地址以第一个为准
+func upstreamServerIndex(servers []webv1alpha1.UpstreamServer) map[string]webv1alpha1.UpstreamServer {
+	index := make(map[string]webv1alpha1.UpstreamServer, len(servers))
+	for _, s := range servers {
+		if _, ok := index[s.Address]; !ok {
+			index[s.Address] = s
+		}
+	}
+	return index
+}
+
+// EffectiveUpstreamWeight returns the weight the balancer uses for a server, 0 while it is draining
+func EffectiveUpstreamWeight(server webv1alpha1.UpstreamServer) int {
+	if server.Drain {
+		return 0
+	}
+	if server.Weight <= 0 {
+		return 1
+	}
+	return server.Weight
+}
+
+// BuildUpstreamServerStatuses reports the checked servers with the weights they are rendered with,
+// servers that are down are listed with weight 0
+func BuildUpstreamServerStatuses(upstream *webv1alpha1.Upstream, results []*health.CheckResult) []webv1alpha1.UpstreamServerStatus {
+	servers := upstreamServerIndex(upstream.Spec.Servers)
+	var statuses []webv1alpha1.UpstreamServerStatus
+	for _, r := range results {
+		server := servers[r.Address]
+		status := webv1alpha1.UpstreamServerStatus{
+			Address:  r.Address,
+			Alive:    r.Alive,
+			Weight:   EffectiveUpstreamWeight(server),
+			Backup:   server.Backup,
+			Draining: server.Drain,
+			Reason:   r.Reason,
+		}
+		if !r.Alive {
+			status.Weight = 0
+		}
+		if !r.LastTransition.IsZero() {
+			t := metav1.NewTime(r.LastTransition)
+			status.LastTransitionTime = &t
+		}
+		if !r.CertNotAfter.IsZero() {
+			days := certificateExpiryDays(r.CertNotAfter, time.Now())
+			status.CertificateExpiryDays = &days
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
+}
+
+// certificateExpiryDays 返回证书剩余的整天数，已过期时为负数
+func certificateExpiryDays(notAfter, now time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / 24))
+}
+
+// serverFailLimits returns the max_fails and fail_timeout of a server with the nginx defaults applied
+func serverFailLimits(server webv1alpha1.UpstreamServer) (int, int) {
+	maxFails := 1
+	if server.MaxFails != nil {
+		maxFails = *server.MaxFails
+	}
+	failTimeout := server.FailTimeoutSeconds
+	if failTimeout <= 0 {
+		failTimeout = 10
+	}
+	return maxFails, failTimeout
+}
+
+// renderServerOptions 渲染 balancer Lua 使用的 weight/backup/max_fails/fail_timeout 字段
+func renderServerOptions(server webv1alpha1.UpstreamServer) string {
+	maxFails, failTimeout := serverFailLimits(server)
+
+	options := fmt.Sprintf("weight = %d, ", EffectiveUpstreamWeight(server))
+	if server.Backup {
+		options += "backup = true, "
+	}
+	if server.Drain {
+		options += "drain = true, "
+	}
+	return options + fmt.Sprintf("max_fails = %d, fail_timeout = %d", maxFails, failTimeout)
+}
+
+func buildConfigLines(results []*health.CheckResult, servers map[string]webv1alpha1.UpstreamServer) []string {
 	var lines []string
 	for _, r := range results {
 		h, p, _ := utils.SplitHostPort(r.Address)
 		if r.Alive {
+			// port 直接写入 Lua 数字字面量，必须是合法端口
+			if err := utils.ValidatePort(p); err != nil {
+				continue
+			}
 			ipList := make([]string, 0, len(r.IPs))
 			for _, ip := range r.IPs {
-				ipList = append(ipList, fmt.Sprintf("\"%s\"", ip))
+				ipList = append(ipList, utils.QuoteLua(ip))
 			}
 			lines = append(lines, fmt.Sprintf(
-				"{ host = \"%s\", port = %s, weight = 1, ips = { %s } },",
-				h, p, strings.Join(ipList, ", "),
+				"{ host = %s, port = %s, %s, ips = { %s } },",
+				utils.QuoteLua(h), p, renderServerOptions(servers[r.Address]), strings.Join(ipList, ", "),
 			))
 		}
 	}
 	return lines
 }
 
-func renderNginxUpstreamBlock(name string, lines []string) string {
-	if len(lines) == 0 {
+// renderNginxUpstreamBlock 渲染 Address 类型的 upstream 块。peersFile 不为空时 servers 由 upstreams.dynamic
+// 从 shared dict 读取，lines 不再渲染
+func renderNginxUpstreamBlock(name string, lines []string, peersFile, balancer, options string, keepalive *webv1alpha1.UpstreamKeepalive) string {
+	if len(lines) == 0 && peersFile == "" {
 		return ""
 	}
 	var b strings.Builder
 	b.WriteString(fmt.Sprintf("upstream %s {\n", name))
 	b.WriteString("    server 0.0.0.1;\n")
 	b.WriteString("    balancer_by_lua_block {\n")
-	b.WriteString("        local servers = {\n")
-	for _, line := range lines {
-		b.WriteString("            " + line + "\n")
+	if peersFile != "" {
+		b.WriteString(fmt.Sprintf("        local servers = require(\"upstreams.dynamic\").peers(%s, %s)\n\n",
+			utils.QuoteLua(name), utils.QuoteLua(peersFile)))
+	} else {
+		b.WriteString("        local servers = {\n")
+		for _, line := range lines {
+			b.WriteString("            " + line + "\n")
+		}
+		b.WriteString("        }\n\n")
 	}
-	b.WriteString("        }\n\n")
-	b.WriteString("        require(\"upstreams.balancer\").randomWeightedBalance(servers)\n")
+	if strings.TrimSpace(balancer) != "" {
+		b.WriteString("        local function user_balancer(servers)\n")
+		b.WriteString(indentLua(strings.TrimSuffix(balancer, "\n"), "            "))
+		b.WriteString("        end\n")
+		b.WriteString("        local picked = user_balancer(servers)\n")
+		b.WriteString("        if picked then\n")
+		b.WriteString("            return require(\"upstreams.balancer\").setPeer(picked)\n")
+		b.WriteString("        end\n\n")
+	}
+	b.WriteString(fmt.Sprintf("        require(\"upstreams.balancer\").balance(servers, %s)\n", options))
 	b.WriteString("    }\n")
+	// keepalive 必须位于 balancer_by_lua_block 之后
+	if keepalive != nil {
+		b.WriteString(fmt.Sprintf("    keepalive %d;\n", keepalive.Connections))
+		b.WriteString(fmt.Sprintf("    keepalive_timeout %ds;\n", intOr(keepalive.TimeoutSeconds, 60)))
+		b.WriteString(fmt.Sprintf("    keepalive_requests %d;\n", intOr(keepalive.MaxRequests, 1000)))
+	}
 	b.WriteString("}\n")
 	return b.String()
 }
@@ -75,20 +423,22 @@ func renderNginxUpstreamBlock(name string, lines []string) string {
 		NormalizeRequestRef *corev1.LocalObjectReference `json:"normalizeRequestRef,omitempty"`
 	}
 */
-func renderNginxUpstreamLua(name string, results []*health.CheckResult, servers []webv1alpha1.UpstreamServer) string {
+func renderNginxUpstreamLua(name string, results []*health.CheckResult, servers []webv1alpha1.UpstreamServer, balancer, options string) string {
 	var b strings.Builder
 
 	b.WriteString(fmt.Sprintf("-- upstream-%s.lua\n", name))
-	b.WriteString("local random = require(\"upstreams.random_weighted\")\n\n")
+	b.WriteString("local lb = require(\"upstreams.lb\")\n\n")
 
+	index := upstreamServerIndex(servers)
 	alives := 0
 	b.WriteString("local servers = {\n")
 	for _, s := range results {
+		line := fmt.Sprintf("{ address = %s, %s },", utils.QuoteLua(s.Address), renderServerOptions(index[s.Address]))
 		if s.Alive {
-			b.WriteString(fmt.Sprintf("    { address = \"%s\", weight = 1 },\n", s.Address))
+			b.WriteString("    " + line + "\n")
 			alives++
 		} else {
-			b.WriteString(fmt.Sprintf("--    { address = \"%s\", weight = 1 },\n", s.Address))
+			b.WriteString("--    " + line + "\n")
 		}
 	}
 	b.WriteString("}\n\n")
@@ -96,7 +446,7 @@ func renderNginxUpstreamLua(name string, results []*health.CheckResult, servers
 	b.WriteString("local normalizeFunc = {\n")
 	for _, i2 := range servers {
 		if i2.NormalizeRequestRef != nil {
-			b.WriteString(fmt.Sprintf("    [\"%s\"] = \"normalizerules.%s\",\n", i2.Address, i2.NormalizeRequestRef.Name))
+			b.WriteString(fmt.Sprintf("    [%s] = %s,\n", utils.QuoteLua(i2.Address), utils.QuoteLua("normalizerules."+i2.NormalizeRequestRef.Name)))
 		}
 	}
 	b.WriteString("}\n\n")
@@ -105,10 +455,28 @@ func renderNginxUpstreamLua(name string, results []*health.CheckResult, servers
 		return ""
 	}
 
-	b.WriteString("random.init(servers)\n\n")
+	if strings.TrimSpace(balancer) != "" {
+		b.WriteString("local function user_balancer(servers)\n")
+		b.WriteString(indentLua(strings.TrimSuffix(balancer, "\n"), "    "))
+		b.WriteString("end\n\n")
+	} else {
+		b.WriteString("local function user_balancer(servers)\n")
+		b.WriteString("    return nil\n")
+		b.WriteString("end\n\n")
+	}
+
+	b.WriteString(fmt.Sprintf("local options = %s\n\n", options))
 	b.WriteString("return {\n")
 	b.WriteString("  default = function()\n")
-	b.WriteString("    local picked = random.pick()\n")
+	b.WriteString("    local picked = user_balancer(servers)\n")
+	b.WriteString("    if not picked then\n")
+	b.WriteString("      local server = lb.select(servers, options)\n")
+	b.WriteString("      picked = server and server.address\n")
+	b.WriteString("    end\n")
+	b.WriteString("    if not picked then\n")
+	b.WriteString("      ngx.log(ngx.ERR, \"no available upstream server\")\n")
+	b.WriteString("      return ngx.exit(502)\n")
+	b.WriteString("    end\n\n")
 	b.WriteString("    ngx.ctx.server_host = picked\n")
 	b.WriteString("    local uri = ngx.var.uri or \"/\"\n")
 	b.WriteString("    local prefix = ngx.var.location_prefix or \"/\"\n\n")
@@ -119,6 +487,10 @@ func renderNginxUpstreamLua(name string, results []*health.CheckResult, servers
 	b.WriteString("    if from == 1 and to then\n")
 	b.WriteString("        uri = \"/\" .. uri:sub(to + 1)\n")
 	b.WriteString("    end\n\n")
+	b.WriteString("    local path_prefix = ngx.var.upstream_path_prefix\n")
+	b.WriteString("    if path_prefix and path_prefix ~= \"\" then\n")
+	b.WriteString("        uri = path_prefix:gsub(\"/$\", \"\") .. uri\n")
+	b.WriteString("    end\n\n")
 	b.WriteString("    local addr = picked\n")
 	b.WriteString("    ngx.ctx.req_address = addr\n")
 	b.WriteString("    if addr:match(\"https?://[^/]+/.+\") then\n")
@@ -3,13 +3,16 @@ package handler
 import (
 	"openresty-operator/internal/runtime/health"
 	"testing"
+	"time"
 
 	webv1alpha1 "openresty-operator/api/v1alpha1"
 
 	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestGenerateUpstreamConfig(t *testing.T) {
+	zero := 0
 	tests := []struct {
 		name     string
 		upstream *webv1alpha1.Upstream
@@ -40,7 +43,221 @@ func TestGenerateUpstreamConfig(t *testing.T) {
 				{Address: "https://foo.com", Alive: true},
 				{Address: "https://bar.com", Alive: false},
 			},
-			wantPart: "local random = require",
+			wantPart: "local lb = require(\"upstreams.lb\")",
+		},
+		{
+			name: "Address mode with custom balancer",
+			upstream: &webv1alpha1.Upstream{
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeAddress,
+					Lua:  &webv1alpha1.UpstreamLuaBlock{Balancer: "return servers[1]"},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "127.0.0.1:80", Alive: true, IPs: []string{"127.0.0.1"}},
+			},
+			wantPart: "        local function user_balancer(servers)\n" +
+				"            return servers[1]\n" +
+				"        end\n" +
+				"        local picked = user_balancer(servers)\n" +
+				"        if picked then\n" +
+				"            return require(\"upstreams.balancer\").setPeer(picked)\n",
+		},
+		{
+			name: "FullURL mode with custom balancer",
+			upstream: &webv1alpha1.Upstream{
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeFullURL,
+					Lua:  &webv1alpha1.UpstreamLuaBlock{Balancer: "return servers[1].address"},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "https://foo.com", Alive: true},
+			},
+			wantPart: "    local picked = user_balancer(servers)\n" +
+				"    if not picked then\n" +
+				"      local server = lb.select(servers, options)\n",
+		},
+		{
+			name: "Address mode with weight, backup and drain",
+			upstream: &webv1alpha1.Upstream{
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeAddress,
+					Servers: []webv1alpha1.UpstreamServer{
+						{Address: "a.local:80", Weight: 5, MaxFails: &zero, FailTimeoutSeconds: 30},
+						{Address: "b.local:80", Backup: true},
+						{Address: "c.local:80", Weight: 3, Drain: true},
+					},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "a.local:80", Alive: true, IPs: []string{"10.0.0.1"}},
+				{Address: "b.local:80", Alive: true, IPs: []string{"10.0.0.2"}},
+				{Address: "c.local:80", Alive: true, IPs: []string{"10.0.0.3"}},
+			},
+			wantPart: "            { host = \"a.local\", port = 80, weight = 5, max_fails = 0, fail_timeout = 30, ips = { \"10.0.0.1\" } },\n" +
+				"            { host = \"b.local\", port = 80, weight = 1, backup = true, max_fails = 1, fail_timeout = 10, ips = { \"10.0.0.2\" } },\n" +
+				"            { host = \"c.local\", port = 80, weight = 0, drain = true, max_fails = 1, fail_timeout = 10, ips = { \"10.0.0.3\" } },\n",
+		},
+		{
+			name: "FullURL mode with weight and backup",
+			upstream: &webv1alpha1.Upstream{
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeFullURL,
+					Servers: []webv1alpha1.UpstreamServer{
+						{Address: "https://foo.com", Weight: 2},
+						{Address: "https://bar.com", Backup: true},
+					},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "https://foo.com", Alive: true},
+				{Address: "https://bar.com", Alive: true},
+			},
+			wantPart: "    { address = \"https://foo.com\", weight = 2, max_fails = 1, fail_timeout = 10 },\n" +
+				"    { address = \"https://bar.com\", weight = 1, backup = true, max_fails = 1, fail_timeout = 10 },\n",
+		},
+		{
+			name: "Address mode with default algorithm",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeAddress,
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "127.0.0.1:80", Alive: true, IPs: []string{"127.0.0.1"}},
+			},
+			wantPart: "        require(\"upstreams.balancer\").balance(servers, { name = \"api\", algorithm = \"random-weighted\" })\n",
+		},
+		{
+			name: "Address mode with least-connections",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type:         webv1alpha1.UpstreamTypeAddress,
+					LoadBalancer: &webv1alpha1.UpstreamLoadBalancer{Algorithm: webv1alpha1.LoadBalancerLeastConnections},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "127.0.0.1:80", Alive: true, IPs: []string{"127.0.0.1"}},
+			},
+			wantPart: "        require(\"upstreams.balancer\").balance(servers, { name = \"api\", algorithm = \"least-connections\" })\n",
+		},
+		{
+			name: "Address mode with consistent hash",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeAddress,
+					LoadBalancer: &webv1alpha1.UpstreamLoadBalancer{
+						Algorithm: webv1alpha1.LoadBalancerConsistentHash,
+						Hash:      &webv1alpha1.ConsistentHash{By: webv1alpha1.ConsistentHashKeyHeader, Name: "X-User"},
+					},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "127.0.0.1:80", Alive: true, IPs: []string{"127.0.0.1"}},
+			},
+			wantPart: "balance(servers, { name = \"api\", algorithm = \"consistent-hash\", hash = { by = \"header\", name = \"X-User\", vnodes = 160 } })\n",
+		},
+		{
+			name: "FullURL mode with peak-ewma",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "ext"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type:         webv1alpha1.UpstreamTypeFullURL,
+					LoadBalancer: &webv1alpha1.UpstreamLoadBalancer{Algorithm: webv1alpha1.LoadBalancerPeakEWMA},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "https://foo.com", Alive: true},
+			},
+			wantPart: "local options = { name = \"ext\", algorithm = \"peak-ewma\" }\n",
+		},
+		{
+			name: "Passive health check",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeAddress,
+					PassiveHealthCheck: &webv1alpha1.UpstreamPassiveHealthCheck{
+						ConsecutiveFailures: 3,
+						FailureStatuses:     []string{"502-504", "429"},
+					},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "127.0.0.1:80", Alive: true, IPs: []string{"127.0.0.1"}},
+			},
+			wantPart: "require(\"upstreams.balancer\").balance(servers, { name = \"api\", algorithm = \"random-weighted\", " +
+				"passive = { failures = 3, statuses = { { 502, 504 }, { 429, 429 } }, ejection = 30, max_percent = 50 } })",
+		},
+		{
+			name: "Passive health check defaults",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "ext"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type:               webv1alpha1.UpstreamTypeFullURL,
+					PassiveHealthCheck: &webv1alpha1.UpstreamPassiveHealthCheck{EjectionSeconds: 60},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "https://foo.com", Alive: true},
+			},
+			wantPart: "local options = { name = \"ext\", algorithm = \"random-weighted\", " +
+				"passive = { failures = 5, statuses = { { 500, 599 } }, ejection = 60, max_percent = 50 } }\n",
+		},
+		{
+			name: "Runtime DNS resolution",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeAddress,
+					DNS: &webv1alpha1.UpstreamDNS{
+						Resolution:    webv1alpha1.DNSResolutionRuntime,
+						MaxTTLSeconds: 60,
+						Fallback:      webv1alpha1.DNSFallbackFail,
+					},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "backend.local:80", Alive: true, IPs: []string{"10.0.0.1"}},
+			},
+			wantPart: "require(\"upstreams.balancer\").balance(servers, { name = \"api\", algorithm = \"random-weighted\", " +
+				"dns = { min_ttl = 5, max_ttl = 60, fallback = \"fail\" } })",
+		},
+		{
+			name: "Operator DNS resolution",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type: webv1alpha1.UpstreamTypeAddress,
+					DNS:  &webv1alpha1.UpstreamDNS{Resolution: webv1alpha1.DNSResolutionOperator},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "backend.local:80", Alive: true, IPs: []string{"10.0.0.1"}},
+			},
+			wantPart: "require(\"upstreams.balancer\").balance(servers, { name = \"api\", algorithm = \"random-weighted\" })",
+		},
+		{
+			name: "Address mode with keepalive",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type:      webv1alpha1.UpstreamTypeAddress,
+					Keepalive: &webv1alpha1.UpstreamKeepalive{Connections: 32, MaxRequests: 500},
+				},
+			},
+			results: []*health.CheckResult{
+				{Address: "10.0.0.1:80", Alive: true, IPs: []string{"10.0.0.1"}},
+			},
+			wantPart: "    }\n" +
+				"    keepalive 32;\n" +
+				"    keepalive_timeout 60s;\n" +
+				"    keepalive_requests 500;\n" +
+				"}\n",
 		},
 		{
 			name: "All servers dead",
@@ -88,3 +305,257 @@ func TestGenerateUpstreamConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildUpstreamServerStatuses(t *testing.T) {
+	transition := time.Date(2025, 10, 19, 8, 0, 0, 0, time.UTC)
+	upstream := &webv1alpha1.Upstream{
+		Spec: webv1alpha1.UpstreamSpec{
+			Servers: []webv1alpha1.UpstreamServer{
+				{Address: "b.local:80", Weight: 4},
+				{Address: "a.local:80", Backup: true},
+				{Address: "c.local:80", Weight: 2, Drain: true},
+				{Address: "d.local:80"},
+			},
+		},
+	}
+	results := []*health.CheckResult{
+		{Address: "b.local:80", Alive: true},
+		{Address: "a.local:80", Alive: true, CertNotAfter: time.Now().Add(30*24*time.Hour + time.Hour)},
+		{Address: "c.local:80", Alive: true},
+		{Address: "d.local:80", Alive: false, Reason: "HTTP_STATUS", LastTransition: transition},
+	}
+
+	since := metav1.NewTime(transition)
+	expiryDays := 30
+	assert.Equal(t, []webv1alpha1.UpstreamServerStatus{
+		{Address: "a.local:80", Alive: true, Weight: 1, Backup: true, CertificateExpiryDays: &expiryDays},
+		{Address: "b.local:80", Alive: true, Weight: 4},
+		{Address: "c.local:80", Alive: true, Weight: 0, Draining: true},
+		{Address: "d.local:80", Alive: false, Weight: 0, Reason: "HTTP_STATUS", LastTransitionTime: &since},
+	}, BuildUpstreamServerStatuses(upstream, results))
+}
+
+func TestValidateUpstream(t *testing.T) {
+	tests := []struct {
+		name         string
+		loadBalancer *webv1alpha1.UpstreamLoadBalancer
+		healthCheck  *webv1alpha1.UpstreamHealthCheck
+		passive      *webv1alpha1.UpstreamPassiveHealthCheck
+		dns          *webv1alpha1.UpstreamDNS
+		keepalive    *webv1alpha1.UpstreamKeepalive
+		stickiness   *webv1alpha1.UpstreamStickiness
+		upstreamType webv1alpha1.UpstreamType
+		dynamic      bool
+		wantProblems []string
+	}{
+		{name: "No load balancer"},
+		{
+			name:         "Round robin",
+			loadBalancer: &webv1alpha1.UpstreamLoadBalancer{Algorithm: webv1alpha1.LoadBalancerRoundRobin},
+		},
+		{
+			name: "Consistent hash by cookie",
+			loadBalancer: &webv1alpha1.UpstreamLoadBalancer{
+				Algorithm: webv1alpha1.LoadBalancerConsistentHash,
+				Hash:      &webv1alpha1.ConsistentHash{By: webv1alpha1.ConsistentHashKeyCookie, Name: "session"},
+			},
+		},
+		{
+			name:         "Consistent hash without hash",
+			loadBalancer: &webv1alpha1.UpstreamLoadBalancer{Algorithm: webv1alpha1.LoadBalancerConsistentHash},
+			wantProblems: []string{"loadBalancer.hash is required by the consistent-hash algorithm"},
+		},
+		{
+			name: "Header without name",
+			loadBalancer: &webv1alpha1.UpstreamLoadBalancer{
+				Algorithm: webv1alpha1.LoadBalancerConsistentHash,
+				Hash:      &webv1alpha1.ConsistentHash{By: webv1alpha1.ConsistentHashKeyHeader},
+			},
+			wantProblems: []string{"loadBalancer.hash.name"},
+		},
+		{
+			name: "Invalid cookie name",
+			loadBalancer: &webv1alpha1.UpstreamLoadBalancer{
+				Algorithm: webv1alpha1.LoadBalancerConsistentHash,
+				Hash:      &webv1alpha1.ConsistentHash{By: webv1alpha1.ConsistentHashKeyCookie, Name: "a b"},
+			},
+			wantProblems: []string{"loadBalancer.hash.name: invalid cookie name"},
+		},
+		{
+			name: "HTTP health check",
+			healthCheck: &webv1alpha1.UpstreamHealthCheck{
+				Type:             webv1alpha1.HealthCheckTypeHTTP,
+				Path:             "/healthz",
+				Headers:          []webv1alpha1.NginxKV{{Key: "X-Probe", Value: "1"}},
+				ExpectedStatuses: []string{"200-299", "404"},
+				BodyMatch:        `"status":\s*"ok"`,
+			},
+		},
+		{
+			name: "Invalid health check",
+			healthCheck: &webv1alpha1.UpstreamHealthCheck{
+				Type:             webv1alpha1.HealthCheckTypeHTTP,
+				Path:             "healthz",
+				Headers:          []webv1alpha1.NginxKV{{Key: "X Probe", Value: "1"}},
+				ExpectedStatuses: []string{"299-200", "abc"},
+				BodyMatch:        "(",
+			},
+			wantProblems: []string{
+				"healthCheck.path",
+				"healthCheck.headers",
+				"healthCheck.expectedStatuses: invalid status range \"299-200\"",
+				"healthCheck.expectedStatuses: invalid status \"abc\"",
+				"healthCheck.bodyMatch",
+			},
+		},
+		{
+			name:         "Invalid passive failure status",
+			passive:      &webv1alpha1.UpstreamPassiveHealthCheck{FailureStatuses: []string{"5xx"}},
+			wantProblems: []string{"passiveHealthCheck.failureStatuses: invalid status \"5xx\""},
+		},
+		{
+			name: "gRPC health check",
+			healthCheck: &webv1alpha1.UpstreamHealthCheck{
+				Type:             webv1alpha1.HealthCheckTypeGRPCS,
+				GRPCService:      "orders.v1.Orders",
+				MinCertValidDays: 14,
+			},
+		},
+		{
+			name: "gRPC and TLS options on an HTTP check",
+			healthCheck: &webv1alpha1.UpstreamHealthCheck{
+				Type:             webv1alpha1.HealthCheckTypeHTTP,
+				GRPCService:      "orders.v1.Orders",
+				MinCertValidDays: 14,
+			},
+			wantProblems: []string{
+				"healthCheck.grpcService: only supported by grpc and grpcs checks",
+				"healthCheck.minCertValidDays: only supported by https, grpcs and tls checks",
+			},
+		},
+		{
+			name:         "Dynamic FullURL upstream",
+			upstreamType: webv1alpha1.UpstreamTypeFullURL,
+			dynamic:      true,
+			wantProblems: []string{"updateStrategy Dynamic is only supported by Address upstreams"},
+		},
+		{
+			name:         "Sticky cookie of a FullURL upstream",
+			stickiness:   &webv1alpha1.UpstreamStickiness{Name: "route", TTLSeconds: 600, SameSite: "Strict"},
+			upstreamType: webv1alpha1.UpstreamTypeFullURL,
+		},
+		{
+			name:       "Invalid sticky cookie",
+			stickiness: &webv1alpha1.UpstreamStickiness{Name: "a b", Path: "app", SameSite: "None"},
+			wantProblems: []string{
+				"stickiness.name: invalid cookie name",
+				"stickiness.path",
+				"stickiness.sameSite None requires secure",
+			},
+		},
+		{
+			name:         "Sticky header without name",
+			stickiness:   &webv1alpha1.UpstreamStickiness{By: webv1alpha1.StickinessHeader},
+			wantProblems: []string{"stickiness.name"},
+		},
+		{
+			name:         "Sticky existing cookie without name",
+			stickiness:   &webv1alpha1.UpstreamStickiness{By: webv1alpha1.StickinessExistingCookie},
+			wantProblems: []string{"stickiness.name: invalid cookie name"},
+		},
+		{
+			name:         "Keepalive of a FullURL upstream",
+			keepalive:    &webv1alpha1.UpstreamKeepalive{Connections: 16},
+			upstreamType: webv1alpha1.UpstreamTypeFullURL,
+			wantProblems: []string{"keepalive is only supported by Address upstreams"},
+		},
+		{
+			name: "Runtime DNS resolution",
+			dns:  &webv1alpha1.UpstreamDNS{Resolution: webv1alpha1.DNSResolutionRuntime, MinTTLSeconds: 10, MaxTTLSeconds: 60},
+		},
+		{
+			name:         "Runtime DNS resolution of a FullURL upstream",
+			dns:          &webv1alpha1.UpstreamDNS{Resolution: webv1alpha1.DNSResolutionRuntime, MinTTLSeconds: 60, MaxTTLSeconds: 10},
+			upstreamType: webv1alpha1.UpstreamTypeFullURL,
+			wantProblems: []string{
+				"dns.resolution runtime is only supported by Address upstreams",
+				"dns.minTTLSeconds must not exceed dns.maxTTLSeconds",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamType := tt.upstreamType
+			if upstreamType == "" {
+				upstreamType = webv1alpha1.UpstreamTypeAddress
+			}
+			strategy := webv1alpha1.UpstreamUpdateReload
+			if tt.dynamic {
+				strategy = webv1alpha1.UpstreamUpdateDynamic
+			}
+			valid, problems := ValidateUpstream(&webv1alpha1.Upstream{
+				Spec: webv1alpha1.UpstreamSpec{
+					Type:               upstreamType,
+					Servers:            []webv1alpha1.UpstreamServer{{Address: "127.0.0.1:80"}},
+					LoadBalancer:       tt.loadBalancer,
+					HealthCheck:        tt.healthCheck,
+					PassiveHealthCheck: tt.passive,
+					DNS:                tt.dns,
+					Keepalive:          tt.keepalive,
+					Stickiness:         tt.stickiness,
+					UpdateStrategy:     strategy,
+				},
+			})
+			assert.Equal(t, len(tt.wantProblems) == 0, valid)
+			assert.Len(t, problems, len(tt.wantProblems))
+			for i, want := range tt.wantProblems {
+				assert.Contains(t, problems[i], want)
+			}
+		})
+	}
+}
+
+func TestUpstreamHealthProbe(t *testing.T) {
+	assert.Nil(t, UpstreamHealthProbe(nil))
+
+	probe := UpstreamHealthProbe(&webv1alpha1.UpstreamHealthCheck{
+		Type:             webv1alpha1.HealthCheckTypeHTTPS,
+		Path:             "/healthz",
+		Headers:          []webv1alpha1.NginxKV{{Key: "X-Probe", Value: "1"}},
+		ExpectedStatuses: []string{"200-299", "404"},
+		JitterSeconds:    3,
+	})
+	assert.Equal(t, &health.Probe{
+		Scheme:             "https",
+		Path:               "/healthz",
+		Headers:            []health.Header{{Name: "X-Probe", Value: "1"}},
+		ExpectedStatuses:   []health.StatusRange{{From: 200, To: 299}, {From: 404, To: 404}},
+		Interval:           10 * time.Second,
+		Timeout:            2 * time.Second,
+		Jitter:             3 * time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}, probe)
+
+	grpc := UpstreamHealthProbe(&webv1alpha1.UpstreamHealthCheck{
+		Type:             webv1alpha1.HealthCheckTypeGRPC,
+		GRPCService:      "orders.v1.Orders",
+		MinCertValidDays: 14,
+	})
+	assert.Equal(t, "grpc", grpc.Scheme)
+	assert.Equal(t, "orders.v1.Orders", grpc.ServiceName)
+	assert.Equal(t, 14, grpc.MinCertValidDays)
+
+	tcp := UpstreamHealthProbe(&webv1alpha1.UpstreamHealthCheck{IntervalSeconds: 5, UnhealthyThreshold: 1})
+	assert.Equal(t, "", tcp.Scheme)
+	assert.Equal(t, 5*time.Second, tcp.Interval)
+	assert.Equal(t, 1, tcp.UnhealthyThreshold)
+}
+
+func TestCertificateExpiryDays(t *testing.T) {
+	now := time.Date(2025, 10, 19, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, 30, certificateExpiryDays(now.Add(30*24*time.Hour+time.Hour), now))
+	assert.Equal(t, 0, certificateExpiryDays(now.Add(time.Hour), now))
+	assert.Equal(t, -1, certificateExpiryDays(now.Add(-time.Hour), now))
+}
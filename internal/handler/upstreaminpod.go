@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/utils"
+	"strconv"
+)
+
+const (
+	// InPodHealthPath is served by the reload-agent of every OpenResty pod on utils.ReloadAgentPort
+	// when the inPodHealthCheck of the OpenResty is enabled
+	InPodHealthPath = "/upstreams/health"
+
+	// InPodHealthTargetsSuffix is the targets file of an Upstream read by the reload-agent
+	InPodHealthTargetsSuffix = ".health.json"
+)
+
+// InPodHealthTargets is rendered into the ConfigMap of an Upstream, telling the reload-agent which servers to check
+type InPodHealthTargets struct {
+	// Upstream is the name of the upstream in the balancer options
+	Upstream    string                          `json:"upstream"`
+	Servers     []string                        `json:"servers"`
+	HealthCheck webv1alpha1.UpstreamHealthCheck `json:"healthCheck"`
+}
+
+// PodHealth maps the name of an upstream to the servers checked by the reload-agent of a pod
+type PodHealth map[string][]PodServerHealth
+
+// PodServerHealth is the in-pod view of a server
+type PodServerHealth struct {
+	Server string `json:"server"`
+	Alive  bool   `json:"alive"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// GenerateInPodHealthTargets renders the servers of an Upstream and its healthCheck, with the defaults applied,
+// for the reload-agent. Servers that are down for the operator are still listed, the pod may see them differently.
+func GenerateInPodHealthTargets(upstream *webv1alpha1.Upstream) string {
+	check := webv1alpha1.UpstreamHealthCheck{}
+	if upstream.Spec.HealthCheck != nil {
+		check = *upstream.Spec.HealthCheck
+	}
+	if check.Type == "" {
+		check.Type = webv1alpha1.HealthCheckTypeTCP
+	}
+	check.IntervalSeconds = intOr(check.IntervalSeconds, 10)
+	check.TimeoutSeconds = intOr(check.TimeoutSeconds, 2)
+	check.HealthyThreshold = intOr(check.HealthyThreshold, 2)
+	check.UnhealthyThreshold = intOr(check.UnhealthyThreshold, 3)
+
+	targets := InPodHealthTargets{
+		Upstream: utils.SanitizeName(upstream.Name),
+		Servers: utils.MapList(upstream.Spec.Servers, func(server webv1alpha1.UpstreamServer) string {
+			return server.Address
+		}),
+		HealthCheck: check,
+	}
+	data, _ := json.MarshalIndent(targets, "", "  ")
+	return string(data) + "\n"
+}
+
+// PodHealthURL returns the in-pod health endpoint of the reload-agent of an OpenResty pod
+func PodHealthURL(podIP string) string {
+	return "http://" + net.JoinHostPort(podIP, strconv.Itoa(utils.ReloadAgentPort)) + InPodHealthPath
+}
+
+// FetchPodHealth reads the in-pod view of the servers from the reload-agent of an OpenResty pod
+func FetchPodHealth(ctx context.Context, httpClient *http.Client, url string) (PodHealth, error) {
+	var health PodHealth
+	if err := fetchPodJSON(ctx, httpClient, url, &health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+// CountUnhealthyPods returns how many pods report each server of an Upstream down
+func CountUnhealthyPods(upstream *webv1alpha1.Upstream, pods []PodHealth) map[string]int {
+	name := utils.SanitizeName(upstream.Name)
+	counts := make(map[string]int)
+	for _, health := range pods {
+		for _, server := range health[name] {
+			if !server.Alive {
+				counts[server.Server]++
+			}
+		}
+	}
+	return counts
+}
+
+// ApplyUnhealthyPods records the number of pods reporting each server down in the server statuses
+func ApplyUnhealthyPods(statuses []webv1alpha1.UpstreamServerStatus, counts map[string]int) {
+	for i := range statuses {
+		statuses[i].UnhealthyPods = counts[statuses[i].Address]
+	}
+}
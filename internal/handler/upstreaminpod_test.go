@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGenerateInPodHealthTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream *webv1alpha1.Upstream
+		want     string
+	}{
+		{
+			name: "Defaults without healthCheck",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api.v1"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type:    webv1alpha1.UpstreamTypeAddress,
+					Servers: []webv1alpha1.UpstreamServer{{Address: "10.0.0.1:8080"}, {Address: "10.0.0.2:8080"}},
+				},
+			},
+			want: `{
+  "upstream": "api-v1",
+  "servers": [
+    "10.0.0.1:8080",
+    "10.0.0.2:8080"
+  ],
+  "healthCheck": {
+    "type": "tcp",
+    "intervalSeconds": 10,
+    "timeoutSeconds": 2,
+    "healthyThreshold": 2,
+    "unhealthyThreshold": 3
+  }
+}
+`,
+		},
+		{
+			name: "HTTP check keeps its settings",
+			upstream: &webv1alpha1.Upstream{
+				ObjectMeta: metav1.ObjectMeta{Name: "api"},
+				Spec: webv1alpha1.UpstreamSpec{
+					Type:    webv1alpha1.UpstreamTypeAddress,
+					Servers: []webv1alpha1.UpstreamServer{{Address: "10.0.0.1:8080"}},
+					HealthCheck: &webv1alpha1.UpstreamHealthCheck{
+						Type:             webv1alpha1.HealthCheckTypeHTTP,
+						Path:             "/healthz",
+						IntervalSeconds:  5,
+						ExpectedStatuses: []string{"200-299"},
+					},
+				},
+			},
+			want: `{
+  "upstream": "api",
+  "servers": [
+    "10.0.0.1:8080"
+  ],
+  "healthCheck": {
+    "type": "http",
+    "path": "/healthz",
+    "expectedStatuses": [
+      "200-299"
+    ],
+    "intervalSeconds": 5,
+    "timeoutSeconds": 2,
+    "healthyThreshold": 2,
+    "unhealthyThreshold": 3
+  }
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, GenerateInPodHealthTargets(tt.upstream))
+		})
+	}
+}
+
+func TestFetchPodHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != InPodHealthPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"api":[{"server":"10.0.0.1:8080","alive":true},{"server":"10.0.0.2:8080","alive":false,"reason":"TCP_FAIL"}]}`))
+	}))
+	defer server.Close()
+
+	podHealth, err := FetchPodHealth(context.Background(), server.Client(), server.URL+InPodHealthPath)
+	assert.NoError(t, err)
+	assert.Equal(t, PodHealth{
+		"api": {
+			{Server: "10.0.0.1:8080", Alive: true},
+			{Server: "10.0.0.2:8080", Reason: "TCP_FAIL"},
+		},
+	}, podHealth)
+
+	_, err = FetchPodHealth(context.Background(), server.Client(), server.URL+"/other")
+	assert.ErrorContains(t, err, "unexpected status 404")
+}
+
+func TestCountUnhealthyPods(t *testing.T) {
+	upstream := &webv1alpha1.Upstream{ObjectMeta: metav1.ObjectMeta{Name: "api.v1"}}
+	pods := []PodHealth{
+		{"api-v1": {{Server: "10.0.0.1:8080"}, {Server: "10.0.0.2:8080", Alive: true}}},
+		{"api-v1": {{Server: "10.0.0.1:8080"}, {Server: "10.0.0.2:8080"}}, "other": {{Server: "10.0.0.3:8080"}}},
+		{},
+	}
+
+	counts := CountUnhealthyPods(upstream, pods)
+	assert.Equal(t, map[string]int{"10.0.0.1:8080": 2, "10.0.0.2:8080": 1}, counts)
+
+	statuses := []webv1alpha1.UpstreamServerStatus{
+		{Address: "10.0.0.1:8080", Alive: true, Weight: 1},
+		{Address: "10.0.0.3:8080", Alive: true, Weight: 1},
+	}
+	ApplyUnhealthyPods(statuses, counts)
+	assert.Equal(t, []webv1alpha1.UpstreamServerStatus{
+		{Address: "10.0.0.1:8080", Alive: true, Weight: 1, UnhealthyPods: 2},
+		{Address: "10.0.0.3:8080", Alive: true, Weight: 1},
+	}, statuses)
+}
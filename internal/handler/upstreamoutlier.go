@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/utils"
+	"strconv"
+	"strings"
+)
+
+// UpstreamOutliersPath is served by every OpenResty pod on utils.NginxStatusPort,
+// listing the servers ejected by the passive health check of each upstream
+const UpstreamOutliersPath = "/upstreams/outliers"
+
+// PodOutliers maps the name of an upstream, as rendered in the balancer options, to the servers a pod ejects
+type PodOutliers map[string][]EjectedServer
+
+// EjectedServer is a server ejected by an OpenResty pod
+type EjectedServer struct {
+	// Server is the id of the server in the balancer: the address, or the URL of FullURL upstreams
+	Server string `json:"server"`
+	// Until is the unix time the ejection ends
+	Until float64 `json:"until"`
+}
+
+// PodOutliersURL returns the outlier endpoint of an OpenResty pod
+func PodOutliersURL(podIP string) string {
+	return "http://" + net.JoinHostPort(podIP, strconv.Itoa(utils.NginxStatusPort)) + UpstreamOutliersPath
+}
+
+// FetchPodOutliers reads the servers ejected by an OpenResty pod
+func FetchPodOutliers(ctx context.Context, httpClient *http.Client, url string) (PodOutliers, error) {
+	var outliers PodOutliers
+	if err := fetchPodJSON(ctx, httpClient, url, &outliers); err != nil {
+		return nil, err
+	}
+	return outliers, nil
+}
+
+// fetchPodJSON decodes the JSON served by a container of an OpenResty pod
+func fetchPodJSON(ctx context.Context, httpClient *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", url, err)
+	}
+	return nil
+}
+
+// CountEjectedPods returns how many pods eject each server of an Upstream
+func CountEjectedPods(upstream *webv1alpha1.Upstream, pods []PodOutliers) map[string]int {
+	name := utils.SanitizeName(upstream.Name)
+	counts := make(map[string]int)
+	for _, outliers := range pods {
+		seen := make(map[string]bool)
+		for _, ejected := range outliers[name] {
+			if !seen[ejected.Server] {
+				seen[ejected.Server] = true
+				counts[ejected.Server]++
+			}
+		}
+	}
+	return counts
+}
+
+// ApplyEjectedPods records the number of pods ejecting each server in the server statuses
+func ApplyEjectedPods(statuses []webv1alpha1.UpstreamServerStatus, counts map[string]int) {
+	normalized := make(map[string]int, len(counts))
+	for id, n := range counts {
+		normalized[balancerServerID(id)] += n
+	}
+	for i := range statuses {
+		statuses[i].EjectedPods = normalized[balancerServerID(statuses[i].Address)]
+	}
+}
+
+// balancerServerID 与 Lua 的 lb.id() 保持一致：Address 的 server 以 host:port 标识，缺省端口为 80，
+// FullURL 的 server 直接以 URL 标识
+func balancerServerID(address string) string {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return address
+	}
+	h, p, err := utils.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return h + ":" + p
+}
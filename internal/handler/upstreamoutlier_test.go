@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFetchPodOutliers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != UpstreamOutliersPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"api":[{"server":"10.0.0.1:8080","until":1760860800.5}],"ext":[]}`))
+	}))
+	defer server.Close()
+
+	outliers, err := FetchPodOutliers(context.Background(), server.Client(), server.URL+UpstreamOutliersPath)
+	assert.NoError(t, err)
+	assert.Equal(t, PodOutliers{
+		"api": {{Server: "10.0.0.1:8080", Until: 1760860800.5}},
+		"ext": {},
+	}, outliers)
+
+	_, err = FetchPodOutliers(context.Background(), server.Client(), server.URL+"/other")
+	assert.ErrorContains(t, err, "unexpected status 404")
+}
+
+func TestCountEjectedPods(t *testing.T) {
+	upstream := &webv1alpha1.Upstream{ObjectMeta: metav1.ObjectMeta{Name: "api.v1"}}
+	pods := []PodOutliers{
+		{"api-v1": {{Server: "10.0.0.1:8080"}, {Server: "10.0.0.2:8080"}}},
+		{"api-v1": {{Server: "10.0.0.1:8080"}}, "other": {{Server: "10.0.0.2:8080"}}},
+		{},
+	}
+
+	counts := CountEjectedPods(upstream, pods)
+	assert.Equal(t, map[string]int{"10.0.0.1:8080": 2, "10.0.0.2:8080": 1}, counts)
+
+	statuses := []webv1alpha1.UpstreamServerStatus{
+		{Address: "10.0.0.1:8080", Alive: true, Weight: 1},
+		{Address: "10.0.0.3:8080", Alive: true, Weight: 1},
+	}
+	ApplyEjectedPods(statuses, counts)
+	assert.Equal(t, []webv1alpha1.UpstreamServerStatus{
+		{Address: "10.0.0.1:8080", Alive: true, Weight: 1, EjectedPods: 2},
+		{Address: "10.0.0.3:8080", Alive: true, Weight: 1},
+	}, statuses)
+}
+
+func TestApplyEjectedPodsDefaultPort(t *testing.T) {
+	statuses := []webv1alpha1.UpstreamServerStatus{
+		{Address: "backend.default.svc", Alive: true, Weight: 1},
+		{Address: "10.0.0.1:80", Alive: true, Weight: 1},
+		{Address: "https://a.example.com", Alive: true, Weight: 1},
+	}
+	ApplyEjectedPods(statuses, map[string]int{
+		"backend.default.svc:80": 2,
+		"10.0.0.1:80":            1,
+		"https://a.example.com":  3,
+	})
+	assert.Equal(t, []int{2, 1, 3}, []int{statuses[0].EjectedPods, statuses[1].EjectedPods, statuses[2].EjectedPods})
+}
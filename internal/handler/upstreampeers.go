@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/runtime/health"
+	"openresty-operator/internal/utils"
+	"strconv"
+	"strings"
+)
+
+const (
+	// UpstreamPeersSuffix is the peers file of a Dynamic upstream, pushed by the reload-agent to UpstreamPeersPath
+	UpstreamPeersSuffix = ".peers.json"
+
+	// UpstreamPeersPath is served by the nginx status server on utils.NginxStatusPort, only to the pod itself
+	UpstreamPeersPath = "/upstreams/peers"
+)
+
+// UpstreamPeers is rendered into the ConfigMap of a Dynamic upstream instead of the servers table of the balancer
+type UpstreamPeers struct {
+	// Upstream is the name of the upstream in the balancer options
+	Upstream string `json:"upstream"`
+	// Version changes with the servers, pushing the same version twice is a no-op
+	Version string         `json:"version"`
+	Servers []UpstreamPeer `json:"servers"`
+}
+
+// UpstreamPeer mirrors an entry of the servers table read by upstreams.balancer
+type UpstreamPeer struct {
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	Weight      int      `json:"weight"`
+	Backup      bool     `json:"backup,omitempty"`
+	Drain       bool     `json:"drain,omitempty"`
+	MaxFails    int      `json:"max_fails"`
+	FailTimeout int      `json:"fail_timeout"`
+	IPs         []string `json:"ips"`
+}
+
+// IsDynamicUpstream reports whether the servers of an Upstream are pushed to the balancer without reloads
+func IsDynamicUpstream(upstream *webv1alpha1.Upstream) bool {
+	return upstream.Spec.Type == webv1alpha1.UpstreamTypeAddress &&
+		upstream.Spec.UpdateStrategy == webv1alpha1.UpstreamUpdateDynamic
+}
+
+// IsRuntimeUpstreamFile reports whether a ConfigMap key is only read at runtime, by the reload-agent or the Lua
+// balancer, and not by nginx itself
+func IsRuntimeUpstreamFile(key string) bool {
+	return strings.HasSuffix(key, UpstreamPeersSuffix) || strings.HasSuffix(key, InPodHealthTargetsSuffix)
+}
+
+// upstreamPeersFile is where the peers file of a Dynamic upstream is mounted in the OpenResty pods
+func upstreamPeersFile(upstream *webv1alpha1.Upstream) string {
+	return utils.NginxUpstreamConfigDir + "/" + upstream.Name + "/" + upstream.Name + UpstreamPeersSuffix
+}
+
+// GenerateUpstreamPeers renders the alive servers of a Dynamic upstream, "" for other upstreams or when no server
+// is alive, in which case the last peers are kept like the last config of a Reload upstream
+func GenerateUpstreamPeers(upstream *webv1alpha1.Upstream, results []*health.CheckResult) string {
+	if !IsDynamicUpstream(upstream) {
+		return ""
+	}
+
+	servers := upstreamServerIndex(upstream.Spec.Servers)
+	peers := make([]UpstreamPeer, 0, len(results))
+	for _, r := range results {
+		if !r.Alive {
+			continue
+		}
+		h, p, _ := utils.SplitHostPort(r.Address)
+		if utils.ValidatePort(p) != nil {
+			continue
+		}
+		port, _ := strconv.Atoi(p)
+		server := servers[r.Address]
+		maxFails, failTimeout := serverFailLimits(server)
+		ips := r.IPs
+		if ips == nil {
+			ips = []string{}
+		}
+		peers = append(peers, UpstreamPeer{
+			Host:        h,
+			Port:        port,
+			Weight:      EffectiveUpstreamWeight(server),
+			Backup:      server.Backup,
+			Drain:       server.Drain,
+			MaxFails:    maxFails,
+			FailTimeout: failTimeout,
+			IPs:         ips,
+		})
+	}
+	if len(peers) == 0 {
+		return ""
+	}
+
+	data, _ := json.Marshal(peers)
+	sum := sha256.Sum256(data)
+	file, _ := json.MarshalIndent(UpstreamPeers{
+		Upstream: utils.SanitizeName(upstream.Name),
+		Version:  hex.EncodeToString(sum[:])[:16],
+		Servers:  peers,
+	}, "", "  ")
+	return string(file) + "\n"
+}
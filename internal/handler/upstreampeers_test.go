@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/runtime/health"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGenerateUpstreamPeers(t *testing.T) {
+	maxFails := 3
+	upstream := &webv1alpha1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{Name: "api.v1"},
+		Spec: webv1alpha1.UpstreamSpec{
+			Type:           webv1alpha1.UpstreamTypeAddress,
+			UpdateStrategy: webv1alpha1.UpstreamUpdateDynamic,
+			Servers: []webv1alpha1.UpstreamServer{
+				{Address: "backend.local:8080", MaxFails: &maxFails},
+				{Address: "10.0.0.2:8080", Backup: true},
+				{Address: "10.0.0.3:8080"},
+			},
+		},
+	}
+	results := []*health.CheckResult{
+		{Address: "backend.local:8080", Alive: true, IPs: []string{"10.0.0.1"}},
+		{Address: "10.0.0.2:8080", Alive: true},
+		{Address: "10.0.0.3:8080", Reason: "TCP_FAIL"},
+	}
+
+	rendered := GenerateUpstreamPeers(upstream, results)
+	var peers UpstreamPeers
+	assert.NoError(t, json.Unmarshal([]byte(rendered), &peers))
+	assert.Equal(t, "api-v1", peers.Upstream)
+	assert.Len(t, peers.Version, 16)
+	assert.Equal(t, []UpstreamPeer{
+		{Host: "backend.local", Port: 8080, Weight: 1, MaxFails: 3, FailTimeout: 10, IPs: []string{"10.0.0.1"}},
+		{Host: "10.0.0.2", Port: 8080, Weight: 1, Backup: true, MaxFails: 1, FailTimeout: 10, IPs: []string{}},
+	}, peers.Servers)
+
+	assert.Equal(t, rendered, GenerateUpstreamPeers(upstream, results), "peers must be stable")
+
+	results[2].Alive = true
+	var changed UpstreamPeers
+	assert.NoError(t, json.Unmarshal([]byte(GenerateUpstreamPeers(upstream, results)), &changed))
+	assert.NotEqual(t, peers.Version, changed.Version)
+
+	assert.Empty(t, GenerateUpstreamPeers(upstream, []*health.CheckResult{{Address: "10.0.0.3:8080"}}),
+		"the last peers are kept when no server is alive")
+
+	upstream.Spec.UpdateStrategy = webv1alpha1.UpstreamUpdateReload
+	assert.Empty(t, GenerateUpstreamPeers(upstream, results))
+}
+
+func TestGenerateDynamicUpstreamConfig(t *testing.T) {
+	upstream := &webv1alpha1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{Name: "api"},
+		Spec: webv1alpha1.UpstreamSpec{
+			Type:           webv1alpha1.UpstreamTypeAddress,
+			UpdateStrategy: webv1alpha1.UpstreamUpdateDynamic,
+			Servers:        []webv1alpha1.UpstreamServer{{Address: "10.0.0.1:80"}, {Address: "10.0.0.2:80"}},
+		},
+	}
+	one := []*health.CheckResult{{Address: "10.0.0.1:80", Alive: true, IPs: []string{"10.0.0.1"}}}
+	two := append(one, &health.CheckResult{Address: "10.0.0.2:80", Alive: true, IPs: []string{"10.0.0.2"}})
+
+	config := GenerateUpstreamConfig(upstream, one)
+	assert.Equal(t, "upstream api {\n"+
+		"    server 0.0.0.1;\n"+
+		"    balancer_by_lua_block {\n"+
+		"        local servers = require(\"upstreams.dynamic\").peers(\"api\", \"/etc/nginx/conf.d/upstreams/api/api.peers.json\")\n\n"+
+		"        require(\"upstreams.balancer\").balance(servers, { name = \"api\", algorithm = \"random-weighted\" })\n"+
+		"    }\n"+
+		"}\n", config)
+	assert.Equal(t, config, GenerateUpstreamConfig(upstream, two), "server changes must not change the config")
+	assert.Empty(t, GenerateUpstreamConfig(upstream, []*health.CheckResult{{Address: "10.0.0.1:80"}}))
+}
+
+func TestIsRuntimeUpstreamFile(t *testing.T) {
+	assert.True(t, IsRuntimeUpstreamFile("api.peers.json"))
+	assert.True(t, IsRuntimeUpstreamFile("api.health.json"))
+	assert.False(t, IsRuntimeUpstreamFile("api.conf"))
+	assert.False(t, IsRuntimeUpstreamFile("api.lua"))
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/runtime/health"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ListFunc lists objects, like client.Client.List
+type ListFunc func(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error
+
+// ServiceEndpoint is a pod address behind the Service selected by an Upstream serviceRef
+type ServiceEndpoint struct {
+	// Address is the pod IP joined with the target port, e.g. "10.0.0.12:8080"
+	Address string
+	IP      string
+
+	// Terminating endpoints still serve the requests they have, but get no new ones
+	Terminating bool
+}
+
+// ServiceRefKey returns the "<namespace>/<name>" of the Service selected by an Upstream, "" without serviceRef
+func ServiceRefKey(upstream *webv1alpha1.Upstream) string {
+	ref := upstream.Spec.ServiceRef
+	if ref == nil {
+		return ""
+	}
+	return defaultOr(ref.Namespace, upstream.Namespace) + "/" + ref.Name
+}
+
+// ResolveServiceEndpoints reads the Service and EndpointSlices selected by the serviceRef of an Upstream
+func ResolveServiceEndpoints(ctx context.Context, get GetFunc, list ListFunc, upstream *webv1alpha1.Upstream) ([]ServiceEndpoint, error) {
+	ref := upstream.Spec.ServiceRef
+	namespace := defaultOr(ref.Namespace, upstream.Namespace)
+
+	var service corev1.Service
+	if err := get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &service); err != nil {
+		return nil, fmt.Errorf("failed to get Service %s/%s: %w", namespace, ref.Name, err)
+	}
+
+	var slices discoveryv1.EndpointSliceList
+	if err := list(ctx, &slices, client.InNamespace(namespace), client.MatchingLabels{discoveryv1.LabelServiceName: ref.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list EndpointSlices of Service %s/%s: %w", namespace, ref.Name, err)
+	}
+
+	return CollectServiceEndpoints(&service, ref.Port, slices.Items)
+}
+
+// CollectServiceEndpoints returns the ready endpoints of a Service port, followed by the terminating endpoints
+// still serving, sorted by address. Endpoints neither ready nor serving are left out.
+func CollectServiceEndpoints(service *corev1.Service, port intstr.IntOrString, slices []discoveryv1.EndpointSlice) ([]ServiceEndpoint, error) {
+	servicePort, err := findServicePort(service, port)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var ready, terminating []ServiceEndpoint
+	for _, slice := range slices {
+		// ExternalName 等 Service 的 EndpointSlice 可能是 FQDN 类型
+		if slice.AddressType != discoveryv1.AddressTypeIPv4 && slice.AddressType != discoveryv1.AddressTypeIPv6 {
+			continue
+		}
+
+		targetPort := int32(0)
+		for _, p := range slice.Ports {
+			if p.Port != nil && derefString(p.Name) == servicePort.Name {
+				targetPort = *p.Port
+				break
+			}
+		}
+		if targetPort == 0 {
+			continue
+		}
+
+		for _, ep := range slice.Endpoints {
+			if len(ep.Addresses) == 0 {
+				continue
+			}
+			// 同一 endpoint 的多个地址属于同一个 Pod，按约定取第一个
+			ip := ep.Addresses[0]
+			address := net.JoinHostPort(ip, strconv.Itoa(int(targetPort)))
+			if seen[address] {
+				continue
+			}
+
+			// Ready 为 nil 时按 ready 处理，见 EndpointConditions 的说明
+			isReady := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+			isServing := ep.Conditions.Serving == nil || *ep.Conditions.Serving
+			isTerminating := ep.Conditions.Terminating != nil && *ep.Conditions.Terminating
+
+			switch {
+			case isReady && !isTerminating:
+				ready = append(ready, ServiceEndpoint{Address: address, IP: ip})
+			case isTerminating && isServing:
+				terminating = append(terminating, ServiceEndpoint{Address: address, IP: ip, Terminating: true})
+			default:
+				continue
+			}
+			seen[address] = true
+		}
+	}
+
+	sortEndpoints := func(endpoints []ServiceEndpoint) {
+		sort.Slice(endpoints, func(i, j int) bool {
+			return endpoints[i].Address < endpoints[j].Address
+		})
+	}
+	sortEndpoints(ready)
+	sortEndpoints(terminating)
+	return append(ready, terminating...), nil
+}
+
+// ServiceEndpointServers turns endpoints into servers and check results rendered along with the static servers.
+// Terminating endpoints are rendered draining, so they get no new requests.
+func ServiceEndpointServers(endpoints []ServiceEndpoint) ([]webv1alpha1.UpstreamServer, []*health.CheckResult) {
+	servers := make([]webv1alpha1.UpstreamServer, 0, len(endpoints))
+	results := make([]*health.CheckResult, 0, len(endpoints))
+	for _, ep := range endpoints {
+		servers = append(servers, webv1alpha1.UpstreamServer{Address: ep.Address, Drain: ep.Terminating})
+		results = append(results, &health.CheckResult{Address: ep.Address, IPs: []string{ep.IP}, Alive: true})
+	}
+	return servers, results
+}
+
+func findServicePort(service *corev1.Service, port intstr.IntOrString) (*corev1.ServicePort, error) {
+	for i, p := range service.Spec.Ports {
+		if port.Type == intstr.String && p.Name == port.StrVal ||
+			port.Type == intstr.Int && p.Port == port.IntVal {
+			return &service.Spec.Ports[i], nil
+		}
+	}
+	return nil, fmt.Errorf("port %s not found in Service %s/%s", port.String(), service.Namespace, service.Name)
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCollectServiceEndpoints(t *testing.T) {
+	yes, no := true, false
+	httpName, metricsName := "http", "metrics"
+	httpPort, metricsPort := int32(8080), int32(9090)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "apps"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80},
+				{Name: "metrics", Port: 9090},
+			},
+		},
+	}
+	slices := []discoveryv1.EndpointSlice{
+		{
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports: []discoveryv1.EndpointPort{
+				{Name: &metricsName, Port: &metricsPort},
+				{Name: &httpName, Port: &httpPort},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &yes}},
+				{Addresses: []string{"10.0.0.1"}},
+				{Addresses: []string{"10.0.0.3"}, Conditions: discoveryv1.EndpointConditions{Ready: &no, Serving: &yes, Terminating: &yes}},
+				{Addresses: []string{"10.0.0.4"}, Conditions: discoveryv1.EndpointConditions{Ready: &no, Serving: &no, Terminating: &yes}},
+				{Addresses: []string{"10.0.0.5"}, Conditions: discoveryv1.EndpointConditions{Ready: &no}},
+			},
+		},
+		{
+			AddressType: discoveryv1.AddressTypeIPv6,
+			Ports:       []discoveryv1.EndpointPort{{Name: &httpName, Port: &httpPort}},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"fd00::1"}, Conditions: discoveryv1.EndpointConditions{Ready: &yes}},
+			},
+		},
+		{
+			AddressType: discoveryv1.AddressTypeFQDN,
+			Ports:       []discoveryv1.EndpointPort{{Name: &httpName, Port: &httpPort}},
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"backend.example.com"}}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		port    intstr.IntOrString
+		want    []ServiceEndpoint
+		wantErr string
+	}{
+		{
+			name: "Port by name",
+			port: intstr.FromString("http"),
+			want: []ServiceEndpoint{
+				{Address: "10.0.0.1:8080", IP: "10.0.0.1"},
+				{Address: "10.0.0.2:8080", IP: "10.0.0.2"},
+				{Address: "[fd00::1]:8080", IP: "fd00::1"},
+				{Address: "10.0.0.3:8080", IP: "10.0.0.3", Terminating: true},
+			},
+		},
+		{
+			name: "Port by number",
+			port: intstr.FromInt32(9090),
+			want: []ServiceEndpoint{
+				{Address: "10.0.0.1:9090", IP: "10.0.0.1"},
+				{Address: "10.0.0.2:9090", IP: "10.0.0.2"},
+				{Address: "10.0.0.3:9090", IP: "10.0.0.3", Terminating: true},
+			},
+		},
+		{
+			name:    "Unknown port",
+			port:    intstr.FromString("grpc"),
+			wantErr: "port grpc not found in Service apps/backend",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CollectServiceEndpoints(service, tt.port, slices)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolveServiceEndpoints(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = discoveryv1.AddToScheme(scheme)
+
+	name, port := "", int32(8080)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "apps"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "backend-abc",
+				Namespace: "apps",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "backend"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports:       []discoveryv1.EndpointPort{{Name: &name, Port: &port}},
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}}},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "other-abc",
+				Namespace: "apps",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "other"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports:       []discoveryv1.EndpointPort{{Name: &name, Port: &port}},
+			Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.9"}}},
+		},
+	).Build()
+
+	upstream := &webv1alpha1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "gateway"},
+		Spec: webv1alpha1.UpstreamSpec{
+			Type:       webv1alpha1.UpstreamTypeAddress,
+			ServiceRef: &webv1alpha1.UpstreamServiceRef{Name: "backend", Namespace: "apps", Port: intstr.FromInt32(80)},
+		},
+	}
+	assert.Equal(t, "apps/backend", ServiceRefKey(upstream))
+
+	endpoints, err := ResolveServiceEndpoints(context.Background(), c.Get, c.List, upstream)
+	assert.NoError(t, err)
+	assert.Equal(t, []ServiceEndpoint{{Address: "10.0.0.1:8080", IP: "10.0.0.1"}}, endpoints)
+
+	upstream.Spec.ServiceRef.Namespace = ""
+	assert.Equal(t, "gateway/backend", ServiceRefKey(upstream))
+	_, err = ResolveServiceEndpoints(context.Background(), c.Get, c.List, upstream)
+	assert.ErrorContains(t, err, "failed to get Service gateway/backend")
+}
+
+func TestServiceEndpointConfig(t *testing.T) {
+	servers, results := ServiceEndpointServers([]ServiceEndpoint{
+		{Address: "10.0.0.1:8080", IP: "10.0.0.1"},
+		{Address: "10.0.0.3:8080", IP: "10.0.0.3", Terminating: true},
+	})
+	upstream := &webv1alpha1.Upstream{
+		ObjectMeta: metav1.ObjectMeta{Name: "api"},
+		Spec: webv1alpha1.UpstreamSpec{
+			Type:    webv1alpha1.UpstreamTypeAddress,
+			Servers: servers,
+		},
+	}
+
+	assert.Contains(t, GenerateUpstreamConfig(upstream, results),
+		"            { host = \"10.0.0.1\", port = 8080, weight = 1, max_fails = 1, fail_timeout = 10, ips = { \"10.0.0.1\" } },\n"+
+			"            { host = \"10.0.0.3\", port = 8080, weight = 0, drain = true, max_fails = 1, fail_timeout = 10, ips = { \"10.0.0.3\" } },\n")
+	assert.Equal(t, []webv1alpha1.UpstreamServerStatus{
+		{Address: "10.0.0.1:8080", Alive: true, Weight: 1},
+		{Address: "10.0.0.3:8080", Alive: true, Weight: 0, Draining: true},
+	}, BuildUpstreamServerStatuses(upstream, results))
+}
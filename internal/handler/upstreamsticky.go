@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/constants"
+	"openresty-operator/internal/utils"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultAffinityCookie is the name of the cookie issued by the balancer when stickiness.name is empty
+	DefaultAffinityCookie = "affinity"
+
+	// StickinessSecretKey is the key of the user Secret holding the signing key
+	StickinessSecretKey = "key"
+)
+
+// UsesAffinityCookie reports whether the balancer issues a signed affinity cookie for the Upstream
+func UsesAffinityCookie(upstream *webv1alpha1.Upstream) bool {
+	s := upstream.Spec.Stickiness
+	return s != nil && (s.By == "" || s.By == webv1alpha1.StickinessCookie)
+}
+
+// StickinessSecretName is the Secret mounted into the OpenResty pods with the key signing the affinity cookies
+func StickinessSecretName(upstream *webv1alpha1.Upstream) string {
+	return "secret-sticky-" + upstream.Name
+}
+
+// affinityKeyName is the secrets_store key of the signing key, upstreams.sticky derives it from the upstream name
+func affinityKeyName(name string) string {
+	return "sticky/" + name
+}
+
+func validateStickiness(s *webv1alpha1.UpstreamStickiness) []string {
+	var problems []string
+	switch s.By {
+	case webv1alpha1.StickinessHeader:
+		if err := utils.ValidateHeaderName(s.Name); err != nil {
+			problems = append(problems, fmt.Sprintf("stickiness.name: %v", err))
+		}
+	case webv1alpha1.StickinessExistingCookie:
+		if s.Name == "" || utils.ValidateNginxToken(s.Name) != nil {
+			problems = append(problems, fmt.Sprintf("stickiness.name: invalid cookie name %q", s.Name))
+		}
+	default:
+		if s.Name != "" && utils.ValidateNginxToken(s.Name) != nil {
+			problems = append(problems, fmt.Sprintf("stickiness.name: invalid cookie name %q", s.Name))
+		}
+		if s.Path != "" && (!strings.HasPrefix(s.Path, "/") || strings.ContainsAny(s.Path, " \t\r\n;,")) {
+			problems = append(problems, fmt.Sprintf("stickiness.path: must start with '/' and contain no whitespace, ';' or ',': %q", s.Path))
+		}
+		if s.SameSite == "None" && !s.Secure {
+			problems = append(problems, "stickiness.sameSite None requires secure")
+		}
+	}
+	return problems
+}
+
+// renderStickyOptions 渲染 upstreams.lb 的 sticky 参数：已有的 cookie 或请求头按一致性哈希映射，
+// 签发的 cookie 由 upstreams.sticky 处理
+func renderStickyOptions(s *webv1alpha1.UpstreamStickiness) string {
+	switch s.By {
+	case webv1alpha1.StickinessHeader:
+		return fmt.Sprintf("{ hash = { by = \"header\", name = %s, vnodes = 160 } }", utils.QuoteLua(s.Name))
+	case webv1alpha1.StickinessExistingCookie:
+		return fmt.Sprintf("{ hash = { by = \"cookie\", name = %s, vnodes = 160 } }", utils.QuoteLua(s.Name))
+	}
+	return fmt.Sprintf("{ cookie = %s, ttl = %d, path = %s, samesite = %s, secure = %t }",
+		utils.QuoteLua(defaultOr(s.Name, DefaultAffinityCookie)),
+		s.TTLSeconds,
+		utils.QuoteLua(defaultOr(s.Path, "/")),
+		utils.QuoteLua(defaultOr(s.SameSite, "Lax")),
+		s.Secure)
+}
+
+// StickinessSigningKey returns the key signing the affinity cookies: the "key" of the user Secret when given,
+// otherwise the key already kept in the managed Secret, or a new random key
+func StickinessSigningKey(upstream *webv1alpha1.Upstream, source, managed *corev1.Secret) (string, error) {
+	if source != nil {
+		value := source.Data[StickinessSecretKey]
+		if len(value) == 0 {
+			return "", fmt.Errorf("key %q not found in secret %s/%s", StickinessSecretKey, source.Namespace, source.Name)
+		}
+		return hex.EncodeToString(value), nil
+	}
+
+	if managed != nil {
+		var keys map[string]string
+		if err := json.Unmarshal(managed.Data["keys.json"], &keys); err == nil {
+			if key := keys[affinityKeyName(utils.SanitizeName(upstream.Name))]; key != "" {
+				return key, nil
+			}
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// GenerateStickinessSecret renders the managed Secret loaded into secrets_store by the OpenResty pods
+func GenerateStickinessSecret(upstream *webv1alpha1.Upstream, key string) (*corev1.Secret, error) {
+	jsonBytes, err := json.Marshal(map[string]string{affinityKeyName(utils.SanitizeName(upstream.Name)): key})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal keys JSON: %w", err)
+	}
+
+	name := StickinessSecretName(upstream)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: upstream.Namespace,
+			Labels:    constants.BuildCommonLabels(upstream, "secret"),
+			Annotations: map[string]string{
+				// 挂载目录名，与 Location 的 secret 共用 secrets 目录
+				constants.AnnotationSecretHeaders: name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"keys.json": jsonBytes,
+		},
+	}, nil
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/constants"
+	"openresty-operator/internal/runtime/health"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGenerateStickyUpstreamConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		upstream   webv1alpha1.UpstreamSpec
+		results    []*health.CheckResult
+		wantSticky string
+	}{
+		{
+			name: "Issued cookie defaults",
+			upstream: webv1alpha1.UpstreamSpec{
+				Type:       webv1alpha1.UpstreamTypeAddress,
+				Stickiness: &webv1alpha1.UpstreamStickiness{},
+			},
+			results:    []*health.CheckResult{{Address: "10.0.0.1:80", Alive: true, IPs: []string{"10.0.0.1"}}},
+			wantSticky: `sticky = { cookie = "affinity", ttl = 0, path = "/", samesite = "Lax", secure = false } })`,
+		},
+		{
+			name: "Issued cookie of a FullURL upstream",
+			upstream: webv1alpha1.UpstreamSpec{
+				Type: webv1alpha1.UpstreamTypeFullURL,
+				Stickiness: &webv1alpha1.UpstreamStickiness{
+					By: webv1alpha1.StickinessCookie, Name: "route", TTLSeconds: 3600, Path: "/app", SameSite: "None", Secure: true,
+				},
+			},
+			results:    []*health.CheckResult{{Address: "https://a.example.com", Alive: true}},
+			wantSticky: `sticky = { cookie = "route", ttl = 3600, path = "/app", samesite = "None", secure = true } }`,
+		},
+		{
+			name: "Existing cookie",
+			upstream: webv1alpha1.UpstreamSpec{
+				Type:       webv1alpha1.UpstreamTypeAddress,
+				Stickiness: &webv1alpha1.UpstreamStickiness{By: webv1alpha1.StickinessExistingCookie, Name: "JSESSIONID"},
+			},
+			results:    []*health.CheckResult{{Address: "10.0.0.1:80", Alive: true, IPs: []string{"10.0.0.1"}}},
+			wantSticky: `sticky = { hash = { by = "cookie", name = "JSESSIONID", vnodes = 160 } } })`,
+		},
+		{
+			name: "Header",
+			upstream: webv1alpha1.UpstreamSpec{
+				Type:       webv1alpha1.UpstreamTypeAddress,
+				Stickiness: &webv1alpha1.UpstreamStickiness{By: webv1alpha1.StickinessHeader, Name: "X-Session"},
+			},
+			results:    []*health.CheckResult{{Address: "10.0.0.1:80", Alive: true, IPs: []string{"10.0.0.1"}}},
+			wantSticky: `sticky = { hash = { by = "header", name = "X-Session", vnodes = 160 } } })`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &webv1alpha1.Upstream{ObjectMeta: metav1.ObjectMeta{Name: "api"}, Spec: tt.upstream}
+			assert.Contains(t, GenerateUpstreamConfig(upstream, tt.results), tt.wantSticky)
+		})
+	}
+}
+
+func TestStickinessSigningKey(t *testing.T) {
+	upstream := &webv1alpha1.Upstream{ObjectMeta: metav1.ObjectMeta{Name: "api.v1", Namespace: "default"}}
+
+	generated, err := StickinessSigningKey(upstream, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, generated, 64)
+
+	managed, err := GenerateStickinessSecret(upstream, generated)
+	assert.NoError(t, err)
+	kept, err := StickinessSigningKey(upstream, nil, managed)
+	assert.NoError(t, err)
+	assert.Equal(t, generated, kept, "the generated key must survive reconciles")
+
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "affinity", Namespace: "default"},
+		Data:       map[string][]byte{StickinessSecretKey: []byte("s3cret")},
+	}
+	fromSource, err := StickinessSigningKey(upstream, source, managed)
+	assert.NoError(t, err)
+	assert.Equal(t, "733363726574", fromSource)
+
+	_, err = StickinessSigningKey(upstream, &corev1.Secret{ObjectMeta: source.ObjectMeta}, managed)
+	assert.EqualError(t, err, `key "key" not found in secret default/affinity`)
+}
+
+func TestGenerateStickinessSecret(t *testing.T) {
+	upstream := &webv1alpha1.Upstream{ObjectMeta: metav1.ObjectMeta{Name: "api.v1", Namespace: "default"}}
+
+	secret, err := GenerateStickinessSecret(upstream, "abcd")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-sticky-api.v1", secret.Name)
+	assert.Equal(t, "secret", secret.Labels[constants.LabelComponent])
+	assert.Equal(t, "secret-sticky-api.v1", secret.Annotations[constants.AnnotationSecretHeaders])
+
+	var keys map[string]string
+	assert.NoError(t, json.Unmarshal(secret.Data["keys.json"], &keys))
+	assert.Equal(t, map[string]string{"sticky/api-v1": "abcd"}, keys, "keyed by the upstream name of the balancer options")
+}
@@ -0,0 +1,323 @@
+package nginxconf
+
+// Context is the block a directive appears in, as a bit mask so a spec can allow several
+type Context uint32
+
+const (
+	ContextMain Context = 1 << iota
+	ContextEvents
+	ContextHTTP
+	ContextServer
+	ContextLocation
+	ContextUpstream
+	// ContextServerIf / ContextLocationIf are "if" blocks in server and location
+	ContextServerIf
+	ContextLocationIf
+	ContextLimitExcept
+)
+
+const (
+	ctxHSL    = ContextHTTP | ContextServer | ContextLocation
+	ctxHSLIf  = ctxHSL | ContextLocationIf
+	ctxAnyIf  = ctxHSLIf | ContextServerIf
+	ctxAnyAll = ContextMain | ContextEvents | ctxAnyIf | ContextUpstream | ContextLimitExcept
+)
+
+func (c Context) String() string {
+	switch c {
+	case ContextMain:
+		return "main"
+	case ContextEvents:
+		return "events"
+	case ContextHTTP:
+		return "http"
+	case ContextServer:
+		return "server"
+	case ContextLocation:
+		return "location"
+	case ContextUpstream:
+		return "upstream"
+	case ContextServerIf, ContextLocationIf:
+		return "if"
+	case ContextLimitExcept:
+		return "limit_except"
+	default:
+		return "unknown"
+	}
+}
+
+const unlimited = -1
+
+type directiveSpec struct {
+	contexts Context
+	minArgs  int
+	maxArgs  int
+	// flag directives only accept "on" or "off"
+	flag  bool
+	block bool
+	// unique directives may appear only once per block ("is duplicate" in nginx)
+	unique bool
+	// opaque blocks (map, types, ...) contain key/value pairs instead of directives
+	opaque bool
+	// childContext is the context of the directives inside a block
+	childContext Context
+}
+
+func simple(ctx Context, min, max int) directiveSpec {
+	return directiveSpec{contexts: ctx, minArgs: min, maxArgs: max}
+}
+
+func single(ctx Context, min, max int) directiveSpec {
+	return directiveSpec{contexts: ctx, minArgs: min, maxArgs: max, unique: true}
+}
+
+func flag(ctx Context) directiveSpec {
+	return directiveSpec{contexts: ctx, minArgs: 1, maxArgs: 1, flag: true, unique: true}
+}
+
+func block(ctx Context, min, max int, child Context) directiveSpec {
+	return directiveSpec{contexts: ctx, minArgs: min, maxArgs: max, block: true, childContext: child}
+}
+
+func opaque(ctx Context, min, max int) directiveSpec {
+	return directiveSpec{contexts: ctx, minArgs: min, maxArgs: max, block: true, opaque: true}
+}
+
+func lua(ctx Context, args int) directiveSpec {
+	return directiveSpec{contexts: ctx, minArgs: args, maxArgs: args, block: true, unique: true}
+}
+
+// knownDirectives covers the nginx core/http modules and ngx_lua directives shipped in the OpenResty image.
+// A name may have several specs when its meaning depends on the context ("server" in http vs upstream).
+var knownDirectives = map[string][]directiveSpec{
+	// core
+	"user":                    {single(ContextMain, 1, 2)},
+	"worker_processes":        {single(ContextMain, 1, 1)},
+	"worker_rlimit_nofile":    {single(ContextMain, 1, 1)},
+	"worker_shutdown_timeout": {single(ContextMain, 1, 1)},
+	"pid":                     {single(ContextMain, 1, 1)},
+	"daemon":                  {flag(ContextMain)},
+	"master_process":          {flag(ContextMain)},
+	"pcre_jit":                {flag(ContextMain)},
+	"env":                     {simple(ContextMain, 1, 1)},
+	"include":                 {simple(ctxAnyAll, 1, 1)},
+	"error_log":               {simple(ContextMain|ctxHSL, 1, 2)},
+	"events":                  {{contexts: ContextMain, block: true, unique: true, childContext: ContextEvents}},
+	"http":                    {{contexts: ContextMain, block: true, unique: true, childContext: ContextHTTP}},
+
+	// events
+	"worker_connections": {single(ContextEvents, 1, 1)},
+	"use":                {single(ContextEvents, 1, 1)},
+	"multi_accept":       {flag(ContextEvents)},
+	"accept_mutex":       {flag(ContextEvents)},
+
+	// http core
+	"server": {
+		block(ContextHTTP, 0, 0, ContextServer),
+		simple(ContextUpstream, 1, unlimited),
+	},
+	"location":                      {block(ContextServer|ContextLocation, 1, 2, ContextLocation)},
+	"if":                            {block(ContextServer|ContextLocation, 1, unlimited, 0)},
+	"limit_except":                  {block(ContextLocation, 1, unlimited, ContextLimitExcept)},
+	"listen":                        {simple(ContextServer, 1, unlimited)},
+	"server_name":                   {simple(ContextServer, 1, unlimited)},
+	"root":                          {single(ctxHSLIf, 1, 1)},
+	"alias":                         {single(ContextLocation, 1, 1)},
+	"index":                         {simple(ctxHSL, 1, unlimited)},
+	"try_files":                     {single(ContextServer|ContextLocation, 2, unlimited)},
+	"return":                        {simple(ContextServer|ContextLocation|ContextServerIf|ContextLocationIf, 1, 2)},
+	"rewrite":                       {simple(ContextServer|ContextLocation|ContextServerIf|ContextLocationIf, 2, 3)},
+	"set":                           {simple(ContextServer|ContextLocation|ContextServerIf|ContextLocationIf, 2, 2)},
+	"break":                         {simple(ContextServer|ContextLocation|ContextServerIf|ContextLocationIf, 0, 0)},
+	"internal":                      {single(ContextLocation, 0, 0)},
+	"error_page":                    {simple(ctxHSLIf, 2, unlimited)},
+	"client_max_body_size":          {single(ctxHSL, 1, 1)},
+	"client_body_buffer_size":       {single(ctxHSL, 1, 1)},
+	"client_body_timeout":           {single(ctxHSL, 1, 1)},
+	"client_header_timeout":         {single(ContextHTTP|ContextServer, 1, 1)},
+	"client_header_buffer_size":     {single(ContextHTTP|ContextServer, 1, 1)},
+	"client_body_temp_path":         {single(ctxHSL, 1, 4)},
+	"large_client_header_buffers":   {single(ContextHTTP|ContextServer, 2, 2)},
+	"keepalive_timeout":             {single(ctxHSL|ContextUpstream, 1, 2)},
+	"keepalive_requests":            {single(ctxHSL|ContextUpstream, 1, 1)},
+	"send_timeout":                  {single(ctxHSL, 1, 1)},
+	"sendfile":                      {flag(ctxHSLIf)},
+	"tcp_nopush":                    {flag(ctxHSL)},
+	"tcp_nodelay":                   {flag(ctxHSL)},
+	"aio":                           {single(ctxHSL, 1, 1)},
+	"directio":                      {single(ctxHSL, 1, 1)},
+	"output_buffers":                {single(ctxHSL, 2, 2)},
+	"postpone_output":               {single(ctxHSL, 1, 1)},
+	"chunked_transfer_encoding":     {flag(ctxHSL)},
+	"default_type":                  {single(ctxHSL, 1, 1)},
+	"types":                         {opaque(ctxHSL, 0, 0)},
+	"types_hash_max_size":           {single(ctxHSL, 1, 1)},
+	"types_hash_bucket_size":        {single(ctxHSL, 1, 1)},
+	"server_names_hash_max_size":    {single(ContextHTTP, 1, 1)},
+	"server_names_hash_bucket_size": {single(ContextHTTP, 1, 1)},
+	"variables_hash_max_size":       {single(ContextHTTP, 1, 1)},
+	"variables_hash_bucket_size":    {single(ContextHTTP, 1, 1)},
+	"map_hash_max_size":             {single(ContextHTTP, 1, 1)},
+	"map_hash_bucket_size":          {single(ContextHTTP, 1, 1)},
+	"resolver":                      {single(ctxHSL, 1, unlimited)},
+	"resolver_timeout":              {single(ctxHSL, 1, 1)},
+	"server_tokens":                 {single(ctxHSL, 1, 1)},
+	"underscores_in_headers":        {flag(ContextHTTP | ContextServer)},
+	"ignore_invalid_headers":        {flag(ContextHTTP | ContextServer)},
+	"merge_slashes":                 {flag(ContextHTTP | ContextServer)},
+	"absolute_redirect":             {flag(ctxHSL)},
+	"port_in_redirect":              {flag(ctxHSL)},
+	"server_name_in_redirect":       {flag(ctxHSL)},
+	"recursive_error_pages":         {flag(ctxHSL)},
+	"log_not_found":                 {flag(ctxHSL)},
+	"log_subrequest":                {flag(ctxHSL)},
+	"satisfy":                       {single(ctxHSL, 1, 1)},
+	"open_file_cache":               {single(ctxHSL, 1, 2)},
+	"open_file_cache_valid":         {single(ctxHSL, 1, 1)},
+	"open_file_cache_errors":        {flag(ctxHSL)},
+	"etag":                          {flag(ctxHSL)},
+	"charset":                       {single(ctxHSLIf, 1, 1)},
+	"map":                           {opaque(ContextHTTP, 2, 2)},
+	"geo":                           {opaque(ContextHTTP, 1, 2)},
+	"split_clients":                 {opaque(ContextHTTP, 2, 2)},
+	"stub_status":                   {single(ContextServer|ContextLocation, 0, 1)},
+	"mirror":                        {simple(ctxHSL, 1, 1)},
+	"http2":                         {flag(ContextHTTP | ContextServer)},
+
+	// log
+	"access_log": {simple(ctxHSLIf|ContextLimitExcept, 1, unlimited)},
+	"log_format": {simple(ContextHTTP, 2, unlimited)},
+
+	// headers / access
+	"add_header":           {simple(ctxHSLIf, 2, 3)},
+	"expires":              {single(ctxHSLIf, 1, 2)},
+	"allow":                {simple(ctxHSL|ContextLimitExcept, 1, 1)},
+	"deny":                 {simple(ctxHSL|ContextLimitExcept, 1, 1)},
+	"auth_basic":           {single(ctxHSL|ContextLimitExcept, 1, 1)},
+	"auth_basic_user_file": {single(ctxHSL|ContextLimitExcept, 1, 1)},
+	"real_ip_header":       {single(ctxHSL, 1, 1)},
+	"set_real_ip_from":     {simple(ctxHSL, 1, 1)},
+	"real_ip_recursive":    {flag(ctxHSL)},
+	"sub_filter":           {simple(ctxHSL, 2, 2)},
+	"sub_filter_once":      {flag(ctxHSL)},
+	"sub_filter_types":     {single(ctxHSL, 1, unlimited)},
+
+	// ssl
+	"ssl_certificate":           {simple(ContextHTTP|ContextServer, 1, 1)},
+	"ssl_certificate_key":       {simple(ContextHTTP|ContextServer, 1, 1)},
+	"ssl_protocols":             {single(ContextHTTP|ContextServer, 1, unlimited)},
+	"ssl_ciphers":               {single(ContextHTTP|ContextServer, 1, 1)},
+	"ssl_prefer_server_ciphers": {flag(ContextHTTP | ContextServer)},
+	"ssl_session_cache":         {single(ContextHTTP|ContextServer, 1, 2)},
+	"ssl_session_timeout":       {single(ContextHTTP|ContextServer, 1, 1)},
+	"ssl_client_certificate":    {single(ContextHTTP|ContextServer, 1, 1)},
+	"ssl_verify_client":         {single(ContextHTTP|ContextServer, 1, 1)},
+
+	// proxy
+	"proxy_pass":                    {single(ContextLocation|ContextLocationIf|ContextLimitExcept, 1, 1)},
+	"proxy_set_header":              {simple(ctxHSL, 2, 2)},
+	"proxy_http_version":            {single(ctxHSL, 1, 1)},
+	"proxy_connect_timeout":         {single(ctxHSL, 1, 1)},
+	"proxy_send_timeout":            {single(ctxHSL, 1, 1)},
+	"proxy_read_timeout":            {single(ctxHSL, 1, 1)},
+	"proxy_buffering":               {flag(ctxHSL)},
+	"proxy_request_buffering":       {flag(ctxHSL)},
+	"proxy_buffer_size":             {single(ctxHSL, 1, 1)},
+	"proxy_buffers":                 {single(ctxHSL, 2, 2)},
+	"proxy_busy_buffers_size":       {single(ctxHSL, 1, 1)},
+	"proxy_redirect":                {simple(ctxHSL, 1, 2)},
+	"proxy_hide_header":             {simple(ctxHSL, 1, 1)},
+	"proxy_pass_header":             {simple(ctxHSL, 1, 1)},
+	"proxy_ignore_headers":          {single(ctxHSL, 1, unlimited)},
+	"proxy_intercept_errors":        {flag(ctxHSL)},
+	"proxy_next_upstream":           {single(ctxHSL, 1, unlimited)},
+	"proxy_next_upstream_tries":     {single(ctxHSL, 1, 1)},
+	"proxy_next_upstream_timeout":   {single(ctxHSL, 1, 1)},
+	"proxy_cache":                   {single(ctxHSL, 1, 1)},
+	"proxy_cache_valid":             {simple(ctxHSL, 1, unlimited)},
+	"proxy_cache_key":               {single(ctxHSL, 1, 1)},
+	"proxy_cache_path":              {simple(ContextHTTP, 2, unlimited)},
+	"proxy_cache_bypass":            {simple(ctxHSL, 1, unlimited)},
+	"proxy_no_cache":                {simple(ctxHSL, 1, unlimited)},
+	"proxy_cache_methods":           {single(ctxHSL, 1, unlimited)},
+	"proxy_cache_lock":              {flag(ctxHSL)},
+	"proxy_cache_use_stale":         {single(ctxHSL, 1, unlimited)},
+	"proxy_temp_path":               {single(ctxHSL, 1, 4)},
+	"proxy_ssl_server_name":         {flag(ctxHSL)},
+	"proxy_ssl_name":                {single(ctxHSL, 1, 1)},
+	"proxy_ssl_verify":              {flag(ctxHSL)},
+	"proxy_ssl_trusted_certificate": {single(ctxHSL, 1, 1)},
+	"proxy_ssl_protocols":           {single(ctxHSL, 1, unlimited)},
+
+	// gzip
+	"gzip":              {flag(ctxHSLIf)},
+	"gzip_types":        {single(ctxHSL, 1, unlimited)},
+	"gzip_min_length":   {single(ctxHSL, 1, 1)},
+	"gzip_comp_level":   {single(ctxHSL, 1, 1)},
+	"gzip_vary":         {flag(ctxHSL)},
+	"gzip_proxied":      {single(ctxHSL, 1, unlimited)},
+	"gzip_http_version": {single(ctxHSL, 1, 1)},
+	"gzip_disable":      {simple(ctxHSL, 1, unlimited)},
+	"gzip_buffers":      {single(ctxHSL, 2, 2)},
+	"gzip_static":       {single(ctxHSL, 1, 1)},
+
+	// limits
+	"limit_req_zone":       {simple(ContextHTTP, 3, 4)},
+	"limit_req":            {simple(ctxHSL, 1, 3)},
+	"limit_req_status":     {single(ctxHSL, 1, 1)},
+	"limit_req_log_level":  {single(ctxHSL, 1, 1)},
+	"limit_req_dry_run":    {flag(ctxHSL)},
+	"limit_conn_zone":      {simple(ContextHTTP, 2, 2)},
+	"limit_conn":           {simple(ctxHSL, 2, 2)},
+	"limit_conn_status":    {single(ctxHSL, 1, 1)},
+	"limit_conn_log_level": {single(ctxHSL, 1, 1)},
+	"limit_conn_dry_run":   {flag(ctxHSL)},
+	"limit_rate":           {single(ctxHSLIf, 1, 1)},
+	"limit_rate_after":     {single(ctxHSLIf, 1, 1)},
+
+	// upstream
+	"upstream":       {block(ContextHTTP, 1, 1, ContextUpstream)},
+	"keepalive":      {single(ContextUpstream, 1, 1)},
+	"keepalive_time": {single(ContextUpstream|ctxHSL, 1, 1)},
+	"least_conn":     {single(ContextUpstream, 0, 0)},
+	"ip_hash":        {single(ContextUpstream, 0, 0)},
+	"hash":           {single(ContextUpstream, 1, 2)},
+	"random":         {single(ContextUpstream, 0, 2)},
+	"zone":           {single(ContextUpstream, 1, 2)},
+
+	// ngx_lua
+	"lua_package_path":             {single(ContextHTTP, 1, 1)},
+	"lua_package_cpath":            {single(ContextHTTP, 1, 1)},
+	"lua_code_cache":               {flag(ctxHSL)},
+	"lua_shared_dict":              {simple(ContextHTTP, 2, 2)},
+	"lua_need_request_body":        {flag(ctxHSL)},
+	"lua_socket_connect_timeout":   {single(ctxHSL, 1, 1)},
+	"lua_socket_send_timeout":      {single(ctxHSL, 1, 1)},
+	"lua_socket_read_timeout":      {single(ctxHSL, 1, 1)},
+	"lua_socket_pool_size":         {single(ctxHSL, 1, 1)},
+	"lua_socket_keepalive_timeout": {single(ctxHSL, 1, 1)},
+	"lua_ssl_trusted_certificate":  {single(ctxHSL, 1, 1)},
+	"lua_ssl_verify_depth":         {single(ctxHSL, 1, 1)},
+	"lua_max_pending_timers":       {single(ContextHTTP, 1, 1)},
+	"lua_max_running_timers":       {single(ContextHTTP, 1, 1)},
+	"init_by_lua_block":            {lua(ContextHTTP, 0)},
+	"init_worker_by_lua_block":     {lua(ContextHTTP, 0)},
+	"set_by_lua_block":             {{contexts: ContextServer | ContextLocation | ContextServerIf | ContextLocationIf, minArgs: 1, maxArgs: 1, block: true}},
+	"rewrite_by_lua_block":         {lua(ctxHSLIf, 0)},
+	"access_by_lua_block":          {lua(ctxHSLIf, 0)},
+	"content_by_lua_block":         {lua(ContextLocation|ContextLocationIf, 0)},
+	"header_filter_by_lua_block":   {lua(ctxHSLIf, 0)},
+	"body_filter_by_lua_block":     {lua(ctxHSLIf, 0)},
+	"log_by_lua_block":             {lua(ctxHSLIf, 0)},
+	"balancer_by_lua_block":        {lua(ContextUpstream, 0)},
+	"ssl_certificate_by_lua_block": {lua(ContextHTTP|ContextServer, 0)},
+	"init_by_lua_file":             {single(ContextHTTP, 1, 1)},
+	"init_worker_by_lua_file":      {single(ContextHTTP, 1, 1)},
+	"rewrite_by_lua_file":          {single(ctxHSLIf, 1, 1)},
+	"access_by_lua_file":           {single(ctxHSLIf, 1, 1)},
+	"content_by_lua_file":          {single(ContextLocation|ContextLocationIf, 1, 1)},
+	"header_filter_by_lua_file":    {single(ctxHSLIf, 1, 1)},
+	"body_filter_by_lua_file":      {single(ctxHSLIf, 1, 1)},
+	"log_by_lua_file":              {single(ctxHSLIf, 1, 1)},
+	"balancer_by_lua_file":         {single(ContextUpstream, 1, 1)},
+}
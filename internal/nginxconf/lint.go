@@ -0,0 +1,172 @@
+package nginxconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a single lint result, Line refers to the linted config text
+type Finding struct {
+	Line      int
+	Directive string
+	Severity  Severity
+	Message   string
+}
+
+func (f Finding) String() string {
+	if f.Directive == "" {
+		return fmt.Sprintf("line %d: [%s] %s", f.Line, f.Severity, f.Message)
+	}
+	return fmt.Sprintf("line %d: [%s] %s: %s", f.Line, f.Severity, f.Directive, f.Message)
+}
+
+type Findings []Finding
+
+func (fs Findings) HasErrors() bool {
+	for _, f := range fs {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs Findings) Errors() Findings {
+	var errs Findings
+	for _, f := range fs {
+		if f.Severity == SeverityError {
+			errs = append(errs, f)
+		}
+	}
+	return errs
+}
+
+func (fs Findings) Strings() []string {
+	if len(fs) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(fs))
+	for _, f := range fs {
+		out = append(out, f.String())
+	}
+	return out
+}
+
+// Lint parses config text that will be placed in ctx (e.g. a Location fragment is included
+// in ContextServer) and reports syntax errors, unknown directives, directives used in the
+// wrong context, wrong argument counts and duplicated directives.
+func Lint(src string, ctx Context) Findings {
+	dirs, err := Parse(src)
+	if err != nil {
+		line := 0
+		if perr, ok := err.(*ParseError); ok {
+			line = perr.Line
+		}
+		return Findings{{Line: line, Severity: SeverityError, Message: strings.TrimPrefix(err.Error(), fmt.Sprintf("line %d: ", line))}}
+	}
+
+	var findings Findings
+	lintBlock(dirs, ctx, &findings)
+	return findings
+}
+
+func lintBlock(dirs []*Directive, ctx Context, findings *Findings) {
+	seen := make(map[string]int)
+
+	for _, d := range dirs {
+		spec, known, wrongContext := lookup(d.Name, ctx)
+		if !known {
+			*findings = append(*findings, Finding{Line: d.Line, Directive: d.Name, Severity: SeverityWarning, Message: "unknown directive"})
+			// 未知指令的子块仍按当前上下文检查括号以外的内容
+			if d.IsBlock && !isLuaBlock(d.Name) {
+				lintBlock(d.Block, ctx, findings)
+			}
+			continue
+		}
+		if wrongContext {
+			*findings = append(*findings, Finding{Line: d.Line, Directive: d.Name, Severity: SeverityError,
+				Message: fmt.Sprintf("directive is not allowed in %s context", ctx)})
+			continue
+		}
+
+		if spec.block != d.IsBlock {
+			msg := "directive has no opening \"{\""
+			if d.IsBlock {
+				msg = "directive does not take a block"
+			}
+			*findings = append(*findings, Finding{Line: d.Line, Directive: d.Name, Severity: SeverityError, Message: msg})
+			continue
+		}
+
+		if len(d.Args) < spec.minArgs || (spec.maxArgs != unlimited && len(d.Args) > spec.maxArgs) {
+			*findings = append(*findings, Finding{Line: d.Line, Directive: d.Name, Severity: SeverityError,
+				Message: fmt.Sprintf("invalid number of arguments (%d, expected %s)", len(d.Args), describeArgs(spec))})
+		} else if spec.flag && d.Args[0] != "on" && d.Args[0] != "off" {
+			*findings = append(*findings, Finding{Line: d.Line, Directive: d.Name, Severity: SeverityError,
+				Message: fmt.Sprintf("invalid value %q, it must be \"on\" or \"off\"", d.Args[0])})
+		}
+
+		if spec.unique {
+			if first, ok := seen[d.Name]; ok {
+				*findings = append(*findings, Finding{Line: d.Line, Directive: d.Name, Severity: SeverityError,
+					Message: fmt.Sprintf("directive is duplicate, first defined at line %d", first)})
+			} else {
+				seen[d.Name] = d.Line
+			}
+		}
+
+		if d.Name == "root" || d.Name == "alias" {
+			other := "alias"
+			if d.Name == "alias" {
+				other = "root"
+			}
+			if first, ok := seen[other]; ok {
+				*findings = append(*findings, Finding{Line: d.Line, Directive: d.Name, Severity: SeverityError,
+					Message: fmt.Sprintf("directive is duplicate, %s directive was specified at line %d", other, first)})
+			}
+		}
+
+		if d.IsBlock && !spec.opaque && !isLuaBlock(d.Name) && d.Name != "set_by_lua_block" {
+			child := spec.childContext
+			if d.Name == "if" {
+				child = ContextLocationIf
+				if ctx == ContextServer {
+					child = ContextServerIf
+				}
+			}
+			lintBlock(d.Block, child, findings)
+		}
+	}
+}
+
+// lookup returns the spec of a directive valid in ctx; wrongContext is set when the directive exists but not in ctx
+func lookup(name string, ctx Context) (directiveSpec, bool, bool) {
+	specs, ok := knownDirectives[name]
+	if !ok {
+		return directiveSpec{}, false, false
+	}
+	for _, spec := range specs {
+		if spec.contexts&ctx != 0 {
+			return spec, true, false
+		}
+	}
+	return specs[0], true, true
+}
+
+func describeArgs(spec directiveSpec) string {
+	switch {
+	case spec.maxArgs == unlimited:
+		return fmt.Sprintf("at least %d", spec.minArgs)
+	case spec.minArgs == spec.maxArgs:
+		return fmt.Sprintf("%d", spec.minArgs)
+	default:
+		return fmt.Sprintf("%d-%d", spec.minArgs, spec.maxArgs)
+	}
+}
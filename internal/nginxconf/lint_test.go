@@ -0,0 +1,197 @@
+package nginxconf
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/handler"
+	"openresty-operator/internal/runtime/health"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	src := `
+# comment
+http {
+    log_format main '$remote_addr "$request" ${request_time}';
+    server {
+        listen 80;
+        location / {
+            content_by_lua_block {
+                -- } in a comment
+                local s = "}" .. '{'
+                local t = { a = [[ } ]] }
+                ngx.say(s)
+            }
+        }
+    }
+}
+`
+	dirs, err := Parse(src)
+	assert.NoError(t, err)
+	if assert.Len(t, dirs, 1) {
+		httpBlock := dirs[0]
+		assert.Equal(t, "http", httpBlock.Name)
+		assert.Equal(t, 3, httpBlock.Line)
+		assert.Equal(t, []string{"main", `$remote_addr "$request" ${request_time}`}, httpBlock.Block[0].Args)
+
+		location := httpBlock.Block[1].Block[1]
+		assert.Equal(t, []string{"/"}, location.Args)
+		assert.Contains(t, location.Block[0].Lua, "ngx.say(s)")
+	}
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name         string
+		src          string
+		ctx          Context
+		wantFindings []string
+	}{
+		{
+			name: "Valid location fragment",
+			src: `location /api/ {
+    set $location_path "/api/";
+    proxy_pass http://backend;
+    proxy_set_header Host $host;
+    if ($uri ~* \.(js|css)$) {
+        set $cache "max-age=60";
+    }
+    access_by_lua_block {
+        if ngx.var.arg_deny then return ngx.exit(403) end
+    }
+}
+`,
+			ctx: ContextServer,
+		},
+		{
+			name:         "Unbalanced braces",
+			src:          "location / {\n    proxy_pass http://a;\n",
+			ctx:          ContextServer,
+			wantFindings: []string{`line 1: [error] unexpected end of file, expecting "}"`},
+		},
+		{
+			name:         "Extra closing brace",
+			src:          "location / {\n}\n}\n",
+			ctx:          ContextServer,
+			wantFindings: []string{`line 3: [error] unexpected "}"`},
+		},
+		{
+			name:         "Missing semicolon",
+			src:          "location / {\n    proxy_pass http://a\n}\n",
+			ctx:          ContextServer,
+			wantFindings: []string{`line 3: [error] unexpected "}", expecting ";" after "proxy_pass"`},
+		},
+		{
+			name:         "Injected directive via semicolon",
+			src:          "location / {\n    add_header X-A a; }\nserver { listen 81; }\nlocation /b {\n}\n",
+			ctx:          ContextServer,
+			wantFindings: []string{"line 3: [error] server: directive is not allowed in server context"},
+		},
+		{
+			name:         "Unknown directive",
+			src:          "location / {\n    proxy_passs http://a;\n}\n",
+			ctx:          ContextServer,
+			wantFindings: []string{"line 2: [warning] proxy_passs: unknown directive"},
+		},
+		{
+			name:         "Wrong context",
+			src:          "location / {\n    listen 80;\n}\n",
+			ctx:          ContextServer,
+			wantFindings: []string{"line 2: [error] listen: directive is not allowed in location context"},
+		},
+		{
+			name: "Invalid number of arguments and flag value",
+			src:  "location / {\n    proxy_set_header X-A;\n    gzip yes;\n}\n",
+			ctx:  ContextServer,
+			wantFindings: []string{
+				"line 2: [error] proxy_set_header: invalid number of arguments (1, expected 2)",
+				`line 3: [error] gzip: invalid value "yes", it must be "on" or "off"`,
+			},
+		},
+		{
+			name: "Duplicate directives",
+			src:  "location / {\n    proxy_pass http://a;\n    proxy_pass http://b;\n    root /a;\n    alias /b/;\n}\n",
+			ctx:  ContextServer,
+			wantFindings: []string{
+				"line 3: [error] proxy_pass: directive is duplicate, first defined at line 2",
+				"line 5: [error] alias: directive is duplicate, root directive was specified at line 4",
+			},
+		},
+		{
+			name: "Server and upstream meaning of server",
+			src:  "upstream backend {\n    server 10.0.0.1:80 weight=2;\n    keepalive 16;\n}\nserver {\n    listen 80;\n}\n",
+			ctx:  ContextHTTP,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := Lint(tt.src, tt.ctx)
+			assert.Equal(t, tt.wantFindings, findings.Strings())
+		})
+	}
+}
+
+func TestRenderedConfigIsLintClean(t *testing.T) {
+	entries := []webv1alpha1.LocationEntry{
+		{
+			Path:                    "/api/",
+			ProxyPass:               "http://backend",
+			Headers:                 []webv1alpha1.NginxKV{{Key: "X-Env", Value: "prod"}},
+			Timeout:                 &webv1alpha1.Timeouts{Connect: "5s", Read: "30s"},
+			AccessLog:               ptr.To(false),
+			Gzip:                    &webv1alpha1.GzipConf{Enable: true, Types: []string{"application/json"}},
+			Lua:                     &webv1alpha1.LuaBlock{Access: "ngx.log(ngx.INFO, '{')"},
+			RateLimitPolicyRef:      &webv1alpha1.RateLimitPolicyReference{Name: "api-limit"},
+			TrafficShapingPolicyRef: &webv1alpha1.TrafficShapingPolicyReference{Name: "downloads"},
+			QuotaRef:                &webv1alpha1.QuotaReference{Name: "plan"},
+		},
+		{
+			Path:                  "/full/",
+			ProxyPass:             "https://example.com/v1",
+			ProxyPassIsFullURL:    true,
+			EnableUpstreamMetrics: true,
+			HeadersFromSecret:     []webv1alpha1.ValueFromSecret{{Name: "X-Token", SecretName: "s", SecretKey: "k"}},
+		},
+		{
+			Path: "/app/",
+			Static: &webv1alpha1.StaticConf{
+				ConfigMap:    &corev1.LocalObjectReference{Name: "bundle"},
+				Mode:         webv1alpha1.StaticModeAlias,
+				Index:        "index.html",
+				SPA:          true,
+				GzipStatic:   true,
+				CacheControl: []webv1alpha1.StaticCacheControl{{Extensions: []string{"js", "css"}, Value: "max-age=3600"}},
+			},
+		},
+	}
+	rateLimits := map[string]webv1alpha1.RateLimitPolicySpec{"api-limit": {ZoneName: "api", Rate: "10r/s", Burst: 5, NoDelay: true}}
+	trafficShaping := map[string]webv1alpha1.TrafficShapingPolicySpec{"downloads": {ZoneName: "dl", Connections: 2, LimitRate: "500k", LimitRateAfter: "1m"}}
+	quotas := map[string]webv1alpha1.QuotaSpec{"plan": {Period: webv1alpha1.QuotaPeriodDay, Limit: 1000}}
+	assert.Empty(t, Lint(handler.GenerateLocationConfig("demo", "default", entries, nil, nil, rateLimits, trafficShaping, quotas), ContextServer).Strings())
+
+	server := &webv1alpha1.ServerBlock{}
+	server.Name = "demo"
+	server.Spec.Listen = "80"
+	server.Spec.LocationRefs = []string{"demo"}
+	server.Spec.Headers = []webv1alpha1.NginxKV{{Key: "X-Frame-Options", Value: "DENY"}}
+	assert.Empty(t, Lint(handler.GenerateServerBlockConfig(server), ContextHTTP).Strings())
+
+	upstream := &webv1alpha1.Upstream{}
+	upstream.Name = "backend"
+	upstream.Spec.Type = webv1alpha1.UpstreamTypeAddress
+	results := []*health.CheckResult{{Address: "backend.default.svc:8080", IPs: []string{"10.0.0.1"}, Alive: true}}
+	assert.Empty(t, Lint(handler.GenerateUpstreamConfig(upstream, results), ContextHTTP).Strings())
+
+	http := &webv1alpha1.HttpBlock{
+		Include:           []string{"mime.types"},
+		LogFormat:         `$remote_addr [$time_local] "$request"`,
+		AccessLog:         "/var/log/nginx/access.log main",
+		ClientMaxBodySize: "10m",
+		Gzip:              true,
+	}
+	conf := handler.RenderNginxConf(http, &webv1alpha1.MetricsServer{Enable: true}, []string{"include /etc/nginx/conf.d/servers/demo/demo.conf;"})
+	assert.Empty(t, Lint(conf, ContextMain).Strings())
+}
@@ -0,0 +1,328 @@
+package nginxconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Directive is a single nginx directive, either simple ("name args;") or a block ("name args { ... }")
+type Directive struct {
+	Name string
+	Args []string
+	Line int
+
+	IsBlock bool
+	Block   []*Directive
+
+	// Lua holds the raw body of *_by_lua_block directives, which is not nginx syntax
+	Lua string
+}
+
+// ParseError is a syntax error in the nginx config, Line is 1-based
+type ParseError struct {
+	Line    int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+func isLuaBlock(name string) bool {
+	return strings.HasSuffix(name, "_by_lua_block")
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// Parse parses nginx config text into a directive tree
+func Parse(src string) ([]*Directive, error) {
+	p := &parser{src: src, line: 1}
+	dirs, err := p.parseBlock(false, 0)
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenSemicolon
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+func (p *parser) parseBlock(inBlock bool, openLine int) ([]*Directive, error) {
+	var dirs []*Directive
+	var cur *Directive
+
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.kind {
+		case tokenEOF:
+			if cur != nil {
+				return nil, &ParseError{Line: cur.Line, Message: fmt.Sprintf("unexpected end of file, expecting \";\" or \"}\" after \"%s\"", cur.Name)}
+			}
+			if inBlock {
+				return nil, &ParseError{Line: openLine, Message: "unexpected end of file, expecting \"}\""}
+			}
+			return dirs, nil
+
+		case tokenWord:
+			if cur == nil {
+				cur = &Directive{Name: tok.text, Line: tok.line}
+			} else {
+				cur.Args = append(cur.Args, tok.text)
+			}
+
+		case tokenSemicolon:
+			if cur == nil {
+				return nil, &ParseError{Line: tok.line, Message: "unexpected \";\""}
+			}
+			dirs = append(dirs, cur)
+			cur = nil
+
+		case tokenOpen:
+			if cur == nil {
+				return nil, &ParseError{Line: tok.line, Message: "unexpected \"{\""}
+			}
+			cur.IsBlock = true
+			if isLuaBlock(cur.Name) {
+				lua, err := p.readLua(tok.line)
+				if err != nil {
+					return nil, err
+				}
+				cur.Lua = lua
+			} else {
+				block, err := p.parseBlock(true, tok.line)
+				if err != nil {
+					return nil, err
+				}
+				cur.Block = block
+			}
+			dirs = append(dirs, cur)
+			cur = nil
+
+		case tokenClose:
+			if cur != nil {
+				return nil, &ParseError{Line: tok.line, Message: fmt.Sprintf("unexpected \"}\", expecting \";\" after \"%s\"", cur.Name)}
+			}
+			if !inBlock {
+				return nil, &ParseError{Line: tok.line, Message: "unexpected \"}\""}
+			}
+			return dirs, nil
+		}
+	}
+}
+
+func (p *parser) peek(offset int) byte {
+	if p.pos+offset >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos+offset]
+}
+
+func (p *parser) advance() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *parser) next() (token, error) {
+	// 跳过空白与注释
+	for p.pos < len(p.src) {
+		c := p.peek(0)
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			p.advance()
+			continue
+		}
+		if c == '#' {
+			for p.pos < len(p.src) && p.peek(0) != '\n' {
+				p.advance()
+			}
+			continue
+		}
+		break
+	}
+
+	if p.pos >= len(p.src) {
+		return token{kind: tokenEOF, line: p.line}, nil
+	}
+
+	line := p.line
+	switch p.peek(0) {
+	case ';':
+		p.advance()
+		return token{kind: tokenSemicolon, line: line}, nil
+	case '{':
+		p.advance()
+		return token{kind: tokenOpen, line: line}, nil
+	case '}':
+		p.advance()
+		return token{kind: tokenClose, line: line}, nil
+	case '"', '\'':
+		quote := p.advance()
+		var b strings.Builder
+		for {
+			if p.pos >= len(p.src) {
+				return token{}, &ParseError{Line: line, Message: "unterminated quoted string"}
+			}
+			c := p.advance()
+			if c == '\\' && p.pos < len(p.src) {
+				next := p.advance()
+				if next != quote && next != '\\' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(next)
+				continue
+			}
+			if c == quote {
+				break
+			}
+			b.WriteByte(c)
+		}
+		return token{kind: tokenWord, text: b.String(), line: line}, nil
+	}
+
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.peek(0)
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';' || c == '}' {
+			break
+		}
+		if c == '{' {
+			// ${var} 属于变量名的一部分
+			if p.pos > 0 && p.src[p.pos-1] == '$' {
+				for p.pos < len(p.src) && p.peek(0) != '}' {
+					b.WriteByte(p.advance())
+				}
+				if p.pos < len(p.src) {
+					b.WriteByte(p.advance())
+				}
+				continue
+			}
+			break
+		}
+		if c == '\\' && p.pos+1 < len(p.src) {
+			b.WriteByte(p.advance())
+		}
+		b.WriteByte(p.advance())
+	}
+	return token{kind: tokenWord, text: b.String(), line: line}, nil
+}
+
+// readLua consumes a Lua block body up to its matching "}", skipping braces inside
+// Lua strings and comments the same way ngx_lua does.
+func (p *parser) readLua(openLine int) (string, error) {
+	start := p.pos
+	depth := 1
+
+	for p.pos < len(p.src) {
+		c := p.peek(0)
+		switch {
+		case c == '-' && p.peek(1) == '-':
+			p.advance()
+			p.advance()
+			if level, ok := p.longBracketLevel(); ok {
+				if err := p.skipLongBracket(level); err != nil {
+					return "", err
+				}
+				continue
+			}
+			for p.pos < len(p.src) && p.peek(0) != '\n' {
+				p.advance()
+			}
+		case c == '"' || c == '\'':
+			line := p.line
+			quote := p.advance()
+			for {
+				if p.pos >= len(p.src) || p.peek(0) == '\n' {
+					return "", &ParseError{Line: line, Message: "unterminated Lua string"}
+				}
+				ch := p.advance()
+				if ch == '\\' && p.pos < len(p.src) {
+					p.advance()
+					continue
+				}
+				if ch == quote {
+					break
+				}
+			}
+		case c == '[':
+			if level, ok := p.longBracketLevel(); ok {
+				if err := p.skipLongBracket(level); err != nil {
+					return "", err
+				}
+				continue
+			}
+			p.advance()
+		case c == '{':
+			depth++
+			p.advance()
+		case c == '}':
+			depth--
+			if depth == 0 {
+				lua := p.src[start:p.pos]
+				p.advance()
+				return lua, nil
+			}
+			p.advance()
+		default:
+			p.advance()
+		}
+	}
+
+	return "", &ParseError{Line: openLine, Message: "unexpected end of file, expecting \"}\" to close Lua block"}
+}
+
+// longBracketLevel reports whether a Lua long bracket ("[[", "[=[", ...) starts at the current position
+func (p *parser) longBracketLevel() (int, bool) {
+	if p.peek(0) != '[' {
+		return 0, false
+	}
+	level := 0
+	for p.peek(1+level) == '=' {
+		level++
+	}
+	if p.peek(1+level) != '[' {
+		return 0, false
+	}
+	return level, true
+}
+
+func (p *parser) skipLongBracket(level int) error {
+	line := p.line
+	for i := 0; i < level+2; i++ {
+		p.advance()
+	}
+	closing := "]" + strings.Repeat("=", level) + "]"
+	for p.pos < len(p.src) {
+		if strings.HasPrefix(p.src[p.pos:], closing) {
+			for i := 0; i < len(closing); i++ {
+				p.advance()
+			}
+			return nil
+		}
+		p.advance()
+	}
+	return &ParseError{Line: line, Message: "unterminated Lua long string or comment"}
+}
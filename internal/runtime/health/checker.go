@@ -3,6 +3,8 @@ package health
 import (
 	"fmt"
 	"github.com/go-logr/logr"
+	"hash/fnv"
+	"math/rand"
 	"net"
 	"openresty-operator/internal/utils"
 	"sync"
@@ -15,12 +17,34 @@ var (
 	Checker *checker
 )
 
+// defaultInterval is the interval of the DNS and TCP check of servers without a Probe
+const defaultInterval = 60 * time.Second
+
 type CheckResult struct {
 	Address string
 	IPs     []string
 	Comment string
 	Alive   bool
 	Reason  string
+
+	// LastTransition is when Alive last changed, or when the address was first checked
+	LastTransition time.Time
+	// CertNotAfter is the expiry of the server certificate seen by the last https, grpcs or tls check
+	CertNotAfter time.Time
+}
+
+// Target is an address checked with an optional Probe, nil only resolves and dials it
+type Target struct {
+	Address string
+	Probe   *Probe
+}
+
+// Key identifies a Target in the checker, upstreams checking an address with the same Probe share its state
+func (t Target) Key() string {
+	if t.Probe == nil {
+		return t.Address
+	}
+	return t.Address + "#" + t.Probe.fingerprint()
 }
 
 func Init(workerCount int, timeout time.Duration, log logr.Logger) {
@@ -28,15 +52,17 @@ func Init(workerCount int, timeout time.Duration, log logr.Logger) {
 }
 
 type checker struct {
-	queue       workqueue.TypedRateLimitingInterface[string]
-	statusMap   map[string]*CheckResult
-	failures    map[string]int
-	refCount    map[string]int
-	maxFailures int
-	lock        sync.RWMutex
-	numWorker   int
-	timeout     time.Duration
-	log         logr.Logger
+	queue     workqueue.TypedRateLimitingInterface[string]
+	statusMap map[string]*CheckResult
+	targets   map[string]Target
+	successes map[string]int
+	failures  map[string]int
+	// owners 记录引用每个 target 的 Upstream，全部释放后才停止检查
+	owners    map[string]map[string]struct{}
+	lock      sync.RWMutex
+	numWorker int
+	timeout   time.Duration
+	log       logr.Logger
 }
 
 func newChecker(workerCount int, timeout time.Duration, log logr.Logger) *checker {
@@ -45,13 +71,14 @@ func newChecker(workerCount int, timeout time.Duration, log logr.Logger) *checke
 			workqueue.TypedRateLimitingQueueConfig[string]{
 				Name: "healthcheck",
 			}),
-		statusMap:   make(map[string]*CheckResult),
-		failures:    make(map[string]int),
-		maxFailures: 10,
-		numWorker:   workerCount,
-		timeout:     timeout,
-		refCount:    make(map[string]int),
-		log:         log.WithName("health-checker"),
+		statusMap: make(map[string]*CheckResult),
+		targets:   make(map[string]Target),
+		successes: make(map[string]int),
+		failures:  make(map[string]int),
+		owners:    make(map[string]map[string]struct{}),
+		numWorker: workerCount,
+		timeout:   timeout,
+		log:       log.WithName("health-checker"),
 	}
 	for i := 0; i < c.numWorker; i++ {
 		go c.worker()
@@ -59,112 +86,172 @@ func newChecker(workerCount int, timeout time.Duration, log logr.Logger) *checke
 	return c
 }
 
-func (c *checker) Submit(addresses []string) map[string]*CheckResult {
+// Submit starts checking the targets of owner and returns the latest results by address,
+// nil for targets not checked yet
+func (c *checker) Submit(owner string, targets []Target) map[string]*CheckResult {
 	results := make(map[string]*CheckResult)
-	c.lock.RLock()
-	for _, addr := range addresses {
-		val, ok := c.statusMap[addr]
-		if !ok {
-			c.lock.RUnlock()
-			c.lock.Lock()
-			c.refCount[addr]++
-			c.lock.Unlock()
-			c.queue.Add(addr)
-			c.lock.RLock()
-			results[addr] = nil
-		} else {
-			results[addr] = val
+	var added []string
+
+	c.lock.Lock()
+	for _, t := range targets {
+		key := t.Key()
+		if _, ok := c.targets[key]; !ok {
+			c.targets[key] = t
+			c.owners[key] = make(map[string]struct{})
+			added = append(added, key)
 		}
+		c.owners[key][owner] = struct{}{}
+		results[t.Address] = c.statusMap[key]
+	}
+	c.lock.Unlock()
+
+	for _, key := range added {
+		c.queue.Add(key)
 	}
-	c.lock.RUnlock()
 	return results
 }
 
-func (c *checker) Release(addresses []string) {
+// Release stops checking the targets once no owner references them
+func (c *checker) Release(owner string, targets []Target) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	for _, addr := range addresses {
-		if count, ok := c.refCount[addr]; ok {
-			if count <= 1 {
-				delete(c.refCount, addr)
-				delete(c.statusMap, addr)
-				delete(c.failures, addr)
-			} else {
-				c.refCount[addr]--
-			}
+	for _, t := range targets {
+		key := t.Key()
+		owners, ok := c.owners[key]
+		if !ok {
+			continue
+		}
+		delete(owners, owner)
+		if len(owners) == 0 {
+			delete(c.owners, key)
+			delete(c.targets, key)
+			delete(c.statusMap, key)
+			delete(c.successes, key)
+			delete(c.failures, key)
 		}
 	}
 }
 
 func (c *checker) worker() {
 	for {
-		item, shutdown := c.queue.Get()
+		key, shutdown := c.queue.Get()
 		if shutdown {
 			return
 		}
-		addr := item
-		result := c.performHealthCheck(addr)
-		c.log.Info("Health check completed", "address", addr, "alive", result.Alive, "reason", result.Reason, "time", time.Now().Format(time.RFC3339))
 
-		c.lock.Lock()
-		c.statusMap[addr] = result
-		if result.Alive {
-			c.failures[addr] = 0
-		} else {
-			c.failures[addr]++
+		c.lock.RLock()
+		target, ok := c.targets[key]
+		c.lock.RUnlock()
+		if !ok {
+			// 已被 Release，不再检查
+			c.queue.Forget(key)
+			c.queue.Done(key)
+			continue
 		}
-		shouldRequeue := c.failures[addr] < c.maxFailures
-		if !shouldRequeue {
-			delete(c.statusMap, addr)
-			delete(c.failures, addr)
-			delete(c.refCount, addr)
+
+		observed := c.performHealthCheck(target)
+		c.log.V(1).Info("Health check completed", "address", target.Address, "alive", observed.Alive, "reason", observed.Reason)
+
+		c.lock.Lock()
+		_, tracked := c.targets[key]
+		if tracked {
+			result := c.transition(key, target.Probe, observed)
+			if prev := c.statusMap[key]; prev == nil || prev.Alive != result.Alive {
+				c.log.Info("Health state changed", "address", target.Address, "alive", result.Alive, "reason", result.Reason)
+			}
+			c.statusMap[key] = result
 		}
 		c.lock.Unlock()
 
-		if shouldRequeue {
-			c.queue.AddAfter(addr, 60*time.Second)
+		// 持续检查失败的地址，保持其 down 状态，直到不再被引用
+		if tracked {
+			c.queue.AddAfter(key, target.Probe.nextInterval())
 		}
+		c.queue.Done(key)
+	}
+}
+
+// transition applies the thresholds of the probe to an observed result. The first check decides the state,
+// later an up server goes down after unhealthyThreshold consecutive failures and back up after healthyThreshold
+// consecutive successes. Must be called with the lock held.
+func (c *checker) transition(key string, probe *Probe, observed *CheckResult) *CheckResult {
+	if observed.Alive {
+		c.successes[key]++
+		c.failures[key] = 0
+	} else {
+		c.failures[key]++
+		c.successes[key] = 0
+	}
+
+	prev := c.statusMap[key]
+	result := *observed
+	if prev == nil {
+		result.LastTransition = time.Now()
+		return &result
+	}
+
+	switch {
+	case prev.Alive && !observed.Alive && c.failures[key] < probe.unhealthyThreshold():
+		// 未达到阈值前仍按上一次的结果渲染
+		result = *prev
+	case !prev.Alive && observed.Alive && c.successes[key] < probe.healthyThreshold():
+		result.Alive = false
+		result.Reason = prev.Reason
+		result.Comment = prev.Comment
+	}
 
-		c.queue.Done(item)
+	// 证书有效期总是使用最新一次检查的结果
+	result.CertNotAfter = observed.CertNotAfter
+	if result.Alive == prev.Alive {
+		result.LastTransition = prev.LastTransition
+	} else {
+		result.LastTransition = time.Now()
 	}
+	return &result
 }
 
-func (c *checker) performHealthCheck(addr string) *CheckResult {
+func (c *checker) performHealthCheck(target Target) *CheckResult {
+	addr := target.Address
 	host, port, _ := utils.SplitHostPort(addr)
 	ips := c.lookupHost(host)
-	dnsIsReady := len(ips) > 0
-	tcpIsReady := true
-	if dnsIsReady {
-		tcpIsReady = c.testTCP(net.JoinHostPort(host, port))
-	}
-
-	return &CheckResult{
-		Address: addr,
-		Alive:   tcpIsReady && dnsIsReady,
-		IPs:     ips,
-		Reason: func() string {
-			if !dnsIsReady {
-				return "DNS_ERROR"
-			}
-			if !tcpIsReady {
-				return "TCP_FAIL"
-			}
-			return ""
-		}(),
-		Comment: func() string {
-			if !dnsIsReady {
-				return fmt.Sprintf("# server %s;  // DNS error", addr)
-			}
-			if !tcpIsReady {
-				return fmt.Sprintf("# server %s;  // tcp unreachable", addr)
-			}
-			return ""
-		}(),
+	if len(ips) == 0 {
+		return &CheckResult{
+			Address: addr,
+			Reason:  "DNS_ERROR",
+			Comment: fmt.Sprintf("# server %s;  // DNS error", addr),
+		}
 	}
+
+	result := &CheckResult{Address: addr, IPs: ips, Alive: true}
+	if p := target.Probe; p != nil && p.Scheme != "" {
+		var outcome probeOutcome
+		switch {
+		case p.isHTTP():
+			outcome = c.testHTTP(host, port, p)
+		case p.isGRPC():
+			outcome = c.testGRPC(host, port, p)
+		default:
+			outcome = c.testTLS(host, port, p)
+		}
+		result.CertNotAfter = outcome.certNotAfter
+		if outcome.reason != "" {
+			result.Alive = false
+			result.Reason = outcome.reason
+			result.Comment = fmt.Sprintf("# server %s;  // %s", addr, outcome.comment)
+		}
+		return result
+	}
+
+	if !c.testTCP(net.JoinHostPort(host, port), target.Probe.timeoutOr(c.timeout)) {
+		result.Alive = false
+		result.Reason = "TCP_FAIL"
+		result.Comment = fmt.Sprintf("# server %s;  // tcp unreachable", addr)
+	}
+	return result
 }
 
-func (c *checker) testTCP(address string) bool {
-	conn, err := net.DialTimeout("tcp", address, c.timeout)
+func (c *checker) testTCP(address string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", address, timeout)
 	if err != nil {
 		return false
 	}
@@ -179,3 +266,17 @@ func (c *checker) lookupHost(address string) []string {
 	}
 	return ips
 }
+
+// jitter returns a random duration in [0, max)
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+func hashString(s string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return fmt.Sprintf("%x", h.Sum64())
+}
@@ -0,0 +1,197 @@
+package health
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    StatusRange
+		wantErr bool
+	}{
+		{in: "200", want: StatusRange{From: 200, To: 200}},
+		{in: "200-299", want: StatusRange{From: 200, To: 299}},
+		{in: " 404 ", want: StatusRange{From: 404, To: 404}},
+		{in: "299-200", wantErr: true},
+		{in: "600", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "5xx", wantErr: true},
+		{in: "200-2x9", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseStatusRange(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTransition(t *testing.T) {
+	c := &checker{
+		statusMap: make(map[string]*CheckResult),
+		successes: make(map[string]int),
+		failures:  make(map[string]int),
+	}
+	probe := &Probe{HealthyThreshold: 2, UnhealthyThreshold: 3}
+	up := &CheckResult{Address: "a:80", Alive: true}
+	down := &CheckResult{Address: "a:80", Reason: "HTTP_STATUS"}
+
+	observe := func(r *CheckResult) *CheckResult {
+		result := c.transition("a:80", probe, r)
+		c.statusMap["a:80"] = result
+		return result
+	}
+
+	// 首次检查直接决定状态
+	assert.True(t, observe(up).Alive)
+	first := c.statusMap["a:80"].LastTransition
+
+	assert.True(t, observe(down).Alive)
+	assert.True(t, observe(down).Alive)
+	assert.Equal(t, first, c.statusMap["a:80"].LastTransition)
+
+	result := observe(down)
+	assert.False(t, result.Alive)
+	assert.Equal(t, "HTTP_STATUS", result.Reason)
+	assert.NotEqual(t, first, result.LastTransition)
+
+	assert.False(t, observe(up).Alive)
+	assert.Equal(t, "HTTP_STATUS", c.statusMap["a:80"].Reason)
+	assert.True(t, observe(up).Alive)
+}
+
+func TestTestHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			if r.Header.Get("X-Probe") != "1" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"status": "ok"}`))
+		case "/moved":
+			http.Redirect(w, r, "/healthz", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	c := newChecker(0, time.Second, logr.Discard())
+	probe := func(p Probe) *Probe {
+		p.Scheme = "http"
+		p.Headers = []Header{{Name: "X-Probe", Value: "1"}}
+		return &p
+	}
+
+	tests := []struct {
+		name       string
+		probe      *Probe
+		wantReason string
+	}{
+		{name: "Healthy", probe: probe(Probe{Path: "/healthz", BodyMatch: `"status":\s*"ok"`})},
+		{name: "Unexpected status", probe: probe(Probe{Path: "/down"}), wantReason: "HTTP_STATUS"},
+		{name: "Expected status", probe: probe(Probe{Path: "/down", ExpectedStatuses: []StatusRange{{From: 503, To: 503}}})},
+		{name: "Redirect not followed", probe: probe(Probe{Path: "/moved", ExpectedStatuses: []StatusRange{{From: 200, To: 299}}}), wantReason: "HTTP_STATUS"},
+		{name: "Body mismatch", probe: probe(Probe{Path: "/healthz", BodyMatch: "fail"}), wantReason: "HTTP_BODY"},
+		{name: "Missing header", probe: &Probe{Scheme: "http", Path: "/healthz"}, wantReason: "HTTP_STATUS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantReason, c.testHTTP(host, port, tt.probe).reason)
+		})
+	}
+
+	server.Close()
+	assert.Equal(t, "HTTP_ERROR", c.testHTTP(host, port, probe(Probe{Path: "/healthz", Timeout: 100 * time.Millisecond})).reason)
+}
+
+func TestTestTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	notAfter := server.Certificate().NotAfter
+	c := newChecker(0, time.Second, logr.Discard())
+
+	tests := []struct {
+		name         string
+		probe        *Probe
+		wantReason   string
+		wantNotAfter bool
+	}{
+		{name: "Untrusted chain", probe: &Probe{Scheme: "tls"}, wantReason: "TLS_ERROR"},
+		{name: "Skip verify", probe: &Probe{Scheme: "tls", InsecureSkipVerify: true}, wantNotAfter: true},
+		{name: "Expiring", probe: &Probe{Scheme: "tls", InsecureSkipVerify: true, MinCertValidDays: 365 * 70}, wantReason: "TLS_EXPIRING", wantNotAfter: true},
+		{name: "HTTPS records expiry", probe: &Probe{Scheme: "https", InsecureSkipVerify: true, ExpectedStatuses: []StatusRange{{From: 404, To: 404}}}, wantNotAfter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outcome probeOutcome
+			if tt.probe.isHTTP() {
+				outcome = c.testHTTP(host, port, tt.probe)
+			} else {
+				outcome = c.testTLS(host, port, tt.probe)
+			}
+			assert.Equal(t, tt.wantReason, outcome.reason)
+			if tt.wantNotAfter {
+				assert.True(t, notAfter.Equal(outcome.certNotAfter))
+			} else {
+				assert.True(t, outcome.certNotAfter.IsZero())
+			}
+		})
+	}
+}
+
+func TestTestGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	healthServer := grpchealth.NewServer()
+	healthServer.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("payments", healthpb.HealthCheckResponse_NOT_SERVING)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	c := newChecker(0, time.Second, logr.Discard())
+
+	tests := []struct {
+		name       string
+		service    string
+		wantReason string
+	}{
+		{name: "Server", service: ""},
+		{name: "Serving service", service: "orders"},
+		{name: "Not serving service", service: "payments", wantReason: "GRPC_STATUS"},
+		{name: "Unknown service", service: "users", wantReason: "GRPC_ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome := c.testGRPC(host, port, &Probe{Scheme: "grpc", ServiceName: tt.service})
+			assert.Equal(t, tt.wantReason, outcome.reason, outcome.comment)
+		})
+	}
+}
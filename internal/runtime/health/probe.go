@@ -0,0 +1,307 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxBodyMatchSize limits how much of a response body is read for BodyMatch
+	maxBodyMatchSize = 64 << 10
+
+	userAgent = "openresty-operator-health-check"
+)
+
+// Probe is the active check of a Target along with the thresholds moving it between up and down
+type Probe struct {
+	// Scheme is "http" or "https" for HTTP checks, "grpc" or "grpcs" for gRPC checks, "tls" for a TLS handshake,
+	// empty for a TCP dial
+	Scheme             string
+	Method             string
+	Path               string
+	Host               string
+	Headers            []Header
+	ExpectedStatuses   []StatusRange
+	BodyMatch          string
+	InsecureSkipVerify bool
+
+	// ServiceName is sent in the grpc.health.v1 request, empty checks the whole server
+	ServiceName string
+	// MinCertValidDays fails TLS checks when the certificate expires within this many days
+	MinCertValidDays int
+
+	Interval           time.Duration
+	Timeout            time.Duration
+	Jitter             time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+type Header struct {
+	Name  string
+	Value string
+}
+
+// StatusRange is an inclusive range of HTTP status codes
+type StatusRange struct {
+	From int
+	To   int
+}
+
+// ParseStatusRange parses "200" or "200-299"
+func ParseStatusRange(s string) (StatusRange, error) {
+	from, to, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	var r StatusRange
+	var err error
+	if r.From, err = strconv.Atoi(from); err != nil {
+		return r, fmt.Errorf("invalid status %q", s)
+	}
+	r.To = r.From
+	if isRange {
+		if r.To, err = strconv.Atoi(to); err != nil {
+			return r, fmt.Errorf("invalid status range %q", s)
+		}
+	}
+	if r.From < 100 || r.To > 599 || r.From > r.To {
+		return r, fmt.Errorf("invalid status range %q", s)
+	}
+	return r, nil
+}
+
+func (p *Probe) fingerprint() string {
+	return hashString(fmt.Sprintf("%+v", *p))
+}
+
+func (p *Probe) isHTTP() bool {
+	return p.Scheme == "http" || p.Scheme == "https"
+}
+
+func (p *Probe) isGRPC() bool {
+	return p.Scheme == "grpc" || p.Scheme == "grpcs"
+}
+
+// probeOutcome is the result of a single HTTP, gRPC or TLS check, reason is empty when it passes
+type probeOutcome struct {
+	reason  string
+	comment string
+	// certNotAfter is the expiry of the server certificate, zero without TLS
+	certNotAfter time.Time
+}
+
+func failed(reason, format string, args ...any) probeOutcome {
+	return probeOutcome{reason: reason, comment: fmt.Sprintf(format, args...)}
+}
+
+func (p *Probe) serverName(host string) string {
+	if p.Host != "" {
+		return p.Host
+	}
+	return host
+}
+
+func (p *Probe) tlsConfig(host string) *tls.Config {
+	return &tls.Config{
+		ServerName:         p.serverName(host),
+		InsecureSkipVerify: p.InsecureSkipVerify,
+	}
+}
+
+// checkCertificate records the expiry of the leaf certificate. The chain and the server name are verified
+// during the handshake unless InsecureSkipVerify, an expired certificate always fails.
+func (p *Probe) checkCertificate(state *tls.ConnectionState, outcome probeOutcome) probeOutcome {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return outcome
+	}
+	leaf := state.PeerCertificates[0]
+	outcome.certNotAfter = leaf.NotAfter
+	if outcome.reason != "" {
+		return outcome
+	}
+
+	left := time.Until(leaf.NotAfter)
+	switch {
+	case left <= 0:
+		outcome.reason, outcome.comment = "TLS_EXPIRED", fmt.Sprintf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	case p.MinCertValidDays > 0 && left < time.Duration(p.MinCertValidDays)*24*time.Hour:
+		outcome.reason, outcome.comment = "TLS_EXPIRING", fmt.Sprintf("certificate expires at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return outcome
+}
+
+func (p *Probe) nextInterval() time.Duration {
+	if p == nil {
+		return defaultInterval
+	}
+	return p.Interval + jitter(p.Jitter)
+}
+
+func (p *Probe) timeoutOr(fallback time.Duration) time.Duration {
+	if p == nil || p.Timeout <= 0 {
+		return fallback
+	}
+	return p.Timeout
+}
+
+func (p *Probe) healthyThreshold() int {
+	if p == nil || p.HealthyThreshold <= 0 {
+		return 1
+	}
+	return p.HealthyThreshold
+}
+
+func (p *Probe) unhealthyThreshold() int {
+	if p == nil || p.UnhealthyThreshold <= 0 {
+		return 1
+	}
+	return p.UnhealthyThreshold
+}
+
+func (p *Probe) statusExpected(code int) bool {
+	if len(p.ExpectedStatuses) == 0 {
+		return code >= 200 && code < 400
+	}
+	for _, r := range p.ExpectedStatuses {
+		if code >= r.From && code <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// testHTTP sends the request of the probe to host:port and matches the response
+func (c *checker) testHTTP(host, port string, p *Probe) probeOutcome {
+	client := &http.Client{
+		Timeout: p.timeoutOr(c.timeout),
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig:   p.tlsConfig(host),
+		},
+		// 重定向按响应状态码判断，不跟随
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	path := p.Path
+	if path == "" {
+		path = "/"
+	}
+	method := p.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequest(method, p.Scheme+"://"+net.JoinHostPort(host, port)+path, nil)
+	if err != nil {
+		return failed("HTTP_ERROR", "%s", err)
+	}
+	if p.Host != "" {
+		req.Host = p.Host
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for _, h := range p.Headers {
+		req.Header.Set(h.Name, h.Value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return failed("HTTP_ERROR", "http %s", err)
+	}
+	defer resp.Body.Close()
+
+	return p.checkCertificate(resp.TLS, p.matchResponse(resp))
+}
+
+func (p *Probe) matchResponse(resp *http.Response) probeOutcome {
+	if !p.statusExpected(resp.StatusCode) {
+		return failed("HTTP_STATUS", "http status %d", resp.StatusCode)
+	}
+
+	if p.BodyMatch != "" {
+		re, err := regexp.Compile(p.BodyMatch)
+		if err != nil {
+			return failed("HTTP_BODY", "invalid bodyMatch: %s", err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyMatchSize))
+		if err != nil {
+			return failed("HTTP_ERROR", "http read body: %s", err)
+		}
+		if !re.Match(body) {
+			return failed("HTTP_BODY", "http body does not match")
+		}
+	}
+	return probeOutcome{}
+}
+
+// testGRPC calls grpc.health.v1.Health/Check, the server is healthy only when it answers SERVING
+func (c *checker) testGRPC(host, port string, p *Probe) probeOutcome {
+	creds := insecure.NewCredentials()
+	if p.Scheme == "grpcs" {
+		creds = credentials.NewTLS(p.tlsConfig(host))
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds), grpc.WithUserAgent(userAgent)}
+	if p.Host != "" {
+		opts = append(opts, grpc.WithAuthority(p.Host))
+	}
+
+	// passthrough 直接拨号，不经过 gRPC 的 DNS resolver
+	conn, err := grpc.NewClient("passthrough:///"+net.JoinHostPort(host, port), opts...)
+	if err != nil {
+		return failed("GRPC_ERROR", "grpc %s", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeoutOr(c.timeout))
+	defer cancel()
+	for _, h := range p.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, h.Name, h.Value)
+	}
+
+	var remote peer.Peer
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: p.ServiceName}, grpc.Peer(&remote))
+
+	var outcome probeOutcome
+	switch {
+	case err != nil:
+		outcome = failed("GRPC_ERROR", "grpc %s", status.Code(err))
+		if s, ok := status.FromError(err); ok && s.Message() != "" {
+			outcome.comment += ": " + s.Message()
+		}
+	case resp.GetStatus() != healthpb.HealthCheckResponse_SERVING:
+		outcome = failed("GRPC_STATUS", "grpc status %s", resp.GetStatus())
+	}
+
+	if info, ok := remote.AuthInfo.(credentials.TLSInfo); ok {
+		return p.checkCertificate(&info.State, outcome)
+	}
+	return outcome
+}
+
+// testTLS completes a TLS handshake, verifying the certificate chain and server name
+func (c *checker) testTLS(host, port string, p *Probe) probeOutcome {
+	dialer := &net.Dialer{Timeout: p.timeoutOr(c.timeout)}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), p.tlsConfig(host))
+	if err != nil {
+		return failed("TLS_ERROR", "tls %s", err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	return p.checkCertificate(&state, probeOutcome{})
+}
@@ -15,6 +15,10 @@ func RegisterAll() error {
 		OpenRestyCRDRefStatus,
 		UpstreamDNSResolvable,
 		OpenrestyOperatorEventInfo,
+		QuotaConsumers,
+		QuotaExceededConsumers,
+		QuotaConsumerUsed,
+		QuotaConsumerLimit,
 	}
 
 	for _, c := range collectors {
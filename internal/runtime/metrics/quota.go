@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// QuotaConsumers 当前周期内发送过请求的调用方数量
+	QuotaConsumers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "openresty",
+			Subsystem: "quota",
+			Name:      "consumers",
+			Help:      "Number of consumers that sent requests in the current quota period.",
+		},
+		[]string{"ns", "quota"},
+	)
+
+	// QuotaExceededConsumers 当前周期内用完配额的调用方数量
+	QuotaExceededConsumers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "openresty",
+			Subsystem: "quota",
+			Name:      "exceeded_consumers",
+			Help:      "Number of consumers that used up their quota in the current period.",
+		},
+		[]string{"ns", "quota"},
+	)
+
+	// QuotaConsumerUsed 只导出用量最高的调用方，避免调用方过多导致指标基数膨胀
+	QuotaConsumerUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "openresty",
+			Subsystem: "quota",
+			Name:      "consumer_used",
+			Help:      "Requests counted against the quota of the top consumers in the current period.",
+		},
+		[]string{"ns", "quota", "consumer"},
+	)
+
+	QuotaConsumerLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "openresty",
+			Subsystem: "quota",
+			Name:      "consumer_limit",
+			Help:      "Quota limit of the top consumers in the current period.",
+		},
+		[]string{"ns", "quota", "consumer"},
+	)
+)
+
+func SetQuotaUsage(namespace, quota string, consumers, exceeded int) {
+	QuotaConsumers.WithLabelValues(namespace, quota).Set(float64(consumers))
+	QuotaExceededConsumers.WithLabelValues(namespace, quota).Set(float64(exceeded))
+	// 上一轮的 top 调用方可能已经不在列表中
+	labels := prometheus.Labels{"ns": namespace, "quota": quota}
+	QuotaConsumerUsed.DeletePartialMatch(labels)
+	QuotaConsumerLimit.DeletePartialMatch(labels)
+}
+
+func SetQuotaConsumerUsage(namespace, quota, consumer string, used, limit int64) {
+	QuotaConsumerUsed.WithLabelValues(namespace, quota, consumer).Set(float64(used))
+	QuotaConsumerLimit.WithLabelValues(namespace, quota, consumer).Set(float64(limit))
+}
+
+func DeleteQuotaMetrics(namespace, quota string) {
+	labels := prometheus.Labels{"ns": namespace, "quota": quota}
+	QuotaConsumers.DeletePartialMatch(labels)
+	QuotaExceededConsumers.DeletePartialMatch(labels)
+	QuotaConsumerUsed.DeletePartialMatch(labels)
+	QuotaConsumerLimit.DeletePartialMatch(labels)
+}
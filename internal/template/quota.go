@@ -0,0 +1,9 @@
+package template
+
+// QuotaFlushScript adds the requests counted by one pod to the total of a consumer stored at KEYS[1].
+// ARGV: request count and the unix time the counter expires at. Returns the new total.
+const QuotaFlushScript = `local total = redis.call("INCRBY", KEYS[1], ARGV[1])
+redis.call("EXPIREAT", KEYS[1], ARGV[2])
+
+return total
+`
@@ -0,0 +1,67 @@
+package template
+
+// GlobalRateLimitGCRAScript is the Redis script of the gcra algorithm, the Generic Cell Rate Algorithm
+// also used by limit_req. KEYS[1] stores the theoretical arrival time of the next request.
+// ARGV: emission interval and burst tolerance in microseconds.
+// Returns {allowed, remaining, retry after in milliseconds}.
+//
+// 数值参数统一通过 string.format("%.0f") 传给 redis.call，避免大整数被格式化为科学计数法
+const GlobalRateLimitGCRAScript = `redis.replicate_commands()
+
+local key = KEYS[1]
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call("GET", key)) or now
+if tat < now then
+    tat = now
+end
+
+local allow_at = tat - tolerance
+if now < allow_at then
+    return {0, 0, math.ceil((allow_at - now) / 1000)}
+end
+
+tat = tat + interval
+redis.call("SET", key, string.format("%.0f", tat), "PX", string.format("%.0f", math.ceil((tat - now) / 1000)))
+
+return {1, math.floor((tolerance - (tat - now)) / interval) + 1, 0}
+`
+
+// GlobalRateLimitSlidingWindowScript is the Redis script of the sliding-window algorithm. Requests are
+// counted in fixed windows stored at KEYS[1]:<window>, the previous window is weighted by its overlap
+// with the sliding window. ARGV: request limit and window length in milliseconds.
+// Returns {allowed, remaining, retry after in milliseconds}.
+const GlobalRateLimitSlidingWindowScript = `redis.replicate_commands()
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local current = math.floor(now / window)
+local elapsed = now - current * window
+local current_key = KEYS[1] .. ":" .. string.format("%.0f", current)
+local previous_key = KEYS[1] .. ":" .. string.format("%.0f", current - 1)
+
+local previous_count = tonumber(redis.call("GET", previous_key)) or 0
+local current_count = tonumber(redis.call("GET", current_key)) or 0
+local count = previous_count * (window - elapsed) / window + current_count
+
+if count + 1 > limit then
+    local retry_after = window - elapsed
+    if previous_count > 0 and current_count + 1 <= limit then
+        retry_after = math.ceil(window * (1 - (limit - current_count - 1) / previous_count)) - elapsed
+    end
+    return {0, 0, retry_after}
+end
+
+redis.call("INCR", current_key)
+redis.call("PEXPIRE", current_key, string.format("%.0f", window * 2))
+
+return {1, math.floor(limit - count - 1), 0}
+`
@@ -8,25 +8,70 @@ const (
 	NginxServerConfigDir        = NginxConfDir + "/servers"
 	NginxLocationConfigDir      = NginxConfDir + "/locations"
 	NginxUpstreamConfigDir      = NginxConfDir + "/upstreams"
+	NginxRateLimitConfigDir     = NginxConfDir + "/ratelimits"
+	NginxTrafficShapingDir      = NginxConfDir + "/trafficshaping"
+	NginxQuotaConfigDir         = NginxConfDir + "/quotas"
 	NginxLuaLibDir              = "/usr/local/openresty/lualib"
 	NginxLuaLibUpstreamDir      = NginxLuaLibDir + "/upstreams"
 	NginxLuaLibNormalizeRuleDir = NginxLuaLibDir + "/normalizerules"
 	NginxLuaLibSecretDir        = NginxLuaLibDir + "/secrets"
+	NginxLuaLibModuleDir        = NginxLuaLibDir + "/luamodules"
+	NginxLuaLibRateLimitDir     = NginxLuaLibDir + "/ratelimits"
+	NginxRateLimitSecretDir     = "/etc/nginx/secrets/ratelimits"
+	NginxLuaLibQuotaDir         = NginxLuaLibDir + "/quotas"
+	NginxQuotaSecretDir         = "/etc/nginx/secrets/quotas"
 	NginxLogDir                 = "/var/log/nginx"
+	NginxStaticDir              = "/usr/share/nginx/static"
 	NginxTemplate               = `
 worker_processes auto;
 events { worker_connections 1024; }
 http {
 	
-    resolver kube-dns.kube-system.svc.cluster.local valid=30s;
+    resolver {{ .Resolver }};
+{{- if .ResolverTimeout }}
+    resolver_timeout {{ .ResolverTimeout }}s;
+{{- end }}
 	
 	lua_shared_dict secrets_store 10m;
     lua_shared_dict prometheus_metrics 10M;
+    lua_shared_dict upstream_balancer 10m;
+    lua_shared_dict upstream_outliers 1m;
+    lua_shared_dict upstream_health 1m;
+    lua_shared_dict upstream_dns 1m;
+    lua_shared_dict upstream_peers 10m;
     init_worker_by_lua_block {
 		require("secrets.secrets_loader").reload()
 		require("metrics").init()
 {{ indent .InitLua 8 }}
     }
+    server {
+        listen {{ .StatusPort }};
+        access_log off;
+        location = /upstreams/outliers {
+            content_by_lua_block {
+                require("upstreams.outlier").status()
+            }
+        }
+        location = /upstreams/health {
+            # 只接受同一 Pod 内 reload-agent 推送的结果
+            allow 127.0.0.1;
+            deny all;
+            client_body_buffer_size 1m;
+            content_by_lua_block {
+                require("upstreams.health").push()
+            }
+        }
+        location = /upstreams/peers {
+            # Dynamic upstream 的 servers，只接受同一 Pod 内 reload-agent 的推送
+            allow 127.0.0.1;
+            deny all;
+            client_body_buffer_size 10m;
+            client_max_body_size 10m;
+            content_by_lua_block {
+                require("upstreams.dynamic").push()
+            }
+        }
+    }
 {{- if .EnableMetrics }}
     server {
         listen {{ .MetricsPort }};
@@ -40,7 +85,7 @@ http {
 {{- range .Includes }}
     include {{ . }};
 {{- end }}
-{{- if .LogFormat }}log_format main '{{ .LogFormat }}';{{ end }}
+{{- if .LogFormat }}log_format main {{ .LogFormat }};{{ end }}
 {{- if .AccessLog }}access_log {{ .AccessLog }};{{ end }}
 {{- if .ErrorLog }}error_log {{ .ErrorLog }};{{ end }}
 {{- if .ClientMaxBodySize }}client_max_body_size {{ .ClientMaxBodySize }};{{ end }}
@@ -54,3 +99,12 @@ http {
 }
 `
 )
+
+const (
+	// NginxStatusPort serves the pod-local state of the Lua balancer, e.g. the servers ejected by passive health checks
+	NginxStatusPort = 19090
+	// DefaultResolver is the name server of nginx when the OpenResty configures none
+	DefaultResolver = "kube-dns.kube-system.svc.cluster.local"
+	// ReloadAgentPort serves the metrics of the reload-agent and the results of its in-pod health checks
+	ReloadAgentPort = 19091
+)
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 所有 handler 中的渲染器都通过这里把用户输入写入 nginx 配置或 Lua 源码:
+//   - NginxArg / QuoteNginx 把值渲染为单个 nginx 参数，必要时加引号转义
+//   - QuoteLua 把值渲染为 Lua 字符串字面量
+//   - Validate* 拒绝无法安全表示的输入（控制字符、原样拼接字段中的 ; { } 等）
+
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// NginxArg renders s as a single nginx directive argument, quoting it only when it would
+// otherwise be split, end the directive, open/close a block, start a comment or be unescaped.
+func NginxArg(s string) string {
+	if needsNginxQuoting(s) {
+		return QuoteNginx(s)
+	}
+	return s
+}
+
+// QuoteNginx renders s as a quoted nginx string that nginx reads back byte for byte.
+// Single quotes are used when s contains double quotes only, which keeps log formats readable.
+func QuoteNginx(s string) string {
+	quote := byte('"')
+	if strings.Contains(s, `"`) && !strings.Contains(s, "'") {
+		quote = '\''
+	}
+
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte(quote)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' || c == quote {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte(quote)
+	return b.String()
+}
+
+func needsNginxQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\r', '\n', ';', '{', '}', '"', '\'', '#':
+			// "${" 是合法的变量写法，其余位置的花括号都会被 nginx 当作块
+			if c == '{' && i > 0 && s[i-1] == '$' {
+				if end := strings.IndexByte(s[i:], '}'); end > 0 {
+					i += end
+					continue
+				}
+			}
+			return true
+		case '\\':
+			// nginx 会对 \" \' \\ \t \r \n 反转义，其他如 \. 原样保留
+			if i+1 == len(s) || strings.IndexByte(`"'\trn`, s[i+1]) >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// QuoteLua renders s as a Lua 5.1 string literal. Unlike Go's %q it never emits \u or \x escapes
+// that LuaJIT parses differently, and it keeps valid UTF-8 text as-is.
+func QuoteLua(s string) string {
+	validUTF8 := utf8.ValidString(s)
+
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f || (c >= 0x80 && !validUTF8):
+			// 十进制转义固定三位，避免与后面的数字连在一起
+			b.WriteString(fmt.Sprintf("\\%03d", c))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// ValidateNginxValue rejects control characters, which would end a header or split a directive even when quoted
+func ValidateNginxValue(s string) error {
+	for _, r := range s {
+		if r < 0x20 && r != '\t' || r == 0x7f {
+			return fmt.Errorf("contains control character %q", r)
+		}
+	}
+	return nil
+}
+
+// ValidateNginxToken checks values rendered as one bare argument, e.g. timeouts, sizes and zone names
+func ValidateNginxToken(s string) error {
+	if s == "" {
+		return fmt.Errorf("cannot be empty")
+	}
+	if strings.ContainsAny(s, " \t\r\n;{}\"'#\\") {
+		return fmt.Errorf("must be a single value without spaces, quotes or ';', '{', '}', '#'")
+	}
+	return ValidateNginxValue(s)
+}
+
+// ValidateNginxArgs checks values rendered verbatim as several arguments, e.g. "80 ssl" or "/var/log/access.log main"
+func ValidateNginxArgs(s string) error {
+	if strings.ContainsAny(s, ";{}\"'#\\") {
+		return fmt.Errorf("must not contain quotes or ';', '{', '}', '#', '\\'")
+	}
+	if strings.ContainsAny(s, "\r\n") {
+		return fmt.Errorf("must not contain line breaks")
+	}
+	return ValidateNginxValue(s)
+}
+
+// ValidateHeaderName checks an HTTP header field name (RFC 7230 token)
+func ValidateHeaderName(name string) error {
+	if !headerNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	return nil
+}
+
+// ValidatePort checks a numeric TCP port
+func ValidatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"openresty-operator/internal/nginxconf"
+	"testing"
+)
+
+func TestNginxArg(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "Plain value", value: "DENY", want: "DENY"},
+		{name: "Variables", value: "$http_x_real_ip${request_id}", want: "$http_x_real_ip${request_id}"},
+		{name: "Regex escape kept bare", value: `\.(js|css)$`, want: `\.(js|css)$`},
+		{name: "Empty value", value: "", want: `""`},
+		{name: "Spaces", value: "max-age=60, public", want: `"max-age=60, public"`},
+		{name: "Directive injection", value: "a; return 200", want: `"a; return 200"`},
+		{name: "Block injection", value: "a} server {", want: `"a} server {"`},
+		{name: "Double quotes use single quotes", value: `say "hi"`, want: `'say "hi"'`},
+		{name: "Both quotes", value: `it's "x"`, want: `"it's \"x\""`},
+		{name: "Backslash sequence nginx would unescape", value: `a\nb`, want: `"a\\nb"`},
+		{name: "Trailing backslash", value: `a\`, want: `"a\\"`},
+		{name: "Comment", value: "#x", want: `"#x"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NginxArg(tt.value))
+		})
+	}
+}
+
+func TestQuoteLua(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "Plain value", value: "abc", want: `"abc"`},
+		{name: "Quotes and backslash", value: `a"b\c`, want: `"a\"b\\c"`},
+		{name: "Line breaks", value: "a\nb\r\tc", want: `"a\nb\r\tc"`},
+		{name: "Control characters", value: "a\x00" + "1\x7f", want: `"a\0001\127"`},
+		{name: "UTF-8 kept", value: "名字", want: `"名字"`},
+		{name: "Invalid UTF-8 escaped", value: "\xff\xfe", want: `"\255\254"`},
+		{name: "Long bracket is plain text", value: "]]--[[", want: `"]]--[["`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, QuoteLua(tt.value))
+		})
+	}
+}
+
+func TestValidateHeaderName(t *testing.T) {
+	assert.NoError(t, ValidateHeaderName("X-Request-Id"))
+	assert.Error(t, ValidateHeaderName(""))
+	assert.Error(t, ValidateHeaderName("X A"))
+	assert.Error(t, ValidateHeaderName("X-A:"))
+	assert.Error(t, ValidateHeaderName("X-A\r\nX-B"))
+}
+
+func FuzzQuoteLua(f *testing.F) {
+	f.Add("plain")
+	f.Add("a\"b\\c\n\r\t")
+	f.Add("\x00\x01\x7f\xff")
+	f.Add("\x001")
+	f.Add("名字]]--[[")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		L := lua.NewState()
+		defer L.Close()
+
+		if err := L.DoString("return " + QuoteLua(s)); err != nil {
+			t.Fatalf("QuoteLua(%q) = %s does not parse: %v", s, QuoteLua(s), err)
+		}
+		assert.Equal(t, s, L.Get(-1).String())
+	})
+}
+
+func FuzzNginxArg(f *testing.F) {
+	f.Add("plain")
+	f.Add(`a; } server { "x" 'y' \n \\ #`)
+	f.Add("${var}{")
+	f.Add(`\`)
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, arg := range []string{NginxArg(s), QuoteNginx(s)} {
+			dirs, err := nginxconf.Parse("add_header X " + arg + ";\n")
+			if err != nil {
+				t.Fatalf("%s does not parse: %v", arg, err)
+			}
+			if assert.Len(t, dirs, 1) {
+				assert.Equal(t, []string{"X", s}, dirs[0].Args)
+			}
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// LuaSyntaxError is a Lua parse failure, Line/Column are 1-based within the parsed script
+type LuaSyntaxError struct {
+	Line    int
+	Column  int
+	Near    string
+	Message string
+}
+
+func (e *LuaSyntaxError) Error() string {
+	if e.Near == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d, column %d near '%s': %s", e.Line, e.Column, e.Near, e.Message)
+}
+
+var (
+	luaJITNumberSuffix = regexp.MustCompile(`\b((?:0[xX][0-9a-fA-F]+)|(?:[0-9]+))([uU]?[lL][lL]|i)\b`)
+	luaJITGoto         = regexp.MustCompile(`\bgoto[ \t]+[A-Za-z_][A-Za-z0-9_]*`)
+	luaJITLabel        = regexp.MustCompile(`::[ \t]*[A-Za-z_][A-Za-z0-9_]*[ \t]*::`)
+)
+
+// maskLuaJITExtensions rewrites the LuaJIT syntax unknown to Lua 5.1 into Lua 5.1 of the same length, so that
+// positions in parse errors still match the user code: 64-bit integer and imaginary literals, goto and labels
+func maskLuaJITExtensions(code string) string {
+	code = luaJITNumberSuffix.ReplaceAllStringFunc(code, func(m string) string {
+		sub := luaJITNumberSuffix.FindStringSubmatch(m)
+		return sub[1] + strings.Repeat(" ", len(sub[2]))
+	})
+	// "goto x" 至少 6 个字符，与 "do end" 等长
+	code = luaJITGoto.ReplaceAllStringFunc(code, func(m string) string {
+		return "do end" + strings.Repeat(" ", len(m)-len("do end"))
+	})
+	return luaJITLabel.ReplaceAllStringFunc(code, func(m string) string {
+		return strings.Repeat(" ", len(m))
+	})
+}
+
+// ParseLua checks the syntax of a Lua 5.1 chunk with the LuaJIT extensions used by OpenResty without executing it
+func ParseLua(code string) *LuaSyntaxError {
+	_, err := parse.Parse(strings.NewReader(maskLuaJITExtensions(code)), "<lua>")
+	if err == nil {
+		return nil
+	}
+
+	var perr *parse.Error
+	if !errors.As(err, &perr) {
+		return &LuaSyntaxError{Line: 1, Message: strings.TrimSpace(err.Error())}
+	}
+
+	syntaxErr := &LuaSyntaxError{
+		Line:    perr.Pos.Line,
+		Column:  perr.Pos.Column,
+		Near:    perr.Token,
+		Message: strings.TrimSpace(perr.Message),
+	}
+	if perr.Pos.Line == parse.EOF {
+		// 未闭合的 function/if 等在文本末尾才会被发现
+		syntaxErr.Line = strings.Count(strings.TrimRight(code, "\n"), "\n") + 1
+		syntaxErr.Column = 0
+		syntaxErr.Near = ""
+		syntaxErr.Message = "unexpected end of script: " + syntaxErr.Message
+	}
+	return syntaxErr
+}
+
+func ValidateLuaSyntax(code string) (bool, string) {
+	if strings.TrimSpace(code) == "" {
+		return true, ""
+	}
+	if err := ParseLua(code); err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+var luaBlockOpenPattern = regexp.MustCompile(`^(\s*)(\w+_by_lua_block)\s*\{\s*$`)
+
+// LuaBlockSource is the Lua body of a *_by_lua_block directive found in rendered nginx config
+type LuaBlockSource struct {
+	Directive string
+	// StartLine is the config line number of the first Lua line
+	StartLine int
+	Code      string
+}
+
+// ExtractLuaBlocks finds *_by_lua_block bodies in config rendered by the operator, which always
+// closes a block with "}" at the indentation of its opening line.
+func ExtractLuaBlocks(conf string) []LuaBlockSource {
+	var blocks []LuaBlockSource
+	lines := strings.Split(conf, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		m := luaBlockOpenPattern.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		closing := m[1] + "}"
+		var body []string
+		j := i + 1
+		for ; j < len(lines) && strings.TrimRight(lines[j], " \t") != closing; j++ {
+			body = append(body, lines[j])
+		}
+		blocks = append(blocks, LuaBlockSource{
+			Directive: m[2],
+			StartLine: i + 2,
+			Code:      strings.Join(body, "\n"),
+		})
+		i = j
+	}
+
+	return blocks
+}
+
+// ValidateGeneratedLua parses every Lua block of a rendered nginx config, line numbers refer to the config
+func ValidateGeneratedLua(conf string) []string {
+	var problems []string
+	for _, block := range ExtractLuaBlocks(conf) {
+		if err := ParseLua(block.Code); err != nil {
+			err.Line += block.StartLine - 1
+			problems = append(problems, fmt.Sprintf("%s: %s", block.Directive, err.Error()))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLuaJITExtensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		wantErr string
+	}{
+		{name: "64-bit literals", code: "local a = 1ULL\nlocal b = 0x7fffffffffffffffLL + 2ll"},
+		{name: "Imaginary literal", code: "local c = 12i"},
+		{
+			name: "goto and label",
+			code: "for i = 1, 3 do\n    if i == 2 then goto continue end\n    ngx.say(i)\n    ::continue::\nend",
+		},
+		{name: "Suffix inside a string", code: `local s = "1LL goto x ::y::"`},
+		{
+			name:    "Errors keep their position",
+			code:    "local a = 1ULL\nlocal b = = 2",
+			wantErr: "line 2, column 11 near '='",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ParseLua(tt.code)
+			if tt.wantErr == "" {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
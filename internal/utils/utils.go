@@ -79,6 +79,8 @@ func extractSpec(obj client.Object) (interface{}, bool) {
 		return o.Spec, true
 	case *webv1alpha1.Location:
 		return o.Spec, true
+	case *webv1alpha1.LuaModule:
+		return o.Spec, true
 	default:
 		return nil, false
 	}
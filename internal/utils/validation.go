@@ -1,16 +1,27 @@
 package utils
 
 import (
+	"encoding/json"
+	"fmt"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
 	"regexp"
+	"sort"
 	"strings"
 )
 
+var staticExtensionPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
 func ValidateLocationPath(path string) (bool, string) {
 	trimmed := strings.TrimSpace(path)
 	if trimmed == "" {
 		return false, "path cannot be empty"
 	}
 
+	if err := ValidateNginxValue(trimmed); err != nil {
+		return false, "path " + err.Error()
+	}
+
 	// Regex match: ~ or ~*
 	if strings.HasPrefix(trimmed, "~") {
 		if !regexp.MustCompile(`^~\*?\s+.+`).MatchString(trimmed) {
@@ -57,3 +68,216 @@ func ValidateLocationPath(path string) (bool, string) {
 
 	return true, ""
 }
+
+func ValidateStaticEntry(entry webv1alpha1.LocationEntry) (bool, string) {
+	static := entry.Static
+	if static == nil {
+		return true, ""
+	}
+
+	if entry.ProxyPass != "" || entry.ProxyPassIsFullURL || entry.UpstreamRef != nil {
+		return false, "static and proxyPass cannot be set together"
+	}
+
+	sources := 0
+	if static.ConfigMap != nil {
+		sources++
+	}
+	if static.PersistentVolumeClaim != "" {
+		sources++
+	}
+	if static.Image != nil {
+		sources++
+	}
+	if sources != 1 {
+		return false, "static requires exactly one of configMap, persistentVolumeClaim or image"
+	}
+
+	if static.Mode != webv1alpha1.StaticModeRoot {
+		path := strings.TrimSpace(entry.Path)
+		if strings.HasPrefix(path, "~") || strings.HasPrefix(path, "=") {
+			return false, "static alias mode requires a prefix path"
+		}
+	}
+
+	if strings.Contains(static.SubPath, "..") || strings.HasPrefix(static.SubPath, "/") {
+		return false, "static subPath must be a relative path without '..'"
+	}
+	if static.SubPath != "" && static.ConfigMap != nil {
+		return false, "static subPath is not supported by configMap, whose keys have no sub-directories"
+	}
+
+	for _, rule := range static.CacheControl {
+		for _, ext := range rule.Extensions {
+			if !staticExtensionPattern.MatchString(ext) {
+				return false, "invalid static cacheControl extension: " + ext
+			}
+		}
+	}
+
+	return true, ""
+}
+
+func ValidateUpstreamRefEntry(entry webv1alpha1.LocationEntry) (bool, string) {
+	ref := entry.UpstreamRef
+	if ref == nil {
+		return true, ""
+	}
+
+	if entry.ProxyPass != "" || entry.ProxyPassIsFullURL {
+		return false, "upstreamRef and proxyPass cannot be set together"
+	}
+
+	if strings.TrimSpace(ref.Name) == "" {
+		return false, "upstreamRef name cannot be empty"
+	}
+
+	if ref.Scheme != "" && ref.Scheme != "http" && ref.Scheme != "https" {
+		return false, "upstreamRef scheme must be http or https"
+	}
+
+	if ref.PathPrefix != "" && (!strings.HasPrefix(ref.PathPrefix, "/") || strings.ContainsAny(ref.PathPrefix, " ;")) {
+		return false, "upstreamRef pathPrefix must start with '/' and contain no spaces or ';'"
+	}
+
+	if ref.PathPrefix != "" && strings.HasPrefix(strings.TrimSpace(entry.Path), "~") {
+		return false, "upstreamRef pathPrefix cannot be used with regex paths"
+	}
+
+	return true, ""
+}
+
+// ValidateEntryValues checks the user values that are rendered into nginx directives of a LocationEntry
+func ValidateEntryValues(entry webv1alpha1.LocationEntry) (bool, string) {
+	var problems []string
+	check := func(field string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s", field, err.Error()))
+		}
+	}
+
+	if entry.ProxyPass != "" {
+		check("proxyPass", ValidateNginxToken(entry.ProxyPass))
+	}
+	for _, h := range entry.Headers {
+		check("headers", ValidateHeaderName(h.Key))
+		check(fmt.Sprintf("headers[%s]", h.Key), ValidateNginxValue(h.Value))
+	}
+	for _, h := range entry.HeadersFromSecret {
+		check("headersFromSecret", ValidateHeaderName(h.Name))
+	}
+	if entry.Timeout != nil {
+		for field, value := range map[string]string{
+			"timeout.connect": entry.Timeout.Connect,
+			"timeout.send":    entry.Timeout.Send,
+			"timeout.read":    entry.Timeout.Read,
+		} {
+			if value != "" {
+				check(field, ValidateNginxToken(value))
+			}
+		}
+	}
+	if entry.RateLimitPolicyRef != nil && strings.TrimSpace(entry.RateLimitPolicyRef.Name) == "" {
+		check("rateLimitPolicyRef", fmt.Errorf("name cannot be empty"))
+	}
+	if entry.TrafficShapingPolicyRef != nil && strings.TrimSpace(entry.TrafficShapingPolicyRef.Name) == "" {
+		check("trafficShapingPolicyRef", fmt.Errorf("name cannot be empty"))
+	}
+	if entry.QuotaRef != nil && strings.TrimSpace(entry.QuotaRef.Name) == "" {
+		check("quotaRef", fmt.Errorf("name cannot be empty"))
+	}
+	if entry.Gzip != nil {
+		for _, t := range entry.Gzip.Types {
+			check("gzip.types", ValidateNginxToken(t))
+		}
+	}
+	if entry.Cache != nil {
+		if entry.Cache.Zone != "" {
+			check("cache.zone", ValidateNginxToken(entry.Cache.Zone))
+		}
+		check("cache.valid", ValidateNginxArgs(entry.Cache.Valid))
+	}
+	if entry.Static != nil {
+		if entry.Static.Index != "" {
+			check("static.index", ValidateNginxToken(entry.Static.Index))
+		}
+		for _, rule := range entry.Static.CacheControl {
+			check("static.cacheControl", ValidateNginxValue(rule.Value))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return false, strings.Join(problems, "; ")
+	}
+	return true, ""
+}
+
+func ValidateLuaEntry(entry webv1alpha1.LocationEntry) (bool, string) {
+	if entry.Lua == nil {
+		return true, ""
+	}
+
+	if strings.TrimSpace(entry.Lua.Content) != "" &&
+		(entry.ProxyPass != "" || entry.ProxyPassIsFullURL || entry.UpstreamRef != nil || entry.Static != nil) {
+		return false, "lua content cannot be combined with proxyPass, upstreamRef or static"
+	}
+
+	phases := []struct {
+		name string
+		code string
+	}{
+		{"rewrite", entry.Lua.Rewrite},
+		{"access", entry.Lua.Access},
+		{"content", entry.Lua.Content},
+		{"header_filter", entry.Lua.HeaderFilter},
+		{"body_filter", entry.Lua.BodyFilter},
+		{"log", entry.Lua.Log},
+	}
+	var problems []string
+	for _, phase := range phases {
+		if valid, reason := ValidateLuaSyntax(phase.code); !valid {
+			problems = append(problems, fmt.Sprintf("%s: %s", phase.name, reason))
+		}
+	}
+	if len(problems) > 0 {
+		return false, strings.Join(problems, "; ")
+	}
+
+	return true, ""
+}
+
+// ValidateNormalizeRuleLua checks the syntax of every { lua: "..." } entry of a NormalizeRule
+func ValidateNormalizeRuleLua(rule *webv1alpha1.NormalizeRule) []string {
+	var problems []string
+
+	check := func(fields map[string]apiextensionsv1.JSON, fieldPath string) {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			var obj map[string]interface{}
+			if err := json.Unmarshal(fields[key].Raw, &obj); err != nil {
+				continue
+			}
+			code, ok := obj["lua"].(string)
+			if !ok {
+				continue
+			}
+			if valid, reason := ValidateLuaSyntax(code); !valid {
+				problems = append(problems, fmt.Sprintf("%s[%s]: %s", fieldPath, key, reason))
+			}
+		}
+	}
+
+	if rule.Spec.Request != nil {
+		check(rule.Spec.Request.Body, "spec.request.body")
+		check(rule.Spec.Request.Query, "spec.request.query")
+	}
+	check(rule.Spec.Response, "spec.response")
+
+	return problems
+}
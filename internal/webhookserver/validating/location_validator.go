@@ -25,6 +25,9 @@ func (v *LocationValidator) Handle(ctx context.Context, req admission.Request) a
 	pathSet := make(map[string]struct{})
 	var invalidPaths []string
 	var duplicatePaths []string
+	var invalidStatic []string
+	var invalidUpstreamRefs []string
+	var invalidLua []string
 
 	for _, entry := range loc.Spec.Entries {
 		valid, reason := utils.ValidateLocationPath(entry.Path)
@@ -35,10 +38,21 @@ func (v *LocationValidator) Handle(ctx context.Context, req admission.Request) a
 			duplicatePaths = append(duplicatePaths, entry.Path)
 		}
 		pathSet[entry.Path] = struct{}{}
+
+		if valid, reason := utils.ValidateStaticEntry(entry); !valid {
+			invalidStatic = append(invalidStatic, fmt.Sprintf("%s (%s)", entry.Path, reason))
+		}
+		if valid, reason := utils.ValidateUpstreamRefEntry(entry); !valid {
+			invalidUpstreamRefs = append(invalidUpstreamRefs, fmt.Sprintf("%s (%s)", entry.Path, reason))
+		}
+		if valid, reason := utils.ValidateLuaEntry(entry); !valid {
+			invalidLua = append(invalidLua, fmt.Sprintf("%s (%s)", entry.Path, reason))
+		}
 	}
 
-	if len(invalidPaths)+len(duplicatePaths) > 0 {
-		msg := fmt.Sprintf("Invalid paths: %v; Duplicates: %v", invalidPaths, duplicatePaths)
+	if len(invalidPaths)+len(duplicatePaths)+len(invalidStatic)+len(invalidUpstreamRefs)+len(invalidLua) > 0 {
+		msg := fmt.Sprintf("Invalid paths: %v; Duplicates: %v; Invalid static: %v; Invalid upstreamRefs: %v; Invalid lua: %v",
+			invalidPaths, duplicatePaths, invalidStatic, invalidUpstreamRefs, invalidLua)
 		return admission.Denied(msg)
 	}
 
@@ -0,0 +1,36 @@
+package validating
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	webv1alpha1 "openresty-operator/api/v1alpha1"
+	"openresty-operator/internal/utils"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type NormalizeRuleValidator struct {
+	Client  client.Client
+	Decoder admission.Decoder
+}
+
+func (v *NormalizeRuleValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var rule webv1alpha1.NormalizeRule
+	if err := v.Decoder.Decode(req, &rule); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if problems := utils.ValidateNormalizeRuleLua(&rule); len(problems) > 0 {
+		return admission.Denied(fmt.Sprintf("Invalid lua: %s", strings.Join(problems, "; ")))
+	}
+
+	return admission.Allowed("NormalizeRule is valid")
+}
+
+func (v *NormalizeRuleValidator) InjectDecoder(d admission.Decoder) error {
+	v.Decoder = d
+	return nil
+}
@@ -15,4 +15,11 @@ func SetupWebhook(mgr ctrl.Manager) {
 	mgr.GetWebhookServer().Register("/validate-location", &admission.Webhook{
 		Handler: hook,
 	})
+
+	normalizeRuleHook := &validating.NormalizeRuleValidator{}
+	_ = normalizeRuleHook.InjectDecoder(decoder)
+
+	mgr.GetWebhookServer().Register("/validate-normalizerule", &admission.Webhook{
+		Handler: normalizeRuleHook,
+	})
 }